@@ -16,6 +16,8 @@
 
 package swift
 
+import "fmt"
+
 // Access interface for validating entitlement to access the network.
 type Access interface {
 
@@ -24,3 +26,65 @@ type Access interface {
 	// provide the reason.
 	GetAllowed(accessKey string) (bool, error)
 }
+
+// AccessScoped is optionally implemented by an Access implementation to limit
+// the tables and networks an access key can create storage operations for.
+// Keys for implementations that do not support scopes can access everything.
+type AccessScoped interface {
+
+	// GetScope returns the scope of the accessKey. A nil scope grants access
+	// to all tables and networks.
+	GetScope(accessKey string) (*Scope, error)
+}
+
+// Scope limits the tables and networks that an access key can use. An empty
+// list allows any table or network.
+type Scope struct {
+	Tables   []string // Tables that storage operations can use
+	Networks []string // Networks that storage operations can visit
+}
+
+// scopeError is returned when a storage operation is outside the scope of the
+// access key.
+type scopeError struct {
+	message string
+}
+
+func (e *scopeError) Error() string { return e.message }
+
+// getScope returns the scope for the access key, or nil if the Access
+// implementation does not support scopes.
+func getScope(a Access, accessKey string) (*Scope, error) {
+	if p, ok := a.(AccessScoped); ok {
+		return p.GetScope(accessKey)
+	}
+	return nil, nil
+}
+
+// validate returns an error if the table t or any of the networks ns are not
+// allowed by the scope. A nil scope allows everything.
+func (s *Scope) validate(t string, ns ...string) error {
+	if s == nil {
+		return nil
+	}
+	if len(s.Tables) > 0 && scopeContains(s.Tables, t) == false {
+		return &scopeError{fmt.Sprintf("table '%s' not allowed", t)}
+	}
+	if len(s.Networks) > 0 {
+		for _, n := range ns {
+			if scopeContains(s.Networks, n) == false {
+				return &scopeError{fmt.Sprintf("network '%s' not allowed", n)}
+			}
+		}
+	}
+	return nil
+}
+
+func scopeContains(a []string, v string) bool {
+	for _, i := range a {
+		if i == v {
+			return true
+		}
+	}
+	return false
+}
@@ -19,7 +19,8 @@ package swift
 // AccessSimple is a implementation of swift.Access for testing where a list
 // of keys returns true, and all others return false.
 type AccessSimple struct {
-	validKeys map[string]bool // A list of the keys that are valid.
+	validKeys map[string]bool   // A list of the keys that are valid.
+	scopes    map[string]*Scope // Optional scopes for the keys
 }
 
 // NewAccessSimple creates a new instance of the AccessSimple structure
@@ -39,3 +40,32 @@ func NewAccessSimple(validKeys []string) *AccessSimple {
 func (a *AccessSimple) GetAllowed(accessKey string) (bool, error) {
 	return a.validKeys[accessKey], nil
 }
+
+// NewAccessSimpleWithScopes creates a new instance of the AccessSimple structure
+// where the keys of the map are valid and limited to the scope provided. A nil
+// scope allows access to everything. The network names of the scopes are
+// normalized so that they match the networks of the nodes.
+func NewAccessSimpleWithScopes(scopes map[string]*Scope) *AccessSimple {
+	var a AccessSimple
+	a.validKeys = make(map[string]bool)
+	a.scopes = make(map[string]*Scope)
+	for k, v := range scopes {
+		a.validKeys[k] = true
+		if v == nil {
+			a.scopes[k] = nil
+			continue
+		}
+		s := Scope{Tables: v.Tables}
+		for _, n := range v.Networks {
+			s.Networks = append(s.Networks, normalizeNetwork(n))
+		}
+		a.scopes[k] = &s
+	}
+	return &a
+}
+
+// GetScope returns the scope for the access key, or nil if the key is not
+// limited.
+func (a *AccessSimple) GetScope(accessKey string) (*Scope, error) {
+	return a.scopes[accessKey], nil
+}
@@ -20,57 +20,76 @@ import (
 	"bytes"
 	"crypto/rand"
 	"io/ioutil"
-	"log"
+	mathrand "math/rand"
 	"net/http"
-	"net/url"
+	"sync"
 	"time"
 )
 
 // The size of the nounce used for the keep alive service.
 const nounceSize = 32
 
+// The maximum number of nodes polled concurrently by the alive service.
+const alivePollingWorkers = 8
+
 // aliveService type is service which polls known nodes to determine if they are
 // 'alive' and responding to requests. Only nodes that have not been accessed
-// for a period of time greater than the polling interval will be polled. On a
-// successful poll, the node's accessed time is updated and the 'alive' value is
-// set to true. Otherwise, the node's 'alive' value is set to false.
+// for a period of time greater than the polling interval plus a random jitter
+// will be polled. On a successful poll, the node's accessed time is updated and
+// the 'alive' value is set to true. Otherwise, the node's 'alive' value is set
+// to false.
 type aliveService struct {
 	ticker          *time.Ticker
 	config          Configuration  // swift config
 	store           storageManager // swift storage manager
 	pollingInterval time.Duration
+	jitter          time.Duration        // maximum random delay per node
+	due             map[string]time.Time // next poll time keyed on domain
+	client          *http.Client         // client used to poll nodes
+	mutex           *sync.Mutex          // mutex used to lock client and due
+	done            chan struct{}        // closed to stop the polling loop
 }
 
 // newAliveService creates a new instance of type alive and starts the
-// background polling service.
-func newAliveService(c Configuration, s storageManager) *aliveService {
+// background polling service. If h is nil then the default client from
+// newAliveClient is used to poll nodes. If the polling seconds are zero then
+// polling is disabled and the background service is not started.
+func newAliveService(
+	c Configuration,
+	s storageManager,
+	h *http.Client) *aliveService {
 	var a aliveService
 
 	a.config = c
 	a.store = s
+	a.mutex = &sync.Mutex{}
 
-	if a.config.AlivePollingSeconds == 0 {
+	if a.config.AlivePollingSeconds < 0 {
 		panic("configured for 'alivePollingSeconds' is not valid, please set " +
-			"to a positive integer")
+			"to zero or a positive integer")
 	}
 	a.pollingInterval = time.Duration(time.Duration(
 		a.config.AlivePollingSeconds) * time.Second)
+	a.jitter = time.Duration(a.config.AlivePollingJitterSeconds) * time.Second
+	a.due = make(map[string]time.Time)
+	a.done = make(chan struct{})
+	a.setClient(h)
 
-	// start the polling loop
-	go a.aliveLoop()
+	// start the polling loop if polling is enabled
+	if a.pollingInterval > 0 {
+		go a.aliveLoop()
+	}
 
 	return &a
 }
 
-// checkAlive starts a new ticker and stores a reference to it in the
-// aliveService. For each tick, all nodes known by the storageService are
-// polled.
+// newAliveClient returns the default client used to poll nodes.
 // The transport is configured to disable keep-alive to avoid exhausting the
 // number of open connections in the environment. Compression is not used
 // because the payload is only 32 bytes. There is no benefit from HTTP 2 so this
 // is not required. There is a short timeout as an alive node will respond
 // quickly.
-func (a *aliveService) aliveLoop() {
+func (a *aliveService) newAliveClient() *http.Client {
 	t := &http.Transport{
 		DisableKeepAlives:     true,
 		DisableCompression:    true,
@@ -81,76 +100,186 @@ func (a *aliveService) aliveLoop() {
 		IdleConnTimeout:       time.Second,
 		ResponseHeaderTimeout: time.Second,
 		ExpectContinueTimeout: time.Second}
-	c := &http.Client{
+	return &http.Client{
 		Timeout:   a.pollingInterval,
 		Transport: t}
+}
+
+// setClient sets the client used to poll nodes. If h is nil then the default
+// client is used.
+func (a *aliveService) setClient(h *http.Client) {
+	if h == nil {
+		h = a.newAliveClient()
+	}
+	a.mutex.Lock()
+	a.client = h
+	a.mutex.Unlock()
+}
+
+// getClient returns the client currently used to poll nodes.
+func (a *aliveService) getClient() *http.Client {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.client
+}
 
-	a.ticker = time.NewTicker(a.pollingInterval)
-	for _ = range a.ticker.C {
-		a.ticker.Stop()
-		a.pollNodes(c)
-		a.ticker.Reset(a.pollingInterval)
+// checkAlive starts a new ticker and stores a reference to it in the
+// aliveService. For each tick, all nodes known by the storageService that are
+// due are polled. The loop ends when the service is stopped.
+func (a *aliveService) aliveLoop() {
+	d := a.getTickInterval()
+	a.ticker = time.NewTicker(d)
+	defer a.ticker.Stop()
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-a.ticker.C:
+			a.ticker.Stop()
+			a.pollNodes(a.getClient())
+			a.ticker.Reset(d)
+		}
 	}
 }
 
-// pollNodes gets the latest copy of all the nodes and polls each one if it's
-// last accessed time is older than the polling interval.
+// stop ends the polling loop. Called when the storage manager that owns the
+// service has been replaced. Must only be called once.
+func (a *aliveService) stop() {
+	close(a.done)
+}
+
+// getTickInterval returns the time between checks for nodes that are due. If
+// jitter is configured and is shorter than the polling interval then the
+// checks happen every jitter period so that each node is polled close to its
+// own due time without fetching all the nodes too often.
+func (a *aliveService) getTickInterval() time.Duration {
+	if a.jitter > 0 && a.jitter < a.pollingInterval {
+		return a.jitter
+	}
+	return a.pollingInterval
+}
+
+// pollNodes gets the latest copy of all the nodes and polls each one that is
+// due using a bounded number of concurrent workers. Due times for nodes that
+// no longer exist are removed.
 func (a *aliveService) pollNodes(c *http.Client) {
 	ns, err := a.store.getAllNodes()
 	if err == nil {
+		a.pruneDue(ns)
+		q := make(chan *node)
+		var w sync.WaitGroup
+		for i := 0; i < alivePollingWorkers && i < len(ns); i++ {
+			w.Add(1)
+			go func() {
+				defer w.Done()
+				for n := range q {
+					a.pollNode(n, c)
+				}
+			}()
+		}
 		for _, n := range ns {
-			a.pollNode(n, c)
+			q <- n
 		}
+		close(q)
+		w.Wait()
 		c.CloseIdleConnections()
 	}
 }
 
+// isDue returns true if the node should be polled at time t. Nodes accessed
+// within the polling interval are never due. The first time a node is seen it
+// is due at the last accessed time plus the polling interval and a random
+// jitter.
+func (a *aliveService) isDue(n *node, t time.Time) bool {
+	if t.Sub(n.Accessed()) < a.pollingInterval {
+		return false
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	d, ok := a.due[n.domain]
+	if !ok {
+		d = n.Accessed().Add(a.pollingInterval + a.getJitter())
+		a.due[n.domain] = d
+	}
+	return d.After(t) == false
+}
+
+// pruneDue removes the due times of domains that are not in the nodes ns.
+func (a *aliveService) pruneDue(ns []*node) {
+	d := make(map[string]bool, len(ns))
+	for _, n := range ns {
+		d[n.domain] = true
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for k := range a.due {
+		if d[k] == false {
+			delete(a.due, k)
+		}
+	}
+}
+
+// setDue sets the next time the node should be polled to the time t plus the
+// polling interval and a random jitter.
+func (a *aliveService) setDue(n *node, t time.Time) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.due[n.domain] = t.Add(a.pollingInterval + a.getJitter())
+}
+
+// getJitter returns a random duration between zero and the configured jitter.
+// Must be called with the mutex held.
+func (a *aliveService) getJitter() time.Duration {
+	if a.jitter <= 0 {
+		return 0
+	}
+	return time.Duration(mathrand.Int63n(int64(a.jitter)))
+}
+
 // pollNode polls the given node to determine if it is alive and responding to
-// requests. If the node has not been accessed for longer than the polling
-// interval then the node is polled with a nonce value that has been encrypted
-// with the polled node's shared secret. If there is a response back from the
-// polled node and the response value is the same as the original nonce value
-// then the node's 'alive' value is set to true.
+// requests. If the node is due to be polled then the node is polled with a
+// nonce value that has been encrypted with the polled node's shared secret. If
+// there is a response back from the polled node and the response value is the
+// same as the original nonce value then the node's 'alive' value is set to
+// true.
 //
 // n is the node to be polled
 //
 // c is the http.Client to use for the request
 func (a *aliveService) pollNode(n *node, c *http.Client) {
-	if time.Now().UTC().Sub(n.accessed) >= a.pollingInterval {
+	t := time.Now().UTC()
+	if a.isDue(n, t) {
+		defer a.setDue(n, t)
 
 		// create a new nonce value
 		nonce, err := nonce()
 		if err != nil {
-			if a.config.Debug {
-				log.Printf("SWIFT: could not generate nonce, "+
-					"aliveService failed to check node '%s'\r\n", n.domain)
-				log.Println(err.Error())
-			}
-			n.alive = false
+			a.config.debugf("SWIFT: could not generate nonce, "+
+				"aliveService failed to check node '%s': %s\n",
+				n.domain,
+				err.Error())
+			n.SetAlive(false)
 			return
 		}
 
 		// encrypt the nonce using the target node's shared secret
 		b1, err := n.encode(nonce)
 		if err != nil {
-			if a.config.Debug {
-				log.Printf("SWIFT: could not encrypt nonce using node's "+
-					"shared secret, aliveService failed to check node "+
-					"'%s'\r\n", n.domain)
-				log.Println(err.Error())
-			}
+			a.config.debugf("SWIFT: could not encrypt nonce using node's "+
+				"shared secret, aliveService failed to check node "+
+				"'%s': %s\n",
+				n.domain,
+				err.Error())
 		}
 
 		// call the node's 'alive' endpoint with the encrypted nonce value
 		// and get the response.
 		b2, err := a.callAlive(n, c, b1)
 		if err != nil {
-			if a.config.Debug {
-				log.Printf("SWIFT: alive check failed for node "+
-					"'%s'\r\n", n.domain)
-				log.Println(err.Error())
-			}
-			n.alive = false
+			a.config.debugf("SWIFT: alive check failed for node '%s': %s\n",
+				n.domain,
+				err.Error())
+			n.SetAlive(false)
 			return
 		}
 
@@ -158,11 +287,11 @@ func (a *aliveService) pollNode(n *node, c *http.Client) {
 		// confirms that the node is responding and that the known shared
 		// secret is valid.
 		if bytes.Equal(nonce, b2) {
-			n.alive = true
-			n.accessed = time.Now().UTC()
+			n.SetAlive(true)
+			n.SetAccessed(time.Now().UTC())
 			return
 		}
-		n.alive = false
+		n.SetAlive(false)
 	}
 }
 
@@ -175,11 +304,7 @@ func (a *aliveService) callAlive(
 	d []byte) ([]byte, error) {
 
 	// Construct the URL for the alive service endpoint.
-	url := url.URL{
-		Scheme: a.config.Scheme,
-		Host:   n.domain,
-		Path:   "/swift/api/v1/alive",
-	}
+	url := a.config.APIURL(n.domain, "alive")
 
 	// Use the client provided to post the byte array.
 	r, err := c.Post(
@@ -0,0 +1,338 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAliveServiceClient(t *testing.T) {
+
+	// Create a node with a secret.
+	n, err := newNode(
+		"network",
+		"test.com",
+		time.Now().UTC(),
+		time.Now().UTC(),
+		time.Now().UTC().AddDate(1, 0, 0),
+		roleStorage,
+		"",
+		"test.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	x, err := newSecret()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	n.addSecret(x)
+
+	// Create a test server which decodes the nonce with the node.
+	h := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			b, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			d, err := n.decode(b)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write(d)
+		}))
+	defer h.Close()
+	u, err := url.Parse(h.URL)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	n.domain = u.Host
+
+	// Create the alive service with the test server's client and poll the
+	// node.
+	c := newConfigurationTest()
+	c.Scheme = "http"
+	c.AlivePollingSeconds = 60
+	var sm storageManager
+	sm.nodes = map[string]*node{n.domain: n}
+	a := newAliveService(c, sm, h.Client())
+	if a.getClient() != h.Client() {
+		fmt.Println("alive service not using client provided")
+		t.Fail()
+		return
+	}
+	a.pollNode(n, a.getClient())
+	if n.IsAlive() == false {
+		fmt.Printf("node '%s' not marked alive\n", n.domain)
+		t.Fail()
+	}
+}
+
+func TestAliveServiceJitter(t *testing.T) {
+	c := newConfigurationTest()
+	c.AlivePollingSeconds = 60
+	c.AlivePollingJitterSeconds = 30
+	var sm storageManager
+	sm.nodes = map[string]*node{}
+	a := newAliveService(c, sm, nil)
+	n := &node{domain: "test.com", accessed: time.Now().UTC()}
+	if a.isDue(n, n.Accessed().Add(59*time.Second)) {
+		fmt.Println("node due before polling interval")
+		t.Fail()
+	}
+	if a.isDue(n, n.Accessed().Add(91*time.Second)) == false {
+		fmt.Println("node not due after polling interval and jitter")
+		t.Fail()
+	}
+	s := time.Now().UTC()
+	a.setDue(n, s)
+	d := a.due[n.domain].Sub(s)
+	if d < a.pollingInterval || d >= a.pollingInterval+a.jitter {
+		fmt.Printf("due in '%s' outside of interval and jitter\n", d)
+		t.Fail()
+	}
+}
+
+func TestAliveServiceTickInterval(t *testing.T) {
+	for _, d := range []struct {
+		polling  int
+		jitter   int
+		expected time.Duration
+	}{
+		{60, 0, 60 * time.Second},
+		{60, 30, 30 * time.Second},
+		{60, 90, 60 * time.Second}} {
+		c := newConfigurationTest()
+		c.AlivePollingJitterSeconds = d.jitter
+		var sm storageManager
+		a := newAliveService(c, sm, nil)
+		a.pollingInterval = time.Duration(d.polling) * time.Second
+		a.jitter = time.Duration(d.jitter) * time.Second
+		if a.getTickInterval() != d.expected {
+			fmt.Printf("Tick '%s' expected '%s'\n",
+				a.getTickInterval(),
+				d.expected)
+			t.Fail()
+		}
+	}
+}
+
+func TestAliveServicePruneDue(t *testing.T) {
+	n, err := newStoreQueueTestNode("test.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	c := newConfigurationTest()
+	var sm storageManager
+	sm.stores = []Store{newVolatile("test", false, []*node{n})}
+	a := newAliveService(c, sm, nil)
+	a.pollingInterval = time.Minute
+	a.due[n.domain] = time.Now().UTC().Add(time.Minute)
+	a.due["removed.com"] = time.Now().UTC()
+	a.pollNodes(a.getClient())
+	if _, ok := a.due["removed.com"]; ok {
+		fmt.Println("Due time not removed for missing node")
+		t.Fail()
+	}
+	if _, ok := a.due[n.domain]; ok == false {
+		fmt.Println("Due time removed for existing node")
+		t.Fail()
+	}
+}
+
+func TestAliveServicePollNodesConcurrent(t *testing.T) {
+	var m sync.Mutex
+	var active, peak int
+	var ns []*node
+	for i := 0; i < alivePollingWorkers*3; i++ {
+		n, err := newStoreQueueTestNode(fmt.Sprintf("test-%d.com", i))
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		ns = append(ns, n)
+	}
+
+	// Create a test server which records the peak number of concurrent polls
+	// and decodes the nonce with the node for the host.
+	h := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			m.Lock()
+			active++
+			if active > peak {
+				peak = active
+			}
+			m.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			b, _ := ioutil.ReadAll(r.Body)
+			for _, n := range ns {
+				if n.domain == r.Host {
+					d, _ := n.decode(b)
+					w.Write(d)
+				}
+			}
+			m.Lock()
+			active--
+			m.Unlock()
+		}))
+	defer h.Close()
+
+	// Use a client that sends all requests to the test server.
+	d := &net.Dialer{}
+	hc := &http.Client{Transport: &http.Transport{
+		DialContext: func(
+			ctx context.Context,
+			network string,
+			addr string) (net.Conn, error) {
+			return d.DialContext(ctx, network, h.Listener.Addr().String())
+		}}}
+
+	c := newConfigurationTest()
+	c.Scheme = "http"
+	c.AlivePollingSeconds = 60
+	var sm storageManager
+	sm.stores = []Store{newVolatile("test", false, ns)}
+	a := newAliveService(c, sm, hc)
+	a.pollNodes(a.getClient())
+	if peak > alivePollingWorkers {
+		fmt.Printf("'%d' concurrent polls exceeds limit\n", peak)
+		t.Fail()
+	}
+	if peak < 2 {
+		fmt.Println("nodes not polled concurrently")
+		t.Fail()
+	}
+	for _, n := range ns {
+		if n.IsAlive() == false {
+			fmt.Printf("node '%s' not marked alive\n", n.domain)
+			t.Fail()
+		}
+	}
+}
+
+// TestAliveServicePollNodeRace polls a node whilst other goroutines read and
+// update the alive and accessed fields as the handlers do. Run with -race to
+// detect unsynchronized access.
+func TestAliveServicePollNodeRace(t *testing.T) {
+	n, err := newStoreQueueTestNode("test.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	h := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			b, _ := ioutil.ReadAll(r.Body)
+			d, _ := n.decode(b)
+			w.Write(d)
+		}))
+	defer h.Close()
+	d := &net.Dialer{}
+	hc := &http.Client{Transport: &http.Transport{
+		DialContext: func(
+			ctx context.Context,
+			network string,
+			addr string) (net.Conn, error) {
+			return d.DialContext(ctx, network, h.Listener.Addr().String())
+		}}}
+
+	c := newConfigurationTest()
+	c.Scheme = "http"
+	c.AlivePollingSeconds = 1
+	var sm storageManager
+	a := newAliveService(c, sm, hc)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+
+			// Make the node due to be polled again.
+			n.SetAccessed(time.Time{})
+			a.setDue(n, time.Now().UTC().Add(-a.pollingInterval))
+			a.pollNode(n, a.getClient())
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			if n.IsAlive() {
+				n.SetAccessed(time.Now().UTC())
+			}
+			_ = n.Accessed()
+			n.SetAlive(true)
+		}
+	}()
+	wg.Wait()
+	if n.IsAlive() == false || n.Accessed().IsZero() {
+		fmt.Printf("node '%s' not marked alive\n", n.domain)
+		t.Fail()
+	}
+}
+
+func TestAliveServiceDisabled(t *testing.T) {
+	n, err := newStoreQueueTestNode("test.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	c := newConfigurationTest()
+	c.AlivePollingSeconds = 0
+	sm, err := newStorageManager(c, nil, newVolatile("test", false, []*node{n}))
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if sm.alive != nil {
+		fmt.Println("alive service created when polling disabled")
+		t.Fail()
+	}
+	if n.IsAlive() == false {
+		fmt.Printf("node '%s' not alive when polling disabled\n", n.domain)
+		t.Fail()
+	}
+
+	// Creating the service directly does not panic or start polling.
+	a := newAliveService(c, *sm, nil)
+	time.Sleep(10 * time.Millisecond)
+	if a.ticker != nil {
+		fmt.Println("polling started when disabled")
+		t.Fail()
+	}
+}
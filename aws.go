@@ -34,6 +34,9 @@ type AWS struct {
 	name      string
 	timestamp time.Time          // The last time the maps were refreshed
 	svc       *dynamodb.DynamoDB // Reference to the creators table
+	attempts  int                // Maximum attempts for each table scan
+	// True if the table scans use strongly consistent reads
+	consistentReads bool
 	common
 }
 
@@ -47,6 +50,8 @@ type NodeItem struct {
 	Role         int       // The role the node has in the network
 	ScramblerKey string    // Secret used to scramble data with fixed nonce
 	CookieDomain string    // The domain to use with cookies
+	Weight       int       // Relative capacity of the node, 0 for default
+	Previous     string    // Scrambler key before the last rotation
 }
 
 // SecretItem is the dynamodb table item representation of a secret
@@ -59,9 +64,16 @@ type SecretItem struct {
 
 // NewAWS creates a new instance of the AWS structure
 func NewAWS() (*AWS, error) {
+	return NewAWSWithRetry(defaultStoreRetryAttempts)
+}
+
+// NewAWSWithRetry creates a new instance of the AWS structure which will make
+// up to attempts scans of each table before failing a refresh.
+func NewAWSWithRetry(attempts int) (*AWS, error) {
 	var a AWS
 	var s *session.Session
 	a.name = "AWS DynamoDB Store"
+	a.attempts = attempts
 	// Configure session with credentials from .aws/credentials or env and
 	// region from .aws/config or env
 	s = session.Must(session.NewSessionWithOptions(session.Options{
@@ -292,6 +304,14 @@ func (a *AWS) getName() string {
 	return a.name
 }
 
+// ping describes the nodes table to confirm DynamoDB can be reached.
+func (a *AWS) ping() error {
+	_, err := a.svc.DescribeTable(&dynamodb.DescribeTableInput{
+		TableName: aws.String(nodesTableName),
+	})
+	return err
+}
+
 func (a *AWS) getReadOnly() bool {
 	return false
 }
@@ -299,34 +319,12 @@ func (a *AWS) getReadOnly() bool {
 // GetNode takes a domain name and returns the associated node. If a node
 // does not exist then nil is returned.
 func (a *AWS) getNode(domain string) (*node, error) {
-	n, err := a.common.getNode(domain)
-	if err != nil {
-		return nil, err
-	}
-	if n == nil {
-		err = a.refresh()
-		if err != nil {
-			return nil, err
-		}
-		n, err = a.common.getNode(domain)
-	}
-	return n, err
+	return a.common.getNodeOrRefresh(domain, a.refresh)
 }
 
 // GetNodes returns all the nodes associated with a network.
 func (a *AWS) getNodes(network string) (*nodes, error) {
-	ns, err := a.common.getNodes(network)
-	if err != nil {
-		return nil, err
-	}
-	if ns == nil {
-		err = a.refresh()
-		if err != nil {
-			return nil, err
-		}
-		ns, err = a.common.getNodes(network)
-	}
-	return ns, err
+	return a.common.getNodesOrRefresh(network, a.refresh)
 }
 
 // getAllNodes refreshes internal data and returns all nodes.
@@ -365,12 +363,13 @@ func (a *AWS) setNode(n *node) error {
 		n.expires.Unix(),
 		n.role,
 		n.getScramblerKey(),
-		n.cookieDomain}
+		n.cookieDomain,
+		n.weight,
+		n.getPreviousScramblerKey()}
 
 	av, err := dynamodbattribute.MarshalMap(item)
 	if err != nil {
-		fmt.Println("Got error marshalling new creator item:")
-		return err
+		return fmt.Errorf("marshalling node item: %w", err)
 	}
 
 	input := &dynamodb.PutItemInput{
@@ -380,16 +379,59 @@ func (a *AWS) setNode(n *node) error {
 
 	_, err = a.svc.PutItem(input)
 	if err != nil {
-		fmt.Println("Got error calling PutItem:")
-		return err
+		return fmt.Errorf("putting node item: %w", err)
 	}
 
 	return nil
 }
 
-func (a *AWS) refresh() error {
-	nets := make(map[string]*nodes)
+// deleteNode removes the node and its secrets from the tables and refreshes the
+// maps.
+func (a *AWS) deleteNode(domain string) error {
+	n, err := a.getNode(domain)
+	if err != nil {
+		return err
+	}
+	if n == nil {
+		return fmt.Errorf("node '%s' not found", domain)
+	}
 
+	for _, s := range n.secrets {
+		_, err = a.svc.DeleteItem(&dynamodb.DeleteItemInput{
+			Key: map[string]*dynamodb.AttributeValue{
+				domainFieldName: {
+					S: aws.String(n.domain),
+				},
+				scramblerKeyFieldName: {
+					S: aws.String(s.key),
+				},
+			},
+			TableName: aws.String(secretsTableName),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = a.svc.DeleteItem(&dynamodb.DeleteItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			networkFieldName: {
+				S: aws.String(n.network),
+			},
+			domainFieldName: {
+				S: aws.String(n.domain),
+			},
+		},
+		TableName: aws.String(nodesTableName),
+	})
+	if err != nil {
+		return err
+	}
+
+	return a.refresh()
+}
+
+func (a *AWS) refresh() error {
 	// Fetch the nodes and then add the secrets.
 	ns, err := a.fetchNodes()
 	if err != nil {
@@ -400,29 +442,9 @@ func (a *AWS) refresh() error {
 		return err
 	}
 
-	// Create a map of networks from the nodes found.
-	for _, v := range ns {
-		net := nets[v.network]
-		if net == nil {
-			net = &nodes{}
-			net.dict = make(map[string]*node)
-			nets[v.network] = net
-		}
-		net.all = append(net.all, v)
-		net.dict[v.domain] = v
-	}
-
-	// Finally sort the nodes by hash values and whether they are active.
-	for _, net := range nets {
-		net.order()
-	}
-
-	// In a single atomic operation update the reference to the networks and
-	// nodes.
-	a.mutex.Lock()
-	a.nodes = ns
-	a.networks = nets
-	a.mutex.Unlock()
+	// Build the networks and replace the nodes and networks in a single
+	// operation.
+	a.replaceNodes(ns)
 
 	return nil
 }
@@ -432,15 +454,11 @@ func (a *AWS) fetchNodes() (map[string]*node, error) {
 	ns := make(map[string]*node)
 
 	// Fetch all the records from the nodes table in Dynamo.
-	params := &dynamodb.ScanInput{
-		TableName: aws.String(nodesTableName),
-	}
+	params := a.newScanInput(nodesTableName)
 
-	result, err := a.svc.Scan(params)
+	result, err := a.scan(params)
 	if err != nil {
-		fmt.Println("Query API call failed:")
-		fmt.Println((err.Error()))
-		return nil, err
+		return nil, fmt.Errorf("scanning nodes table: %w", err)
 	}
 
 	// Iterate over the records creating nodes and adding them to the networks
@@ -450,9 +468,7 @@ func (a *AWS) fetchNodes() (map[string]*node, error) {
 
 		err = dynamodbattribute.UnmarshalMap(i, &ni)
 		if err != nil {
-			fmt.Println("Got error un-marshalling:")
-			fmt.Println(err.Error())
-			return nil, err
+			return nil, fmt.Errorf("un-marshalling node item: %w", err)
 		}
 
 		ns[ni.Domain], err = newNode(
@@ -467,23 +483,56 @@ func (a *AWS) fetchNodes() (map[string]*node, error) {
 		if err != nil {
 			return nil, err
 		}
+		ns[ni.Domain].setWeight(ni.Weight)
+		err = ns[ni.Domain].setPreviousScramblerKey(ni.Previous)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return ns, err
 }
 
+// setConsistentReads sets whether the table scans use strongly consistent reads
+// so that nodes written just before a refresh are always returned. Strongly
+// consistent reads consume twice the read capacity of eventually consistent
+// reads and may have higher latency.
+func (a *AWS) setConsistentReads(v bool) {
+	a.consistentReads = v
+}
+
+// newScanInput returns the parameters to scan all the items in the table.
+func (a *AWS) newScanInput(table string) *dynamodb.ScanInput {
+	return &dynamodb.ScanInput{
+		TableName:      aws.String(table),
+		ConsistentRead: aws.Bool(a.consistentReads),
+	}
+}
+
+// scan the table retrying with back off if there is an error.
+func (a *AWS) scan(
+	params *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	var result *dynamodb.ScanOutput
+	err := retry(
+		a.getConfig(),
+		a.attempts,
+		defaultStoreRetryDelay,
+		func() error {
+			var err error
+			result, err = a.svc.Scan(params)
+			return err
+		})
+	return result, err
+}
+
 func (a *AWS) addSecrets(ns map[string]*node) error {
 
 	// Fetch all the records from the secrets table in DynamoDB.
-	params := &dynamodb.ScanInput{
-		TableName: aws.String(secretsTableName),
-	}
+	params := a.newScanInput(secretsTableName)
 
-	result, err := a.svc.Scan(params)
+	result, err := a.scan(params)
 	if err != nil {
-		fmt.Println("Query API call failed:")
-		fmt.Println((err.Error()))
-		return err
+		return fmt.Errorf("scanning secrets table: %w", err)
 	}
 
 	// Iterate over the secrets adding them to nodes.
@@ -492,9 +541,7 @@ func (a *AWS) addSecrets(ns map[string]*node) error {
 
 		err = dynamodbattribute.UnmarshalMap(i, &secretItem)
 		if err != nil {
-			fmt.Println("Got error un-marshalling:")
-			fmt.Println(err.Error())
-			return err
+			return fmt.Errorf("un-marshalling secret item: %w", err)
 		}
 
 		s, err := newSecretFromKey(secretItem.ScramblerKey, secretItem.TimeStamp)
@@ -526,8 +573,7 @@ func (a *AWS) setNodeSecrets(n *node) error {
 
 		av, err := dynamodbattribute.MarshalMap(item)
 		if err != nil {
-			fmt.Println("Got error marshalling new creator item:")
-			return err
+			return fmt.Errorf("marshalling secret item: %w", err)
 		}
 
 		pi = append(pi, &dynamodb.WriteRequest{
@@ -17,6 +17,7 @@
 package swift
 
 import (
+	"fmt"
 	"sync"
 	"time"
 
@@ -33,14 +34,26 @@ type Azure struct {
 	timestamp    time.Time      // The last time the maps were refreshed
 	nodesTable   *storage.Table // Reference to the node table
 	secretsTable *storage.Table // Reference to the table of node secrets
+	attempts     int            // Maximum attempts for each table query
 	common
 }
 
 // NewAzure creates a new client for accessing table storage with the
 // credentials supplied.
 func NewAzure(account string, accessKey string) (*Azure, error) {
+	return NewAzureWithRetry(account, accessKey, defaultStoreRetryAttempts)
+}
+
+// NewAzureWithRetry creates a new client for accessing table storage with the
+// credentials supplied which will make up to attempts queries of each table
+// before failing a refresh.
+func NewAzureWithRetry(
+	account string,
+	accessKey string,
+	attempts int) (*Azure, error) {
 	var a Azure
 	a.name = "Azure Table Storage"
+	a.attempts = attempts
 	c, err := storage.NewBasicClient(account, accessKey)
 	if err != nil {
 		return nil, err
@@ -68,38 +81,21 @@ func (a *Azure) getName() string {
 	return a.name
 }
 
+// ping gets the nodes table to confirm table storage can be reached.
+func (a *Azure) ping() error {
+	return a.nodesTable.Get(azureTimeout, storage.NoMetadata)
+}
+
 func (a *Azure) getReadOnly() bool {
 	return false
 }
 
 func (a *Azure) getNode(domain string) (*node, error) {
-	n, err := a.common.getNode(domain)
-	if err != nil {
-		return nil, err
-	}
-	if n == nil {
-		err = a.refresh()
-		if err != nil {
-			return nil, err
-		}
-		n, err = a.common.getNode(domain)
-	}
-	return n, err
+	return a.common.getNodeOrRefresh(domain, a.refresh)
 }
 
 func (a *Azure) getNodes(network string) (*nodes, error) {
-	ns, err := a.common.getNodes(network)
-	if err != nil {
-		return nil, err
-	}
-	if ns == nil {
-		err = a.refresh()
-		if err != nil {
-			return nil, err
-		}
-		ns, err = a.common.getNodes(network)
-	}
-	return ns, err
+	return a.common.getNodesOrRefresh(network, a.refresh)
 }
 
 // getAllNodes refreshes internal data and returns all nodes.
@@ -136,9 +132,36 @@ func (a *Azure) setNode(n *node) error {
 	e.Properties[roleFieldName] = n.role
 	e.Properties[scramblerKeyFieldName] = n.getScramblerKey()
 	e.Properties[cookieDomainFieldName] = n.cookieDomain
+	e.Properties[weightFieldName] = n.weight
+	e.Properties[previousFieldName] = n.getPreviousScramblerKey()
 	return e.Insert(storage.FullMetadata, nil)
 }
 
+// deleteNode removes the node and its secrets from the tables and refreshes the
+// maps.
+func (a *Azure) deleteNode(domain string) error {
+	n, err := a.getNode(domain)
+	if err != nil {
+		return err
+	}
+	if n == nil {
+		return fmt.Errorf("node '%s' not found", domain)
+	}
+	for _, s := range n.secrets {
+		e := a.secretsTable.GetEntityReference(n.domain, s.key)
+		err = e.Delete(true, nil)
+		if err != nil {
+			return err
+		}
+	}
+	e := a.nodesTable.GetEntityReference(n.network, n.domain)
+	err = e.Delete(true, nil)
+	if err != nil {
+		return err
+	}
+	return a.refresh()
+}
+
 func azureCreateTable(t *storage.Table) error {
 	err := t.Create(azureTimeout, storage.FullMetadata, nil)
 	if err != nil {
@@ -155,8 +178,6 @@ func azureCreateTable(t *storage.Table) error {
 }
 
 func (a *Azure) refresh() error {
-	nets := make(map[string]*nodes)
-
 	// Fetch the nodes and then add the secrets.
 	ns, err := a.fetchNodes()
 	if err != nil {
@@ -167,40 +188,34 @@ func (a *Azure) refresh() error {
 		return err
 	}
 
-	// Create a map of networks from the nodes found.
-	for _, v := range ns {
-		net := nets[v.network]
-		if net == nil {
-			net = &nodes{}
-			net.dict = make(map[string]*node)
-			nets[v.network] = net
-		}
-		net.all = append(net.all, v)
-		net.dict[v.domain] = v
-	}
-
-	// Finally sort the nodes by hash values and whether they are active.
-	for _, net := range nets {
-		net.order()
-	}
-
-	// In a single atomic operation update the reference to the networks and
-	// nodes.
-	a.mutex.Lock()
-	a.nodes = ns
-	a.networks = nets
-	a.mutex.Unlock()
+	// Build the networks and replace the nodes and networks in a single
+	// operation.
+	a.replaceNodes(ns)
 
 	return nil
 }
 
+// queryEntities returns all the entities in the table retrying with back off
+// if there is an error.
+func (a *Azure) queryEntities(
+	t *storage.Table) (*storage.EntityQueryResult, error) {
+	var e *storage.EntityQueryResult
+	err := retry(
+		a.getConfig(),
+		a.attempts,
+		defaultStoreRetryDelay,
+		func() error {
+			var err error
+			e, err = t.QueryEntities(azureTimeout, storage.FullMetadata, nil)
+			return err
+		})
+	return e, err
+}
+
 func (a *Azure) addSecrets(ns map[string]*node) error {
 
 	// Fetch all the records from the secrets table in Azure.
-	e, err := a.secretsTable.QueryEntities(
-		azureTimeout,
-		storage.FullMetadata,
-		nil)
+	e, err := a.queryEntities(a.secretsTable)
 	if err != nil {
 		return err
 	}
@@ -229,10 +244,7 @@ func (a *Azure) fetchNodes() (map[string]*node, error) {
 	ns := make(map[string]*node)
 
 	// Fetch all the records from the nodes table in Azure.
-	e, err := a.nodesTable.QueryEntities(
-		azureTimeout,
-		storage.FullMetadata,
-		nil)
+	e, err := a.queryEntities(a.nodesTable)
 	if err != nil {
 		return nil, err
 	}
@@ -252,6 +264,15 @@ func (a *Azure) fetchNodes() (map[string]*node, error) {
 		if err != nil {
 			return nil, err
 		}
+		if w, ok := i.Properties[weightFieldName].(float64); ok {
+			ns[i.RowKey].setWeight(int(w))
+		}
+		if p, ok := i.Properties[previousFieldName].(string); ok {
+			err = ns[i.RowKey].setPreviousScramblerKey(p)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	return ns, err
@@ -0,0 +1,31 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import "time"
+
+// Clock provides the current time. Used so that time sensitive behavior can be
+// tested without waiting for time to pass.
+type Clock interface {
+	// Now returns the current time in UTC.
+	Now() time.Time
+}
+
+// realClock is the default clock which uses the system time.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now().UTC() }
@@ -0,0 +1,203 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock that only changes time when advanced.
+type fakeClock struct {
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func TestClockOperationTimeout(t *testing.T) {
+	c := newConfigurationTest()
+	c.StorageOperationTimeout = 30
+	s := NewServices(c, nil, nil, nil)
+	f := newFakeClock()
+	s.SetClock(f)
+	o := newOperation(s, nil)
+	if o.TimeStamp() != f.Now() {
+		fmt.Println("Operation time stamp not from clock")
+		t.Fail()
+	}
+	f.Advance(29 * time.Second)
+	if o.IsTimeStampValid() == false {
+		fmt.Println("Operation timed out early")
+		t.Fail()
+	}
+	f.Advance(2 * time.Second)
+	if o.IsTimeStampValid() {
+		fmt.Println("Operation did not time out")
+		t.Fail()
+	}
+}
+
+func TestClockCookiesValid(t *testing.T) {
+	c := newConfigurationTest()
+	c.HomeNodeTimeout = 60
+	s := NewServices(c, nil, nil, nil)
+	f := newFakeClock()
+	s.SetClock(f)
+	n, err := newResultCompressTestNode()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	o := newOperation(s, n)
+	o.table = "t"
+	var p pair
+	p.key = "a"
+	p.created = f.Now()
+	p.expires = f.Now().AddDate(0, 0, 1)
+	p.values = [][]byte{[]byte("A")}
+	p.conflict = conflictNewest
+	w := httptest.NewRecorder()
+	o.request = httptest.NewRequest("GET", "http://access.com/", nil)
+	err = o.setValueInCookie(w, o.request, &p)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	cp, err := n.getValueFromCookie(w.Result().Cookies()[0], 0)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if cp.cookieWriteTime != f.Now() {
+		fmt.Println("Cookie write time not from clock")
+		t.Fail()
+	}
+	o.resolved = []*pair{&p}
+	o.cookiePairs = []*pair{cp}
+	f.Advance(59 * time.Second)
+	if o.getCookiesValid() == false {
+		fmt.Println("Cookies invalid before home node timeout")
+		t.Fail()
+	}
+	f.Advance(2 * time.Second)
+	if o.getCookiesValid() {
+		fmt.Println("Cookies valid after home node timeout")
+		t.Fail()
+	}
+	if p.isValid(f) == false {
+		fmt.Println("Pair invalid before expiry")
+		t.Fail()
+	}
+	f.Advance(24 * time.Hour)
+	if p.isValid(f) {
+		fmt.Println("Pair valid after expiry")
+		t.Fail()
+	}
+}
+
+func TestClockHomeNode(t *testing.T) {
+	c := newConfigurationTest()
+	c.StorageOperationTimeout = 30
+	s := NewServices(c, nil, nil, nil)
+	f := &fakeClock{time.Now().UTC()}
+	s.SetClock(f)
+	ns, err := createNodes()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	h, err := s.getHomeNodeInNetwork(ns, "212.36.33.158", "127.0.0.1")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+
+	// The home node is skipped once the clock reaches its expiry.
+	h.expires = f.Now().Add(time.Hour)
+	f.Advance(time.Hour)
+	n, err := s.getHomeNodeInNetwork(ns, "212.36.33.158", "127.0.0.1")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if n == h {
+		fmt.Println("Home node expiry not checked with the clock")
+		t.Fail()
+	}
+}
+
+func TestClockCreatePair(t *testing.T) {
+	f := newFakeClock()
+	e := f.Now().AddDate(0, 0, 1).Format("2006-01-02")
+	p, err := createPair("a>"+e, "A", 0, f.Now())
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if p.created.Equal(f.Now()) == false {
+		fmt.Printf("Created '%s' not clock time '%s'\n", p.created, f.Now())
+		t.Fail()
+	}
+	f.Advance(48 * time.Hour)
+	_, err = createPair("a>"+e, "A", 0, f.Now())
+	if err == nil {
+		fmt.Println("Expiry before the clock time accepted")
+		t.Fail()
+	}
+}
+
+func TestClockMergePairs(t *testing.T) {
+	f := newFakeClock()
+	var o, c pair
+	o.key = "a"
+	o.conflict = conflictAdd
+	o.values = [][]byte{[]byte("A")}
+	c.key = "a"
+	c.values = [][]byte{[]byte("B")}
+	p, _, err := resolveConflict(&o, &c, 0, f.Now())
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if p.created.Equal(f.Now()) == false {
+		fmt.Printf("Merged created '%s' not clock time '%s'\n",
+			p.created,
+			f.Now())
+		t.Fail()
+	}
+	e := f.Now().Add(time.Minute)
+	if getClientTTL(300, e, f.Now()) != time.Minute {
+		fmt.Println("Client TTL not calculated from the clock time")
+		t.Fail()
+	}
+}
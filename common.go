@@ -18,37 +18,144 @@ package swift
 
 import (
 	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// storeRefreshDebounce is the minimum time between background refreshes
+// started because a node or network was not found.
+const storeRefreshDebounce = 10 * time.Second
+
 // common is a partial implementation of sws.Store for use with other more
 // complex implementations, and the test methods.
 type common struct {
 	nodes    map[string]*node  // Map of domain names to nodes
 	networks map[string]*nodes // Map of network names to nodes
 	mutex    *sync.Mutex       // mutual-exclusion lock used for refresh
+	// 1 while a background refresh started by a miss is in flight
+	refreshing int32
+	// Unix nano time the last background refresh was started
+	refreshStarted int64
+	// True if a miss refreshes the store before returning
+	syncRefresh bool
+	// Hash salts keyed on normalized network name used when networks are built
+	salts map[string]string
+	// Compressor assigned to nodes as they are added to the store
+	compressor Compressor
+	// The *Configuration used to log messages, set by the storage manager
+	config atomic.Value
 }
 
 func (c *common) init(ns []*node) {
-	c.nodes = make(map[string]*node)
-	c.networks = make(map[string]*nodes)
 	c.mutex = &sync.Mutex{}
+	m := make(map[string]*node)
+	for _, n := range ns {
+		m[n.domain] = n
+	}
+	c.replaceNodes(m)
+}
+
+// replaceNodes builds the networks for the nodes and then replaces the nodes
+// and networks of the store in a single operation. Networks are never changed
+// once built as they are shared with requests that are in progress.
+func (c *common) replaceNodes(ns map[string]*node) {
+	c.mutex.Lock()
+	s := c.salts
+	p := c.compressor
+	c.mutex.Unlock()
+	if p != nil {
+		for _, n := range ns {
+			n.setCompressor(p)
+		}
+	}
+	nets := newNetworks(ns, s)
+	c.mutex.Lock()
+	c.nodes = ns
+	c.networks = nets
+	c.mutex.Unlock()
+}
+
+// setSalts sets the hash salts for each network and rebuilds the networks if
+// the salts have changed. Called by the storage manager with the configured
+// salts before the store is used.
+func (c *common) setSalts(s map[string]string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if equalSalts(c.salts, s) {
+		return
+	}
+	c.salts = s
+	c.networks = newNetworks(c.nodes, s)
+}
 
+// setCompressor sets the compressor for the nodes of the store and for any
+// nodes added when the store is refreshed. Called by the storage manager with
+// the configured compressor before the store is used.
+func (c *common) setCompressor(p Compressor) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.compressor = p
+	for _, n := range c.nodes {
+		n.setCompressor(p)
+	}
+}
+
+// setConfig sets the configuration used to log messages from the store. Called
+// by the storage manager before the store is used.
+func (c *common) setConfig(x *Configuration) { c.config.Store(x) }
+
+// getConfig returns the configuration used to log messages from the store. If
+// none has been set then a default configuration is returned which logs errors
+// with the default logger.
+func (c *common) getConfig() *Configuration {
+	if x, ok := c.config.Load().(*Configuration); ok {
+		return x
+	}
+	return &Configuration{}
+}
+
+// equalSalts returns true if the salts a and b contain the same values.
+func equalSalts(a map[string]string, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; ok == false || w != v {
+			return false
+		}
+	}
+	return true
+}
+
+// newNetworks returns a map of network names to the nodes in the network. The
+// salt for each network is applied before the nodes are ordered.
+func newNetworks(
+	ns map[string]*node,
+	salts map[string]string) map[string]*nodes {
+	nets := make(map[string]*nodes)
 	for _, n := range ns {
-		c.nodes[n.domain] = n
-		net := c.networks[n.network]
+		net := nets[n.network]
 		if net == nil {
 			net = &nodes{}
 			net.dict = make(map[string]*node)
-			c.networks[n.network] = net
+			nets[n.network] = net
 		}
 		net.all = append(net.all, n)
 		net.dict[n.domain] = n
 	}
-
-	for _, net := range c.networks {
+	for k, net := range nets {
+		net.salt = salts[normalizeNetwork(k)]
 		net.order()
 	}
+	return nets
+}
+
+// deleteNode is not supported by default. Stores that support deletes must
+// provide their own implementation.
+func (c *common) deleteNode(domain string) error {
+	return fmt.Errorf("not supported")
 }
 
 // GetAccessNode returns an access node for the network, or null if there is no
@@ -73,24 +180,116 @@ func (c *common) GetAccessNode(network string) (string, error) {
 // getNode takes a domain name and returns the associated node. If a node
 // does not exist then nil is returned.
 func (c *common) getNode(domain string) (*node, error) {
+	if c.mutex != nil {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+	}
 	return c.nodes[domain], nil
 }
 
 // getNodes returns all the nodes associated with a network.
 func (c *common) getNodes(network string) (*nodes, error) {
-	return c.networks[network], nil
+	if c.mutex != nil {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+	}
+	return c.networks[normalizeNetwork(network)], nil
+}
+
+// setSyncRefresh sets whether a miss refreshes the store on the request path
+// before returning rather than in the background.
+func (c *common) setSyncRefresh(v bool) {
+	c.syncRefresh = v
+}
+
+// getNodeOrRefresh returns the node for the domain. If the node is not found
+// then the store is refreshed using the function provided. If synchronous
+// refresh is enabled the node is looked up again after the refresh, otherwise
+// the refresh happens in the background and nil is returned immediately.
+func (c *common) getNodeOrRefresh(
+	domain string,
+	refresh func() error) (*node, error) {
+	n, err := c.getNode(domain)
+	if err != nil || n != nil {
+		return n, err
+	}
+	if c.syncRefresh == false {
+		c.refreshAsync(refresh)
+		return nil, nil
+	}
+	err = refresh()
+	if err != nil {
+		return nil, err
+	}
+	return c.getNode(domain)
+}
+
+// getNodesOrRefresh returns the nodes for the network refreshing the store if
+// the network is not found in the same way as getNodeOrRefresh.
+func (c *common) getNodesOrRefresh(
+	network string,
+	refresh func() error) (*nodes, error) {
+	ns, err := c.getNodes(network)
+	if err != nil || ns != nil {
+		return ns, err
+	}
+	if c.syncRefresh == false {
+		c.refreshAsync(refresh)
+		return nil, nil
+	}
+	err = refresh()
+	if err != nil {
+		return nil, err
+	}
+	return c.getNodes(network)
 }
 
+// refreshAsync starts a background refresh unless one is already in flight or
+// one was started within the debounce period. Errors are logged as there is
+// no request to return them to.
+func (c *common) refreshAsync(refresh func() error) {
+	t := time.Now().UnixNano()
+	if t-atomic.LoadInt64(&c.refreshStarted) < int64(storeRefreshDebounce) {
+		return
+	}
+	if atomic.CompareAndSwapInt32(&c.refreshing, 0, 1) == false {
+		return
+	}
+	atomic.StoreInt64(&c.refreshStarted, t)
+	go func() {
+		defer atomic.StoreInt32(&c.refreshing, 0)
+		err := refresh()
+		if err != nil {
+			c.getConfig().errorf(
+				"SWIFT: background refresh failed: %s\n",
+				err.Error())
+		}
+	}()
+}
+
+// getAllNodes returns all the nodes ordered by network and then domain.
 func (c *common) getAllNodes() ([]*node, error) {
 	var ns []*node
 
 	for _, n := range c.nodes {
 		ns = append(ns, n)
 	}
+	sortNodes(ns)
 
 	return ns, nil
 }
 
+// sortNodes orders the nodes by network and then domain so that the order is
+// the same for every call.
+func sortNodes(ns []*node) {
+	sort.Slice(ns, func(i, j int) bool {
+		if ns[i].network != ns[j].network {
+			return ns[i].network < ns[j].network
+		}
+		return ns[i].domain < ns[j].domain
+	})
+}
+
 // getSharingNodes returns all the nodes with the role share for all networks.
 func (c *common) getSharingNodes() []*node {
 	var n []*node
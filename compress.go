@@ -17,15 +17,87 @@ package swift
 
 import (
 	"bytes"
+	"compress/gzip"
 	"compress/zlib"
 	"fmt"
+	"io"
 	"io/ioutil"
 )
 
-// compress the byte array using the zlib compression routine.
-func compress(b []byte) ([]byte, error) {
+// Names of the compression modes that can be used with Configuration.
+const (
+	compressionZlib = "zlib"
+	compressionGzip = "gzip"
+	compressionNone = "none"
+)
+
+// Compressor compresses and decompresses the byte arrays that nodes encode and
+// decode.
+type Compressor interface {
+	Compress(b []byte) ([]byte, error)
+	Decompress(b []byte) ([]byte, error)
+}
+
+// The default compressor used when none has been provided to the node.
+var defaultCompressor Compressor = &zlibCompressor{}
+
+// NewCompressor returns the compressor for the name provided. Either "zlib",
+// "gzip" or "none". An empty name returns the default zlib compressor.
+func NewCompressor(name string) (Compressor, error) {
+	switch name {
+	case "", compressionZlib:
+		return &zlibCompressor{}, nil
+	case compressionGzip:
+		return &gzipCompressor{}, nil
+	case compressionNone:
+		return &noneCompressor{}, nil
+	}
+	return nil, fmt.Errorf("Compression '%s' invalid", name)
+}
+
+// zlibCompressor uses the zlib compression routine.
+type zlibCompressor struct{}
+
+func (c *zlibCompressor) Compress(b []byte) ([]byte, error) {
+	var o bytes.Buffer
+	return compress(b, &o, zlib.NewWriter(&o))
+}
+
+func (c *zlibCompressor) Decompress(b []byte) ([]byte, error) {
+	z, err := zlib.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	return decompress(z)
+}
+
+// gzipCompressor uses the gzip compression routine for interoperability with
+// clients that do not support zlib.
+type gzipCompressor struct{}
+
+func (c *gzipCompressor) Compress(b []byte) ([]byte, error) {
 	var o bytes.Buffer
-	z := zlib.NewWriter(&o)
+	return compress(b, &o, gzip.NewWriter(&o))
+}
+
+func (c *gzipCompressor) Decompress(b []byte) ([]byte, error) {
+	z, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	return decompress(z)
+}
+
+// noneCompressor does not compress the byte array. Used with very small
+// payloads where compression adds overhead.
+type noneCompressor struct{}
+
+func (c *noneCompressor) Compress(b []byte) ([]byte, error) { return b, nil }
+
+func (c *noneCompressor) Decompress(b []byte) ([]byte, error) { return b, nil }
+
+// compress the byte array using the writer z which outputs to o.
+func compress(b []byte, o *bytes.Buffer, z io.WriteCloser) ([]byte, error) {
 	i, err := z.Write(b)
 	if err != nil {
 		return nil, err
@@ -40,13 +112,40 @@ func compress(b []byte) ([]byte, error) {
 	return o.Bytes(), nil
 }
 
-// decompress the byte array using the zlib compression routine.
-func decompress(b []byte) ([]byte, error) {
-	f := bytes.NewReader(b)
-	z, err := zlib.NewReader(f)
-	if err != nil {
-		return nil, err
-	}
+// decompress all the bytes from the reader z.
+func decompress(z io.ReadCloser) ([]byte, error) {
 	defer z.Close()
 	return ioutil.ReadAll(z)
 }
+
+// decompressAny decompresses the byte array with the compressor c. If the byte
+// array starts with a zlib or gzip header then that routine is tried first so
+// that data compressed before the compressor was changed can still be read.
+func decompressAny(c Compressor, b []byte) ([]byte, error) {
+	var h Compressor
+	if isZlib(b) {
+		h = &zlibCompressor{}
+	} else if isGzip(b) {
+		h = &gzipCompressor{}
+	}
+	if h != nil {
+		d, err := h.Decompress(b)
+		if err == nil {
+			return d, nil
+		}
+	}
+	return c.Decompress(b)
+}
+
+// isZlib returns true if the byte array starts with a valid zlib header using
+// the deflate method.
+func isZlib(b []byte) bool {
+	return len(b) >= 2 &&
+		b[0]&0x0f == 8 &&
+		(uint16(b[0])<<8|uint16(b[1]))%31 == 0
+}
+
+// isGzip returns true if the byte array starts with the gzip magic number.
+func isGzip(b []byte) bool {
+	return len(b) >= 2 && b[0] == 0x1f && b[1] == 0x8b
+}
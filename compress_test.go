@@ -0,0 +1,115 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+var testCompressData = []byte("Hello World Hello World Hello World")
+
+func TestCompressRoundTrip(t *testing.T) {
+	for _, m := range []string{
+		compressionZlib,
+		compressionGzip,
+		compressionNone} {
+		c, err := NewCompressor(m)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		b, err := c.Compress(testCompressData)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		d, err := decompressAny(c, b)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		if bytes.Equal(d, testCompressData) == false {
+			fmt.Printf("Compression '%s' did not round trip\n", m)
+			t.Fail()
+		}
+	}
+}
+
+func TestCompressBackwardCompatible(t *testing.T) {
+	b, err := defaultCompressor.Compress(testCompressData)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	for _, m := range []string{compressionGzip, compressionNone} {
+		c, err := NewCompressor(m)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		d, err := decompressAny(c, b)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		if bytes.Equal(d, testCompressData) == false {
+			fmt.Printf("Compression '%s' could not read zlib data\n", m)
+			t.Fail()
+		}
+	}
+}
+
+func TestCompressInvalid(t *testing.T) {
+	_, err := NewCompressor("lzw")
+	if err == nil {
+		fmt.Println("Invalid compression accepted")
+		t.Fail()
+	}
+}
+
+func TestCompressNode(t *testing.T) {
+	var n node
+	n.compressor = &gzipCompressor{}
+	b, err := n.encode(testCompressData)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if isGzip(b) == false {
+		fmt.Println("Node did not use gzip compressor")
+		t.Fail()
+	}
+	d, err := n.decode(b)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if bytes.Equal(d, testCompressData) == false {
+		fmt.Println("Node did not decode gzip data")
+		t.Fail()
+	}
+}
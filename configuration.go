@@ -19,6 +19,9 @@ package swift
 import (
 	"fmt"
 	"log"
+	"net/url"
+	"path"
+	"strings"
 	"time"
 
 	"github.com/SWAN-community/config-go"
@@ -30,15 +33,52 @@ type Configuration struct {
 	// If a local file with SWIFT node information is to be used the path to the
 	// file.
 	SwiftFile string `mapstructure:"swiftFile"`
+	// The base 64 URL encoded AES master key used to encrypt the node secrets
+	// in the local storage file. Empty means secrets are stored in plaintext.
+	SwiftLocalKey string `mapstructure:"swiftLocalKey"`
 	// The number of seconds between polling operations for alive checks. This
 	// is supplement to the passive check so if a node has not been accessed for
-	// more than this then it is eligible for polling.
+	// more than this then it is eligible for polling. Zero disables polling and
+	// all nodes are treated as alive.
 	AlivePollingSeconds int `mapstructure:"alivePollingSeconds"`
+	// The maximum number of seconds of random delay added to the polling
+	// interval for each node so that instances do not poll nodes at the same
+	// time. Zero means no jitter.
+	AlivePollingJitterSeconds int `mapstructure:"alivePollingJitterSeconds"`
 	// The number of seconds from creation of an operation that it is valid for.
 	// Used to prevent repeated processing of the same operation.
 	StorageOperationTimeout int `mapstructure:"storageOperationTimeout"`
 	// The number of minutes between refreshes of the storage manager.
 	StorageManagerRefreshMinutes int `mapstructure:"storageManagerRefreshMinutes"`
+	// The maximum number of attempts made to read the nodes and secrets from a
+	// cloud store during a refresh before the error is returned. Zero means
+	// the default of 3.
+	StoreRetryAttempts int `mapstructure:"storeRetryAttempts"`
+	// True if a store is refreshed on the request path when a node or network
+	// is not found. False means the refresh happens in the background and the
+	// node is reported as not found until the refresh completes.
+	SyncStoreRefresh bool `mapstructure:"syncStoreRefresh"`
+	// The number of days before a node expires that it is reported by
+	// HandlerExpiring so that it can be registered again before it leaves the
+	// network. Zero means the default of 30.
+	ExpiryWarningDays int `mapstructure:"expiryWarningDays"`
+	// True if stores read nodes with strongly consistent reads so that nodes
+	// written just before a refresh are always found. Only AWS DynamoDB offers
+	// a choice and strongly consistent scans cost twice the read capacity and
+	// may be slower. Google Firestore and Azure Table Storage reads are always
+	// strongly consistent. False by default.
+	StoreConsistentReads bool `mapstructure:"storeConsistentReads"`
+	// The cipher used for new node secrets. Either "aes-gcm" or
+	// "chacha20poly1305". Empty means "aes-gcm". Existing secrets continue to
+	// use the cipher they were created with.
+	Cipher string `mapstructure:"cipher"`
+	// The Content-Security-Policy header for all HTML responses including
+	// those of storage operations and the register and nodes pages. Empty
+	// means no header is sent unless a storage operation is CSP safe.
+	// "default" uses a policy that allows the inline styles and the inline
+	// scripts that carry the nonce of the response. Any other value is used
+	// as is with "{nonce}" replaced by the nonce of the response.
+	ContentSecurityPolicy string `mapstructure:"contentSecurityPolicy"`
 	// The maximum number of Store instances that can be referenced by a storage
 	// manager.
 	MaxStores int `mapstructure:"maxStores"`
@@ -46,6 +86,20 @@ type Configuration struct {
 	// upon to be current. Used by the home node to determine if it should
 	// consult other nodes in the network before returning it's current values.
 	HomeNodeTimeout int `mapstructure:"homeNodeTimeout"`
+	// Home node timeouts in seconds for specific tables which override
+	// HomeNodeTimeout. A value of zero for a table means the network is always
+	// consulted.
+	HomeNodeTimeoutTables map[string]int `mapstructure:"homeNodeTimeoutTables"`
+	// Secret values mixed into the hashes used to select home nodes keyed on
+	// network name. Prevents the home node for an IP address being correlated
+	// across networks. Every instance in a network must use the same salt.
+	// Networks without a salt use unsalted hashes.
+	NetworkSalts map[string]string `mapstructure:"networkSalts"`
+	// The number of addresses at the end of the X-Forwarded-For header that
+	// are added by trusted proxies. The address before them is used as the
+	// client address to select the home node. Zero means the last address in
+	// the header is used.
+	TrustedProxyCount int `mapstructure:"trustedProxyCount"`
 	// The default message to display in the user interface if one is not
 	// provided by the requestor of the storage operation.
 	Message string `mapstructure:"message"`
@@ -61,28 +115,321 @@ type Configuration struct {
 	// The progress circle color to use in the user interface if one is not
 	// provided by the requestor of the storage operation.
 	ProgressColor string `mapstructure:"progressColor"`
+	// Messages displayed in the user interface keyed on language and then
+	// message identifier. Used in preference to the built in English messages.
+	Messages map[string]map[string]string `mapstructure:"messages"`
 	// The HTTP scheme to use (HTTP for development and HTTPS for production).
 	Scheme string `mapstructure:"scheme"`
 	// The number of nodes to consult when accessing the SWIFT network.
 	NodeCount byte `mapstructure:"nodeCount"`
+	// The maximum number of times the cookie warning is shown for a single
+	// storage operation. Once reached the operation gives up and returns to
+	// the caller without storing the values. Zero means no limit.
+	MaxWarningRetries int `mapstructure:"maxWarningRetries"`
+	// The number of seconds a cookie recording the number of cookie warnings
+	// shown to the browser is kept for. If the browser keeps the cookie but
+	// does not return other cookies then the warning is not shown again once
+	// the escalation threshold is reached. Zero means no cookie is used.
+	WarningCookieSeconds int `mapstructure:"warningCookieSeconds"`
+	// The number of warnings recorded in the warning cookie after which the
+	// browser is told cookies are not supported rather than being warned
+	// again. Zero means 1.
+	WarningEscalationThreshold int `mapstructure:"warningEscalationThreshold"`
+	// The maximum number of bytes a single pair value can contain. Each pair is
+	// stored in a cookie and browsers will silently drop cookies larger than
+	// around 4KB. Zero means no limit is applied.
+	MaxValueBytes int `mapstructure:"maxValueBytes"`
+	// The maximum number of values a key with the add conflict policy can
+	// contain after the values from different nodes are merged. The oldest
+	// values are removed once the limit is reached. Zero means no limit.
+	MaxMergedValues int `mapstructure:"maxMergedValues"`
+	// The maximum number of encrypted values that can be decoded in a single
+	// batch decode request. Zero means the default of 100.
+	MaxDecodeBatchSize int `mapstructure:"maxDecodeBatchSize"`
+	// The number of seconds clients should trust a value in the results before
+	// querying the network again. Independent of the expiry of the stored
+	// value. Zero means no client TTL is provided.
+	ClientTTLSeconds int `mapstructure:"clientTTLSeconds"`
+	// True if registering a node requires a one time setup token issued via
+	// the register token API. False allows any unregistered domain to register.
+	RegisterTokenRequired bool `mapstructure:"registerTokenRequired"`
+	// The file used to persist the setup tokens that have been issued so that
+	// they remain valid after a restart. Empty means tokens are held in memory
+	// only.
+	RegisterTokenFile string `mapstructure:"registerTokenFile"`
+	// True if storage operations must name the access node that will decode
+	// the results via the accessNode parameter. False uses the access node
+	// that created the operation when no access node is provided.
+	AccessNodeRequired bool `mapstructure:"accessNodeRequired"`
+	// True if a node can not be stored when its scrambler key is already used
+	// by another node. Nodes sharing a scrambler key can unscramble each
+	// other's table names.
+	UniqueScramblerKeys bool `mapstructure:"uniqueScramblerKeys"`
+	// True if storage operations should prefer nodes that are confirmed as
+	// alive, only using other nodes if no alive node is available. Defaults to
+	// true when the configuration is created with NewConfig.
+	RequireAliveNodes bool `mapstructure:"requireAliveNodes"`
+	// The key of the pair that contains a TCF v2 style consent string. If set
+	// the consent fields are included alongside the raw value when results are
+	// decoded as JSON. Empty means no consent key.
+	ConsentKey string `mapstructure:"consentKey"`
+	// The key used to sign JWTs returned from the decode as JWT API. If empty
+	// the API is not available.
+	JWTSigningKey string `mapstructure:"jwtSigningKey"`
+	// The action to take when a node registers with a cookie domain that is
+	// already used by a node in a different network and the cookies of both
+	// nodes would collide. Either "ignore", "warn" or "reject". Empty is the
+	// same as "ignore".
+	CookieDomainOverlap string `mapstructure:"cookieDomainOverlap"`
+	// The hosts that storage operations can return to. An entry starting with
+	// "*." matches any sub domain of the domain that follows. Empty means any
+	// host is allowed.
+	AllowedReturnHosts []string `mapstructure:"allowedReturnHosts"`
+	// The origins, for example "https://example.com", that browsers can use to
+	// call the decode handlers. Empty means any origin is allowed.
+	AllowedOrigins []string `mapstructure:"allowedOrigins"`
+	// The compression used when nodes encode data. Either "zlib", "gzip" or
+	// "none". Empty is the same as "zlib". Data compressed with zlib or gzip
+	// can always be decoded.
+	Compression string `mapstructure:"compression"`
+	// The compression used for the results returned at the end of a storage
+	// operation. Either "zlib", "gzip" or "none". Empty uses the compression
+	// of the access node which encrypts the results.
+	ResultsCompression string `mapstructure:"resultsCompression"`
+	// The file used to persist writes to the store until they complete. If
+	// set then node registration does not wait for the store and failed writes
+	// are retried in the background. Empty means writes are synchronous.
+	StoreQueueFile string `mapstructure:"storeQueueFile"`
+	// The maximum number of seconds between retries of a failed store write.
+	// Zero means 60 seconds.
+	StoreQueueMaxBackoffSeconds int `mapstructure:"storeQueueMaxBackoffSeconds"`
+	// The number of attempts to write a node to the store before it is moved
+	// to the dead letter file alongside the queue file. Zero means 10.
+	StoreQueueMaxAttempts int `mapstructure:"storeQueueMaxAttempts"`
+	// The base 64 URL encoded AES key used to encrypt the queue file as it
+	// contains node secrets. Empty means SwiftLocalKey is used, and if that is
+	// also empty the file is stored in plaintext.
+	StoreQueueKey string `mapstructure:"storeQueueKey"`
+	// The number of seconds to wait for an access node to encrypt the results
+	// of a storage operation. Zero means 5 seconds.
+	AccessNodeTimeoutSeconds int `mapstructure:"accessNodeTimeoutSeconds"`
+	// The path that other nodes' API handlers are mounted at when calling them
+	// from this node. Empty means "/swift/api/v1".
+	APIBasePath string `mapstructure:"apiBasePath"`
+	// The path used for all the cookies that store values. Empty means the
+	// path is the scrambled table so that the browser only sends the cookies
+	// for the table of the operation. A shared path, such as "/", means the
+	// cookies written before the scrambler of a node was rotated are still
+	// sent and are read until the next rotation. Cookie names are scrambled so
+	// replacing the scrambler other than by rotation still loses the values.
+	// The browser sends the cookies for every table with every storage
+	// operation, so nodes receive values for tables that are not part of the
+	// operation and requests are larger. Storage operation URLs are prefixed
+	// with the path so that the browser sends the cookies. When set the table
+	// forms part of the cookie name so that values for the same key in
+	// different tables remain separate.
+	CookiePath string `mapstructure:"cookiePath"`
+	// The prefix added to the names of all the cookies set by storage
+	// operations. Used to prevent the cookies colliding with other cookies on
+	// the same domain. Changing the prefix means existing cookies are ignored.
+	// Empty means no prefix.
+	CookiePrefix string `mapstructure:"cookiePrefix"`
+	// True to add a HMAC of the table and operation to the URLs of storage
+	// operations. The table in the URL is only scrambled so without the HMAC a
+	// valid operation could be paired with a different table. All the nodes
+	// in the network must use the same setting.
+	OperationMAC bool `mapstructure:"operationMAC"`
+	// True to remember recently completed storage operations so that a
+	// repeated request for the same operation, for example when the browser
+	// is refreshed, returns to the caller without writing the cookies or
+	// visiting the nodes again.
+	ReplayCache bool `mapstructure:"replayCache"`
+	// The maximum number of completed storage operations remembered when
+	// ReplayCache is true. Zero means the default of 1000.
+	ReplayCacheSize int `mapstructure:"replayCacheSize"`
 	// True to enable debug logging and user interfaces.
 	Debug bool `mapstructure:"debug"`
+	// Used to record debug and error messages. Must be set before the services
+	// are created as the configuration is copied. Nil means the standard log
+	// package is used.
+	Logger Logger `mapstructure:"-"`
 }
 
+// cookiePrefixInvalid are the characters that can not be used in a cookie name.
+const cookiePrefixInvalid = " \t\"(),/:;<=>?@[\\]{}"
+
+// defaultAPIBasePath is the path of the API handlers if no other is configured.
+const defaultAPIBasePath = "/swift/api/v1"
+
 // HomeNodeTimeoutDuration the home node timeout as a time.Duration
 func (c *Configuration) HomeNodeTimeoutDuration() time.Duration {
 	return time.Duration(c.HomeNodeTimeout) * time.Second
 }
 
+// HomeNodeTimeoutTableDuration the home node timeout for the table as a
+// time.Duration. If the table does not have a specific timeout then the
+// global home node timeout is returned.
+func (c *Configuration) HomeNodeTimeoutTableDuration(
+	table string) time.Duration {
+	if t, ok := c.HomeNodeTimeoutTables[table]; ok {
+		return time.Duration(t) * time.Second
+	}
+	return c.HomeNodeTimeoutDuration()
+}
+
 // StorageOperationTimeoutDuration the storage operation timeout as a
 // time.Duration
 func (c *Configuration) StorageOperationTimeoutDuration() time.Duration {
 	return time.Duration(c.StorageOperationTimeout) * time.Second
 }
 
+// StoreQueueMaxBackoffDuration the maximum time between retries of a failed
+// store write as a time.Duration
+func (c *Configuration) StoreQueueMaxBackoffDuration() time.Duration {
+	if c.StoreQueueMaxBackoffSeconds == 0 {
+		return time.Minute
+	}
+	return time.Duration(c.StoreQueueMaxBackoffSeconds) * time.Second
+}
+
+// StoreQueueMaxAttemptsOrDefault the number of attempts to write a node before
+// it is moved to the dead letter file.
+func (c *Configuration) StoreQueueMaxAttemptsOrDefault() int {
+	if c.StoreQueueMaxAttempts == 0 {
+		return defaultStoreQueueMaxAttempts
+	}
+	return c.StoreQueueMaxAttempts
+}
+
+// StoreQueueKeyOrDefault the key used to encrypt the queue file, or an empty
+// string if the file is not encrypted.
+func (c *Configuration) StoreQueueKeyOrDefault() string {
+	if c.StoreQueueKey == "" {
+		return c.SwiftLocalKey
+	}
+	return c.StoreQueueKey
+}
+
+// AccessNodeTimeoutDuration the time to wait for an access node to encrypt the
+// results of a storage operation as a time.Duration
+func (c *Configuration) AccessNodeTimeoutDuration() time.Duration {
+	if c.AccessNodeTimeoutSeconds == 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(c.AccessNodeTimeoutSeconds) * time.Second
+}
+
+// WarningEscalationThresholdOrDefault the number of warnings recorded in the
+// warning cookie after which cookies are considered unsupported.
+func (c *Configuration) WarningEscalationThresholdOrDefault() int {
+	if c.WarningEscalationThreshold == 0 {
+		return 1
+	}
+	return c.WarningEscalationThreshold
+}
+
+// MaxDecodeBatchSizeOrDefault the maximum number of encrypted values in a
+// batch decode request.
+func (c *Configuration) MaxDecodeBatchSizeOrDefault() int {
+	if c.MaxDecodeBatchSize == 0 {
+		return defaultDecodeBatchSize
+	}
+	return c.MaxDecodeBatchSize
+}
+
+// ReplayCacheSizeOrDefault the maximum number of completed storage operations
+// remembered for replay.
+func (c *Configuration) ReplayCacheSizeOrDefault() int {
+	if c.ReplayCacheSize == 0 {
+		return defaultReplayCacheSize
+	}
+	return c.ReplayCacheSize
+}
+
+// StoreRetryAttemptsOrDefault the maximum number of attempts to make when
+// reading from a cloud store.
+func (c *Configuration) StoreRetryAttemptsOrDefault() int {
+	if c.StoreRetryAttempts == 0 {
+		return defaultStoreRetryAttempts
+	}
+	return c.StoreRetryAttempts
+}
+
+// CipherOrDefault the cipher used for new node secrets.
+func (c *Configuration) CipherOrDefault() string {
+	if c.Cipher == "" {
+		return cipherAESGCM
+	}
+	return c.Cipher
+}
+
+// ContentSecurityPolicyOrDefault the Content-Security-Policy for the HTML
+// responses, or empty if none is configured.
+func (c *Configuration) ContentSecurityPolicyOrDefault() string {
+	if c.ContentSecurityPolicy == contentSecurityPolicyDefault {
+		return defaultContentSecurityPolicy
+	}
+	return c.ContentSecurityPolicy
+}
+
+// ExpiryWarningDaysOrDefault the number of days before a node expires that it
+// is reported as expiring.
+func (c *Configuration) ExpiryWarningDaysOrDefault() int {
+	if c.ExpiryWarningDays == 0 {
+		return defaultExpiryWarningDays
+	}
+	return c.ExpiryWarningDays
+}
+
+// ExpiryWarningDuration the ExpiryWarningDaysOrDefault as a time.Duration.
+func (c *Configuration) ExpiryWarningDuration() time.Duration {
+	return time.Duration(c.ExpiryWarningDaysOrDefault()) * 24 * time.Hour
+}
+
+// getLogger returns the configured logger or the default logger if none is set.
+func (c *Configuration) getLogger() Logger {
+	if c.Logger == nil {
+		return defaultLogger
+	}
+	return c.Logger
+}
+
+// debugf records a debug message with the logger if debug is enabled.
+func (c *Configuration) debugf(format string, v ...interface{}) {
+	if c.Debug {
+		c.getLogger().Debugf(format, v...)
+	}
+}
+
+// errorf records an error message with the logger.
+func (c *Configuration) errorf(format string, v ...interface{}) {
+	c.getLogger().Errorf(format, v...)
+}
+
+// APIURL returns the URL of the API endpoint with the name provided at the host
+// using the configured scheme and API base path.
+func (c *Configuration) APIURL(host string, name string) *url.URL {
+	b := c.APIBasePath
+	if b == "" {
+		b = defaultAPIBasePath
+	}
+	return &url.URL{
+		Scheme: c.Scheme,
+		Host:   host,
+		Path:   path.Join(b, name)}
+}
+
+// getOperationPathPrefix returns the path that prefixes storage operation URLs
+// so that the browser sends the cookies under the configured cookie path. An
+// empty string if no cookie path is configured or the path is the root.
+func (c *Configuration) getOperationPathPrefix() string {
+	return strings.TrimSuffix(c.CookiePath, "/")
+}
+
 // NewConfig creates a new instance of configuration from the file provided.
 func NewConfig(file string) Configuration {
 	var c Configuration
+	c.RequireAliveNodes = true
 	err := config.LoadConfig([]string{"."}, file, &c)
 	if err != nil {
 		fmt.Println(err.Error())
@@ -94,6 +441,13 @@ func NewConfig(file string) Configuration {
 func (c *Configuration) Validate() error {
 	var err error
 	log.Printf("SWIFT:Debug Mode: %t\n", c.Debug)
+	log.Printf("SWIFT:RegisterTokenRequired: %t\n", c.RegisterTokenRequired)
+	if c.RegisterTokenFile != "" {
+		log.Printf("SWIFT:RegisterTokenFile: %s\n", c.RegisterTokenFile)
+	}
+	log.Printf("SWIFT:AccessNodeRequired: %t\n", c.AccessNodeRequired)
+	log.Printf("SWIFT:UniqueScramblerKeys: %t\n", c.UniqueScramblerKeys)
+	log.Printf("SWIFT:RequireAliveNodes: %t\n", c.RequireAliveNodes)
 	if err == nil {
 		if c.Message != "" {
 			log.Printf("SWIFT:Message: %s\n", c.Message)
@@ -129,6 +483,11 @@ func (c *Configuration) Validate() error {
 			err = fmt.Errorf("SWIFT ProgressColor missing in config")
 		}
 	}
+	if err == nil && len(c.Messages) > 0 {
+		for l, m := range c.Messages {
+			log.Printf("SWIFT:Messages: %s (%d)\n", l, len(m))
+		}
+	}
 	if err == nil {
 		if c.Scheme != "" {
 			log.Printf("SWIFT:Scheme: %s\n", c.Scheme)
@@ -141,6 +500,41 @@ func (c *Configuration) Validate() error {
 			err = fmt.Errorf("SWIFT Scheme invalid (https or http)")
 		}
 	}
+	if err == nil {
+		if c.AccessNodeTimeoutSeconds < 0 {
+			err = fmt.Errorf("SWIFT AccessNodeTimeoutSeconds must not be negative")
+		} else {
+			log.Printf("SWIFT:AccessNodeTimeout: %s\n",
+				c.AccessNodeTimeoutDuration())
+		}
+	}
+	if err == nil {
+		if c.APIBasePath == "" {
+			log.Printf("SWIFT:APIBasePath: %s\n", defaultAPIBasePath)
+		} else if strings.HasPrefix(c.APIBasePath, "/") {
+			log.Printf("SWIFT:APIBasePath: %s\n", c.APIBasePath)
+		} else {
+			err = fmt.Errorf("SWIFT APIBasePath must start with '/'")
+		}
+	}
+	if err == nil {
+		if c.CookiePath == "" {
+			log.Println("SWIFT:CookiePath: table")
+		} else if strings.HasPrefix(c.CookiePath, "/") {
+			log.Printf("SWIFT:CookiePath: %s\n", c.CookiePath)
+		} else {
+			err = fmt.Errorf("SWIFT CookiePath must start with '/'")
+		}
+	}
+	if err == nil {
+		if strings.ContainsAny(c.CookiePrefix, cookiePrefixInvalid) {
+			err = fmt.Errorf(
+				"SWIFT CookiePrefix must not contain any of '%s'",
+				cookiePrefixInvalid)
+		} else if c.CookiePrefix != "" {
+			log.Printf("SWIFT:CookiePrefix: %s\n", c.CookiePrefix)
+		}
+	}
 	if err == nil {
 		if c.NodeCount <= 0 {
 			err = fmt.Errorf("SWIFT NodeCount must be greater than 0")
@@ -148,6 +542,75 @@ func (c *Configuration) Validate() error {
 			log.Printf("SWIFT:NodeCount: %d\n", c.NodeCount)
 		}
 	}
+	if err == nil {
+		if c.TrustedProxyCount < 0 {
+			err = fmt.Errorf("SWIFT TrustedProxyCount must be 0 or positive")
+		} else {
+			log.Printf("SWIFT:TrustedProxyCount: %d\n", c.TrustedProxyCount)
+		}
+	}
+	if err == nil {
+		if c.MaxValueBytes < 0 {
+			err = fmt.Errorf("SWIFT MaxValueBytes must be 0 or positive")
+		} else {
+			log.Printf("SWIFT:MaxValueBytes: %d\n", c.MaxValueBytes)
+		}
+	}
+	if err == nil {
+		if c.MaxMergedValues < 0 {
+			err = fmt.Errorf("SWIFT MaxMergedValues must be 0 or positive")
+		} else {
+			log.Printf("SWIFT:MaxMergedValues: %d\n", c.MaxMergedValues)
+		}
+	}
+	if err == nil {
+		if c.MaxDecodeBatchSize < 0 {
+			err = fmt.Errorf("SWIFT MaxDecodeBatchSize must be 0 or positive")
+		} else {
+			log.Printf("SWIFT:MaxDecodeBatchSize: %d\n",
+				c.MaxDecodeBatchSizeOrDefault())
+		}
+	}
+	if err == nil {
+		if c.ReplayCacheSize < 0 {
+			err = fmt.Errorf("SWIFT ReplayCacheSize must be 0 or positive")
+		} else if c.ReplayCache {
+			log.Printf("SWIFT:ReplayCacheSize: %d\n",
+				c.ReplayCacheSizeOrDefault())
+		} else {
+			log.Println("SWIFT:ReplayCache: disabled")
+		}
+	}
+	if err == nil {
+		if c.ClientTTLSeconds < 0 {
+			err = fmt.Errorf("SWIFT ClientTTLSeconds must be 0 or positive")
+		} else {
+			log.Printf("SWIFT:ClientTTLSeconds: %d\n", c.ClientTTLSeconds)
+		}
+	}
+	if err == nil {
+		_, err = NewCompressor(c.Compression)
+		if err == nil {
+			log.Printf("SWIFT:Compression: %s\n", c.Compression)
+		}
+	}
+	if err == nil && c.ResultsCompression != "" {
+		_, err = packResults([]byte{}, c.ResultsCompression)
+		if err == nil {
+			log.Printf("SWIFT:ResultsCompression: %s\n", c.ResultsCompression)
+		}
+	}
+	if err == nil {
+		switch c.CookieDomainOverlap {
+		case "", cookieDomainOverlapIgnore,
+			cookieDomainOverlapWarn,
+			cookieDomainOverlapReject:
+			log.Printf("SWIFT:CookieDomainOverlap: %s\n", c.CookieDomainOverlap)
+		default:
+			err = fmt.Errorf(
+				"SWIFT CookieDomainOverlap invalid (ignore, warn or reject)")
+		}
+	}
 	if err == nil {
 		if c.StorageOperationTimeout <= 0 {
 			err = fmt.Errorf("SWIFT storageOperationTimeout must be greater than 0")
@@ -160,13 +623,34 @@ func (c *Configuration) Validate() error {
 			err = fmt.Errorf("SWIFT HomeNodeTimeout must be greater than 0")
 		}
 	}
+	if err == nil {
+		for k, v := range c.HomeNodeTimeoutTables {
+			if v < 0 {
+				err = fmt.Errorf(
+					"SWIFT HomeNodeTimeoutTables '%s' must be 0 or positive", k)
+				break
+			}
+			log.Printf("SWIFT:HomeNodeTimeoutTables: %s %d\n", k, v)
+		}
+	}
 	if err == nil {
 		if c.AlivePollingSeconds < 0 {
-			err = fmt.Errorf("SWIFT AlivePollingSeconds must 0 or positive")
+			err = fmt.Errorf("SWIFT AlivePollingSeconds must be 0 or positive")
+		} else if c.AlivePollingSeconds == 0 {
+			log.Println("SWIFT:AlivePollingSeconds: disabled")
 		} else {
 			log.Printf("SWIFT:AlivePollingSeconds: %d\n", c.AlivePollingSeconds)
 		}
 	}
+	if err == nil {
+		if c.AlivePollingJitterSeconds < 0 {
+			err = fmt.Errorf(
+				"SWIFT AlivePollingJitterSeconds must be 0 or positive")
+		} else {
+			log.Printf("SWIFT:AlivePollingJitterSeconds: %d\n",
+				c.AlivePollingJitterSeconds)
+		}
+	}
 	if err == nil {
 		if c.StorageManagerRefreshMinutes <= 0 {
 			err = fmt.Errorf("SWIFT StorageManagerRefreshMinutes must be greater than 0")
@@ -174,5 +658,71 @@ func (c *Configuration) Validate() error {
 			log.Printf("SWIFT:StorageManagerRefreshMinutes: %d\n", c.StorageManagerRefreshMinutes)
 		}
 	}
+	if err == nil {
+		if c.WarningCookieSeconds < 0 {
+			err = fmt.Errorf("SWIFT WarningCookieSeconds must not be negative")
+		} else if c.WarningEscalationThreshold < 0 {
+			err = fmt.Errorf(
+				"SWIFT WarningEscalationThreshold must not be negative")
+		} else if c.WarningCookieSeconds > 0 {
+			log.Printf("SWIFT:WarningCookieSeconds: %d\n",
+				c.WarningCookieSeconds)
+			log.Printf("SWIFT:WarningEscalationThreshold: %d\n",
+				c.WarningEscalationThresholdOrDefault())
+		}
+	}
+	if err == nil {
+		if c.StoreRetryAttempts < 0 {
+			err = fmt.Errorf("SWIFT StoreRetryAttempts must not be negative")
+		} else {
+			log.Printf("SWIFT:StoreRetryAttempts: %d\n",
+				c.StoreRetryAttemptsOrDefault())
+			log.Printf("SWIFT:SyncStoreRefresh: %t\n", c.SyncStoreRefresh)
+			log.Printf("SWIFT:StoreConsistentReads: %t\n",
+				c.StoreConsistentReads)
+		}
+	}
+	if err == nil {
+		if c.ExpiryWarningDays < 0 {
+			err = fmt.Errorf("SWIFT ExpiryWarningDays must not be negative")
+		} else {
+			log.Printf("SWIFT:ExpiryWarningDays: %d\n",
+				c.ExpiryWarningDaysOrDefault())
+		}
+	}
+	if err == nil {
+		if _, ok := aeadFactories[c.CipherOrDefault()]; !ok {
+			err = fmt.Errorf("SWIFT Cipher '%s' not supported", c.Cipher)
+		} else {
+			log.Printf("SWIFT:Cipher: %s\n", c.CipherOrDefault())
+		}
+	}
+	if err == nil && c.ContentSecurityPolicy != "" {
+		log.Printf("SWIFT:ContentSecurityPolicy: %s\n",
+			c.ContentSecurityPolicyOrDefault())
+	}
+	if err == nil {
+		if c.MaxWarningRetries < 0 || c.MaxWarningRetries > 255 {
+			err = fmt.Errorf("SWIFT MaxWarningRetries must be between 0 and 255")
+		} else {
+			log.Printf("SWIFT:MaxWarningRetries: %d\n", c.MaxWarningRetries)
+		}
+	}
+	if err == nil {
+		if c.StoreQueueMaxBackoffSeconds < 0 {
+			err = fmt.Errorf("SWIFT StoreQueueMaxBackoffSeconds must be 0 or positive")
+		} else if c.StoreQueueMaxAttempts < 0 {
+			err = fmt.Errorf("SWIFT StoreQueueMaxAttempts must be 0 or positive")
+		} else if c.StoreQueueFile != "" {
+			log.Printf("SWIFT:StoreQueueFile: %s\n", c.StoreQueueFile)
+			log.Printf("SWIFT:StoreQueueMaxBackoffSeconds: %d\n",
+				c.StoreQueueMaxBackoffSeconds)
+			log.Printf("SWIFT:StoreQueueMaxAttempts: %d\n",
+				c.StoreQueueMaxAttemptsOrDefault())
+			if c.StoreQueueKeyOrDefault() == "" {
+				log.Println("SWIFT:StoreQueueKey: none, file not encrypted")
+			}
+		}
+	}
 	return err
 }
@@ -16,7 +16,14 @@
 
 package swift
 
-import "testing"
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
 
 func TestLocalConfigurationSettings(t *testing.T) {
 	c := NewConfig("appsettings.test.local.json")
@@ -134,3 +141,140 @@ func newConfigurationTest() Configuration {
 	c.StorageManagerRefreshMinutes = 10
 	return c
 }
+
+func TestLocalKeyConfigurationEnvironment(t *testing.T) {
+	e := "TEST ENV SWIFT LOCAL KEY"
+	t.Setenv("SWIFT_LOCAL_KEY", e)
+	c := NewConfig("appsettings.test.none.json")
+	if c.SwiftLocalKey != e {
+		t.Error("SWIFT local key not expected value")
+		return
+	}
+}
+
+func TestRequireAliveNodesDefault(t *testing.T) {
+	c := NewConfig("appsettings.test.none.json")
+	if c.RequireAliveNodes == false {
+		t.Error("Require alive nodes not true by default")
+		return
+	}
+}
+
+func TestAPIBasePath(t *testing.T) {
+	c := newConfigurationTest()
+	c.Scheme = "https"
+	for _, d := range []struct {
+		base     string
+		name     string
+		expected string
+	}{
+		{"", "encrypt", "https://a.com/swift/api/v1/encrypt"},
+		{"/gateway/swift", "encrypt", "https://a.com/gateway/swift/encrypt"},
+		{"/gateway/swift/", "share", "https://a.com/gateway/swift/share"},
+		{"/gateway/swift", "alive", "https://a.com/gateway/swift/alive"},
+	} {
+		c.APIBasePath = d.base
+		u := c.APIURL("a.com", d.name).String()
+		if u != d.expected {
+			t.Errorf("Expected '%s' but got '%s'", d.expected, u)
+		}
+	}
+}
+
+func TestAPIBasePathShare(t *testing.T) {
+	var p string
+	h := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			p = r.URL.Path
+		}))
+	defer h.Close()
+	u, err := url.Parse(h.URL)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	c := newConfigurationTest()
+	c.Scheme = "http"
+	c.APIBasePath = "/gateway/swift"
+	n, err := newStoreQueueTestNode(u.Host)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	callShare(n, &c)
+	if p != "/gateway/swift/share" {
+		t.Errorf("Share called at '%s'", p)
+	}
+}
+
+func TestConfigurationHomeNodeTimeoutTables(t *testing.T) {
+	c := newConfigurationTest()
+	c.HomeNodeTimeout = 60
+	c.HomeNodeTimeoutTables = map[string]int{"fast": 0, "slow": 3600}
+	s := NewServices(c, nil, nil, nil)
+	f := newFakeClock()
+	s.SetClock(f)
+	n, err := newResultCompressTestNode()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	o := newOperation(s, n)
+	var p pair
+	p.key = "a"
+	p.created = f.Now()
+	p.expires = f.Now().AddDate(0, 0, 1)
+	p.values = [][]byte{[]byte("A")}
+	p.conflict = conflictNewest
+	w := httptest.NewRecorder()
+	o.request = httptest.NewRequest("GET", "http://access.com/", nil)
+	err = o.setValueInCookie(w, o.request, &p)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	cp, err := n.getValueFromCookie(w.Result().Cookies()[0], 0)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	o.resolved = []*pair{&p}
+	o.cookiePairs = []*pair{cp}
+	f.Advance(120 * time.Second)
+	o.table = "other"
+	if o.getCookiesValid() {
+		fmt.Println("Cookies valid after global home node timeout")
+		t.Fail()
+	}
+	o.table = "slow"
+	if o.getCookiesValid() == false {
+		fmt.Println("Cookies invalid before table home node timeout")
+		t.Fail()
+	}
+	f.Advance(-120 * time.Second)
+	o.table = "fast"
+	if o.getCookiesValid() {
+		fmt.Println("Cookies valid for table with zero home node timeout")
+		t.Fail()
+	}
+}
+
+func TestConfigurationHomeNodeTimeoutTablesNegative(t *testing.T) {
+	c := newConfigurationTest()
+	c.HomeNodeTimeout = 60
+	c.NodeCount = 10
+	c.StorageOperationTimeout = 30
+	c.HomeNodeTimeoutTables = map[string]int{"slow": -1}
+	err := c.Validate()
+	if err == nil {
+		t.Error("expected negative HomeNodeTimeoutTables to be invalid")
+		return
+	}
+	e := "SWIFT HomeNodeTimeoutTables 'slow' must be 0 or positive"
+	if err.Error() != e {
+		t.Errorf("Expected '%s' but got '%s'", e, err.Error())
+	}
+}
@@ -0,0 +1,116 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"sync"
+)
+
+// ConflictStats is a snapshot of the number of times a value from a storage
+// operation and a value from a cookie have been resolved since the services
+// were created. Used to identify keys that are frequently in conflict across
+// nodes.
+type ConflictStats struct {
+	// Number of conflicts resolved keyed on the policy name, for example
+	// "newest", "oldest", "add" or "invalid".
+	Resolved map[string]uint64 `json:"resolved"`
+	// Number of conflicts with the add policy where the values differed and a
+	// new merged list of values was created.
+	Merged uint64 `json:"merged"`
+	// Number of pairs that add values where the list of values was longer
+	// than the configured maximum and the oldest values were removed.
+	Truncated uint64 `json:"truncated"`
+}
+
+// conflictCounters is a concurrency safe record of the conflicts resolved.
+type conflictCounters struct {
+	resolved  map[string]uint64
+	merged    uint64
+	truncated uint64
+	mutex     *sync.Mutex
+}
+
+func newConflictCounters() *conflictCounters {
+	var c conflictCounters
+	c.resolved = make(map[string]uint64)
+	c.mutex = &sync.Mutex{}
+	return &c
+}
+
+// record increments the counter for the policy if one is provided, the merged
+// counter if a merge occurred, and the truncated counter if the merged values
+// were truncated.
+func (c *conflictCounters) record(policy string, merged bool, truncated bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if policy != "" {
+		c.resolved[policy]++
+	}
+	if merged {
+		c.merged++
+	}
+	if truncated {
+		c.truncated++
+	}
+}
+
+// snapshot returns a copy of the counters.
+func (c *conflictCounters) snapshot() ConflictStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var s ConflictStats
+	s.Resolved = make(map[string]uint64, len(c.resolved))
+	for k, v := range c.resolved {
+		s.Resolved[k] = v
+	}
+	s.Merged = c.merged
+	s.Truncated = c.truncated
+	return s
+}
+
+// ConflictStats returns a snapshot of the number of conflicts resolved by each
+// policy since the services were created.
+func (s *Services) ConflictStats() ConflictStats {
+	return s.conflicts.snapshot()
+}
+
+// resolveConflict resolves the conflict between the operation pair o and the
+// cookie pair c recording the policy used if both are present. Pairs that add
+// values are limited to the configured maximum number of values with the
+// oldest values removed.
+func (s *Services) resolveConflict(o *pair, c *pair) (*pair, error) {
+	p, t, err := resolveConflict(
+		o,
+		c,
+		s.config.MaxMergedValues,
+		s.clock.Now())
+	if t {
+		s.config.debugf(
+			"SWIFT:merged values for key '%s' truncated to '%d'\n",
+			p.key,
+			s.config.MaxMergedValues)
+	}
+	if o != nil && c != nil {
+		m := o.conflict == conflictAdd &&
+			err == nil &&
+			valuesEqual(o.values, c.values) == false
+		s.conflicts.record(o.Conflict(), m, t)
+	} else if t {
+		s.conflicts.record("", false, t)
+	}
+	return p, err
+}
@@ -0,0 +1,139 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestConflictStats(t *testing.T) {
+	s := NewServices(newConfigurationTest(), nil, nil, nil)
+	n := time.Now().UTC()
+	for _, d := range []struct {
+		conflict byte
+		o        string
+		c        string
+	}{
+		{conflictNewest, "a", "b"},
+		{conflictNewest, "a", "a"},
+		{conflictAdd, "a", "b"}, // merged
+		{conflictAdd, "a", "a"}} {
+		o := &pair{}
+		o.key = "k"
+		o.conflict = d.conflict
+		o.created = n
+		o.values = [][]byte{[]byte(d.o)}
+		c := &pair{}
+		c.key = "k"
+		c.conflict = d.conflict
+		c.created = n.Add(time.Second)
+		c.values = [][]byte{[]byte(d.c)}
+		_, err := s.resolveConflict(o, c)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+	}
+
+	// A pair without a cookie is not a conflict.
+	_, err := s.resolveConflict(&pair{}, nil)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+
+	x := s.ConflictStats()
+	if x.Resolved["newest"] != 2 ||
+		x.Resolved["add"] != 2 ||
+		len(x.Resolved) != 2 ||
+		x.Merged != 1 {
+		fmt.Printf("Conflict stats '%v' incorrect\n", x)
+		t.Fail()
+	}
+}
+
+func TestConflictMaxMergedValues(t *testing.T) {
+	c := newConfigurationTest()
+	c.MaxMergedValues = 2
+	s := NewServices(c, nil, nil, nil)
+	o := &pair{}
+	o.key = "k"
+	o.conflict = conflictAdd
+	o.values = [][]byte{[]byte("new")}
+	k := &pair{}
+	k.key = "k"
+	k.conflict = conflictAdd
+	k.values = [][]byte{[]byte("a"), []byte("b")}
+	p, err := s.resolveConflict(o, k)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if len(p.values) != 2 ||
+		string(p.values[0]) != "new" ||
+		string(p.values[1]) != "a" {
+		fmt.Printf("Merged values '%s' not truncated\n", p.values)
+		t.Fail()
+	}
+	if s.ConflictStats().Truncated != 1 {
+		fmt.Println("Truncation not recorded")
+		t.Fail()
+	}
+}
+
+// TestConflictMaxMergedValuesUnmerged confirms that a pair which adds values is
+// limited to the maximum number of values when there is no cookie pair or the
+// cookie pair has the same values, and that the operation pair is unchanged.
+func TestConflictMaxMergedValuesUnmerged(t *testing.T) {
+	c := newConfigurationTest()
+	c.MaxMergedValues = 2
+	s := NewServices(c, nil, nil, nil)
+	o := &pair{}
+	o.key = "k"
+	o.conflict = conflictAdd
+	o.values = [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	k := &pair{}
+	k.key = "k"
+	k.conflict = conflictAdd
+	k.values = o.values
+	for _, x := range []*pair{nil, k} {
+		p, err := s.resolveConflict(o, x)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		if len(p.values) != 2 || string(p.values[1]) != "b" {
+			fmt.Printf("Values '%s' not truncated\n", p.values)
+			t.Fail()
+		}
+	}
+	if len(o.values) != 3 || len(k.values) != 3 {
+		fmt.Println("Operation or cookie pair modified")
+		t.Fail()
+	}
+	x := s.ConflictStats()
+	if x.Truncated != 2 || x.Merged != 0 || len(x.Resolved) != 1 {
+		fmt.Printf("Conflict stats '%v' incorrect\n", x)
+		t.Fail()
+	}
+}
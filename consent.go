@@ -0,0 +1,132 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ValueParser parses a stored value into structured fields that are included
+// alongside the raw value when results are returned as JSON.
+type ValueParser interface {
+	// Parse returns the structured form of the value or an error if the value
+	// is not in the expected format.
+	Parse(v []byte) (interface{}, error)
+}
+
+// Number of bits in the fixed length fields of the core segment.
+const consentCoreBits = 200
+
+// Consent contains the fixed length fields from the core segment of a TCF v2
+// style consent string.
+type Consent struct {
+	Version                int       `json:"version"`
+	Created                time.Time `json:"created"`
+	LastUpdated            time.Time `json:"lastUpdated"`
+	CmpID                  int       `json:"cmpId"`
+	CmpVersion             int       `json:"cmpVersion"`
+	ConsentScreen          int       `json:"consentScreen"`
+	ConsentLanguage        string    `json:"consentLanguage"`
+	VendorListVersion      int       `json:"vendorListVersion"`
+	PolicyVersion          int       `json:"policyVersion"`
+	IsServiceSpecific      bool      `json:"isServiceSpecific"`
+	UseNonStandardStacks   bool      `json:"useNonStandardStacks"`
+	SpecialFeatureOptIns   []int     `json:"specialFeatureOptIns"`
+	PurposesConsent        []int     `json:"purposesConsent"`
+	PurposesLITransparency []int     `json:"purposesLITransparency"`
+}
+
+// consentParser parses TCF v2 style consent strings.
+type consentParser struct{}
+
+// Parse the core segment of the consent string v.
+func (consentParser) Parse(v []byte) (interface{}, error) {
+	return parseConsent(string(v))
+}
+
+// parseConsent returns the fixed length fields from the core segment of the
+// consent string s. Any other segments are ignored.
+func parseConsent(s string) (*Consent, error) {
+	var c Consent
+	d, err := base64.RawURLEncoding.DecodeString(
+		strings.TrimRight(strings.SplitN(s, ".", 2)[0], "="))
+	if err != nil {
+		return nil, err
+	}
+	if len(d)*8 < consentCoreBits {
+		return nil, fmt.Errorf("consent string too short")
+	}
+	b := consentBits{data: d}
+	c.Version = b.readInt(6)
+	if c.Version != 2 {
+		return nil, fmt.Errorf("consent version '%d' not supported", c.Version)
+	}
+	c.Created = b.readTime()
+	c.LastUpdated = b.readTime()
+	c.CmpID = b.readInt(12)
+	c.CmpVersion = b.readInt(12)
+	c.ConsentScreen = b.readInt(6)
+	c.ConsentLanguage = string([]byte{
+		byte('A' + b.readInt(6)),
+		byte('A' + b.readInt(6))})
+	c.VendorListVersion = b.readInt(12)
+	c.PolicyVersion = b.readInt(6)
+	c.IsServiceSpecific = b.readInt(1) == 1
+	c.UseNonStandardStacks = b.readInt(1) == 1
+	c.SpecialFeatureOptIns = b.readBitField(12)
+	c.PurposesConsent = b.readBitField(24)
+	c.PurposesLITransparency = b.readBitField(24)
+	return &c, nil
+}
+
+// consentBits reads big endian bit fields from a byte array. The caller must
+// check there are enough bits available.
+type consentBits struct {
+	data []byte
+	pos  int
+}
+
+func (b *consentBits) readInt(n int) int {
+	v := 0
+	for i := 0; i < n; i++ {
+		v <<= 1
+		if b.data[b.pos/8]&(0x80>>uint(b.pos%8)) != 0 {
+			v |= 1
+		}
+		b.pos++
+	}
+	return v
+}
+
+// readTime reads a time stored as deciseconds since the epoch.
+func (b *consentBits) readTime() time.Time {
+	return time.Unix(0, int64(b.readInt(36))*int64(100*time.Millisecond)).UTC()
+}
+
+// readBitField returns the one based ids of the bits set in a field of n bits.
+func (b *consentBits) readBitField(n int) []int {
+	a := []int{}
+	for i := 1; i <= n; i++ {
+		if b.readInt(1) == 1 {
+			a = append(a, i)
+		}
+	}
+	return a
+}
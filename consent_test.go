@@ -0,0 +1,139 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestConsentWellFormed(t *testing.T) {
+	c := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	m, err := testConsentJSON([]byte(testConsentString(c, 42, []int{1, 3})))
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	a, ok := m["parsed"].([]interface{})
+	if ok == false || len(a) != 1 {
+		fmt.Println("Parsed consent missing")
+		t.Fail()
+		return
+	}
+	p := a[0].(map[string]interface{})
+	if p["version"].(float64) != 2 ||
+		p["cmpId"].(float64) != 42 ||
+		p["consentLanguage"].(string) != "EN" ||
+		p["created"].(string) != c.Format(time.RFC3339) {
+		fmt.Printf("Parsed consent '%v' incorrect\n", p)
+		t.Fail()
+	}
+	u := p["purposesConsent"].([]interface{})
+	if len(u) != 2 || u[0].(float64) != 1 || u[1].(float64) != 3 {
+		fmt.Printf("Purposes '%v' not '[1 3]'\n", u)
+		t.Fail()
+	}
+}
+
+func TestConsentMalformed(t *testing.T) {
+	v := []byte("not a consent string")
+	m, err := testConsentJSON(v)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if m["parsed"] != nil {
+		fmt.Println("Malformed consent parsed")
+		t.Fail()
+	}
+	a, ok := m["values"].([]interface{})
+	if ok == false ||
+		len(a) != 1 ||
+		a[0].(string) != base64.StdEncoding.EncodeToString(v) {
+		fmt.Println("Raw value not returned")
+		t.Fail()
+	}
+}
+
+// testConsentJSON returns the JSON for the consent pair after the results
+// containing the value v have been parsed.
+func testConsentJSON(v []byte) (map[string]interface{}, error) {
+	c := newConfigurationTest()
+	c.ConsentKey = "consent"
+	s := NewServices(c, nil, nil, nil)
+	r := newResultsTest(time.Now().UTC().Add(time.Minute))
+	r.pairs = []*Pair{{key: "consent", values: [][]byte{v}}}
+	s.parseValues(r)
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	var m struct {
+		Pairs []map[string]interface{} `json:"pairs"`
+	}
+	err = json.Unmarshal(b, &m)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Pairs) != 1 {
+		return nil, fmt.Errorf("'%d' pairs returned", len(m.Pairs))
+	}
+	return m.Pairs[0], nil
+}
+
+// testConsentString returns a version 2 consent string with the created time,
+// CMP id and purposes consented to provided.
+func testConsentString(c time.Time, cmp int, purposes []int) string {
+	var b []bool
+	w := func(v int, n int) {
+		for i := n - 1; i >= 0; i-- {
+			b = append(b, (v>>uint(i))&1 == 1)
+		}
+	}
+	d := int(c.UnixNano() / int64(100*time.Millisecond))
+	w(2, 6)
+	w(d, 36)
+	w(d, 36)
+	w(cmp, 12)
+	w(1, 12)
+	w(1, 6)
+	w('E'-'A', 6)
+	w('N'-'A', 6)
+	w(1, 12)
+	w(2, 6)
+	w(0, 1)
+	w(0, 1)
+	w(0, 12)
+	p := 0
+	for _, i := range purposes {
+		p |= 1 << uint(24-i)
+	}
+	w(p, 24)
+	w(0, 24)
+	a := make([]byte, (len(b)+7)/8)
+	for i, v := range b {
+		if v {
+			a[i/8] |= 0x80 >> uint(i%8)
+		}
+	}
+	return base64.RawURLEncoding.EncodeToString(a)
+}
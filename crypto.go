@@ -22,33 +22,63 @@ import (
 	"crypto/rand"
 	"fmt"
 	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Identifiers for the ciphers used to encrypt data with a secret.
+const (
+	cipherAESGCM           = "aes-gcm"          // AES in Galois Counter Mode
+	cipherChaCha20Poly1305 = "chacha20poly1305" // ChaCha20-Poly1305
 )
 
-// crypto structure containing AES ciphers.
+// aeadFactories are the functions used to create an AEAD for each cipher
+// identifier from a key.
+var aeadFactories = map[string]func(key []byte) (cipher.AEAD, error){
+	cipherAESGCM:           newAESGCM,
+	cipherChaCha20Poly1305: chacha20poly1305.New}
+
+// crypto structure containing the AEAD cipher.
 type crypto struct {
-	gcm cipher.AEAD
+	aead cipher.AEAD
 }
 
 // newCrypto creates a new instance of the security structure used to encrypt
-// and decrypt data using rotating shared secret keys.
+// and decrypt data using rotating shared secret keys with AES-GCM.
 func newCrypto(key []byte) (*crypto, error) {
+	return newCryptoWithCipher(cipherAESGCM, key)
+}
+
+// newCryptoWithCipher creates a new instance of the security structure for
+// the cipher identifier c and the key.
+func newCryptoWithCipher(c string, key []byte) (*crypto, error) {
 	var x crypto
-	i, err := aes.NewCipher(key)
+	f, ok := aeadFactories[c]
+	if !ok {
+		return nil, fmt.Errorf("cipher '%s' not supported", c)
+	}
+	var err error
+	x.aead, err = f(key)
 	if err != nil {
 		return nil, err
 	}
-	x.gcm, err = cipher.NewGCM(i)
+	return &x, nil
+}
+
+// newAESGCM returns AES in Galois Counter Mode for the key.
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	i, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
-	return &x, nil
+	return cipher.NewGCM(i)
 }
 
 // decrypt the byte array b returning the decrypted byte array.
 //
 // b the byte array previous generated via the encrypt method.
 func (x *crypto) decrypt(b []byte) ([]byte, error) {
-	nonceSize := x.gcm.NonceSize()
+	nonceSize := x.aead.NonceSize()
 	if len(b) < nonceSize {
 		return nil, fmt.Errorf(
 			"data length '%d' shorter than nonce '%d'",
@@ -56,7 +86,7 @@ func (x *crypto) decrypt(b []byte) ([]byte, error) {
 			nonceSize)
 	}
 	nonce, c := b[:nonceSize], b[nonceSize:]
-	d, err := x.gcm.Open(nil, nonce, c, nil)
+	d, err := x.aead.Open(nil, nonce, c, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -74,7 +104,7 @@ func (x *crypto) encryptWithNonce(b []byte, n []byte) []byte {
 	// additional data and appends the result to dst, returning the updated
 	// slice. The nonce must be NonceSize() bytes long and unique for all
 	// time, for a given key.
-	return x.gcm.Seal(n, n, b, nil)
+	return x.aead.Seal(n, n, b, nil)
 }
 
 // encrypt the byte array b with random nonce.
@@ -84,7 +114,7 @@ func (x *crypto) encrypt(b []byte) ([]byte, error) {
 
 	// Create nonce with a cryptographically secure random sequence. Nonce
 	// should never be repeated.
-	n, err := randomBytes(x.gcm.NonceSize())
+	n, err := randomBytes(x.aead.NonceSize())
 	if err != nil {
 		return nil, err
 	}
@@ -18,6 +18,7 @@ package swift
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"testing"
 )
@@ -76,3 +77,52 @@ func testCryptoByteArray(i []byte) ([]byte, error) {
 	}
 	return x.decrypt(c)
 }
+
+func TestCryptoUnknownCipher(t *testing.T) {
+	_, err := newCryptoWithCipher("unknown", testSecret)
+	if err == nil {
+		fmt.Println("Unknown cipher did not return an error")
+		t.Fail()
+	}
+}
+
+func TestSecretCipherRoundTrip(t *testing.T) {
+	for _, c := range []string{cipherAESGCM, cipherChaCha20Poly1305} {
+		s, err := newSecretWithCipher(c)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		e, err := s.crypto.encrypt([]byte("Share Web State"))
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+
+		// Round trip the secret via JSON as the stores do.
+		j, err := json.Marshal(s)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		var r secret
+		err = json.Unmarshal(j, &r)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		if r.cipher != c {
+			fmt.Printf("Cipher '%s' read back as '%s'\n", c, r.cipher)
+			t.Fail()
+		}
+		d, err := r.crypto.decrypt(e)
+		if err != nil || string(d) != "Share Web State" {
+			fmt.Printf("Cipher '%s' did not decrypt after round trip\n", c)
+			t.Fail()
+		}
+	}
+}
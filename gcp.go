@@ -18,6 +18,7 @@ package swift
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"sync"
 	"time"
@@ -32,13 +33,21 @@ type Firebase struct {
 	name      string
 	timestamp time.Time         // The last time the maps were refreshed
 	client    *firestore.Client // Firebase app
+	attempts  int               // Maximum attempts for each collection read
 	common
 }
 
 // NewFirebase creates a new instance of the Firebase structure
 func NewFirebase(project string) (*Firebase, error) {
+	return NewFirebaseWithRetry(project, defaultStoreRetryAttempts)
+}
+
+// NewFirebaseWithRetry creates a new instance of the Firebase structure which
+// will make up to attempts reads of each collection before failing a refresh.
+func NewFirebaseWithRetry(project string, attempts int) (*Firebase, error) {
 	var f Firebase
 	f.name = "Google Firebase"
+	f.attempts = attempts
 	ctx := context.Background()
 	conf := &firebase.Config{ProjectID: project}
 	app, err := firebase.NewApp(ctx, conf)
@@ -62,38 +71,29 @@ func (f *Firebase) getName() string {
 	return f.name
 }
 
+// ping reads a single document from the nodes collection to confirm Firebase
+// can be reached.
+func (f *Firebase) ping() error {
+	_, err := f.client.Collection(nodesTableName).
+		Limit(1).
+		Documents(context.Background()).
+		Next()
+	if err == iterator.Done {
+		return nil
+	}
+	return err
+}
+
 func (f *Firebase) getReadOnly() bool {
 	return false
 }
 
 func (f *Firebase) getNode(domain string) (*node, error) {
-	n, err := f.common.getNode(domain)
-	if err != nil {
-		return nil, err
-	}
-	if n == nil {
-		err = f.refresh()
-		if err != nil {
-			return nil, err
-		}
-		n, err = f.common.getNode(domain)
-	}
-	return n, err
+	return f.common.getNodeOrRefresh(domain, f.refresh)
 }
 
 func (f *Firebase) getNodes(network string) (*nodes, error) {
-	ns, err := f.common.getNodes(network)
-	if err != nil {
-		return nil, err
-	}
-	if ns == nil {
-		err = f.refresh()
-		if err != nil {
-			return nil, err
-		}
-		ns, err = f.common.getNodes(network)
-	}
-	return ns, err
+	return f.common.getNodesOrRefresh(network, f.refresh)
 }
 
 // getAllNodes refreshes internal data and returns all nodes.
@@ -131,14 +131,48 @@ func (f *Firebase) setNode(n *node) error {
 		n.expires.Unix(),
 		n.role,
 		n.getScramblerKey(),
-		n.cookieDomain}
+		n.cookieDomain,
+		n.weight,
+		n.getPreviousScramblerKey()}
 	_, err2 := f.client.Collection(nodesTableName).Doc(n.domain).Set(ctx, item)
 	return err2
 }
 
-func (f *Firebase) refresh() error {
-	nets := make(map[string]*nodes)
+// deleteNode removes the node and its secrets from the collections and
+// refreshes the maps.
+func (f *Firebase) deleteNode(domain string) error {
+	n, err := f.getNode(domain)
+	if err != nil {
+		return err
+	}
+	if n == nil {
+		return fmt.Errorf("node '%s' not found", domain)
+	}
+	ctx := context.Background()
+	iter := f.client.Collection(secretsTableName).
+		Where(domainFieldName, "==", n.domain).
+		Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		_, err = doc.Ref.Delete(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = f.client.Collection(nodesTableName).Doc(n.domain).Delete(ctx)
+	if err != nil {
+		return err
+	}
+	return f.refresh()
+}
 
+func (f *Firebase) refresh() error {
 	// Fetch the nodes and then add the secrets.
 	ns, err := f.fetchNodes()
 	if err != nil {
@@ -149,44 +183,39 @@ func (f *Firebase) refresh() error {
 		return err
 	}
 
-	// Create a map of networks from the nodes found.
-	for _, v := range ns {
-		net := nets[v.network]
-		if net == nil {
-			net = &nodes{}
-			net.dict = make(map[string]*node)
-			nets[v.network] = net
-		}
-		net.all = append(net.all, v)
-		net.dict[v.domain] = v
-	}
-
-	// Finally sort the nodes by hash values and whether they are active.
-	for _, net := range nets {
-		net.order()
-	}
-
-	// In a single atomic operation update the reference to the networks and
-	// nodes.
-	f.mutex.Lock()
-	f.nodes = ns
-	f.networks = nets
-	f.mutex.Unlock()
+	// Build the networks and replace the nodes and networks in a single
+	// operation.
+	f.replaceNodes(ns)
 
 	return nil
 }
 
-func (f *Firebase) addSecrets(ns map[string]*node) error {
-	ctx := context.Background()
-	iter := f.client.Collection(secretsTableName).Documents(ctx)
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
+// documents returns all the documents in the collection retrying with back off
+// if there is an error. Firestore queries are always strongly consistent so
+// there is no equivalent of the DynamoDB consistent read option.
+func (f *Firebase) documents(
+	collection string) ([]*firestore.DocumentSnapshot, error) {
+	var docs []*firestore.DocumentSnapshot
+	err := retry(
+		f.getConfig(),
+		f.attempts,
+		defaultStoreRetryDelay,
+		func() error {
+			var err error
+			docs, err = f.client.Collection(collection).
+				Documents(context.Background()).
+				GetAll()
 			return err
-		}
+		})
+	return docs, err
+}
+
+func (f *Firebase) addSecrets(ns map[string]*node) error {
+	docs, err := f.documents(secretsTableName)
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
 		var item SecretItem
 		doc.DataTo(&item)
 		s, err := newSecretFromKey(item.ScramblerKey, item.TimeStamp)
@@ -208,17 +237,12 @@ func (f *Firebase) addSecrets(ns map[string]*node) error {
 
 func (f *Firebase) fetchNodes() (map[string]*node, error) {
 	ns := make(map[string]*node)
-	ctx := context.Background()
 
-	iter := f.client.Collection(nodesTableName).Documents(ctx)
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			return nil, err
-		}
+	docs, err := f.documents(nodesTableName)
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range docs {
 		var item NodeItem
 		doc.DataTo(&item)
 		ns[item.Domain], err = newNode(
@@ -233,6 +257,11 @@ func (f *Firebase) fetchNodes() (map[string]*node, error) {
 		if err != nil {
 			return nil, err
 		}
+		ns[item.Domain].setWeight(item.Weight)
+		err = ns[item.Domain].setPreviousScramblerKey(item.Previous)
+		if err != nil {
+			return nil, err
+		}
 	}
 	return ns, nil
 }
@@ -32,7 +32,7 @@ func handlerAlive(s *Services) http.HandlerFunc {
 		// Get the body bytes from the request.
 		b, err := ioutil.ReadAll(r.Body)
 		if err != nil {
-			returnAPIError(s, w, err, http.StatusInternalServerError)
+			returnAPIError(s, w, r, err, http.StatusInternalServerError)
 			return
 		}
 		r.Body.Close()
@@ -43,6 +43,7 @@ func handlerAlive(s *Services) http.HandlerFunc {
 			returnAPIError(
 				s,
 				w,
+				r,
 				fmt.Errorf("no node for '%s'", r.Host),
 				http.StatusBadRequest)
 		}
@@ -50,7 +51,7 @@ func handlerAlive(s *Services) http.HandlerFunc {
 		// Decode the body to form the decrypted byte array.
 		decrypted, err := n.decode(b)
 		if err != nil {
-			returnAPIError(s, w, err, http.StatusBadRequest)
+			returnAPIError(s, w, r, err, http.StatusBadRequest)
 			return
 		}
 
@@ -60,13 +61,14 @@ func handlerAlive(s *Services) http.HandlerFunc {
 		w.Header().Set("Cache-Length", fmt.Sprintf("%d", len(decrypted)))
 		l, err := w.Write(decrypted)
 		if err != nil {
-			returnAPIError(s, w, err, http.StatusInternalServerError)
+			returnAPIError(s, w, r, err, http.StatusInternalServerError)
 			return
 		}
 		if l != len(decrypted) {
 			returnAPIError(
 				s,
 				w,
+				r,
 				fmt.Errorf("byte count mismatch"),
 				http.StatusInternalServerError)
 			return
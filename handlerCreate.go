@@ -18,6 +18,7 @@ package swift
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
@@ -25,6 +26,7 @@ import (
 	"net/url"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -44,42 +46,88 @@ const (
 	postMessageOnCompleteParam = "postMessageOnComplete"
 	useHomeNode                = "useHomeNode"
 	javaScript                 = "javaScript"
+	allowPartialParam          = "allowPartial"
+	networksParam              = "networks"
+	homeNodeParam              = "homeNode"
+	formatParam                = "format"
+	cspSafeParam               = "cspSafe"
+	sensitiveParam             = "sensitive"
+	warmupParam                = "warmup"
+	blankOnHomeNodeParam       = "blankOnHomeNode"
 )
 
+// CreateResult is the result of creating a storage operation.
+type CreateResult struct {
+	URL      string    `json:"url"`      // The first URL of the operation
+	HomeNode string    `json:"homeNode"` // The domain of the home node
+	Expires  time.Time `json:"expires"`  // The time the operation times out
+}
+
 // Used to determine the storage character from the key to use for the
 // operation.
 var operationCharacterRegEx *regexp.Regexp
 
+// existsCharacter follows a key to indicate that only the presence of the key
+// is needed and not the values.
+const existsCharacter = '~'
+
 func init() {
 	var err error
-	operationCharacterRegEx, err = regexp.Compile("\\<|\\>|\\+")
+	operationCharacterRegEx, err = regexp.Compile("\\>\\>|\\<|\\>|\\+|\\^|~")
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
 // HandlerCreate takes a Services pointer and returns a HTTP handler used by an
-// Access Node to obtain the initial URL for a storage operation.
+// Access Node to obtain the initial URL for a storage operation. If the format
+// parameter is "json" then a JSON CreateResult is returned, otherwise the URL is
+// returned as plain text.
 func HandlerCreate(s *Services) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
+		// Get the access key before it is removed from the form.
+		k := r.FormValue("accessKey")
+
 		// Check caller can access and parse the form variables.
 		if s.getAccessAllowed(w, r) == false {
-			returnAPIError(s, w,
+			returnAPIError(s, w, r,
 				errors.New("Not authorized"),
 				http.StatusUnauthorized)
 			return
 		}
 
+		// Get the tables and networks the access key is limited to.
+		sc, err := getScope(s.access, k)
+		if err != nil {
+			returnServerError(s, w, r, err)
+			return
+		}
+
 		// Create the URL from the form parameters.
-		u, err := Create(s, r.Host, r.Form)
+		c, err := createWithScope(s, r.Host, r.Form, sc)
 		if err != nil {
-			returnAPIError(s, w, err, http.StatusBadRequest)
+			if _, ok := err.(*scopeError); ok {
+				returnAPIError(s, w, r, err, http.StatusForbidden)
+			} else {
+				returnAPIError(s, w, r, err, http.StatusBadRequest)
+			}
+			return
+		}
+
+		// Return the result as JSON if requested.
+		if r.Form.Get(formatParam) == "json" {
+			j, err := json.Marshal(c)
+			if err != nil {
+				returnServerError(s, w, r, err)
+				return
+			}
+			sendResponse(s, w, r, "application/json", j)
 			return
 		}
 
 		// Return the URL.
-		sendResponse(s, w, "text/plain; charset=utf-8", []byte(u))
+		sendResponse(s, w, r, "text/plain; charset=utf-8", []byte(c.URL))
 	}
 }
 
@@ -99,17 +147,69 @@ func SetHomeNodeHeaders(r *http.Request, q *url.Values) {
 // h the name of the SWIFT internet domain
 // q the form paramters to be used to create the storage operation URL
 func Create(s *Services, h string, q url.Values) (string, error) {
+	c, err := CreateWithResult(s, h, q)
+	if err != nil {
+		return "", err
+	}
+	return c.URL, nil
+}
+
+// EstimateRoundTrips returns the number of nodes a storage operation created
+// for the network would visit without creating the operation. Each node visited
+// is a browser redirect. Used to decide whether to display a user interface
+// before the operation is created.
+// s an instance of swift.Services
+// network the name of the network the operation will use
+// nodeCount the requested number of nodes, or zero for the configured default
+func EstimateRoundTrips(
+	s *Services,
+	network string,
+	nodeCount byte) (int, error) {
+	ns, err := s.store.getNodes(network)
+	if err != nil {
+		return 0, err
+	}
+	if ns == nil {
+		return 0, fmt.Errorf("Network '%s' does not exist", network)
+	}
+	if nodeCount == 0 {
+		nodeCount = s.config.NodeCount
+	}
+	return int(getCountForNodes(nodeCount, ns)), nil
+}
+
+// CreateWithResult creates a storage operation from the parameters passed to
+// the method for the node associated with the host and returns the URL along
+// with the home node and the time the operation expires.
+// s an instance of swift.Services
+// h the name of the SWIFT internet domain
+// q the form paramters to be used to create the storage operation URL
+func CreateWithResult(
+	s *Services,
+	h string,
+	q url.Values) (*CreateResult, error) {
+	return createWithScope(s, h, q, nil)
+}
+
+// createWithScope creates a storage operation in the same way as
+// CreateWithResult returning a scopeError if the table or networks are not
+// allowed by the scope sc. A nil scope allows everything.
+func createWithScope(
+	s *Services,
+	h string,
+	q url.Values,
+	sc *Scope) (*CreateResult, error) {
 	var err error
 
 	// Get the node associated with the request.
 	a := s.store.getNode(h)
 	if a == nil {
-		return "", fmt.Errorf("host '%s' is not a SWIFT node", h)
+		return nil, fmt.Errorf("host '%s' is not a SWIFT node", h)
 	}
 
 	// If the node is not an access node then return an error.
 	if a.role != roleAccess {
-		return "", fmt.Errorf("domain '%s' is not an access node", a.domain)
+		return nil, fmt.Errorf("domain '%s' is not an access node", a.domain)
 	}
 
 	// Create the operation.
@@ -118,13 +218,13 @@ func Create(s *Services, h string, q url.Values) (string, error) {
 	// Set the network for the operation.
 	o.network, err = s.store.getNodes(a.network)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	// Set the access node for the operation.
 	err = setAccessNode(s, o, &q, a)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	// Set any state information if provided.
@@ -133,7 +233,13 @@ func Create(s *Services, h string, q url.Values) (string, error) {
 	// Set the number of SWIFT nodes to use for the operation.
 	err = setCount(o, &q, s)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	// Set the additional networks to visit after the access node's network.
+	err = setJourney(s, o, &q)
+	if err != nil {
+		return nil, err
 	}
 
 	// Check the flag for the posting of a message on completion rather than
@@ -149,18 +255,52 @@ func Create(s *Services, h string, q url.Values) (string, error) {
 	// Check the flag to respond with a JavaScript file.
 	o.SetJavaScript(q.Get(javaScript) == "true")
 
+	// Check the flag to return the values collected so far if the operation
+	// runs out of time.
+	o.SetAllowPartial(q.Get(allowPartialParam) == "true")
+
+	// Check the flag to avoid inline styles and scripts without a nonce.
+	o.SetCSPSafe(q.Get(cspSafeParam) == "true")
+
+	// Check the flag to hide the user interface if the operation completes at
+	// the home node alone.
+	o.SetBlankOnHomeNode(q.Get(blankOnHomeNodeParam) == "true")
+
+	// Check the flag for a warm up operation that only visits the home node to
+	// set or refresh the cookies for the keys. Other nodes and networks are not
+	// visited.
+	o.SetWarmup(q.Get(warmupParam) == "true")
+	if o.Warmup() {
+		o.nodeCount = 1
+		o.journey = nil
+	}
+
 	// Set the return URL to use when posting the message or to redirect the
 	// browser to with the encrypted SWAN data appended.
 	ru, err := validateURL(returnURLParam, q.Get(returnURLParam))
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	err = validateReturnHost(s.config.AllowedReturnHosts, ru)
+	if err != nil {
+		return nil, err
 	}
 	o.returnURL = ru.String()
 
 	// Set the table that will be used for the storage of the key value pairs.
 	o.table = q.Get(tableParam)
 	if o.table == "" {
-		return "", fmt.Errorf("Missing table name")
+		return nil, fmt.Errorf("Missing table name")
+	}
+
+	// Check the table and the networks are allowed for the access key.
+	ns := []string{a.network}
+	for _, l := range o.journey {
+		ns = append(ns, l.network)
+	}
+	err = sc.validate(o.table, ns...)
+	if err != nil {
+		return nil, err
 	}
 
 	// Set the user interface parameters from the optional parameters provided
@@ -187,27 +327,60 @@ func Create(s *Services, h string, q url.Values) (string, error) {
 		o.HTML.ProgressColor = s.config.ProgressColor
 	}
 
+	// Get the keys of the pairs that are flagged as sensitive.
+	f := make(map[string]bool)
+	for _, v := range strings.Split(q.Get(sensitiveParam), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			f[v] = true
+		}
+	}
+
 	// Add the key value pairs from the form parameters.
 	for k, v := range q {
 		if isReserved(k) == false && len(v) > 0 {
-			p, err := createPair(k, v[0])
+			p, err := createPair(k, v[0], s.config.MaxValueBytes, s.clock.Now())
 			if err != nil {
-				return "", err
+				return nil, err
+			}
+			if f[p.key] {
+				p.flags |= PairFlagSensitive
 			}
 			if p.conflict == conflictInvalid {
-				return "", fmt.Errorf(
+				return nil, fmt.Errorf(
 					"Pair does not contain valid conflict flag")
 			}
 			o.resolved = append(o.resolved, p)
 		}
 	}
 
-	// For this network and request find the home node.
-	o.nextNode, err = o.network.getHomeNode(
-		q.Get(xforwarededfor),
-		q.Get(remoteAddr))
-	if err != nil {
-		return "", err
+	// If other networks are to be visited then retain the requested pairs for
+	// use with each network.
+	if len(o.journey) > 0 {
+		o.requested = o.resolved
+	}
+
+	// For this network and request find the home node. If the caller has
+	// pinned the home node then use it providing it is valid for the network,
+	// otherwise use the one associated with the remote address.
+	if h := q.Get(homeNodeParam); h != "" {
+		o.nextNode, err = o.network.getHomeNodeByDomain(h)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"Invalid home node for network '%s'. %s",
+				a.network,
+				err.Error())
+		}
+	} else {
+		o.nextNode, err = s.getHomeNodeInNetwork(
+			o.network,
+			q.Get(xforwarededfor),
+			q.Get(remoteAddr))
+		if err != nil {
+			return nil, fmt.Errorf(
+				"No home node in network '%s'. %s",
+				a.network,
+				err.Error())
+		}
 	}
 
 	// Store the home node for the operation in case something changes about the
@@ -217,41 +390,82 @@ func Create(s *Services, h string, q url.Values) (string, error) {
 	// Get the next URL.
 	u, err := o.getNextURL()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return u.String(), nil
+	return &CreateResult{
+		URL:      u.String(),
+		HomeNode: o.homeNode,
+		Expires: o.timeStamp.Add(
+			s.config.StorageOperationTimeoutDuration())}, nil
 }
 
 // Creates a key value pair from the k and v values provided. If the v parameter
 // is an empty string then the operation will try and retrieve the existing
-// value for the key and will not update it.
-func createPair(k string, v string) (*pair, error) {
+// value for the key and will not update it. If the key ends with '~' then only
+// the presence of the key is retrieved and not the values. If m is greater
+// than zero then values longer than m bytes are rejected. The time t is the
+// current time used to validate expiry dates and as the created time.
+func createPair(k string, v string, m int, t time.Time) (*pair, error) {
 
 	// Get the command for the storage operation.
 	i := operationCharacterRegEx.FindStringIndex(k)
 	if i == nil {
 		return nil, fmt.Errorf("Key '%s' must include a '+' to add the value "+
-			"to a list of values, or '<' (oldest wins) or '>' (newest wins) "+
-			"character to determine how to resolve two values for the same "+
-			"key. If a value is provided this character must be followed by "+
+			"to a list of values, or '<' (oldest wins), '>' (newest wins), "+
+			"'>>' (newest wins, greatest value on a tie) or '^' (home node "+
+			"wins) characters to "+
+			"determine how to resolve two values for the same "+
+			"key. If a value is provided these characters must be followed by "+
 			"a date in YYYY-MM-DD format to indicate when "+
-			"the provided value expires and is automatically deleted.", k)
+			"the provided value expires and is automatically deleted. An "+
+			"optional second date after a '>' sets an earlier expiry for "+
+			"the cookies that store the value. A '~' at the end of the key "+
+			"only determines if the key exists.", k)
 	}
-	if len(i) > 2 || i[1]-i[0] != 1 {
+	if len(k) > i[1] && operationCharacterRegEx.MatchString(k[i[1]:i[1]+1]) {
 		return nil, fmt.Errorf(
-			"Key '%s' must contained only one '+', '<' or '>' character", k)
+			"Key '%s' must contain only one '+', '<', '>', '>>', '^' or '~' "+
+				"before the date",
+			k)
+	}
+
+	// An exists query only retrieves the presence of the key and can not
+	// include a value.
+	if k[i[0]] == existsCharacter {
+		if len(k) != i[1] {
+			return nil, fmt.Errorf(
+				"Key '%s' must end with '~' for an exists query", k)
+		}
+		return createPairExists(k, i)
 	}
 
 	// If there is an expiry date then this indicates that the caller wishes
 	// to write the value to the network if other values don't exist.
-	if len(k)-1 != i[0] {
-		return createPairWithValue(k, v, i)
+	if len(k) != i[1] {
+		return createPairWithValue(k, v, i, m, t)
 	}
 	return createPairWithNoValue(k, i)
 }
 
+// validateValueSize returns an error if the value v for key k is larger than m
+// bytes. If m is zero or less then no limit is applied.
+func validateValueSize(k string, v []byte, m int) error {
+	if m > 0 && len(v) > m {
+		return fmt.Errorf(
+			"Value for key '%s' is '%d' bytes which exceeds the limit of "+
+				"'%d' bytes",
+			k,
+			len(v),
+			m)
+	}
+	return nil
+}
+
 func getConflictPolicy(k string, i []int) (byte, error) {
+	if k[i[0]:i[1]] == ">>" {
+		return conflictNewestValue, nil
+	}
 	switch k[i[0]] {
 	case '+':
 		return conflictAdd, nil
@@ -259,6 +473,8 @@ func getConflictPolicy(k string, i []int) (byte, error) {
 		return conflictOldest, nil
 	case '>':
 		return conflictNewest, nil
+	case '^':
+		return conflictHomeWins, nil
 	default:
 		return conflictInvalid, fmt.Errorf("Character '%c' invalid", k[i[0]])
 	}
@@ -278,7 +494,23 @@ func createPairWithNoValue(k string, i []int) (*pair, error) {
 	return &p, err
 }
 
-func createPairWithValue(k string, v string, i []int) (*pair, error) {
+// createPairExists creates a valueless pair for the key that only retrieves the
+// presence of the key in the network. The newest value is used to provide the
+// timestamps.
+func createPairExists(k string, i []int) (*pair, error) {
+	var p pair
+	p.key = k[:i[0]]
+	p.conflict = conflictNewest
+	p.existsOnly = true
+	return &p, nil
+}
+
+func createPairWithValue(
+	k string,
+	v string,
+	i []int,
+	m int,
+	t time.Time) (*pair, error) {
 	var err error
 	var p pair
 
@@ -290,6 +522,12 @@ func createPairWithValue(k string, v string, i []int) (*pair, error) {
 		b = []byte(v)
 	}
 
+	// Check that the value will fit in a cookie.
+	err = validateValueSize(k[:i[0]], b, m)
+	if err != nil {
+		return nil, err
+	}
+
 	// Set how multiple values for the same key are handled.
 	p.conflict, err = getConflictPolicy(k, i)
 	if err != nil {
@@ -297,46 +535,76 @@ func createPairWithValue(k string, v string, i []int) (*pair, error) {
 	}
 
 	// Work out the expiry time from the date that appears after the conflict
-	// character.
-	p.expires, err = time.Parse("2006-01-02", k[i[0]+1:])
+	// character. An optional second date after a '>' is the expiry of the
+	// cookies used to store the value.
+	d := strings.SplitN(k[i[1]:], ">", 2)
+	p.expires, err = time.Parse("2006-01-02", d[0])
 	if err != nil {
 		return nil, err
 	}
-	if p.expires.Before(time.Now().UTC()) {
+	if p.expires.Before(t) {
 		return nil, fmt.Errorf(
-			"Key expiry date '%s' must be in the future", k[i[0]+1:])
+			"Key expiry date '%s' must be in the future", d[0])
+	}
+	if len(d) > 1 {
+		p.cookieExpires, err = time.Parse("2006-01-02", d[1])
+		if err != nil {
+			return nil, err
+		}
+		if p.cookieExpires.Before(t) {
+			return nil, fmt.Errorf(
+				"Cookie expiry date '%s' must be in the future", d[1])
+		}
+		if p.cookieExpires.After(p.expires) {
+			return nil, fmt.Errorf(
+				"Cookie expiry date '%s' must not be after key expiry '%s'",
+				d[1],
+				d[0])
+		}
 	}
 
 	// Complete the data for the pair.
-	p.created = time.Now().UTC()
+	p.created = t
 	p.key = k[:i[0]]
 	p.values = [][]byte{b}
 
 	return &p, err
 }
 
-// Set the access node domain so that the end operation can be called to decrypt
-// the data in the return url. Verify that the access node provided is a valid
-// access node in the store. This prevents spoof access nodes being provided by
-// bad actors attempting to gain access to the network. If no access node is
-// provided then the default one will be used. The access node is not valid for
-// other purposes so remove it from the parameters.
+// Set the access node domains so that the end operation can be called to
+// decrypt the data in the return url. The accessNode parameter can contain a
+// comma separated list of access nodes which are tried in order. Verify that
+// the access nodes provided are valid access nodes in the store. This prevents
+// spoof access nodes being provided by bad actors attempting to gain access to
+// the network. If no access node is provided then the default one will be used
+// unless the configuration requires the access node to be named explicitly. The
+// access node is not valid for other purposes so remove it from the parameters.
 func setAccessNode(s *Services, o *operation, q *url.Values, a *node) error {
 	v := q.Get("accessNode")
 	if v == "" {
-		o.accessNode = a.domain
-	} else {
-		n := s.store.getNode(v)
-		if n == nil {
-			return fmt.Errorf("'%s' is not a valid access node", v)
+		if s.config.AccessNodeRequired {
+			return fmt.Errorf("accessNode parameter required")
 		}
-		if a.network != n.network {
-			return fmt.Errorf(
-				"'%s' is node a valid access node for network '%s'",
-				v,
-				a.network)
+		o.accessNodes = []string{a.domain}
+	} else {
+		o.accessNodes = nil
+		for _, d := range strings.Split(v, accessNodeSeparator) {
+			d = strings.TrimSpace(d)
+			n := s.store.getNode(d)
+			if n == nil {
+				return fmt.Errorf("'%s' is not a valid access node", d)
+			}
+			if a.network != n.network {
+				return fmt.Errorf(
+					"'%s' is node a valid access node for network '%s'",
+					d,
+					a.network)
+			}
+			if n.role != roleAccess {
+				return fmt.Errorf("'%s' is not an access node", d)
+			}
+			o.accessNodes = append(o.accessNodes, n.domain)
 		}
-		o.accessNode = n.domain
 	}
 	q.Del("accessNode")
 	return nil
@@ -346,26 +614,41 @@ func setAccessNode(s *Services, o *operation, q *url.Values, a *node) error {
 // requested node count is higher than the total number of nodes available then
 // the count is reduced to the available nodes.
 func setCount(o *operation, q *url.Values, s *Services) error {
+	var err error
+	c, err := getCount(q, s)
+	if err != nil {
+		return err
+	}
+	o.nodeCount = getCountForNodes(c, o.network)
+	return nil
+}
+
+// getCountForNodes returns the node count c limited to the number of active
+// storage nodes in the network.
+func getCountForNodes(c byte, ns *nodes) byte {
+	if c > (byte)(len(ns.hash)) {
+		return (byte)(len(ns.hash))
+	}
+	return c
+}
+
+// getCount returns the requested number of SWIFT nodes for the operation or the
+// configured default if not provided.
+func getCount(q *url.Values, s *Services) (byte, error) {
 	if q.Get(nodeCount) != "" {
 		c, err := strconv.Atoi(q.Get(nodeCount))
 		if err != nil {
-			return err
+			return 0, err
 		}
 		if c <= 0 {
-			return fmt.Errorf("SWIFT node count must be greater than 0")
+			return 0, fmt.Errorf("SWIFT node count must be greater than 0")
 		} else if c < 255 {
-			o.nodeCount = byte(c)
-		} else {
-			return fmt.Errorf(
-				"SWIFT node count '%d' must be less than 255", c)
+			return byte(c), nil
 		}
-	} else {
-		o.nodeCount = s.config.NodeCount
+		return 0, fmt.Errorf(
+			"SWIFT node count '%d' must be less than 255", c)
 	}
-	if o.nodeCount > (byte)(len(o.network.hash)) {
-		o.nodeCount = (byte)(len(o.network.hash))
-	}
-	return nil
+	return s.config.NodeCount, nil
 }
 
 func isReserved(s string) bool {
@@ -383,7 +666,36 @@ func isReserved(s string) bool {
 		s == displayUserInterfaceParam ||
 		s == postMessageOnCompleteParam ||
 		s == useHomeNode ||
-		s == javaScript
+		s == javaScript ||
+		s == allowPartialParam ||
+		s == homeNodeParam ||
+		s == networksParam ||
+		s == formatParam ||
+		s == cspSafeParam ||
+		s == sensitiveParam ||
+		s == warmupParam ||
+		s == blankOnHomeNodeParam
+}
+
+// validateReturnHost confirms that the host of the return URL is one of the
+// allowed hosts. Entries starting with "*." match any sub domain. If there are
+// no allowed hosts then all hosts are valid.
+func validateReturnHost(allowed []string, u *url.URL) error {
+	if len(allowed) == 0 {
+		return nil
+	}
+	h := strings.ToLower(u.Hostname())
+	for _, a := range allowed {
+		a = strings.ToLower(a)
+		if strings.HasPrefix(a, "*.") {
+			if strings.HasSuffix(h, a[1:]) {
+				return nil
+			}
+		} else if h == a {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s host '%s' not allowed", returnURLParam, h)
 }
 
 // validateURL confirms that the parameter is a valid URL and then returns the
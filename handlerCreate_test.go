@@ -0,0 +1,491 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetAccessNodeRequired(t *testing.T) {
+	s, a, err := newSetAccessNodeTest(true)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	var o operation
+	q := url.Values{}
+	err = setAccessNode(s, &o, &q, a)
+	if err == nil {
+		fmt.Println("Missing access node accepted in strict mode")
+		t.Fail()
+	}
+	q.Set("accessNode", "test-2.com")
+	err = setAccessNode(s, &o, &q, a)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if o.AccessNode() != "test-2.com" {
+		fmt.Printf("Access node '%s' not 'test-2.com'\n", o.AccessNode())
+		t.Fail()
+	}
+	q.Set("accessNode", "missing.com")
+	err = setAccessNode(s, &o, &q, a)
+	if err == nil {
+		fmt.Println("Invalid access node accepted in strict mode")
+		t.Fail()
+	}
+}
+
+func TestSetAccessNodeRole(t *testing.T) {
+	s, a, err := newSetAccessNodeTest(false)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	n := s.store.getNode("test-2.com")
+	n.role = roleStorage
+	var o operation
+	q := url.Values{}
+	q.Set("accessNode", "test-2.com")
+	err = setAccessNode(s, &o, &q, a)
+	if err == nil {
+		fmt.Println("Storage node accepted as access node")
+		t.Fail()
+	}
+}
+
+func TestSetAccessNodeDefault(t *testing.T) {
+	s, a, err := newSetAccessNodeTest(false)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	var o operation
+	q := url.Values{}
+	err = setAccessNode(s, &o, &q, a)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if o.AccessNode() != a.domain {
+		fmt.Printf("Access node '%s' not '%s'\n", o.AccessNode(), a.domain)
+		t.Fail()
+	}
+}
+
+func TestSetAccessNodeList(t *testing.T) {
+	s, a, err := newSetAccessNodeTest(false)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	var o operation
+	q := url.Values{}
+	q.Set("accessNode", "test-2.com, test-3.com")
+	err = setAccessNode(s, &o, &q, a)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if strings.Join(o.AccessNodes(), ",") != "test-2.com,test-3.com" {
+		fmt.Printf("Access nodes '%v' incorrect\n", o.AccessNodes())
+		t.Fail()
+	}
+	q.Set("accessNode", "test-2.com,missing.com")
+	err = setAccessNode(s, &o, &q, a)
+	if err == nil {
+		fmt.Println("Invalid access node in list accepted")
+		t.Fail()
+	}
+}
+
+func TestCreateHomeNode(t *testing.T) {
+	s, err := newCreateHomeNodeTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	for _, d := range []struct {
+		homeNode string
+		valid    bool
+	}{
+		{"storage-1.com", true},
+		{"storage-2.com", true},
+		{"access.com", false},
+		{"other.com", false},
+		{"missing.com", false}} {
+		q := url.Values{}
+		q.Set("table", "t")
+		q.Set("returnUrl", "http://return.com/")
+		q.Set("homeNode", d.homeNode)
+		q.Set("a>", "")
+		r, err := Create(s, "access.com", q)
+		if d.valid == false {
+			if err == nil {
+				fmt.Printf("Home node '%s' accepted\n", d.homeNode)
+				t.Fail()
+			}
+			continue
+		}
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			continue
+		}
+		u, err := url.Parse(r)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			continue
+		}
+		if u.Host != d.homeNode {
+			fmt.Printf("Home node '%s' not '%s'\n", u.Host, d.homeNode)
+			t.Fail()
+		}
+	}
+}
+
+func TestValidateReturnHost(t *testing.T) {
+	a := []string{"return.com", "*.example.com"}
+	for _, d := range []struct {
+		url   string
+		valid bool
+	}{
+		{"http://return.com/", true},
+		{"https://RETURN.com:8080/path", true},
+		{"https://sub.example.com/", true},
+		{"https://a.b.example.com/", true},
+		{"https://example.com/", false},
+		{"https://badexample.com/", false},
+		{"https://return.com.evil.com/", false},
+		{"https://evil.com/", false}} {
+		u, err := url.Parse(d.url)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			continue
+		}
+		err = validateReturnHost(a, u)
+		if (err == nil) != d.valid {
+			fmt.Printf("Return URL '%s' valid '%v'\n", d.url, err == nil)
+			t.Fail()
+		}
+	}
+	u, _ := url.Parse("https://evil.com/")
+	if validateReturnHost(nil, u) != nil {
+		fmt.Println("Return URL rejected with no allowed hosts")
+		t.Fail()
+	}
+}
+
+func TestCreateAllowedReturnHosts(t *testing.T) {
+	s, err := newCreateHomeNodeTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	s.config.AllowedReturnHosts = []string{"return.com"}
+	for _, d := range []struct {
+		returnURL string
+		valid     bool
+	}{
+		{"http://return.com/", true},
+		{"http://evil.com/", false}} {
+		q := url.Values{}
+		q.Set("table", "t")
+		q.Set("returnUrl", d.returnURL)
+		q.Set("a>", "")
+		_, err := Create(s, "access.com", q)
+		if (err == nil) != d.valid {
+			fmt.Printf("Return URL '%s' valid '%v'\n", d.returnURL, err == nil)
+			t.Fail()
+		}
+	}
+}
+
+func TestHandlerCreateJSON(t *testing.T) {
+	s, err := newCreateHomeNodeTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	s.access = NewAccessSimple([]string{"key"})
+	q := url.Values{}
+	q.Set("accessKey", "key")
+	q.Set("table", "t")
+	q.Set("returnUrl", "http://return.com/")
+	q.Set("homeNode", "storage-1.com")
+	q.Set("a>", "")
+
+	// Plain text is returned by default.
+	w := testHandlerCreate(s, q)
+	b, err := testGzipBody(w)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if w.Code != http.StatusOK ||
+		strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") == false ||
+		strings.Contains(string(b), "storage-1.com") == false {
+		fmt.Printf("Status '%d' body '%s'\n", w.Code, b)
+		t.Fail()
+	}
+
+	// JSON is returned if requested.
+	q.Set("format", "json")
+	n := time.Now().UTC()
+	w = testHandlerCreate(s, q)
+	b, err = testGzipBody(w)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	var c CreateResult
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil || u.Host != "storage-1.com" {
+		fmt.Printf("URL '%s' invalid\n", c.URL)
+		t.Fail()
+	}
+	if c.HomeNode != "storage-1.com" {
+		fmt.Printf("Home node '%s' not 'storage-1.com'\n", c.HomeNode)
+		t.Fail()
+	}
+	e := n.Add(s.config.StorageOperationTimeoutDuration())
+	if c.Expires.Before(e.Add(-time.Second)) ||
+		c.Expires.After(e.Add(time.Second)) {
+		fmt.Printf("Expires '%s' not '%s'\n", c.Expires, e)
+		t.Fail()
+	}
+}
+
+func testHandlerCreate(
+	s *Services,
+	q url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(
+		"POST",
+		"http://access.com/swift/api/v1/create",
+		strings.NewReader(q.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	HandlerCreate(s)(w, r)
+	return w
+}
+
+func newCreateHomeNodeTest() (*Services, error) {
+	var a []*node
+	for _, d := range []struct {
+		network string
+		domain  string
+		role    int
+	}{
+		{"network", "access.com", roleAccess},
+		{"network", "storage-1.com", roleStorage},
+		{"network", "storage-2.com", roleStorage},
+		{"other", "other.com", roleStorage}} {
+		n, err := newNode(
+			d.network,
+			d.domain,
+			time.Now().UTC(),
+			time.Now().UTC().Add(-time.Minute),
+			time.Now().UTC().AddDate(1, 0, 0),
+			d.role,
+			"",
+			"")
+		if err != nil {
+			return nil, err
+		}
+		x, err := newSecret()
+		if err != nil {
+			return nil, err
+		}
+		n.addSecret(x)
+		a = append(a, n)
+	}
+	c := newConfigurationTest()
+	c.NodeCount = 1
+	c.StorageOperationTimeout = 60
+	c.HomeNodeTimeout = 60
+	return NewServices(
+		c,
+		NewStorageService(c, newVolatile("test", false, a)),
+		nil,
+		nil), nil
+}
+
+func newSetAccessNodeTest(required bool) (*Services, *node, error) {
+	v, err := newVolatileTest()
+	if err != nil {
+		return nil, nil, err
+	}
+	c := newConfigurationTest()
+	c.AccessNodeRequired = required
+	s := NewServices(c, NewStorageService(c, v), nil, nil)
+	a := s.store.getNode("test-1.com")
+	if a == nil {
+		return nil, nil, fmt.Errorf("Access node not found")
+	}
+	return s, a, nil
+}
+
+func TestEstimateRoundTrips(t *testing.T) {
+	s, err := newCreateHomeNodeTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	for _, d := range []struct {
+		network   string
+		nodeCount byte
+		expected  int
+	}{
+		{"network", 0, 1}, // configured default
+		{"network", 2, 2},
+		{"network", 10, 2}, // limited to the storage nodes
+		{"other", 5, 1}} {
+		c, err := EstimateRoundTrips(s, d.network, d.nodeCount)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			continue
+		}
+		if c != d.expected {
+			fmt.Printf("'%d' round trips for '%s' with '%d' not '%d'\n",
+				c,
+				d.network,
+				d.nodeCount,
+				d.expected)
+			t.Fail()
+		}
+	}
+	_, err = EstimateRoundTrips(s, "missing", 1)
+	if err == nil {
+		fmt.Println("Round trips estimated for a missing network")
+		t.Fail()
+	}
+}
+
+func TestHandlerCreateScope(t *testing.T) {
+	s, err := newCreateHomeNodeTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	s.access = NewAccessSimpleWithScopes(map[string]*Scope{
+		"all":     nil,
+		"table":   {Tables: []string{"t"}},
+		"network": {Networks: []string{"network"}},
+		"mixed":   {Networks: []string{" Network "}}})
+	for _, d := range []struct {
+		key      string
+		table    string
+		networks string
+		expected int
+	}{
+		{"all", "other", "other", http.StatusOK},
+		{"table", "t", "", http.StatusOK},
+		{"table", "other", "", http.StatusForbidden},
+		{"network", "other", "", http.StatusOK},
+		{"network", "t", "other", http.StatusForbidden},
+		{"mixed", "other", "", http.StatusOK},
+		{"missing", "t", "", http.StatusNetworkAuthenticationRequired}} {
+		q := url.Values{}
+		q.Set("accessKey", d.key)
+		q.Set("table", d.table)
+		q.Set("returnUrl", "http://return.com/")
+		q.Set("a>", "")
+		if d.networks != "" {
+			q.Set("networks", d.networks)
+		}
+		w := testHandlerCreate(s, q)
+		if w.Code != d.expected {
+			fmt.Printf("Key '%s' table '%s' networks '%s' expected '%d' "+
+				"got '%d'\n",
+				d.key,
+				d.table,
+				d.networks,
+				d.expected,
+				w.Code)
+			t.Fail()
+		}
+	}
+}
+
+// TestSimulateVisitHomeNode confirms that the simulated visit starts at the
+// same home node as Create, including when a node expires before the operation
+// could complete.
+func TestSimulateVisitHomeNode(t *testing.T) {
+	s, err := newCreateHomeNodeTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	s.store.getNode("storage-1.com").expires = time.Now().UTC().Add(
+		time.Second * 30)
+	for i := 0; i < 20; i++ {
+		a := fmt.Sprintf("10.0.0.%d", i)
+		q := url.Values{}
+		q.Set("table", "t")
+		q.Set("returnUrl", "http://return.com/")
+		q.Set("remoteAddr", a)
+		q.Set("a>", "")
+		r, err := CreateWithResult(s, "access.com", q)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		v, err := s.SimulateVisit("network", "", a, 2, 1)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		if len(v) != 2 || v[0] != r.HomeNode || v[0] != "storage-2.com" {
+			fmt.Printf("Simulated home node '%v' not '%s'\n", v, r.HomeNode)
+			t.Fail()
+		}
+	}
+}
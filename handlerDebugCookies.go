@@ -0,0 +1,86 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// DebugCookie describes a cookie decoded by HandlerDebugCookies. The value of
+// the cookie is never included.
+type DebugCookie struct {
+	Key             string    `json:"key"`             // Key of the pair
+	Created         time.Time `json:"created"`         // When pair created
+	Expires         time.Time `json:"expires"`         // When pair expires
+	CookieWriteTime time.Time `json:"cookieWriteTime"` // When cookie written
+}
+
+// HandlerDebugCookies returns a JSON list describing every cookie in the
+// request that can be decoded by the node associated with the request host.
+// Only available when debug is enabled and requires a valid access key. The
+// values of the cookies are never returned.
+func HandlerDebugCookies(s *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Only available in debug mode.
+		if s.config.Debug == false {
+			http.NotFound(w, r)
+			return
+		}
+
+		// Check caller can access.
+		if s.getAccessAllowed(w, r) == false {
+			return
+		}
+
+		// Get the node associated with the request.
+		n := s.store.getNode(r.Host)
+		if n == nil {
+			returnAPIError(
+				s,
+				w,
+				r,
+				fmt.Errorf("host '%s' is not a SWIFT node", r.Host),
+				http.StatusBadRequest)
+			return
+		}
+
+		// Decode the cookies ignoring any that are not for the node.
+		cs := make([]*DebugCookie, 0)
+		for _, c := range r.Cookies() {
+			p, err := n.getValueFromCookie(c, 0)
+			if err != nil {
+				continue
+			}
+			cs = append(cs, &DebugCookie{
+				Key:             p.key,
+				Created:         p.created,
+				Expires:         p.expires,
+				CookieWriteTime: p.cookieWriteTime})
+		}
+
+		j, err := json.Marshal(cs)
+		if err != nil {
+			returnServerError(s, w, r, err)
+			return
+		}
+		sendResponse(s, w, r, "application/json", j)
+	}
+}
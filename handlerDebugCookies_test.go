@@ -0,0 +1,151 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandlerDebugCookies(t *testing.T) {
+	s, c, err := newHandlerDebugCookiesTest(true)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	w := testHandlerDebugCookies(s, c, "key")
+	if w.Code != http.StatusOK {
+		fmt.Printf("Status '%d' returned\n", w.Code)
+		t.Fail()
+		return
+	}
+	g, err := gzip.NewReader(w.Result().Body)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	b, err := ioutil.ReadAll(g)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if strings.Contains(string(b), "secret-value") {
+		fmt.Println("Cookie value returned")
+		t.Fail()
+	}
+	var cs []*DebugCookie
+	err = json.Unmarshal(b, &cs)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if len(cs) != 1 || cs[0].Key != "debug" {
+		fmt.Printf("Expected one cookie for key 'debug', got '%d'\n", len(cs))
+		t.Fail()
+		return
+	}
+	if cs[0].CookieWriteTime.IsZero() || cs[0].Expires.IsZero() {
+		fmt.Println("Cookie times not returned")
+		t.Fail()
+	}
+}
+
+func TestHandlerDebugCookiesNotDebug(t *testing.T) {
+	s, c, err := newHandlerDebugCookiesTest(false)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	w := testHandlerDebugCookies(s, c, "key")
+	if w.Code != http.StatusNotFound {
+		fmt.Printf("Status '%d' returned when not in debug\n", w.Code)
+		t.Fail()
+	}
+}
+
+func TestHandlerDebugCookiesNotAllowed(t *testing.T) {
+	s, c, err := newHandlerDebugCookiesTest(true)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	w := testHandlerDebugCookies(s, c, "bad")
+	if w.Code == http.StatusOK {
+		fmt.Println("Debug cookies allowed with invalid access key")
+		t.Fail()
+	}
+}
+
+func testHandlerDebugCookies(
+	s *Services,
+	c []*http.Cookie,
+	k string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(
+		"GET",
+		"http://access.com/swift/api/v1/debug-cookies?accessKey="+k,
+		nil)
+	for _, i := range c {
+		r.AddCookie(i)
+	}
+	w := httptest.NewRecorder()
+	HandlerDebugCookies(s)(w, r)
+	return w
+}
+
+// newHandlerDebugCookiesTest returns services containing a single node and
+// a cookie written by the node for the key "debug".
+func newHandlerDebugCookiesTest(
+	debug bool) (*Services, []*http.Cookie, error) {
+	n, err := newResultCompressTestNode()
+	if err != nil {
+		return nil, nil, err
+	}
+	c := newConfigurationTest()
+	c.Debug = debug
+	s := NewServices(
+		c,
+		NewStorageService(c, newVolatile("test", false, []*node{n})),
+		NewAccessSimple([]string{"key"}),
+		nil)
+	o := newOperation(s, n)
+	o.table = "t"
+	o.request = httptest.NewRequest("GET", "http://access.com/", nil)
+	var p pair
+	p.key = "debug"
+	p.created = time.Now().UTC()
+	p.expires = p.created.AddDate(0, 0, 1)
+	p.values = [][]byte{[]byte("secret-value")}
+	w := httptest.NewRecorder()
+	err = o.setValueInCookie(w, o.request, &p)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s, w.Result().Cookies(), nil
+}
@@ -30,9 +30,12 @@ import (
 func HandlerDecodeAsJSON(s *Services) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
+		// Set the origins that can read the response.
+		setAllowOrigin(s, w, r)
+
 		// Check caller can access and parse the form variables.
 		if s.getAccessAllowed(w, r) == false {
-			returnAPIError(s, w,
+			returnAPIError(s, w, r,
 				errors.New("not authorized"),
 				http.StatusUnauthorized)
 			return
@@ -41,21 +44,21 @@ func HandlerDecodeAsJSON(s *Services) http.HandlerFunc {
 		// Get the node associated with the request.
 		n, err := s.GetAccessNodeForHost(r.Host)
 		if err != nil {
-			returnAPIError(s, w, err, http.StatusInternalServerError)
+			returnAPIError(s, w, r, err, http.StatusInternalServerError)
 			return
 		}
 
 		// Decode the query string to form the byte array.
 		d, err := base64.StdEncoding.DecodeString(r.Form.Get("encrypted"))
 		if err != nil {
-			returnAPIError(s, w, err, http.StatusBadRequest)
+			returnAPIError(s, w, r, err, http.StatusBadRequest)
 			return
 		}
 
 		// Decrypt and decode the data into a Results.
 		v, err := n.DecodeAsResults(d)
 		if err != nil {
-			returnAPIError(s, w, err, http.StatusInternalServerError)
+			returnAPIError(s, w, r, err, http.StatusInternalServerError)
 			return
 		}
 
@@ -64,19 +67,23 @@ func HandlerDecodeAsJSON(s *Services) http.HandlerFunc {
 			returnAPIError(
 				s,
 				w,
+				r,
 				fmt.Errorf("data expired and can no longer be used"),
 				http.StatusBadRequest)
 			return
 		}
 
+		// Add the structured form of any values that have a parser.
+		s.parseValues(v)
+
 		// Turn the Results into a JSON string.
 		j, err := json.Marshal(v)
 		if err != nil {
-			returnAPIError(s, w, err, http.StatusInternalServerError)
+			returnAPIError(s, w, r, err, http.StatusInternalServerError)
 			return
 		}
 
 		// Send the JSON string.
-		sendResponse(s, w, "application/json", j)
+		sendResponse(s, w, r, "application/json", j)
 	}
 }
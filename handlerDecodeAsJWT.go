@@ -0,0 +1,86 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// HandlerDecodeAsJWT returns the incoming request as a JWT signed with the
+// configured JWT signing key. The query string contains the data which must be
+// turned into a byte array, decrypted and the resulting data turned into a JWT
+// where the pairs are the claims and the expiry of the data is the exp claim.
+func HandlerDecodeAsJWT(s *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Set the origins that can read the response.
+		setAllowOrigin(s, w, r)
+
+		// Check caller can access and parse the form variables.
+		if s.getAccessAllowed(w, r) == false {
+			returnAPIError(s, w, r,
+				errors.New("not authorized"),
+				http.StatusUnauthorized)
+			return
+		}
+
+		// Check that a signing key has been configured.
+		if s.config.JWTSigningKey == "" {
+			returnAPIError(
+				s,
+				w,
+				r,
+				fmt.Errorf("JWT signing key not configured"),
+				http.StatusNotImplemented)
+			return
+		}
+
+		// Get the node associated with the request.
+		n, err := s.GetAccessNodeForHost(r.Host)
+		if err != nil {
+			returnAPIError(s, w, r, err, http.StatusInternalServerError)
+			return
+		}
+
+		// Decode the query string to form the byte array.
+		d, err := base64.StdEncoding.DecodeString(r.Form.Get("encrypted"))
+		if err != nil {
+			returnAPIError(s, w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		// Decrypt and decode the data into a Results.
+		v, err := n.DecodeAsResults(d)
+		if err != nil {
+			returnAPIError(s, w, r, err, http.StatusInternalServerError)
+			return
+		}
+
+		// Turn the Results into a signed JWT. Expired results are rejected.
+		j, err := v.AsJWT([]byte(s.config.JWTSigningKey))
+		if err != nil {
+			returnAPIError(s, w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		// Send the JWT.
+		sendResponse(s, w, r, "application/jwt", []byte(j))
+	}
+}
@@ -0,0 +1,145 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// defaultDecodeBatchSize is the maximum number of encrypted values in a batch
+// decode request if one is not configured.
+const defaultDecodeBatchSize = 100
+
+// decodeBatchItemBytes is the number of bytes allowed in the request body for
+// each item in a batch decode request.
+const decodeBatchItemBytes = 64 * 1024
+
+// batchError is returned in place of the results for any item in a batch that
+// could not be decoded.
+type batchError struct {
+	Error string `json:"error"`
+}
+
+// HandlerDecodeBatchAsJSON returns the results for multiple encrypted values as
+// a JSON array. The request body is a JSON array of base 64 encrypted strings.
+// Each item in the response is either the results or an object with an error
+// field in the same order as the request.
+func HandlerDecodeBatchAsJSON(s *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Set the origins that can read the response.
+		setAllowOrigin(s, w, r)
+
+		// Check caller can access and parse the form variables.
+		if s.getAccessAllowed(w, r) == false {
+			returnAPIError(s, w, r,
+				errors.New("not authorized"),
+				http.StatusUnauthorized)
+			return
+		}
+
+		// Get the node associated with the request.
+		n, err := s.GetAccessNodeForHost(r.Host)
+		if err != nil {
+			returnAPIError(s, w, r, err, http.StatusInternalServerError)
+			return
+		}
+
+		// Read the array of encrypted values from the body. The size of the
+		// body is limited by the maximum number of items in a batch.
+		m := s.config.MaxDecodeBatchSizeOrDefault()
+		r.Body = http.MaxBytesReader(
+			w,
+			r.Body,
+			int64(m)*decodeBatchItemBytes)
+		var a []string
+		err = json.NewDecoder(r.Body).Decode(&a)
+		if err != nil {
+			returnAPIError(s, w, r, err, http.StatusBadRequest)
+			return
+		}
+		if len(a) > m {
+			returnAPIError(
+				s,
+				w,
+				r,
+				fmt.Errorf(
+					"batch of '%d' items exceeds the limit of '%d'",
+					len(a),
+					m),
+				http.StatusBadRequest)
+			return
+		}
+
+		// Decode each of the values recording any errors in place of the
+		// results.
+		o := make([]interface{}, len(a))
+		for i, e := range a {
+			v, err := decodeResultsAsJSON(s, n, e)
+			if err != nil {
+				o[i] = &batchError{Error: err.Error()}
+			} else {
+				o[i] = v
+			}
+		}
+
+		// Turn the array into a JSON string.
+		j, err := json.Marshal(o)
+		if err != nil {
+			returnAPIError(s, w, r, err, http.StatusInternalServerError)
+			return
+		}
+
+		// Send the JSON string.
+		sendResponse(s, w, r, "application/json", j)
+	}
+}
+
+// decodeResultsAsJSON decodes the base 64 encrypted string e with the access
+// node n into results with any values parsed. Returns an error if the string
+// is invalid or the results have expired.
+func decodeResultsAsJSON(s *Services, n *node, e string) (*Results, error) {
+	d, err := decodeResultsString(e)
+	if err != nil {
+		return nil, err
+	}
+	v, err := n.DecodeAsResults(d)
+	if err != nil {
+		return nil, err
+	}
+	if v.IsTimeStampValid() == false {
+		return nil, fmt.Errorf("data expired and can no longer be used")
+	}
+	s.parseValues(v)
+	return v, nil
+}
+
+// decodeResultsString returns the bytes of the encrypted results e. Results are
+// returned from storage operations URL safe base 64 encoded without padding,
+// but standard encoding is also accepted for callers that have converted them.
+func decodeResultsString(e string) ([]byte, error) {
+	d, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(e, "="))
+	if err != nil {
+		d, err = base64.StdEncoding.DecodeString(e)
+	}
+	return d, err
+}
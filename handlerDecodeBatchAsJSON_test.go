@@ -0,0 +1,143 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandlerDecodeBatchAsJSON(t *testing.T) {
+	s, a, err := newHandlerDecodeBatchTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	b, err := encodeResults(newResultsTest(time.Now().UTC().Add(time.Minute)))
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	d, err := a.encode(b)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	e := base64.StdEncoding.EncodeToString(d)
+	u := base64.RawURLEncoding.EncodeToString(d)
+
+	// The invalid item returns an error and the others the results in the
+	// same order as the request. Both URL safe and standard encodings are
+	// accepted.
+	w := testHandlerDecodeBatch(s, "key", []string{e, "not*base64!", u})
+	if w.Code != http.StatusOK {
+		fmt.Printf("Status '%d' not OK\n", w.Code)
+		t.Fail()
+		return
+	}
+	g, err := testGzipBody(w)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	var v []map[string]interface{}
+	err = json.Unmarshal(g, &v)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if len(v) != 3 {
+		fmt.Printf("'%d' items returned not '3'\n", len(v))
+		t.Fail()
+		return
+	}
+	for i, x := range v {
+		_, p := x["pairs"]
+		_, f := x["error"]
+		if (i == 1) != f || (i == 1) == p {
+			fmt.Printf("Item '%d' incorrect\n", i)
+			t.Fail()
+		}
+	}
+
+	// Batches larger than the limit are rejected.
+	s.config.MaxDecodeBatchSize = 2
+	w = testHandlerDecodeBatch(s, "key", []string{e, e, e})
+	if w.Code != http.StatusBadRequest {
+		fmt.Printf("Status '%d' not bad request for large batch\n", w.Code)
+		t.Fail()
+	}
+
+	// Bodies larger than allowed for the batch size are rejected.
+	w = testHandlerDecodeBatch(
+		s,
+		"key",
+		[]string{strings.Repeat("A", 2*decodeBatchItemBytes+1)})
+	if w.Code != http.StatusBadRequest {
+		fmt.Printf("Status '%d' not bad request for large body\n", w.Code)
+		t.Fail()
+	}
+
+	// Callers without a valid access key are rejected.
+	w = testHandlerDecodeBatch(s, "wrong", []string{e})
+	if w.Code != http.StatusNetworkAuthenticationRequired {
+		fmt.Printf("Status '%d' not network authentication required\n", w.Code)
+		t.Fail()
+	}
+}
+
+func testHandlerDecodeBatch(
+	s *Services,
+	k string,
+	a []string) *httptest.ResponseRecorder {
+	b, _ := json.Marshal(a)
+	r := httptest.NewRequest(
+		"POST",
+		"http://access.com/swift/api/v1/decode-batch-as-json?accessKey="+k,
+		strings.NewReader(string(b)))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	HandlerDecodeBatchAsJSON(s)(w, r)
+	return w
+}
+
+// newHandlerDecodeBatchTest returns services with the access node "access.com"
+// and the access key "key".
+func newHandlerDecodeBatchTest() (*Services, *node, error) {
+	a, err := newResultCompressTestNode()
+	if err != nil {
+		return nil, nil, err
+	}
+	c := newConfigurationTest()
+	s := NewServices(
+		c,
+		NewStorageService(c, newVolatile("test", false, []*node{a})),
+		NewAccessSimple([]string{"key"}),
+		nil)
+	return s, a, nil
+}
@@ -0,0 +1,96 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HandlerDecodePathAsJSON returns the results contained in the last segment of
+// the URL path as JSON data. The segment is the base 64 URL encoded encrypted
+// results. As the request is a GET with the encrypted results in the path the
+// response can be cached by a CDN until the results expire.
+func HandlerDecodePathAsJSON(s *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Set the origins that can read the response.
+		setAllowOrigin(s, w, r)
+
+		// Check caller can access and parse the form variables.
+		if s.getAccessAllowed(w, r) == false {
+			return
+		}
+
+		// Get the node associated with the request.
+		n, err := s.GetAccessNodeForHost(r.Host)
+		if err != nil {
+			returnAPIError(s, w, r, err, http.StatusInternalServerError)
+			return
+		}
+
+		// Decode the last segment of the path to form the byte array.
+		a := strings.Split(r.URL.Path, "/")
+		d, err := base64.RawURLEncoding.DecodeString(a[len(a)-1])
+		if err != nil {
+			returnAPIError(s, w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		// Decrypt and decode the data into a Results.
+		v, err := n.DecodeAsResults(d)
+		if err != nil {
+			returnAPIError(s, w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		// Validate that the timestamp has not expired.
+		if v.IsTimeStampValid() == false {
+			returnAPIError(
+				s,
+				w,
+				r,
+				fmt.Errorf("data expired and can no longer be used"),
+				http.StatusBadRequest)
+			return
+		}
+
+		// Add the structured form of any values that have a parser.
+		s.parseValues(v)
+
+		// Turn the Results into a JSON string.
+		j, err := json.Marshal(v)
+		if err != nil {
+			returnAPIError(s, w, r, err, http.StatusInternalServerError)
+			return
+		}
+
+		// Send the JSON string allowing it to be cached until the results
+		// expire.
+		sendCacheableResponse(
+			s,
+			w,
+			r,
+			"application/json",
+			j,
+			time.Until(v.expires))
+	}
+}
@@ -0,0 +1,107 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandlerDecodePathAsJSON(t *testing.T) {
+	s, a, err := newHandlerDecodeBatchTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	for _, x := range []struct {
+		expires time.Time
+		code    int
+	}{
+		{time.Now().UTC().Add(time.Minute), http.StatusOK},
+		{time.Now().UTC().Add(-time.Minute), http.StatusBadRequest}} {
+		b, err := encodeResults(newResultsTest(x.expires))
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		d, err := a.encode(b)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		w := testHandlerDecodePath(s, base64.RawURLEncoding.EncodeToString(d))
+		if w.Code != x.code {
+			fmt.Printf("Status '%d' not '%d'\n", w.Code, x.code)
+			t.Fail()
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		if strings.HasPrefix(
+			w.Header().Get("Cache-Control"),
+			"public, max-age=") == false {
+			fmt.Printf("Response not cacheable '%s'\n",
+				w.Header().Get("Cache-Control"))
+			t.Fail()
+		}
+		g, err := testGzipBody(w)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		var v map[string]interface{}
+		err = json.Unmarshal(g, &v)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		if _, ok := v["pairs"]; ok == false {
+			fmt.Println("Results not returned")
+			t.Fail()
+		}
+	}
+
+	// A segment that is not base 64 is rejected.
+	w := testHandlerDecodePath(s, "not*base64!")
+	if w.Code != http.StatusBadRequest {
+		fmt.Printf("Status '%d' for invalid segment\n", w.Code)
+		t.Fail()
+	}
+}
+
+func testHandlerDecodePath(s *Services, e string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(
+		"GET",
+		"http://access.com/swift/api/v1/decode-path-as-json/"+e+
+			"?accessKey=key",
+		nil)
+	w := httptest.NewRecorder()
+	HandlerDecodePathAsJSON(s)(w, r)
+	return w
+}
@@ -0,0 +1,100 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// HandlerDecodeValue returns the raw bytes of the first value for a single key
+// from the incoming request. The query string contains the encrypted data which
+// must be turned into a byte array and decrypted, and the key of the value to
+// return. If the key is not present in the results then not found is returned.
+func HandlerDecodeValue(s *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Check caller can access and parse the form variables.
+		if s.getAccessAllowed(w, r) == false {
+			returnAPIError(s, w, r,
+				errors.New("not authorized"),
+				http.StatusUnauthorized)
+			return
+		}
+
+		// Get the node associated with the request.
+		n, err := s.GetAccessNodeForHost(r.Host)
+		if err != nil {
+			returnAPIError(s, w, r, err, http.StatusInternalServerError)
+			return
+		}
+
+		// Decode the query string to form the byte array.
+		d, err := base64.StdEncoding.DecodeString(r.Form.Get("encrypted"))
+		if err != nil {
+			returnAPIError(s, w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		// Decrypt and decode the data into a Results.
+		v, err := n.DecodeAsResults(d)
+		if err != nil {
+			returnAPIError(s, w, r, err, http.StatusInternalServerError)
+			return
+		}
+
+		// Validate that the timestamp has not expired.
+		if v.IsTimeStampValid() == false {
+			returnAPIError(
+				s,
+				w,
+				r,
+				fmt.Errorf("data expired and can no longer be used"),
+				http.StatusBadRequest)
+			return
+		}
+
+		// Find the pair for the key.
+		k := r.Form.Get("key")
+		p := v.Get(k)
+		if p == nil {
+			returnAPIError(
+				s,
+				w,
+				r,
+				fmt.Errorf("key '%s' not found", k),
+				http.StatusNotFound)
+			return
+		}
+
+		// Send the raw bytes of the first value uncompressed so that the
+		// content length is the length of the value.
+		var b []byte
+		if len(p.values) > 0 {
+			b = p.values[0]
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+		_, err = w.Write(b)
+		if err != nil {
+			returnServerError(s, w, r, err)
+		}
+	}
+}
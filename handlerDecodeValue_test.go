@@ -0,0 +1,127 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestHandlerDecodeValue(t *testing.T) {
+	s, e, err := newHandlerDecodeValueTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	w := testHandlerDecodeValue(s, e, "a")
+	if w.Code != http.StatusOK {
+		fmt.Printf("Status '%d' returned\n", w.Code)
+		t.Fail()
+		return
+	}
+	if w.Header().Get("Content-Type") != "application/octet-stream" ||
+		w.Header().Get("Content-Length") != "5" {
+		fmt.Println("Headers incorrect")
+		t.Fail()
+	}
+	b, err := ioutil.ReadAll(w.Result().Body)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if string(b) != "Hello" {
+		fmt.Printf("Value '%s' not 'Hello'\n", b)
+		t.Fail()
+	}
+}
+
+func TestHandlerDecodeValueNotFound(t *testing.T) {
+	s, e, err := newHandlerDecodeValueTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	w := testHandlerDecodeValue(s, e, "missing")
+	if w.Code != http.StatusNotFound {
+		fmt.Printf("Status '%d' returned not '%d'\n",
+			w.Code,
+			http.StatusNotFound)
+		t.Fail()
+	}
+}
+
+func testHandlerDecodeValue(
+	s *Services,
+	e string,
+	k string) *httptest.ResponseRecorder {
+	q := url.Values{}
+	q.Set("accessKey", "key")
+	q.Set("encrypted", e)
+	q.Set("key", k)
+	w := httptest.NewRecorder()
+	HandlerDecodeValue(s)(w, httptest.NewRequest(
+		"GET",
+		"http://test-1.com/swift/api/v1/decode-value?"+q.Encode(),
+		nil))
+	return w
+}
+
+// newHandlerDecodeValueTest returns services and the test results encrypted by
+// the access node test-1.com.
+func newHandlerDecodeValueTest() (*Services, string, error) {
+	n, err := newNode(
+		"network",
+		"test-1.com",
+		time.Now().UTC(),
+		time.Now().UTC(),
+		time.Now().UTC().AddDate(1, 0, 0),
+		roleAccess,
+		"",
+		"")
+	if err != nil {
+		return nil, "", err
+	}
+	x, err := newSecret()
+	if err != nil {
+		return nil, "", err
+	}
+	n.addSecret(x)
+	c := newConfigurationTest()
+	s := NewServices(
+		c,
+		NewStorageService(c, newVolatile("test", false, []*node{n})),
+		NewAccessSimple([]string{"key"}),
+		nil)
+	b, err := encodeResults(newResultsTest(time.Now().UTC().Add(time.Minute)))
+	if err != nil {
+		return nil, "", err
+	}
+	b, err = n.encode(b)
+	if err != nil {
+		return nil, "", err
+	}
+	return s, base64.StdEncoding.EncodeToString(b), nil
+}
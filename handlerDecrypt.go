@@ -29,9 +29,12 @@ import (
 func HandlerDecrypt(s *Services) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
+		// Set the origins that can read the response.
+		setAllowOrigin(s, w, r)
+
 		// Check caller can access and parse the form variables.
 		if s.getAccessAllowed(w, r) == false {
-			returnAPIError(s, w,
+			returnAPIError(s, w, r,
 				errors.New("Not authorized"),
 				http.StatusUnauthorized)
 			return
@@ -40,33 +43,34 @@ func HandlerDecrypt(s *Services) http.HandlerFunc {
 		// Get the node associated with the request.
 		n, err := s.GetAccessNodeForHost(r.Host)
 		if err != nil {
-			returnAPIError(s, w, err, http.StatusInternalServerError)
+			returnAPIError(s, w, r, err, http.StatusInternalServerError)
 			return
 		}
 
 		// Decode the query string to form the byte array.
 		in, err := base64.StdEncoding.DecodeString(r.Form.Get("encrypted"))
 		if err != nil {
-			returnAPIError(s, w, err, http.StatusBadRequest)
+			returnAPIError(s, w, r, err, http.StatusBadRequest)
 			return
 		}
 
 		// Decrypt the byte array using the node.
 		d, err := n.decode(in)
 		if err != nil {
-			returnAPIError(s, w, err, http.StatusBadRequest)
+			returnAPIError(s, w, r, err, http.StatusBadRequest)
 			return
 		}
 		if d == nil {
 			returnAPIError(
 				s,
 				w,
+				r,
 				fmt.Errorf("Could not decrypt input"),
 				http.StatusBadRequest)
 			return
 		}
 
 		// Send the byte array.
-		sendResponse(s, w, "application/octet-stream", d)
+		sendResponse(s, w, r, "application/octet-stream", d)
 	}
 }
@@ -0,0 +1,57 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// HandlerDelete takes a Services pointer and returns a HTTP handler used to
+// remove the node for the domain form parameter from the store that contains
+// it. Requires a valid access key.
+func HandlerDelete(s *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Check caller can access.
+		if s.getAccessAllowed(w, r) == false {
+			return
+		}
+
+		// Get the domain of the node to delete.
+		d := r.FormValue("domain")
+		if d == "" {
+			returnAPIError(
+				s,
+				w,
+				r,
+				fmt.Errorf("Domain must be provided"),
+				http.StatusBadRequest)
+			return
+		}
+
+		// Delete the node.
+		err := s.store.DeleteNode(d)
+		if err != nil {
+			returnAPIError(s, w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		// Return the domain of the deleted node.
+		sendResponse(s, w, r, "text/plain; charset=utf-8", []byte(d))
+	}
+}
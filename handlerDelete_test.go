@@ -0,0 +1,103 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandlerDelete(t *testing.T) {
+	s, v, err := newHandlerDeleteTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	w := httptest.NewRecorder()
+	HandlerDelete(s)(w, httptest.NewRequest(
+		"GET",
+		"http://test.com/swift/api/v1/delete?accessKey=key&domain=test-1.com",
+		nil))
+	if w.Code != http.StatusOK {
+		fmt.Printf("Status '%d' returned\n", w.Code)
+		t.Fail()
+		return
+	}
+	n, err := v.getNode("test-1.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if n != nil {
+		fmt.Println("Node not deleted from store")
+		t.Fail()
+	}
+	if s.store.getNode("test-1.com") != nil {
+		fmt.Println("Node not deleted from storage manager")
+		t.Fail()
+	}
+	if s.store.getNode("test-2.com") == nil {
+		fmt.Println("Other node deleted from storage manager")
+		t.Fail()
+	}
+}
+
+func TestHandlerDeleteNotAllowed(t *testing.T) {
+	s, v, err := newHandlerDeleteTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	w := httptest.NewRecorder()
+	HandlerDelete(s)(w, httptest.NewRequest(
+		"GET",
+		"http://test.com/swift/api/v1/delete?accessKey=bad&domain=test-1.com",
+		nil))
+	if w.Code == http.StatusOK {
+		fmt.Println("Delete allowed with invalid access key")
+		t.Fail()
+	}
+	n, err := v.getNode("test-1.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if n == nil {
+		fmt.Println("Node deleted with invalid access key")
+		t.Fail()
+	}
+}
+
+func newHandlerDeleteTest() (*Services, *Volatile, error) {
+	v, err := newVolatileTest()
+	if err != nil {
+		return nil, nil, err
+	}
+	c := newConfigurationTest()
+	s := NewServices(
+		c,
+		NewStorageService(c, v),
+		NewAccessSimple([]string{"key"}),
+		nil)
+	return s, v, nil
+}
@@ -18,46 +18,79 @@ package swift
 
 import (
 	"encoding/base64"
+	"fmt"
 	"net/http"
 )
 
+// encryptMACTable is the value used in place of a table when creating the MAC
+// that authorizes a storage node to use the encrypt handler.
+const encryptMACTable = "encrypt"
+
 // HandlerEncrypt takes a Services pointer and returns a HTTP handler used to
 // encrypt the result of a storage operation ready to be provided to the return
-// URL.
+// URL. The caller must either provide a valid access key, or a MAC of the plain
+// data created with a secret of the access node as storage nodes that share
+// the access node's store do from getResults.
 func HandlerEncrypt(s *Services) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-
-		// No access control is needed here. All access nodes can encrypt data.
-		// Access keys are needed to decrypt the data.
-
 		err := r.ParseForm()
 		if err != nil {
-			returnAPIError(s, w, err, http.StatusInternalServerError)
+			returnAPIError(s, w, r, err, http.StatusInternalServerError)
 			return
 		}
 
 		// Get the node associated with the request.
 		n, err := s.GetAccessNodeForHost(r.Host)
 		if err != nil {
-			returnAPIError(s, w, err, http.StatusInternalServerError)
+			returnAPIError(s, w, r, err, http.StatusInternalServerError)
+			return
+		}
+
+		// Check the caller can access.
+		if getEncryptAllowed(s, r, n) == false {
+			returnAPIError(
+				s,
+				w,
+				r,
+				fmt.Errorf("Access denied"),
+				http.StatusNetworkAuthenticationRequired)
 			return
 		}
 
 		// Decode the query string to form the byte array.
 		in, err := base64.StdEncoding.DecodeString(r.Form.Get("plain"))
 		if err != nil {
-			returnAPIError(s, w, err, http.StatusBadRequest)
+			returnAPIError(s, w, r, err, http.StatusBadRequest)
 			return
 		}
 
-		// Encrypt the byte array using the node.
-		out, err := n.encode(in)
+		// Encrypt the byte array using the node. If the caller has already
+		// compressed the data then only encrypt it.
+		var out []byte
+		if r.Form.Get("compress") == "false" {
+			out, err = n.encodeWith(&noneCompressor{}, in)
+		} else {
+			out, err = n.encode(in)
+		}
 		if err != nil {
-			returnAPIError(s, w, err, http.StatusBadRequest)
+			returnAPIError(s, w, r, err, http.StatusBadRequest)
 			return
 		}
 
 		// The output is a binary array.
-		sendResponse(s, w, "application/octet-stream", out)
+		sendResponse(s, w, r, "application/octet-stream", out)
+	}
+}
+
+// getEncryptAllowed returns true if the request contains a valid MAC of the
+// plain data for the access node n, or a valid access key.
+func getEncryptAllowed(s *Services, r *http.Request, n *node) bool {
+	if n.verifyMac(encryptMACTable, r.Form.Get("plain"), r.Form.Get(macParam)) {
+		return true
+	}
+	if s.access == nil {
+		return false
 	}
+	v, err := s.IsAccessAllowed(r)
+	return v && err == nil
 }
@@ -0,0 +1,178 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHandlerEncrypt(t *testing.T) {
+	s, n, err := newHandlerEncryptTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	w := testHandlerEncrypt(s, "access.com", []byte("Hello"))
+	if w.Code != http.StatusOK {
+		fmt.Printf("Status '%d' returned\n", w.Code)
+		t.Fail()
+		return
+	}
+	b, err := testGzipBody(w)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	d, err := n.decode(b)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if string(d) != "Hello" {
+		fmt.Printf("Decrypted '%s' not 'Hello'\n", d)
+		t.Fail()
+	}
+}
+
+func TestHandlerEncryptNotAccessNode(t *testing.T) {
+	s, _, err := newHandlerEncryptTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	w := testHandlerEncrypt(s, "storage.com", []byte("Hello"))
+	if w.Code == http.StatusOK {
+		fmt.Println("Storage node encrypted data")
+		t.Fail()
+	}
+	w = testHandlerEncrypt(s, "missing.com", []byte("Hello"))
+	if w.Code == http.StatusOK {
+		fmt.Println("Unknown node encrypted data")
+		t.Fail()
+	}
+}
+
+func TestHandlerEncryptAccess(t *testing.T) {
+	s, _, err := newHandlerEncryptTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	s.access = NewAccessSimple([]string{"key"})
+	p := base64.StdEncoding.EncodeToString([]byte("Hello"))
+	for _, d := range []struct {
+		mac      string
+		key      string
+		expected int
+	}{
+		{"", "", http.StatusNetworkAuthenticationRequired},
+		{"bad", "", http.StatusNetworkAuthenticationRequired},
+		{"", "bad", http.StatusNetworkAuthenticationRequired},
+		{"", "key", http.StatusOK}} {
+		q := url.Values{}
+		q.Set("plain", p)
+		if d.mac != "" {
+			q.Set(macParam, d.mac)
+		}
+		if d.key != "" {
+			q.Set("accessKey", d.key)
+		}
+		w := testHandlerEncryptWith(s, "access.com", q)
+		if w.Code != d.expected {
+			fmt.Printf("MAC '%s' key '%s' expected '%d' got '%d'\n",
+				d.mac,
+				d.key,
+				d.expected,
+				w.Code)
+			t.Fail()
+		}
+	}
+}
+
+// testHandlerEncrypt encrypts b with the node for host h authorizing the
+// request with a MAC created with the node's secret if the node exists.
+func testHandlerEncrypt(
+	s *Services,
+	h string,
+	b []byte) *httptest.ResponseRecorder {
+	q := url.Values{}
+	p := base64.StdEncoding.EncodeToString(b)
+	q.Set("plain", p)
+	if n := s.store.getNode(h); n != nil {
+		m, err := n.mac(encryptMACTable, p)
+		if err == nil {
+			q.Set(macParam, m)
+		}
+	}
+	return testHandlerEncryptWith(s, h, q)
+}
+
+func testHandlerEncryptWith(
+	s *Services,
+	h string,
+	q url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(
+		"POST",
+		"http://"+h+"/swift/api/v1/encrypt",
+		strings.NewReader(q.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	HandlerEncrypt(s)(w, r)
+	return w
+}
+
+// newHandlerEncryptTest returns services with the access node "access.com" and
+// the storage node "storage.com".
+func newHandlerEncryptTest() (*Services, *node, error) {
+	a, err := newResultCompressTestNode()
+	if err != nil {
+		return nil, nil, err
+	}
+	n, err := newStoreQueueTestNode("storage.com")
+	if err != nil {
+		return nil, nil, err
+	}
+	c := newConfigurationTest()
+	s := NewServices(
+		c,
+		NewStorageService(c, newVolatile("test", false, []*node{a, n})),
+		nil,
+		nil)
+	return s, a, nil
+}
+
+// testGzipBody returns the uncompressed body of the gzip response w.
+func testGzipBody(w *httptest.ResponseRecorder) ([]byte, error) {
+	g, err := gzip.NewReader(w.Result().Body)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(g)
+}
@@ -0,0 +1,80 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// defaultExpiryWarningDays is the number of days before a node expires that it
+// is reported as expiring if not configured.
+const defaultExpiryWarningDays = 30
+
+// HandlerExpiring returns a JSON array of the nodes that expire within the
+// configured ExpiryWarningDays ordered by the time they expire. Nodes that have
+// already expired are included. Used to register nodes again before they
+// leave the hash ring and reduce the number of nodes available to operations.
+// Requires a valid access key.
+func HandlerExpiring(s *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Check caller can access.
+		if s.getAccessAllowed(w, r) == false {
+			return
+		}
+
+		nvs, err := getExpiring(s)
+		if err != nil {
+			returnServerError(s, w, r, err)
+			return
+		}
+
+		j, err := json.Marshal(nvs)
+		if err != nil {
+			returnServerError(s, w, r, err)
+			return
+		}
+		sendResponse(s, w, r, "application/json", j)
+	}
+}
+
+// getExpiring returns a view of the nodes that expire within the configured
+// warning period ordered by expiry time and then domain. If the same domain
+// appears in more than one store then only the most recently created node is
+// considered.
+func getExpiring(s *Services) ([]NodeView, error) {
+	nvs := []NodeView{}
+	d := s.config.ExpiryWarningDuration()
+	ns, err := s.store.getNewestNodes()
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range ns {
+		if n.ExpiresWithin(d) {
+			nvs = append(nvs, newNodeView(n))
+		}
+	}
+	sort.Slice(nvs, func(i, j int) bool {
+		if nvs[i].Expires.Equal(nvs[j].Expires) == false {
+			return nvs[i].Expires.Before(nvs[j].Expires)
+		}
+		return nvs[i].Domain < nvs[j].Domain
+	})
+	return nvs, nil
+}
@@ -0,0 +1,146 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandlerExpiring(t *testing.T) {
+	s, v, err := newHandlerDeleteTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+
+	// Two nodes expire within the default warning period and one expires just
+	// after it.
+	for _, d := range []struct {
+		domain  string
+		expires time.Duration
+	}{
+		{"test-1.com", 20 * 24 * time.Hour},
+		{"test-2.com", 10 * 24 * time.Hour},
+		{"test-3.com", 40 * 24 * time.Hour}} {
+		n, err := v.getNode(d.domain)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		n.expires = time.Now().UTC().Add(d.expires)
+	}
+
+	w := httptest.NewRecorder()
+	HandlerExpiring(s)(w, httptest.NewRequest(
+		"GET",
+		"http://test-1.com/swift/api/v1/expiring?accessKey=key",
+		nil))
+	if w.Code != http.StatusOK {
+		fmt.Printf("Status '%d' returned\n", w.Code)
+		t.Fail()
+		return
+	}
+	b, err := testGzipBody(w)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	var nvs []NodeView
+	err = json.Unmarshal(b, &nvs)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if len(nvs) != 2 ||
+		nvs[0].Domain != "test-2.com" ||
+		nvs[1].Domain != "test-1.com" {
+		fmt.Printf("Unexpected expiring nodes '%v'\n", nvs)
+		t.Fail()
+	}
+
+	// A longer warning period includes the third node.
+	s.config.ExpiryWarningDays = 60
+	nvs, err = getExpiring(s)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if len(nvs) != 3 {
+		fmt.Printf("Expected 3 expiring nodes, got '%d'\n", len(nvs))
+		t.Fail()
+	}
+}
+
+// TestHandlerExpiringStores checks that a domain in more than one store is
+// reported once using the most recently created node.
+func TestHandlerExpiringStores(t *testing.T) {
+	v, err := newVolatileTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	o, err := v.getNode("test-1.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	o.expires = time.Now().UTC().Add(10 * 24 * time.Hour)
+	a, err := v.getNode("test-2.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	a.expires = time.Now().UTC().Add(10 * 24 * time.Hour)
+
+	// The second store has a renewed copy of the first node and an older copy
+	// of the second node.
+	var r, b node
+	r.copyFrom(o)
+	r.created = o.created.Add(time.Hour)
+	r.expires = time.Now().UTC().AddDate(1, 0, 0)
+	b.copyFrom(a)
+	b.created = a.created.Add(-time.Hour)
+	c := newConfigurationTest()
+	s := NewServices(
+		c,
+		NewStorageService(c, v, newVolatile("seed", true, []*node{&r, &b})),
+		nil,
+		nil)
+	nvs, err := getExpiring(s)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if len(nvs) != 1 || nvs[0].Domain != "test-2.com" {
+		fmt.Printf("Unexpected expiring nodes '%v'\n", nvs)
+		t.Fail()
+	}
+}
@@ -0,0 +1,65 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// HandlerExport returns all the nodes, including secrets, for the network
+// provided in the network parameter encrypted with the requesting node's
+// secret. If no network is provided then the requesting node's network is
+// used. The decrypted document can be passed to ImportNetwork to move the
+// network to another store.
+func HandlerExport(s *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Check caller can access.
+		if s.getAccessAllowed(w, r) == false {
+			return
+		}
+
+		// Get the node associated with the request.
+		a := s.store.getNode(r.Host)
+		if a == nil {
+			err := fmt.Errorf("host '%s' is not a SWIFT node", r.Host)
+			returnAPIError(s, w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		n := r.Form.Get("network")
+		if n == "" {
+			n = a.network
+		}
+
+		j, err := s.store.ExportNetwork(n)
+		if err != nil {
+			returnAPIError(s, w, r, err, http.StatusNotFound)
+			return
+		}
+
+		// Encrypt the JSON response using the requesting node's secret.
+		b, err := a.encode(j)
+		if err != nil {
+			returnServerError(s, w, r, err)
+			return
+		}
+
+		w.Write(b)
+	}
+}
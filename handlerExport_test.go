@@ -0,0 +1,169 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandlerExport(t *testing.T) {
+	v := newVolatile("test", false, nil)
+	for _, d := range []struct {
+		network string
+		domain  string
+	}{
+		{"network", "export.com"},
+		{"network", "storage.com"},
+		{"other", "other.com"}} {
+		n, err := newNode(
+			d.network,
+			d.domain,
+			time.Now().UTC(),
+			time.Now().UTC().Add(-time.Minute),
+			time.Now().UTC().AddDate(1, 0, 0),
+			roleStorage,
+			"",
+			d.domain)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		for i := 0; i < 2; i++ {
+			x, err := newSecret()
+			if err != nil {
+				fmt.Println(err)
+				t.Fail()
+				return
+			}
+			n.addSecret(x)
+		}
+		v.setNode(n)
+	}
+	c := newConfigurationTest()
+	s := NewServices(
+		c,
+		NewStorageService(c, v),
+		NewAccessSimple([]string{"key"}),
+		nil)
+
+	t.Run("denied", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		HandlerExport(s)(w, httptest.NewRequest(
+			"GET",
+			"http://export.com/swift/api/v1/export?accessKey=wrong",
+			nil))
+		if w.Code != http.StatusNetworkAuthenticationRequired {
+			fmt.Printf("Expected '%d', got '%d'\n",
+				http.StatusNetworkAuthenticationRequired,
+				w.Code)
+			t.Fail()
+		}
+	})
+
+	t.Run("import", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		HandlerExport(s)(w, httptest.NewRequest(
+			"GET",
+			"http://export.com/swift/api/v1/export?accessKey=key",
+			nil))
+		if w.Code != http.StatusOK {
+			fmt.Printf("Expected '%d', got '%d'\n", http.StatusOK, w.Code)
+			t.Fail()
+			return
+		}
+		b, err := ioutil.ReadAll(w.Result().Body)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		d, err := s.store.getNode("export.com").decode(b)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+
+		// Import the network into an empty store and check the nodes and
+		// their secrets are present.
+		i := newVolatile("import", false, nil)
+		is := NewStorageService(c, i)
+		err = is.ImportNetwork("", d)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		for _, n := range []string{"export.com", "storage.com"} {
+			e, err := i.getNode(n)
+			if err != nil || e == nil {
+				fmt.Printf("Node '%s' not imported\n", n)
+				t.Fail()
+				continue
+			}
+			o := s.store.getNode(n)
+			if len(e.secrets) != len(o.secrets) {
+				fmt.Printf("Node '%s' imported with '%d' of '%d' secrets\n",
+					n,
+					len(e.secrets),
+					len(o.secrets))
+				t.Fail()
+				continue
+			}
+			for j, x := range o.secrets {
+				if e.secrets[j].key != x.key {
+					fmt.Printf("Node '%s' secret '%d' changed\n", n, j)
+					t.Fail()
+				}
+			}
+		}
+		if e, _ := i.getNode("other.com"); e != nil {
+			fmt.Println("Node from other network imported")
+			t.Fail()
+		}
+	})
+
+	t.Run("network case", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		HandlerExport(s)(w, httptest.NewRequest(
+			"GET",
+			"http://export.com/swift/api/v1/export?accessKey=key&network=Network",
+			nil))
+		if w.Code != http.StatusOK {
+			fmt.Printf("Expected '%d', got '%d'\n", http.StatusOK, w.Code)
+			t.Fail()
+		}
+	})
+
+	t.Run("missing network", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		HandlerExport(s)(w, httptest.NewRequest(
+			"GET",
+			"http://export.com/swift/api/v1/export?accessKey=key&network=none",
+			nil))
+		if w.Code != http.StatusNotFound {
+			fmt.Printf("Expected '%d', got '%d'\n", http.StatusNotFound, w.Code)
+			t.Fail()
+		}
+	})
+}
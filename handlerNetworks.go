@@ -0,0 +1,42 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// HandlerNetworks returns a JSON array of the names of all the networks known
+// to the storage service. Used to build user interfaces that need to select a
+// network.
+func HandlerNetworks(s *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Check caller can access.
+		if s.getAccessAllowed(w, r) == false {
+			return
+		}
+
+		j, err := json.Marshal(s.store.GetNetworks())
+		if err != nil {
+			returnServerError(s, w, r, err)
+			return
+		}
+		sendResponse(s, w, r, "application/json", j)
+	}
+}
@@ -0,0 +1,85 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandlerNetworks(t *testing.T) {
+	var a []*node
+	for _, d := range []struct {
+		network string
+		domain  string
+	}{
+		{"zeta", "zeta.com"},
+		{"network", "storage-1.com"},
+		{"network", "storage-2.com"}} {
+		n, err := newNode(
+			d.network,
+			d.domain,
+			time.Now().UTC(),
+			time.Now().UTC(),
+			time.Now().UTC().AddDate(1, 0, 0),
+			roleStorage,
+			"",
+			"")
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		a = append(a, n)
+	}
+
+	// The same network appears in both stores but is only returned once.
+	c := newConfigurationTest()
+	s := NewServices(
+		c,
+		NewStorageService(
+			c,
+			newVolatile("first", false, a[:2]),
+			newVolatile("second", false, a[2:])),
+		NewAccessSimple([]string{"key"}),
+		nil)
+	w := httptest.NewRecorder()
+	HandlerNetworks(s)(w, httptest.NewRequest(
+		"GET",
+		"http://storage-1.com/swift/api/v1/networks?accessKey=key",
+		nil))
+	b, err := testGzipBody(w)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	var v []string
+	err = json.Unmarshal(b, &v)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if len(v) != 2 || v[0] != "network" || v[1] != "zeta" {
+		fmt.Printf("Networks '%v' not '[network zeta]'\n", v)
+		t.Fail()
+	}
+}
@@ -17,11 +17,21 @@
 package swift
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"hash/fnv"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// defaultNodesPageSize is the number of nodes displayed on each page of the
+// nodes HTML if no page size is requested.
+const defaultNodesPageSize = 100
+
 // NodeView is a struct containing the node fields to display in the nodes
 // swiftNodesTemplate
 type NodeView struct {
@@ -36,11 +46,27 @@ type NodeView struct {
 }
 
 // NodeViews is a struct which contains an array of NodeView which is used
-// to display a list of nodes using the swiftNodesTemplate
+// to display a page of the list of nodes using the swiftNodesTemplate
 type NodeViews struct {
-	Nodes []NodeView
+	Nodes    []NodeView
+	Page     int // The current page starting at 1
+	Pages    int // The total number of pages
+	PageSize int // The maximum number of nodes on each page
+	Total    int // The total number of nodes across all pages
 }
 
+// HasPrevious returns true if there is a page before the current one.
+func (nv *NodeViews) HasPrevious() bool { return nv.Page > 1 }
+
+// HasNext returns true if there is a page after the current one.
+func (nv *NodeViews) HasNext() bool { return nv.Page < nv.Pages }
+
+// PreviousPage returns the number of the page before the current one.
+func (nv *NodeViews) PreviousPage() int { return nv.Page - 1 }
+
+// NextPage returns the number of the page after the current one.
+func (nv *NodeViews) NextPage() int { return nv.Page + 1 }
+
 // Get the NodeView
 func (nv *NodeViews) NodeViewItems() []NodeView {
 	return nv.Nodes
@@ -48,73 +74,183 @@ func (nv *NodeViews) NodeViewItems() []NodeView {
 
 // HandlerNodes is a handler that returns a list of all the known nodes, each
 // node is converted into a NodeView item which is then used to populate an HTML
-// template.
+// template. The optional page and pageSize query parameters select the page of
+// nodes to display.
 func HandlerNodes(s *Services) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		nvs, err := getNodesView(s)
+		q := r.URL.Query()
+		p, _ := strconv.Atoi(q.Get("page"))
+		z, _ := strconv.Atoi(q.Get("pageSize"))
+		nvs, err := getNodesView(s, p, z)
 		if err != nil {
-			returnAPIError(s, w, err, http.StatusInternalServerError)
+			returnAPIError(s, w, r, err, http.StatusInternalServerError)
+			return
 		}
-		sendHTMLTemplate(s, w, swiftNodesTemplate, &nvs)
+		sendHTMLTemplate(s, w, r, swiftNodesTemplate, nvs)
 	}
 }
 
 // HandlerNodesJSON is a handler that returns a list of all the alive nodes
-// which is then used to serialize to JSON.
+// which is then used to serialize to JSON. An ETag is returned so that callers
+// can avoid receiving the same list again using If-None-Match.
 func HandlerNodesJSON(s *Services) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		j, err := getJSON(s)
 		if err != nil {
-			returnAPIError(s, w, err, http.StatusInternalServerError)
+			returnAPIError(s, w, r, err, http.StatusInternalServerError)
 			return
 		}
-		sendResponse(s, w, "application/json", j)
+		e := getETag(j)
+		w.Header().Set("ETag", e)
+		if getETagMatch(r.Header.Get("If-None-Match"), e) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		sendResponse(s, w, r, "application/json", j)
 	}
 }
 
-func getJSON(s *Services) ([]byte, error) {
-
-	// Get all the nodes.
-	ns, err := s.store.getAllNodes()
-	if err != nil {
-		return nil, err
+// HandlerNodesByRole is a handler that returns a JSON array of the nodes with
+// the role provided in the role query parameter. Each node is converted into a
+// NodeView item so that secrets are not included.
+func HandlerNodesByRole(s *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v := r.URL.Query().Get("role")
+		i, err := strconv.Atoi(v)
+		if err != nil || i < roleAccess || i > roleShare {
+			returnAPIError(
+				s,
+				w,
+				r,
+				fmt.Errorf("role '%s' is not valid", v),
+				http.StatusBadRequest)
+			return
+		}
+		ns, err := s.store.GetNodesByRole(i)
+		if err != nil {
+			returnAPIError(s, w, r, err, http.StatusInternalServerError)
+			return
+		}
+		nvs := make([]NodeView, 0, len(ns))
+		for _, n := range ns {
+			nvs = append(nvs, newNodeView(n))
+		}
+		j, err := json.Marshal(nvs)
+		if err != nil {
+			returnServerError(s, w, r, err)
+			return
+		}
+		sendResponse(s, w, r, "application/json", j)
 	}
+}
+
+// getETag returns a quoted ETag for the byte array b.
+func getETag(b []byte) string {
+	h := fnv.New64a()
+	h.Write(b)
+	return fmt.Sprintf("\"%x\"", h.Sum64())
+}
 
-	// Turn them into a map.
-	nis := make(map[string]*node)
-	for _, n := range ns {
-		if n.alive {
-			nis[n.domain] = n
+// getETagMatch returns true if the If-None-Match header value v contains the
+// ETag e or is "*".
+func getETagMatch(v string, e string) bool {
+	for _, i := range strings.Split(v, ",") {
+		i = strings.TrimPrefix(strings.TrimSpace(i), "W/")
+		if i == e || i == "*" {
+			return true
 		}
 	}
+	return false
+}
 
-	// Turn the map into a JSON string.
-	j, err := json.Marshal(nis)
+// getJSON returns a JSON object of the alive nodes keyed on domain. The nodes
+// are ordered by domain so that the JSON is the same for the same nodes. Only
+// references to the alive nodes are collected from the stores. If the same
+// domain appears in more than one store then the first is used.
+func getJSON(s *Services) ([]byte, error) {
+	var ns []*node
+	d := make(map[string]bool)
+	err := s.store.iterateAllNodes(func(n *node) error {
+		if n.IsAlive() && d[n.domain] == false {
+			d[n.domain] = true
+			ns = append(ns, n)
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-
-	return j, nil
+	sort.Slice(ns, func(i, j int) bool {
+		return ns[i].domain < ns[j].domain
+	})
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, n := range ns {
+		k, err := json.Marshal(n.domain)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(n)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
 }
 
-func getNodesView(s *Services) (*NodeViews, error) {
+// getNodesView returns a view of the page of nodes ordered by network and then
+// domain. Pages start at 1. If the page size is not positive then the default
+// is used. Pages outside the available range are clamped to the first or last
+// page.
+func getNodesView(s *Services, page int, pageSize int) (*NodeViews, error) {
 	var nvs NodeViews
 	ns, err := s.store.getAllNodes()
 	if err != nil {
 		return nil, err
 	}
-	for _, n := range ns {
-		nv := NodeView{
-			Network:  n.network,
-			Domain:   n.domain,
-			Created:  n.created,
-			Starts:   n.starts,
-			Expires:  n.expires,
-			Role:     n.role,
-			Accessed: n.accessed,
-			Alive:    n.alive,
-		}
-		nvs.Nodes = append(nvs.Nodes, nv)
+	if pageSize <= 0 {
+		pageSize = defaultNodesPageSize
+	}
+	nvs.PageSize = pageSize
+	nvs.Total = len(ns)
+	nvs.Pages = (len(ns) + pageSize - 1) / pageSize
+	if nvs.Pages == 0 {
+		nvs.Pages = 1
+	}
+	if page < 1 {
+		page = 1
+	} else if page > nvs.Pages {
+		page = nvs.Pages
+	}
+	nvs.Page = page
+	i := (page - 1) * pageSize
+	e := i + pageSize
+	if e > len(ns) {
+		e = len(ns)
+	}
+	for _, n := range ns[i:e] {
+		nvs.Nodes = append(nvs.Nodes, newNodeView(n))
 	}
 	return &nvs, nil
 }
+
+// newNodeView returns the view of the node n.
+func newNodeView(n *node) NodeView {
+	return NodeView{
+		Network:  n.network,
+		Domain:   n.domain,
+		Created:  n.created,
+		Starts:   n.starts,
+		Expires:  n.expires,
+		Role:     n.role,
+		Accessed: n.Accessed(),
+		Alive:    n.IsAlive(),
+	}
+}
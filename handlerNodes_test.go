@@ -0,0 +1,306 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandlerNodesJSON(t *testing.T) {
+	s, v, err := newHandlerDeleteTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	n, err := v.getNode("test-2.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	n.SetAlive(false)
+	b, err := getJSON(s)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	var m map[string]json.RawMessage
+	err = json.Unmarshal(b, &m)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if len(m) != 9 || m["test-1.com"] == nil || m["test-2.com"] != nil {
+		fmt.Printf("Expected 9 alive nodes, got '%d'\n", len(m))
+		t.Fail()
+	}
+}
+
+func TestHandlerNodesView(t *testing.T) {
+	s, _, err := newHandlerDeleteTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	nvs, err := getNodesView(s, 0, 0)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if len(nvs.Nodes) != 10 {
+		fmt.Printf("Expected 10 nodes, got '%d'\n", len(nvs.Nodes))
+		t.Fail()
+	}
+}
+
+func TestHandlerNodesViewOrder(t *testing.T) {
+	s, _, err := newHandlerDeleteTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	a, err := getNodesView(s, 0, 0)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	b, err := getNodesView(s, 0, 0)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	for i := range a.Nodes {
+		if a.Nodes[i].Domain != b.Nodes[i].Domain {
+			fmt.Printf("Node '%d' differs between calls\n", i)
+			t.Fail()
+		}
+		if i > 0 && a.Nodes[i-1].Domain > a.Nodes[i].Domain {
+			fmt.Printf("Node '%s' not ordered\n", a.Nodes[i].Domain)
+			t.Fail()
+		}
+	}
+}
+
+func TestHandlerNodesViewPages(t *testing.T) {
+	s, _, err := newHandlerDeleteTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	for _, d := range []struct {
+		page     int
+		pageSize int
+		expected int // the page returned
+		count    int // the number of nodes on the page
+	}{
+		{1, 4, 1, 4},
+		{2, 4, 2, 4},
+		{3, 4, 3, 2},
+		{4, 4, 3, 2},
+		{0, 4, 1, 4},
+		{-1, 4, 1, 4},
+		{1, 0, 1, 10},
+		{2, 0, 1, 10}} {
+		nvs, err := getNodesView(s, d.page, d.pageSize)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		if nvs.Page != d.expected || len(nvs.Nodes) != d.count {
+			fmt.Printf("Page '%d' size '%d' returned page '%d' with '%d'\n",
+				d.page,
+				d.pageSize,
+				nvs.Page,
+				len(nvs.Nodes))
+			t.Fail()
+		}
+		if nvs.Total != 10 {
+			fmt.Printf("Expected 10 nodes in total, got '%d'\n", nvs.Total)
+			t.Fail()
+		}
+	}
+}
+
+func TestHandlerNodesPageLinks(t *testing.T) {
+	s, _, err := newHandlerDeleteTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	r := httptest.NewRequest(
+		"GET",
+		"http://test-1.com/swift/nodes?page=2&pageSize=4",
+		nil)
+	w := httptest.NewRecorder()
+	HandlerNodes(s)(w, r)
+	b, err := testGzipBody(w)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	for _, l := range []string{
+		"?page=1&pageSize=4",
+		"?page=3&pageSize=4",
+		"Page 2 of 3"} {
+		if strings.Contains(string(b), l) == false {
+			fmt.Printf("'%s' not found in nodes HTML\n", l)
+			t.Fail()
+		}
+	}
+}
+
+func TestStorageManagerIterateAllNodesError(t *testing.T) {
+	s, _, err := newHandlerDeleteTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	c := 0
+	err = s.store.iterateAllNodes(func(n *node) error {
+		c++
+		return fmt.Errorf("stop")
+	})
+	if err == nil || c != 1 {
+		fmt.Printf("Iteration did not stop, '%d' nodes visited\n", c)
+		t.Fail()
+	}
+}
+
+func TestHandlerNodesJSONETag(t *testing.T) {
+	s, v, err := newHandlerDeleteTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	w := testHandlerNodesJSON(s, "")
+	e := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || e == "" {
+		fmt.Printf("Status '%d' ETag '%s' returned\n", w.Code, e)
+		t.Fail()
+		return
+	}
+	if testHandlerNodesJSON(s, "").Header().Get("ETag") != e {
+		fmt.Println("ETag not deterministic")
+		t.Fail()
+	}
+	w = testHandlerNodesJSON(s, e)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		fmt.Printf("Status '%d' returned for matching ETag\n", w.Code)
+		t.Fail()
+	}
+
+	// Changing the alive nodes changes the ETag.
+	n, err := v.getNode("test-2.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	n.SetAlive(false)
+	w = testHandlerNodesJSON(s, e)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == e {
+		fmt.Println("ETag not changed when nodes changed")
+		t.Fail()
+	}
+}
+
+func testHandlerNodesJSON(
+	s *Services,
+	e string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "http://test-1.com/swift/api/v1/nodes", nil)
+	if e != "" {
+		r.Header.Set("If-None-Match", e)
+	}
+	w := httptest.NewRecorder()
+	HandlerNodesJSON(s)(w, r)
+	return w
+}
+
+func TestHandlerNodesByRole(t *testing.T) {
+	s, _, err := newHandlerDeleteTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	for _, d := range []struct {
+		role  string
+		code  int
+		count int
+	}{
+		{"0", http.StatusOK, 10},
+		{"1", http.StatusOK, 0},
+		{"access", http.StatusBadRequest, 0},
+		{"9", http.StatusBadRequest, 0}} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(
+			"GET",
+			"http://test-1.com/swift/api/v1/nodes-by-role?role="+d.role,
+			nil)
+		HandlerNodesByRole(s)(w, r)
+		if w.Code != d.code {
+			fmt.Printf("Role '%s' returned '%d'\n", d.role, w.Code)
+			t.Fail()
+			continue
+		}
+		if d.code != http.StatusOK {
+			continue
+		}
+		b, err := testGzipBody(w)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		var nvs []NodeView
+		err = json.Unmarshal(b, &nvs)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		if len(nvs) != d.count {
+			fmt.Printf("Role '%s' returned '%d' nodes\n", d.role, len(nvs))
+			t.Fail()
+		}
+		for i := 1; i < len(nvs); i++ {
+			if nvs[i-1].Domain > nvs[i].Domain {
+				fmt.Println("Nodes not ordered by domain")
+				t.Fail()
+			}
+		}
+	}
+}
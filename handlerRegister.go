@@ -19,30 +19,26 @@ package swift
 import (
 	"fmt"
 	"net/http"
-	"strconv"
 	"time"
 )
 
+// Policies applied when a cookie domain overlaps with a node in another network.
+const (
+	cookieDomainOverlapIgnore = "ignore"
+	cookieDomainOverlapWarn   = "warn"
+	cookieDomainOverlapReject = "reject"
+)
+
 // HandlerRegister takes a Services pointer and returns a HTTP handler used to
 // register a domain as an Access Node or a Storage Node. Does not work after
 // the domain has been registered in the storage service.
 func HandlerRegister(s *Services) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var err error
-
 		var d Register
 		d.StoreNames = s.store.GetStoreNames()
-		d.Store = ""
 		d.request = r
 		d.Services = s
 		d.Domain = r.Host
-		d.Starts = time.Now().UTC().AddDate(0, 0, 1)
-		d.Network = ""
-		d.Expires = time.Now().UTC().AddDate(0, 3, 0)
-		d.Role = roleStorage
-		d.Secret = true
-		d.Scramble = true
-		d.CookieDomain = r.Host
 
 		// Check that the domain has not already been registered.
 		n := s.store.getNode(r.Host)
@@ -51,74 +47,80 @@ func HandlerRegister(s *Services) http.HandlerFunc {
 		}
 
 		// Get any values from the form.
-		err = r.ParseForm()
-		if err != nil {
-			returnServerError(s, w, err)
+		q, err := parseRegisterRequest(r)
+		if e, ok := err.(*registerRequestError); ok {
+			d.NetworkError = e.network
+			d.RoleError = e.role
+			d.ExpiresError = e.expires
+			d.StartsError = e.starts
+		} else if err != nil {
+			returnServerError(s, w, r, err)
 			return
 		}
 		d.DisplayErrors = len(r.Form) > 0
+		d.setRegisterRequest(q)
 
-		// Get the store information
-		d.Store = r.FormValue("store")
-
-		// Get the network information.
-		d.Network = r.FormValue("network")
-		if len(d.Network) <= 3 {
-			d.NetworkError = "Network must be longer than 3 characters"
-		} else if len(d.Network) > 20 {
-			d.NetworkError = "Network can not be longer than 20 characters"
-		}
-
-		// Get the role information.
-		if r.FormValue("role") != "" {
-			d.Role, err = strconv.Atoi(r.FormValue("role"))
+		// Get the setup token if one is required.
+		if s.config.RegisterTokenRequired {
+			err = s.tokens.validate(d.Token, d.Network, d.Role)
 			if err != nil {
-				d.RoleError = err.Error()
-			} else if d.Role != roleAccess &&
-				d.Role != roleStorage &&
-				d.Role != roleShare {
-				d.RoleError = fmt.Sprintf("Role '%d' invalid", d.Role)
+				d.TokenError = err.Error()
 			}
 		}
 
-		// Get the node expiry information.
-		if r.FormValue("expires") != "" {
-			d.Expires, err = time.Parse("2006-01-02", r.FormValue("expires"))
-			if err != nil {
-				d.ExpiresError = err.Error()
-			} else if d.Expires.Before(time.Now().UTC()) {
-				d.ExpiresError = "Expiry date must be in the future"
-			}
+		// Check the cookie domain does not collide with a node in a different
+		// network.
+		if d.NetworkError == "" {
+			checkCookieDomainOverlap(s, &d)
 		}
 
-		// Get the node starts information.
-		if r.FormValue("starts") != "" {
-			d.Starts, err = time.Parse("2006-01-02T15:04", r.FormValue("starts"))
-			if err != nil {
-				d.StartsError = err.Error()
-			}
-		}
-
-		// Get the secrets, scramble and cookie domain.
-		if r.FormValue("cookieDomain") != "" {
-			d.CookieDomain = r.FormValue("cookieDomain")
-		}
-		d.Secret = r.FormValue("secret") == "true" ||
-			r.FormValue("secret") == "yes" ||
-			r.FormValue("secret") == "1"
-		d.Scramble = r.FormValue("scramble") == "true" ||
-			r.FormValue("scramble") == "yes" ||
-			r.FormValue("scramble") == "1"
-
 		// If the form data is valid then store the new node.
 		if d.ExpiresError == "" &&
 			d.RoleError == "" &&
-			d.NetworkError == "" {
+			d.NetworkError == "" &&
+			d.TokenError == "" &&
+			d.CookieDomainError == "" {
 			storeNode(s, &d)
 		}
 
 		// Return the HTML page.
-		sendHTMLTemplate(s, w, registerTemplate, &d)
+		sendHTMLTemplate(s, w, r, registerTemplate, &d)
+	}
+}
+
+// checkCookieDomainOverlap applies the configured cookie domain overlap policy
+// if the node being registered would share cookies with a node in a different
+// network. Cookies collide when they share a domain and a path. The path is the
+// table name which is scrambled when the node has a scrambler. As different
+// scramblers produce different paths for the same table the cookies can only
+// collide when neither node scrambles.
+func checkCookieDomainOverlap(s *Services, d *Register) {
+	if s.config.CookieDomainOverlap == "" ||
+		s.config.CookieDomainOverlap == cookieDomainOverlapIgnore ||
+		d.Scramble {
+		return
+	}
+	ns, err := s.store.getAllNodes()
+	if err != nil {
+		d.Error = err.Error()
+		return
+	}
+	for _, n := range ns {
+		if n.network != d.Network &&
+			n.cookieDomain == d.CookieDomain &&
+			n.scrambler == nil {
+			m := fmt.Sprintf(
+				"Cookie domain '%s' overlaps with node '%s' in network '%s'",
+				d.CookieDomain,
+				n.domain,
+				n.network)
+			if s.config.CookieDomainOverlap == cookieDomainOverlapReject {
+				d.CookieDomainError = m
+			} else {
+				s.config.errorf("SWIFT:%s\n", m)
+			}
+			return
+		}
 	}
 }
 
@@ -152,7 +154,7 @@ func storeNode(s *Services, d *Register) {
 
 	// Add the first secret to the node if secrets are to be used.
 	if d.Secret {
-		x, err := newSecret()
+		x, err := newSecretWithCipher(s.config.CipherOrDefault())
 		if err != nil {
 			d.Error = err.Error()
 			return
@@ -162,12 +164,36 @@ func storeNode(s *Services, d *Register) {
 		n.secrets = []*secret{}
 	}
 
+	// Reserve the setup token immediately before storing the node so that a
+	// concurrent request can not use the same token.
+	if s.config.RegisterTokenRequired {
+		err = s.tokens.reserve(d.Token, d.Network, d.Role)
+		if err != nil {
+			d.TokenError = err.Error()
+			return
+		}
+	}
+
 	// Store the node and it successful mark the registration process as
-	// complete.
+	// complete. The setup token is only consumed once the node is stored so
+	// that it can be presented again if the store failed.
 	err = s.store.setNodes(d.Store, n)
 	if err != nil {
 		d.StoreError = err.Error()
+		if s.config.RegisterTokenRequired {
+			s.tokens.release(d.Token)
+		}
 	} else {
 		d.ReadOnly = true
+		if s.config.RegisterTokenRequired {
+			err = s.tokens.consume(d.Token)
+			if err != nil {
+				s.config.errorf("SWIFT:%s\n", err.Error())
+			}
+		}
+		err = s.store.invalidateUnlessQueued()
+		if err != nil {
+			s.config.errorf("SWIFT:%s\n", err.Error())
+		}
 	}
 }
@@ -0,0 +1,177 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// registerResult is the node returned from the JSON register handler after it
+// has been stored.
+type registerResult struct {
+	Domain  string    `json:"domain"`
+	Network string    `json:"network"`
+	Role    int       `json:"role"`
+	Expires time.Time `json:"expires"`
+}
+
+// registerError is returned from the JSON register handler if the node could
+// not be registered. Fields contains the errors for any invalid fields of the
+// request keyed on the JSON field name.
+type registerError struct {
+	Error  string            `json:"error"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// HandlerRegisterJSON takes a Services pointer and returns a HTTP handler used
+// to register the domain of the request as a node from a JSON RegisterRequest
+// in the request body. Used for automated provisioning of nodes. Returns the
+// node created or a JSON error. Does not work after the domain has been
+// registered in the storage service.
+func HandlerRegisterJSON(s *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Check caller can access.
+		if s.getAccessAllowed(w, r) == false {
+			return
+		}
+
+		// Check that the domain has not already been registered.
+		if s.store.getNode(r.Host) != nil {
+			sendRegisterError(s, w, r, &registerError{
+				Error: fmt.Sprintf("'%s' is already registered", r.Host)},
+				http.StatusConflict)
+			return
+		}
+
+		// Read the request from the body using the defaults for any fields
+		// that are not provided.
+		q := newRegisterRequest(r.Host)
+		err := json.NewDecoder(r.Body).Decode(q)
+		if err != nil {
+			sendRegisterError(
+				s,
+				w,
+				r,
+				&registerError{Error: err.Error()},
+				http.StatusBadRequest)
+			return
+		}
+
+		var d Register
+		d.Services = s
+		d.Domain = r.Host
+		var e registerRequestError
+		q.validate(&e)
+		d.setRegisterRequest(q)
+		d.NetworkError = e.network
+		d.RoleError = e.role
+		d.ExpiresError = e.expires
+
+		// Check the setup token and cookie domain as the HTML handler does.
+		if s.config.RegisterTokenRequired {
+			err = s.tokens.validate(d.Token, d.Network, d.Role)
+			if err != nil {
+				d.TokenError = err.Error()
+			}
+		}
+		if d.NetworkError == "" {
+			checkCookieDomainOverlap(s, &d)
+		}
+		f := getRegisterFieldErrors(&d)
+		if len(f) > 0 {
+			sendRegisterError(s, w, r, &registerError{
+				Error:  "Invalid register request",
+				Fields: f},
+				http.StatusBadRequest)
+			return
+		}
+
+		// Store the node.
+		storeNode(s, &d)
+		if d.TokenError != "" {
+			sendRegisterError(s, w, r, &registerError{
+				Error:  "Invalid register request",
+				Fields: getRegisterFieldErrors(&d)},
+				http.StatusBadRequest)
+			return
+		}
+		if d.ReadOnly == false {
+			m := d.Error
+			if m == "" {
+				m = d.StoreError
+			}
+			sendRegisterError(
+				s,
+				w,
+				r,
+				&registerError{Error: m},
+				http.StatusInternalServerError)
+			return
+		}
+
+		j, err := json.Marshal(&registerResult{
+			Domain:  d.Domain,
+			Network: d.Network,
+			Role:    d.Role,
+			Expires: d.Expires})
+		if err != nil {
+			returnServerError(s, w, r, err)
+			return
+		}
+		sendResponse(s, w, r, "application/json", j)
+	}
+}
+
+// getRegisterFieldErrors returns the errors for the invalid fields of the
+// registration keyed on the JSON field name of the RegisterRequest.
+func getRegisterFieldErrors(d *Register) map[string]string {
+	f := make(map[string]string)
+	for k, v := range map[string]string{
+		"network":      d.NetworkError,
+		"role":         d.RoleError,
+		"expires":      d.ExpiresError,
+		"token":        d.TokenError,
+		"cookieDomain": d.CookieDomainError} {
+		if v != "" {
+			f[k] = v
+		}
+	}
+	return f
+}
+
+// sendRegisterError writes the JSON error e with the status code provided.
+func sendRegisterError(
+	s *Services,
+	w http.ResponseWriter,
+	r *http.Request,
+	e *registerError,
+	code int) {
+	j, err := json.Marshal(e)
+	if err != nil {
+		returnServerError(s, w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(j)
+	s.config.debugf("SWIFT:%s\n", e.Error)
+}
@@ -0,0 +1,86 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// HandlerRegisterToken takes a Services pointer and returns a HTTP handler used
+// by operators to issue a one time setup token. The token permits a single
+// domain to register via HandlerRegister in the network and role provided
+// before the token expires. Requires a valid access key.
+func HandlerRegisterToken(s *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+
+		// Check caller can access.
+		if s.getAccessAllowed(w, r) == false {
+			return
+		}
+
+		// Get the network the token is valid for.
+		n := normalizeNetwork(r.FormValue("network"))
+		if n == "" {
+			returnAPIError(
+				s,
+				w,
+				r,
+				fmt.Errorf("Network must be provided"),
+				http.StatusBadRequest)
+			return
+		}
+
+		// Get the role the token is valid for.
+		o, err := strconv.Atoi(r.FormValue("role"))
+		if err != nil {
+			returnAPIError(s, w, r, err, http.StatusBadRequest)
+			return
+		}
+		if o != roleAccess && o != roleStorage && o != roleShare {
+			returnAPIError(
+				s,
+				w,
+				r,
+				fmt.Errorf("Role '%d' invalid", o),
+				http.StatusBadRequest)
+			return
+		}
+
+		// Get the time the token expires. Defaults to one day.
+		e := time.Now().UTC().AddDate(0, 0, 1)
+		if r.FormValue("expires") != "" {
+			e, err = time.Parse("2006-01-02T15:04", r.FormValue("expires"))
+			if err != nil {
+				returnAPIError(s, w, r, err, http.StatusBadRequest)
+				return
+			}
+		}
+
+		// Issue the token and return it as plain text.
+		t, err := s.IssueRegisterToken(n, o, e)
+		if err != nil {
+			returnServerError(s, w, r, err)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		sendResponse(s, w, r, "text/plain; charset=utf-8", []byte(t))
+	}
+}
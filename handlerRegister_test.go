@@ -0,0 +1,263 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCookieDomainOverlapReject(t *testing.T) {
+	n := testCookieDomainOverlap(t, cookieDomainOverlapReject, false, nil)
+	if n != nil {
+		fmt.Println("Node registered with overlapping cookie domain")
+		t.Fail()
+	}
+}
+
+func TestCookieDomainOverlapWarn(t *testing.T) {
+	var l testLogger
+	n := testCookieDomainOverlap(t, cookieDomainOverlapWarn, false, &l)
+	if n == nil {
+		fmt.Println("Node not registered when overlap policy is warn")
+		t.Fail()
+	}
+	if len(l.error) != 1 || strings.Contains(l.error[0], "overlaps") == false {
+		fmt.Printf("Overlap warning '%v' not logged\n", l.error)
+		t.Fail()
+	}
+}
+
+func TestCookieDomainOverlapScrambled(t *testing.T) {
+	n := testCookieDomainOverlap(t, cookieDomainOverlapReject, true, nil)
+	if n == nil {
+		fmt.Println("Node with scrambled paths not registered")
+		t.Fail()
+	}
+}
+
+// testCookieDomainOverlap registers a node in a different network to an
+// existing node using the same cookie domain. Returns the new node if it was
+// registered, otherwise nil. If l is not nil then it is used as the logger.
+func testCookieDomainOverlap(
+	t *testing.T,
+	p string,
+	scramble bool,
+	l *testLogger) *node {
+	v := newVolatile("test", false, nil)
+	e, err := newNode(
+		"other",
+		"existing.com",
+		time.Now().UTC(),
+		time.Now().UTC(),
+		time.Now().UTC().AddDate(1, 0, 0),
+		roleStorage,
+		"",
+		"shared.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return nil
+	}
+	v.setNode(e)
+	c := newConfigurationTest()
+	c.CookieDomainOverlap = p
+	if l != nil {
+		c.Logger = l
+	}
+	s := NewServices(c, NewStorageService(c, v), NewAccessSimple(nil), nil)
+	q := url.Values{}
+	q.Set("store", "test")
+	q.Set("network", "network")
+	q.Set("role", fmt.Sprintf("%d", roleStorage))
+	q.Set("cookieDomain", "shared.com")
+	if scramble {
+		q.Set("scramble", "true")
+	}
+	r := httptest.NewRequest(
+		"GET",
+		"http://new.com/swift/register?"+q.Encode(),
+		nil)
+	HandlerRegister(s)(httptest.NewRecorder(), r)
+	n, err := v.getNode("new.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return nil
+	}
+	return n
+}
+
+func TestHandlerRegisterNetworkNormalized(t *testing.T) {
+	for _, d := range []struct {
+		network  string
+		expected string // the network of the registered node or empty
+	}{
+		{" New-Net ", "new-net"},
+		{"NEW", "new"},
+		{"ab", ""},
+		{"new_net", ""}} {
+		v := newVolatile("test", false, nil)
+		c := newConfigurationTest()
+		s := NewServices(c, NewStorageService(c, v), NewAccessSimple(nil), nil)
+		q := url.Values{}
+		q.Set("store", "test")
+		q.Set("network", d.network)
+		q.Set("role", fmt.Sprintf("%d", roleStorage))
+		r := httptest.NewRequest(
+			"GET",
+			"http://new.com/swift/register?"+q.Encode(),
+			nil)
+		HandlerRegister(s)(httptest.NewRecorder(), r)
+		n, err := v.getNode("new.com")
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			continue
+		}
+		if d.expected == "" {
+			if n != nil {
+				fmt.Printf("Network '%s' accepted\n", d.network)
+				t.Fail()
+			}
+			continue
+		}
+		if n == nil || n.network != d.expected {
+			fmt.Printf("Network '%s' not registered as '%s'\n",
+				d.network,
+				d.expected)
+			t.Fail()
+		}
+	}
+}
+
+func TestParseRegisterRequest(t *testing.T) {
+	q := url.Values{}
+	q.Set("store", "test")
+	q.Set("network", " Net ")
+	q.Set("role", fmt.Sprintf("%d", roleAccess))
+	q.Set("expires", time.Now().UTC().AddDate(1, 0, 0).Format("2006-01-02"))
+	q.Set("starts", "2030-01-02T03:04")
+	q.Set("secret", "yes")
+	q.Set("scramble", "1")
+	r := httptest.NewRequest(
+		"GET",
+		"http://new.com/swift/register?"+q.Encode(),
+		nil)
+	p, err := parseRegisterRequest(r)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if p.Store != "test" ||
+		p.Network != "net" ||
+		p.Role != roleAccess ||
+		p.Starts.Format("2006-01-02T15:04") != "2030-01-02T03:04" ||
+		p.CookieDomain != "new.com" ||
+		p.Secret == false ||
+		p.Scramble == false {
+		fmt.Printf("Register request '%v' not parsed\n", p)
+		t.Fail()
+	}
+
+	// Invalid fields are reported and the values provided retained for display.
+	q = url.Values{}
+	q.Set("network", "ab")
+	q.Set("role", "9")
+	q.Set("expires", "2000-01-01")
+	q.Set("starts", "tomorrow")
+	r = httptest.NewRequest(
+		"GET",
+		"http://new.com/swift/register?"+q.Encode(),
+		nil)
+	p, err = parseRegisterRequest(r)
+	e, ok := err.(*registerRequestError)
+	if ok == false ||
+		e.network == "" ||
+		e.role == "" ||
+		e.expires == "" ||
+		e.starts == "" {
+		fmt.Printf("Invalid fields not reported '%v'\n", err)
+		t.Fail()
+		return
+	}
+	if p.Network != "ab" || p.Role != 9 || p.Secret || p.Scramble {
+		fmt.Printf("Register request '%v' values not retained\n", p)
+		t.Fail()
+	}
+}
+
+func TestHandlerRegisterJSON(t *testing.T) {
+	v := newVolatile("test", false, nil)
+	c := newConfigurationTest()
+	s := NewServices(
+		c,
+		NewStorageService(c, v),
+		NewAccessSimple([]string{"key"}),
+		nil)
+	e := time.Now().UTC().AddDate(1, 0, 0).Truncate(time.Second)
+	for _, d := range []struct {
+		body string
+		key  string
+		code int
+	}{
+		{`{"store":"test","network":"net"}`,
+			"wrong",
+			http.StatusNetworkAuthenticationRequired},
+		{`{"store":"test","network":"ab"}`, "key", http.StatusBadRequest},
+		{`not json`, "key", http.StatusBadRequest},
+		{`{"store":"test","network":" Net ","role":0,"expires":"` +
+			e.Format(time.RFC3339) + `"}`,
+			"key",
+			http.StatusOK},
+		{`{"store":"test","network":"net"}`, "key", http.StatusConflict}} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(
+			"POST",
+			"http://new.com/swift/api/v1/register?accessKey="+d.key,
+			strings.NewReader(d.body))
+		HandlerRegisterJSON(s)(w, r)
+		if w.Code != d.code {
+			fmt.Printf("Status '%d' for '%s' not '%d'\n",
+				w.Code,
+				d.body,
+				d.code)
+			t.Fail()
+		}
+	}
+	n, err := v.getNode("new.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if n == nil ||
+		n.network != "net" ||
+		n.role != roleAccess ||
+		n.expires.Equal(e) == false ||
+		len(n.secrets) != 1 ||
+		n.scrambler == nil {
+		fmt.Println("Node not registered from JSON")
+		t.Fail()
+	}
+}
@@ -22,8 +22,10 @@ import (
 	"net/http"
 )
 
-// HandlerShare returns an encrypted json document which contains details for
-// all known active nodes.
+// HandlerShare returns an encrypted json document which contains details,
+// including secrets, for all known active nodes in the same network as the
+// share node. The document is encrypted with the share node's shared secret and
+// is decrypted by the callShare method of the storage manager.
 func HandlerShare(s *Services) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var err error
@@ -32,35 +34,41 @@ func HandlerShare(s *Services) http.HandlerFunc {
 		a := s.store.getNode(r.Host)
 		if a == nil {
 			err = fmt.Errorf("host '%s' is not a SWIFT node", r.Host)
-			returnAPIError(s, w, err, http.StatusBadRequest)
+			returnAPIError(s, w, r, err, http.StatusBadRequest)
 			return
 		}
 
-		// If the node is not an access node then return an error.
+		// If the node is not a share node then return an error.
 		if a.role != roleShare {
 			err = fmt.Errorf("domain '%s' is not a share node", a.domain)
-			returnAPIError(s, w, err, http.StatusBadRequest)
+			returnAPIError(s, w, r, err, http.StatusBadRequest)
 			return
 		}
 
-		// Get all active nodes.
-		ns, err := s.store.getAllActiveNodes()
+		// Get all active nodes in the share node's network.
+		all, err := s.store.getAllActiveNodes()
 		if err != nil {
-			returnAPIError(s, w, err, http.StatusBadRequest)
+			returnAPIError(s, w, r, err, http.StatusBadRequest)
 			return
 		}
+		ns := make([]*node, 0, len(all))
+		for _, n := range all {
+			if n.network == a.network {
+				ns = append(ns, n)
+			}
+		}
 
 		// Create JSON response.
 		j, err := json.Marshal(ns)
 		if err != nil {
-			returnAPIError(s, w, err, http.StatusBadRequest)
+			returnAPIError(s, w, r, err, http.StatusBadRequest)
 			return
 		}
 
 		// Encrypt the JSON response using the nodes shared secret.
 		b, err := a.encode(j)
 		if err != nil {
-			returnAPIError(s, w, err, http.StatusBadRequest)
+			returnAPIError(s, w, r, err, http.StatusBadRequest)
 			return
 		}
 
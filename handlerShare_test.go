@@ -0,0 +1,108 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandlerShareNetwork(t *testing.T) {
+	v := newVolatile("test", false, nil)
+	for _, d := range []struct {
+		network string
+		domain  string
+		role    int
+	}{
+		{"network", "share.com", roleShare},
+		{"network", "storage.com", roleStorage},
+		{"other", "other.com", roleStorage}} {
+		n, err := newNode(
+			d.network,
+			d.domain,
+			time.Now().UTC(),
+			time.Now().UTC().Add(-time.Minute),
+			time.Now().UTC().AddDate(1, 0, 0),
+			d.role,
+			"",
+			d.domain)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		x, err := newSecret()
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		n.addSecret(x)
+		n.SetAlive(true)
+		v.setNode(n)
+	}
+	c := newConfigurationTest()
+	s := NewServices(c, NewStorageService(c, v), NewAccessSimple(nil), nil)
+	w := httptest.NewRecorder()
+	HandlerShare(s)(w, httptest.NewRequest(
+		"GET",
+		"http://share.com/swift/api/v1/share",
+		nil))
+	b, err := ioutil.ReadAll(w.Result().Body)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	a, err := v.getNode("share.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	d, err := a.decode(b)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	ns, err := getNodesFromByteArray(d)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if len(ns) != 2 {
+		fmt.Printf("Expected 2 nodes, got '%d'\n", len(ns))
+		t.Fail()
+	}
+	for _, n := range ns {
+		if n.network != "network" {
+			fmt.Printf("Node '%s' from network '%s' shared\n",
+				n.domain,
+				n.network)
+			t.Fail()
+		}
+		if len(n.secrets) == 0 {
+			fmt.Printf("Node '%s' shared without secrets\n", n.domain)
+			t.Fail()
+		}
+	}
+}
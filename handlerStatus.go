@@ -0,0 +1,127 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Status is the JSON response from HandlerStatus describing the health of the
+// node associated with the request and the network it belongs to.
+type Status struct {
+	Domain         string    `json:"domain"`         // The node's domain
+	Network        string    `json:"network"`        // The node's network
+	Role           int       `json:"role"`           // The node's role
+	Created        time.Time `json:"created"`        // When the node was created
+	Starts         time.Time `json:"starts"`         // When the node starts
+	Expires        time.Time `json:"expires"`        // When the node expires
+	Secrets        int       `json:"secrets"`        // Number of secrets held
+	AlivePeers     int       `json:"alivePeers"`     // Alive nodes in network
+	TotalPeers     int       `json:"totalPeers"`     // Active nodes in network
+	Refreshed      time.Time `json:"refreshed"`      // Last storage refresh
+	RefreshMinutes int       `json:"refreshMinutes"` // Refresh interval
+	// True if the network mixes nodes that scramble and nodes that do not
+	ScrambleMixed bool `json:"scrambleMixed"`
+	// Counters for asynchronous store writes, or nil if writes are synchronous
+	Queue *StoreQueueStats `json:"queue,omitempty"`
+}
+
+// HandlerStatus returns a JSON document describing the node associated with
+// the request host, the number of alive and total peers in the node's network,
+// and when the storage manager was last refreshed. Requires a valid access key.
+func HandlerStatus(s *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Check caller can access.
+		if s.getAccessAllowed(w, r) == false {
+			return
+		}
+
+		// Get the node associated with the request.
+		n := s.store.getNode(r.Host)
+		if n == nil {
+			returnAPIError(
+				s,
+				w,
+				r,
+				fmt.Errorf("host '%s' is not a SWIFT node", r.Host),
+				http.StatusBadRequest)
+			return
+		}
+
+		st, err := getStatus(s, n)
+		if err != nil {
+			returnServerError(s, w, r, err)
+			return
+		}
+
+		j, err := json.Marshal(st)
+		if err != nil {
+			returnServerError(s, w, r, err)
+			return
+		}
+		sendResponse(s, w, r, "application/json", j)
+	}
+}
+
+func getStatus(s *Services, n *node) (*Status, error) {
+	var st Status
+	st.Domain = n.domain
+	st.Network = n.network
+	st.Role = n.role
+	st.Created = n.created
+	st.Starts = n.starts
+	st.Expires = n.expires
+	st.Secrets = len(n.secrets)
+	st.Refreshed = s.store.getRefreshed()
+	st.RefreshMinutes = s.config.StorageManagerRefreshMinutes
+	st.Queue = s.store.getQueueStats()
+
+	// Check the settings of the nodes in the network are consistent.
+	ns, err := s.store.getNodes(n.network)
+	if err != nil {
+		return nil, err
+	}
+	if ns != nil {
+		st.ScrambleMixed = ns.scrambleMixed
+	}
+
+	// Count the active nodes in the same network and with the same role as the
+	// node, and of those the nodes that are alive. Both counts use the same set
+	// of nodes so that the alive peers never exceed the total peers.
+	all, err := s.store.getAllNodes()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	for _, i := range all {
+		if i.network == n.network &&
+			i.role == n.role &&
+			i.starts.Before(now) &&
+			i.isActive() {
+			st.TotalPeers++
+			if i.IsAlive() {
+				st.AlivePeers++
+			}
+		}
+	}
+
+	return &st, nil
+}
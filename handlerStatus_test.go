@@ -0,0 +1,144 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandlerStatus(t *testing.T) {
+	s, _, err := newHandlerDeleteTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	w := httptest.NewRecorder()
+	HandlerStatus(s)(w, httptest.NewRequest(
+		"GET",
+		"http://test-1.com/swift/api/v1/status?accessKey=key",
+		nil))
+	if w.Code != http.StatusOK {
+		fmt.Printf("Status '%d' returned\n", w.Code)
+		t.Fail()
+		return
+	}
+	g, err := gzip.NewReader(w.Result().Body)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	var st Status
+	err = json.NewDecoder(g).Decode(&st)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if st.Domain != "test-1.com" || st.Network != "network" {
+		fmt.Printf("Unexpected node '%s' '%s'\n", st.Domain, st.Network)
+		t.Fail()
+	}
+	if st.TotalPeers != 10 {
+		fmt.Printf("Expected 10 peers, got '%d'\n", st.TotalPeers)
+		t.Fail()
+	}
+	if st.AlivePeers > st.TotalPeers {
+		fmt.Printf("Alive peers '%d' exceeds total\n", st.AlivePeers)
+		t.Fail()
+	}
+	if st.RefreshMinutes != s.config.StorageManagerRefreshMinutes {
+		fmt.Println("Refresh minutes not returned")
+		t.Fail()
+	}
+	if st.Refreshed.IsZero() {
+		fmt.Println("Refreshed time not returned")
+		t.Fail()
+	}
+	if st.Queue != nil {
+		fmt.Println("Queue counters returned without a queue")
+		t.Fail()
+	}
+}
+
+// TestHandlerStatusPeers checks that expired nodes and nodes with a different
+// role are excluded from both the alive and total peer counts.
+func TestHandlerStatusPeers(t *testing.T) {
+	v, err := newVolatileTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	e, err := v.testAddStorage(11)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	e.expires = time.Now().Add(-time.Hour)
+	a, err := v.testAddStorage(12)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	a.role = roleStorage
+	c := newConfigurationTest()
+	s := NewServices(c, NewStorageService(c, v), nil, nil)
+	n := s.store.getNode("test-1.com")
+	n.mutex.Lock()
+	n.alive = false
+	n.mutex.Unlock()
+	st, err := getStatus(s, n)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if st.TotalPeers != 10 || st.AlivePeers != 9 {
+		fmt.Printf("Expected 10 total and 9 alive peers, got '%d' and '%d'\n",
+			st.TotalPeers,
+			st.AlivePeers)
+		t.Fail()
+	}
+}
+
+func TestHandlerStatusNotAllowed(t *testing.T) {
+	s, _, err := newHandlerDeleteTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	w := httptest.NewRecorder()
+	HandlerStatus(s)(w, httptest.NewRequest(
+		"GET",
+		"http://test-1.com/swift/api/v1/status?accessKey=bad",
+		nil))
+	if w.Code == http.StatusOK {
+		fmt.Println("Status allowed with invalid access key")
+		t.Fail()
+	}
+}
@@ -21,9 +21,12 @@ import (
 	"fmt"
 	"html/template"
 	"io/ioutil"
-	"log"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -49,11 +52,25 @@ func HandlerStore(
 			return
 		}
 
+		// If the operation has already completed then return to the caller
+		// without visiting any more nodes.
+		if o.replay {
+			o.storeComplete(s, w, r)
+			return
+		}
+
+		// The home node is needed to select the next node. If there is no
+		// home node then the operation can not continue.
+		if o.HomeNode() == nil {
+			returnServerError(s, w, r, fmt.Errorf("No home node available"))
+			return
+		}
+
 		// If the previous node is set then update last accessed time and
 		// confirm it is alive by virtue of being the previous node.
 		if o.PrevNode() != nil {
-			o.prevNodePtr.accessed = time.Now().UTC()
-			o.prevNodePtr.alive = true
+			o.prevNodePtr.SetAccessed(time.Now().UTC())
+			o.prevNodePtr.SetAlive(true)
 			// Update the operation's previous node with this node for the
 			// next node in the chain.
 			o.prevNode = o.thisNode.domain
@@ -71,19 +88,9 @@ func HandlerStore(
 			}
 
 			// If no node is set then find a random storage node that is not the
-			// home node or the current node. Try 10 times before giving up and
-			// just using the node found.
+			// home node or the current node.
 			if o.nextNode == nil {
-				c := 10
-				for o.nextNode == nil && c > 0 {
-					o.nextNode = o.network.getRandomNode(func(i *node) bool {
-						return i.role == roleStorage &&
-							i != o.thisNode &&
-							i.domain != o.HomeNode().domain &&
-							i.starts.Before(time.Now().UTC())
-					})
-					c--
-				}
+				o.nextNode = o.getRandomStorageNode(s.config.RequireAliveNodes)
 			}
 
 			// If there is still no node them use the home node.
@@ -93,7 +100,7 @@ func HandlerStore(
 
 			// If there is still no node then generate an error.
 			if o.nextNode == nil {
-				returnServerError(s, w, fmt.Errorf("No next node available"))
+				returnServerError(s, w, r, fmt.Errorf("No next node available"))
 				return
 			}
 		}
@@ -120,13 +127,27 @@ func HandlerStore(
 	}
 }
 
+// getRandomStorageNode returns a random storage node that has started and is
+// not the home node or the current node. If alive nodes are required then
+// nodes confirmed as alive are preferred and other nodes are only used if no
+// alive node can be found.
+func (o *operation) getRandomStorageNode(alive bool) *node {
+	return o.network.getRandomStorageNodeFrom(
+		rand.Intn,
+		o.thisNode,
+		o.HomeNode(),
+		o.services.clock.Now(),
+		alive)
+}
+
 // The operation is invalid return a malformed request.
 func storeMalformed(s *Services, w http.ResponseWriter, r *http.Request) {
 	var o operation
+	o.services = s
 	o.request = r
 	o.HTML.BackgroundColor = s.config.BackgroundColor
 	o.HTML.MessageColor = s.config.MessageColor
-	sendHTMLTemplate(s, w, malformedTemplate, &o)
+	o.sendHTMLTemplate(s, w, r, malformedTemplate)
 }
 
 // If this is the home node and the last operation of a multi node operation
@@ -147,44 +168,95 @@ func (o *operation) storeDone(
 }
 
 // storeWarning provides a browser specific warning requesting the user changes
-// their settings to support SWIFT.
+// their settings to support SWIFT. If the warning has already been shown the
+// maximum number of times, or the warning cookie shows the browser has been
+// warned before without cookies being returned, then the operation gives up
+// and returns to the caller.
 func (o *operation) storeWarning(
 	s *Services,
 	w http.ResponseWriter,
 	r *http.Request) {
 	var err error
 
+	// Give up if the user has already been warned the maximum number of times.
+	if s.config.MaxWarningRetries > 0 &&
+		int(o.warnings) >= s.config.MaxWarningRetries {
+		o.storeReturn(s, w, r, giveUpTemplate)
+		return
+	}
+
+	// Give up if the warning cookie shows that the browser has been warned
+	// enough times already and still does not return the other cookies.
+	if s.config.WarningCookieSeconds > 0 {
+		c := o.getWarningCount()
+		if c >= s.config.WarningEscalationThresholdOrDefault() {
+			o.storeReturn(s, w, r, giveUpTemplate)
+			return
+		}
+		o.setWarningCookie(s, w, c+1)
+	}
+	o.warnings++
+
 	// The next node after the cookies have been set is the home node. The
 	// counter and the time stamp will also need to be reset to zero.
 	o.nextNode = o.HomeNode()
+	if o.nextNode == nil {
+		returnServerError(s, w, r, fmt.Errorf("No home node available"))
+		return
+	}
 	o.nodesVisited = 0
-	o.timeStamp = time.Now().UTC()
+	o.timeStamp = s.clock.Now()
 
 	// Get the next URL for the node.
 	o.nextURL, err = o.getNextURL()
 	if err != nil {
-		returnServerError(s, w, err)
+		returnServerError(s, w, r, err)
 		return
 	}
 
 	// Send the HTML warning.
-	sendHTMLTemplate(s, w, warningTemplate, o)
+	o.sendHTMLTemplate(s, w, r, warningTemplate)
 }
 
-// If the post on complete flag is set then use the JavaScript post on complete
-// template. If not then use the blank template for the return.
+// If there are other networks to visit then continue with the next network
+// unless the operation has run out of time and partial results are allowed.
+// Otherwise call the completion hook if set. If the post on complete flag is set
+// then use the JavaScript post on complete template. If not then use the blank
+// template for the return.
 func (o *operation) storeComplete(
 	s *Services,
 	w http.ResponseWriter,
 	r *http.Request) {
+	if len(o.journey) > 0 && o.isPartial() == false {
+		o.storeNextNetwork(s, w, r)
+		return
+	}
+
+	// Call the completion hook if there is one. An error aborts the response.
+	// Not called again if the operation is being replayed.
+	if s.onComplete != nil && o.replay == false {
+		err := s.onComplete(o)
+		if err != nil {
+			returnServerError(s, w, r, err)
+			return
+		}
+	}
+
+	// If the operation completed at the home node alone then the user
+	// interface is not displayed if the caller requested it be hidden.
+	u := o.DisplayUserInterface()
+	if o.nodesVisited <= 1 && o.BlankOnHomeNode() {
+		u = false
+	}
+
 	if o.PostMessageOnComplete() {
-		if o.DisplayUserInterface() {
+		if u {
 			o.storePostMessage(s, w, r, postMessageTemplate)
 		} else {
 			o.storePostMessage(s, w, r, postMessageBlankTemplate)
 		}
 	} else {
-		if o.DisplayUserInterface() {
+		if u {
 			if o.nodesVisited <= 1 {
 				o.storeReturn(s, w, r, blankTemplate)
 			} else {
@@ -194,6 +266,11 @@ func (o *operation) storeComplete(
 			o.storeReturn(s, w, r, blankTemplate)
 		}
 	}
+
+	// Remember the completed operation so that it can be replayed.
+	if s.replays != nil && o.replay == false {
+		s.replays.add(o)
+	}
 }
 
 func (o *operation) storePostMessage(
@@ -201,7 +278,7 @@ func (o *operation) storePostMessage(
 	w http.ResponseWriter,
 	r *http.Request,
 	t *template.Template) {
-	sendHTMLTemplate(s, w, t, o)
+	o.sendHTMLTemplate(s, w, r, t)
 }
 
 func (o *operation) storeReturn(
@@ -214,18 +291,21 @@ func (o *operation) storeReturn(
 
 	// Get the results to append to the end of the return URL.
 	x, err := o.Results()
-	if err != nil && s.config.Debug == true {
-		log.Println(err.Error())
+	if err != nil {
+		s.config.debugf("SWIFT:%s\n", err.Error())
 	}
 	nu += x
 
-	// Sets cookies for any non empty resolved pairs.
-	o.setCookies(s, w, r)
+	// Sets cookies for any non empty resolved pairs. Not needed if the
+	// operation is being replayed as the cookies were set when it completed.
+	if o.replay == false {
+		o.setCookies(s, w, r)
+	}
 
 	// Turn the next URL string into a url.URL value.
 	o.nextURL, err = url.Parse(nu)
 	if err != nil {
-		returnServerError(s, w, err)
+		returnServerError(s, w, r, err)
 		return
 	}
 
@@ -241,14 +321,14 @@ func (o *operation) storeReturnHTML(
 	w http.ResponseWriter,
 	r *http.Request,
 	t *template.Template) {
-	sendHTMLTemplate(s, w, t, o)
+	o.sendHTMLTemplate(s, w, r, t)
 }
 
 func (o *operation) storeReturnJavaScript(
 	s *Services,
 	w http.ResponseWriter,
 	r *http.Request) {
-	sendJSTemplate(s, w, javaScriptReturnTemplate, o)
+	sendJSTemplate(s, w, r, javaScriptReturnTemplate, o)
 }
 
 func (o *operation) storeContinue(
@@ -260,7 +340,7 @@ func (o *operation) storeContinue(
 	// Get the next URL for the node.
 	o.nextURL, err = o.getNextURL()
 	if err != nil {
-		returnServerError(s, w, err)
+		returnServerError(s, w, r, err)
 		return
 	}
 
@@ -295,16 +375,81 @@ func (o *operation) storeContinueHTML(s *Services,
 	} else {
 		t = blankTemplate
 	}
-	sendHTMLTemplate(s, w, t, o)
+	o.sendHTMLTemplate(s, w, r, t)
+}
+
+const (
+	// Placeholder in a content security policy for the nonce of the response.
+	contentSecurityPolicyNonce = "{nonce}"
+
+	// Configuration value that selects the default content security policy.
+	contentSecurityPolicyDefault = "default"
+
+	// Content security policy that allows the inline styles of the templates
+	// and the styles handler, and only inline scripts with the nonce.
+	defaultContentSecurityPolicy = "default-src 'none'; " +
+		"style-src 'self' 'unsafe-inline'; " +
+		"script-src 'nonce-" + contentSecurityPolicyNonce + "'; " +
+		"img-src data:"
+
+	// Content security policy for CSP safe operations which do not use inline
+	// styles.
+	cspSafeContentSecurityPolicy = "default-src 'none'; style-src 'self'; " +
+		"script-src 'nonce-" + contentSecurityPolicyNonce + "'; img-src data:"
+)
+
+// sendHTMLTemplate sends the template t for the operation. If the operation is
+// CSP safe then the equivalent template without inline styles is used. If a
+// content security policy is configured, or the operation is CSP safe, then the
+// policy is set with a new nonce for any inline script.
+func (o *operation) sendHTMLTemplate(
+	s *Services,
+	w http.ResponseWriter,
+	r *http.Request,
+	t *template.Template) {
+	p := s.config.ContentSecurityPolicyOrDefault()
+	if o.CSPSafe() {
+		if c, ok := cspTemplates[t]; ok {
+			if p == "" {
+				p = cspSafeContentSecurityPolicy
+			}
+			t = c
+		}
+	}
+	if p != "" {
+		var err error
+		o.nonce, err = setContentSecurityPolicy(w, p)
+		if err != nil {
+			returnServerError(s, w, r, err)
+			return
+		}
+	}
+	sendHTMLTemplate(s, w, r, t, o)
+}
+
+// setContentSecurityPolicy sets the Content-Security-Policy header to the
+// policy p with a new nonce for any inline script. Returns the nonce. The nonce
+// is URL safe base 64 so that it is not escaped when used in the templates.
+func setContentSecurityPolicy(w http.ResponseWriter, p string) (string, error) {
+	b, err := randomBytes(16)
+	if err != nil {
+		return "", err
+	}
+	n := base64.RawURLEncoding.EncodeToString(b)
+	w.Header().Set(
+		"Content-Security-Policy",
+		strings.ReplaceAll(p, contentSecurityPolicyNonce, n))
+	return n, nil
 }
 
 func (o *operation) storeContinueJavaScript(s *Services,
 	w http.ResponseWriter,
 	r *http.Request) {
-	sendJSTemplate(s, w, javaScriptProgressTemplate, o)
+	sendJSTemplate(s, w, r, javaScriptProgressTemplate, o)
 }
 
-// setCookies for all the resolved pairs that are not empty. If no cookies are
+// setCookies for all the resolved pairs that are not empty and are not exists
+// queries. If no cookies are
 // written as part of the storage operation because the values are empty then
 // set a special cookie used to verify that the browser does support cookies if
 // no cookies were included in the request.
@@ -314,7 +459,7 @@ func (o *operation) setCookies(
 	r *http.Request) error {
 	f := false
 	for _, p := range o.resolved {
-		if p.isEmpty() == false {
+		if p.isEmpty() == false && p.existsOnly == false {
 			err := o.setValueInCookie(w, r, p)
 			if err != nil {
 				return err
@@ -328,6 +473,23 @@ func (o *operation) setCookies(
 	return nil
 }
 
+// setWarningCookie sets a cookie recording the number of warnings shown to the
+// browser. The cookie lives longer than the browser warning cookie so that the
+// warning is not repeated if the browser drops the other cookies.
+func (o *operation) setWarningCookie(s *Services, w http.ResponseWriter, c int) {
+	cookie := http.Cookie{
+		Name:     o.getPrefixedCookieName(warningCookieName),
+		Domain:   o.getCookieDomain(),
+		Value:    strconv.Itoa(c),
+		Path:     "/",
+		SameSite: http.SameSiteLaxMode,
+		Secure:   s.config.Scheme == "https",
+		HttpOnly: true,
+		Expires: time.Now().UTC().Add(
+			time.Duration(s.config.WarningCookieSeconds) * time.Second)}
+	http.SetCookie(w, &cookie)
+}
+
 // setBrowserWarningCookie set a cookie to verify cookies are supported. Use a
 // single key "t" with no value. We only need to know it's present in the future
 // and do not need any values. Expires after a minute.
@@ -336,7 +498,7 @@ func (o *operation) setBrowserWarningCookie(
 	w http.ResponseWriter,
 	r *http.Request) error {
 	cookie := http.Cookie{
-		Name:     "t",
+		Name:     o.getPrefixedCookieName(browserWarningCookieName),
 		Domain:   o.getCookieDomain(),
 		Value:    "",
 		Path:     "/",
@@ -350,14 +512,31 @@ func (o *operation) setBrowserWarningCookie(
 
 func (o *operation) getResults() (string, error) {
 
-	// Build the results array of key value pairs.
+	// Build the results array of key value pairs merging the results from
+	// other networks if this is a multi network operation.
 	var r Results
-	for _, p := range o.resolved {
+	n, m, err := o.getNetworkResults()
+	if err != nil {
+		return "", err
+	}
+	for _, p := range m {
+
+		// Exists queries never return the values for the key.
+		if p.existsOnly {
+			p = p.withoutValues()
+		}
+		p.clientTTL = getClientTTL(
+			o.services.config.ClientTTLSeconds,
+			p.expires,
+			o.services.clock.Now())
+		p.exists = p.present()
 		r.pairs = append(r.pairs, &p.Pair)
 	}
+	r.networks = n
+	r.partial = o.isPartial()
 
 	// Add the expiry time for the results.
-	r.expires = time.Now().UTC().Add(
+	r.expires = o.services.clock.Now().Add(
 		o.services.config.StorageOperationTimeoutDuration())
 
 	// Add other state information from the storage operation.
@@ -372,25 +551,71 @@ func (o *operation) getResults() (string, error) {
 		return "", err
 	}
 
-	// Encrypt the result with the access node.
-	var u url.URL
-	u.Scheme = o.services.config.Scheme
-	u.Host = o.accessNode
-	u.Path = "/swift/api/v1/encrypt"
+	// If results compression is configured then pack the results so that the
+	// access node does not need to compress them.
 	q := url.Values{}
-	q.Set("plain", base64.StdEncoding.EncodeToString(out))
-	res, err := http.PostForm(u.String(), q)
-	if err != nil {
-		return "", err
+	if o.services.config.ResultsCompression != "" {
+		out, err = packResults(out, o.services.config.ResultsCompression)
+		if err != nil {
+			return "", err
+		}
+		q.Set("compress", "false")
 	}
-	if res.StatusCode != http.StatusOK {
-		return "", newResponseError(u.String(), res)
+
+	// Encrypt the result with the first access node that responds.
+	if len(o.accessNodes) == 0 {
+		return "", fmt.Errorf("No access node provided")
+	}
+	p := base64.StdEncoding.EncodeToString(out)
+	q.Set("plain", p)
+	for _, a := range o.accessNodes {
+		var in []byte
+
+		// Authorize the request with a MAC created with the secret of the
+		// access node.
+		q.Del(macParam)
+		if x := o.services.store.getNode(a); x != nil {
+			m, err := x.mac(encryptMACTable, p)
+			if err != nil {
+				return "", err
+			}
+			q.Set(macParam, m)
+		}
+		u := o.services.config.APIURL(a, "encrypt")
+		in, err = encryptWithAccessNode(o.services.encrypt, u, q)
+		if err == nil {
+			return base64.RawURLEncoding.EncodeToString(in), nil
+		}
+		o.services.config.errorf("SWIFT:%s\n", err.Error())
 	}
-	in, err := ioutil.ReadAll(res.Body)
+	return "", fmt.Errorf(
+		"None of the '%d' access nodes encrypted the results. %s",
+		len(o.accessNodes),
+		err)
+}
+
+// encryptWithAccessNode posts the form q to the encrypt URL u of an access node
+// using the client c and returns the response if the status is OK.
+func encryptWithAccessNode(
+	c *http.Client,
+	u *url.URL,
+	q url.Values) ([]byte, error) {
+	res, err := c.PostForm(u.String(), q)
 	if err != nil {
-		return "", err
+		if e, ok := err.(net.Error); ok && e.Timeout() {
+			return nil, fmt.Errorf(
+				"Access node '%s' did not respond within '%s': %w",
+				u.Host,
+				c.Timeout,
+				err)
+		}
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, newResponseError(u.String(), res)
 	}
-	return base64.RawURLEncoding.EncodeToString(in), nil
+	return ioutil.ReadAll(res.Body)
 }
 
 func (o *operation) getNextURL() (*url.URL, error) {
@@ -402,11 +627,30 @@ func (o *operation) getNextURL() (*url.URL, error) {
 		return nil, err
 	}
 	var u url.URL
+	t := o.nextNode.scramble(o.table)
 	u.Scheme = o.services.config.Scheme
 	u.Host = o.nextNode.domain
-	u.Path = o.nextNode.scramble(o.table) + "/" + p
-	if err != nil {
-		return nil, err
+	u.Path = o.services.config.getOperationPathPrefix() + "/" + t + "/" + p
+
+	// Add the HMAC of the table and operation so that the next node can
+	// confirm they have not been separated.
+	if o.services.config.OperationMAC {
+		m, err := o.nextNode.mac(t, p)
+		if err != nil {
+			return nil, err
+		}
+		u.RawQuery = url.Values{macParam: []string{m}}.Encode()
+	}
+
+	// Map the registered domain of the next node to its public URL if
+	// configured. The rewriter is passed a copy so that the original URL can be
+	// used if the rewriter returns nil.
+	if o.services.nextURLRewriter != nil {
+		c := u
+		r := o.services.nextURLRewriter(&c, o.nextNode.domain)
+		if r != nil {
+			return r, nil
+		}
 	}
 	return &u, nil
 }
@@ -0,0 +1,716 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Used to find the link in the warning and give up templates.
+var testLinkURLRegex = regexp.MustCompile("<a href=\"([^\"]+)\"")
+
+func TestStoreWarningGiveUp(t *testing.T) {
+	for _, m := range []int{1, 3} {
+		w, g, err := testStoreWarningCycles(m, 0)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			continue
+		}
+		if w != m {
+			fmt.Printf("'%d' warnings shown not '%d'\n", w, m)
+			t.Fail()
+		}
+		if g == false {
+			fmt.Printf("Give up page not shown after '%d' warnings\n", m)
+			t.Fail()
+		}
+	}
+}
+
+func TestStoreWarningCookie(t *testing.T) {
+
+	// Without the warning cookie and unlimited retries the operation never
+	// gives up.
+	w, g, err := testStoreWarningCycles(0, 0)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if g {
+		fmt.Println("Give up page shown without the warning cookie")
+		t.Fail()
+	}
+
+	// With the warning cookie the operation gives up after a single warning
+	// even though the number of retries is unlimited.
+	w, g, err = testStoreWarningCycles(0, 60)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if w != 1 {
+		fmt.Printf("'%d' warnings shown not '1'\n", w)
+		t.Fail()
+	}
+	if g == false {
+		fmt.Println("Give up page not shown with the warning cookie")
+		t.Fail()
+	}
+}
+
+// testStoreWarningCycles follows a storage operation with a browser that never
+// returns cookies other than the warning cookie until the operation gives up.
+// m is the maximum warning retries and k the warning cookie seconds. Returns
+// the number of warnings shown and true if the give up page was shown.
+func testStoreWarningCycles(m int, k int) (int, bool, error) {
+	var s *Services
+
+	// Create a server for the access node to encrypt the results.
+	h := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			HandlerEncrypt(s)(w, r)
+		}))
+	defer h.Close()
+	u, err := url.Parse(h.URL)
+	if err != nil {
+		return 0, false, err
+	}
+	var a []*node
+	for _, d := range []struct {
+		domain string
+		role   int
+	}{
+		{u.Host, roleAccess},
+		{"storage-1.com", roleStorage},
+		{"storage-2.com", roleStorage}} {
+		n, err := newNode(
+			"network",
+			d.domain,
+			time.Now().UTC(),
+			time.Now().UTC().Add(-time.Minute),
+			time.Now().UTC().AddDate(1, 0, 0),
+			d.role,
+			"",
+			"")
+		if err != nil {
+			return 0, false, err
+		}
+		x, err := newSecret()
+		if err != nil {
+			return 0, false, err
+		}
+		n.addSecret(x)
+		a = append(a, n)
+	}
+	c := newConfigurationTest()
+	c.Debug = false
+	c.Scheme = "http"
+	c.NodeCount = 2
+	c.StorageOperationTimeout = 60
+	c.HomeNodeTimeout = 60
+	c.MaxWarningRetries = m
+	c.WarningCookieSeconds = k
+	s = NewServices(
+		c,
+		NewStorageService(c, newVolatile("test", false, a)),
+		NewAccessSimple(nil),
+		nil)
+
+	q := url.Values{}
+	q.Set("table", "t")
+	q.Set("returnUrl", "http://return.com/")
+	q.Set("a>", "")
+	n, err := Create(s, u.Host, q)
+	if err != nil {
+		return 0, false, err
+	}
+
+	// Follow the operation only ever returning the warning cookie.
+	w := 0
+	var x *http.Cookie
+	for i := 0; i < 100; i++ {
+		b, c, err := testStoreStepWithCookie(s, n, x)
+		if c != nil {
+			x = c
+		}
+		if err != nil {
+			return w, false, err
+		}
+		if strings.Contains(b, "Cookies could not be used.") {
+			return w, true, nil
+		}
+		var r *regexp.Regexp
+		if strings.Contains(b, "Cookies are required.") {
+			w++
+			r = testLinkURLRegex
+		} else {
+			r = testNextURLRegex
+		}
+		f := r.FindStringSubmatch(b)
+		if f == nil {
+			return w, false, fmt.Errorf("No next URL in response from '%s'", n)
+		}
+		n = html.UnescapeString(f[1])
+	}
+	return w, false, nil
+}
+
+// testStoreStepWithCookie requests the URL n from the store handler with the
+// warning cookie k if not nil and returns the HTML response and any warning
+// cookie set.
+func testStoreStepWithCookie(
+	s *Services,
+	n string,
+	k *http.Cookie) (string, *http.Cookie, error) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", n, nil)
+	if k != nil {
+		r.AddCookie(k)
+	}
+	HandlerStore(s, nil)(w, r)
+	var c *http.Cookie
+	for _, i := range w.Result().Cookies() {
+		if i.Name == warningCookieName {
+			c = i
+		}
+	}
+	g, err := gzip.NewReader(w.Result().Body)
+	if err != nil {
+		return "", nil, err
+	}
+	b, err := ioutil.ReadAll(g)
+	if err != nil {
+		return "", nil, err
+	}
+	return string(b), c, nil
+}
+
+func TestStoreRandomNodeAlive(t *testing.T) {
+	o, err := newStoreRandomNodeTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+
+	// Only storage-4.com is alive so it must always be chosen.
+	for i := 0; i < 100; i++ {
+		n := o.getRandomStorageNode(true)
+		if n == nil || n.domain != "storage-4.com" {
+			fmt.Println("Node that is not alive chosen for next hop")
+			t.Fail()
+			return
+		}
+	}
+
+	// Without the flag nodes that are not alive can be chosen.
+	f := false
+	for i := 0; i < 100 && f == false; i++ {
+		f = o.getRandomStorageNode(false).domain != "storage-4.com"
+	}
+	if f == false {
+		fmt.Println("Nodes that are not alive never chosen without the flag")
+		t.Fail()
+	}
+
+	// If no nodes are alive then fall back to any node.
+	o.network.dict["storage-4.com"].SetAlive(false)
+	if o.getRandomStorageNode(true) == nil {
+		fmt.Println("No fall back when no nodes are alive")
+		t.Fail()
+	}
+}
+
+// newStoreRandomNodeTest returns an operation at storage-1.com with home node
+// storage-2.com in a network where only storage-4.com is alive.
+func newStoreRandomNodeTest() (*operation, error) {
+	var a []*node
+	for i := 1; i <= 6; i++ {
+		n, err := newNode(
+			"network",
+			fmt.Sprintf("storage-%d.com", i),
+			time.Now().UTC(),
+			time.Now().UTC().Add(-time.Minute),
+			time.Now().UTC().AddDate(1, 0, 0),
+			roleStorage,
+			"",
+			"")
+		if err != nil {
+			return nil, err
+		}
+		n.SetAlive(i == 4)
+		a = append(a, n)
+	}
+	ns, err := newVolatile("test", false, a).getNodes("network")
+	if err != nil {
+		return nil, err
+	}
+	var o operation
+	o.services = &Services{clock: realClock{}}
+	o.network = ns
+	o.thisNode = ns.dict["storage-1.com"]
+	o.homeNodePtr = ns.dict["storage-2.com"]
+	return &o, nil
+}
+
+// TestGetResultsAccessNodeFallback confirms that the results are encrypted by
+// the next access node when the first does not respond.
+func TestGetResultsAccessNodeFallback(t *testing.T) {
+	var s *Services
+	h := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			HandlerEncrypt(s)(w, r)
+		}))
+	defer h.Close()
+	d := httptest.NewServer(http.NotFoundHandler())
+	d.Close()
+	var a []*node
+	for _, v := range []string{h.URL, d.URL} {
+		u, err := url.Parse(v)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		n, err := newNode(
+			"network",
+			u.Host,
+			time.Now().UTC(),
+			time.Now().UTC().Add(-time.Minute),
+			time.Now().UTC().AddDate(1, 0, 0),
+			roleAccess,
+			"",
+			"")
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		x, err := newSecret()
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		n.addSecret(x)
+		a = append(a, n)
+	}
+	c := newConfigurationTest()
+	c.Scheme = "http"
+	c.StorageOperationTimeout = 60
+	s = NewServices(
+		c,
+		NewStorageService(c, newVolatile("test", false, a)),
+		NewAccessSimple(nil),
+		nil)
+	o := newOperation(s, a[0])
+	o.accessNodes = []string{a[1].domain, a[0].domain}
+	e, err := o.getResults()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	b, err := base64.RawURLEncoding.DecodeString(e)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	_, err = a[0].decode(b)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+	}
+
+	// Fails if none of the access nodes respond.
+	o.accessNodes = []string{a[1].domain}
+	_, err = o.getResults()
+	if err == nil {
+		fmt.Println("Results returned without an access node")
+		t.Fail()
+	}
+}
+
+// TestGetResultsAccessNodeTimeout confirms that an access node which does not
+// respond within the configured timeout fails the encrypt call.
+func TestGetResultsAccessNodeTimeout(t *testing.T) {
+	q := make(chan bool)
+	h := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			<-q
+		}))
+	defer h.Close()
+	defer close(q)
+	c := newConfigurationTest()
+	if c.AccessNodeTimeoutDuration() != 5*time.Second {
+		fmt.Printf("Default timeout '%s' not 5s\n", c.AccessNodeTimeoutDuration())
+		t.Fail()
+	}
+	c.AccessNodeTimeoutSeconds = 1
+	s := NewServices(c, nil, nil, nil)
+	u, err := url.Parse(h.URL)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	_, err = encryptWithAccessNode(s.encrypt, u, url.Values{})
+	if err == nil || strings.Contains(err.Error(), "did not respond") == false {
+		fmt.Printf("Expected timeout error, got '%v'\n", err)
+		t.Fail()
+	}
+}
+
+// TestStoreCompleteHook confirms that the completion hook is called before the
+// response and that an error from the hook aborts the response.
+func TestStoreCompleteHook(t *testing.T) {
+	n, err := newResultCompressTestNode()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	c := newConfigurationTest()
+	s := NewServices(
+		c,
+		NewStorageService(c, newVolatile("test", false, []*node{n})),
+		nil,
+		nil)
+	var tables []string
+	var keys []string
+	for _, e := range []error{nil, fmt.Errorf("abort")} {
+		s.SetOnComplete(func(o Operation) error {
+			tables = append(tables, o.Table())
+			for _, p := range o.Values() {
+				keys = append(keys, p.Key())
+			}
+			return e
+		})
+		o := newOperation(s, n)
+		o.table = "t"
+		var p pair
+		p.key = "k"
+		o.resolved = []*pair{&p}
+		o.returnURL = "http://return.com/"
+		o.request = httptest.NewRequest("GET", "http://access.com/", nil)
+		w := httptest.NewRecorder()
+		o.storeComplete(s, w, o.request)
+		if e == nil && w.Code != http.StatusOK {
+			fmt.Printf("Status '%d' returned\n", w.Code)
+			t.Fail()
+		}
+		if e != nil && w.Code != http.StatusInternalServerError {
+			fmt.Printf("Status '%d' returned after hook error\n", w.Code)
+			t.Fail()
+		}
+	}
+	if len(tables) != 2 || tables[0] != "t" {
+		fmt.Printf("Hook called '%d' times\n", len(tables))
+		t.Fail()
+	}
+	if len(keys) != 2 || keys[0] != "k" {
+		fmt.Printf("Hook values '%v' incorrect\n", keys)
+		t.Fail()
+	}
+}
+
+func TestResultsPartial(t *testing.T) {
+	var s *Services
+	h := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			HandlerEncrypt(s)(w, r)
+		}))
+	defer h.Close()
+	u, err := url.Parse(h.URL)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	a, err := newNode(
+		"network",
+		u.Host,
+		time.Now().UTC(),
+		time.Now().UTC().Add(-time.Minute),
+		time.Now().UTC().AddDate(1, 0, 0),
+		roleAccess,
+		"",
+		"")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	x, err := newSecret()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	a.addSecret(x)
+	c := newConfigurationTest()
+	c.Scheme = "http"
+	c.StorageOperationTimeout = 60
+	s = NewServices(
+		c,
+		NewStorageService(c, newVolatile("test", false, []*node{a})),
+		NewAccessSimple(nil),
+		nil)
+	p, err := createPair(
+		"Test>"+time.Now().UTC().AddDate(1, 0, 0).Format("2006-01-02"),
+		"collected",
+		0,
+		time.Now().UTC())
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+
+	// An operation that has run out of time returns no results unless partial
+	// results are allowed.
+	o := newOperation(s, a)
+	o.accessNodes = []string{a.domain}
+	o.resolved = []*pair{p}
+	o.timeStamp = time.Now().UTC().Add(-2 * time.Minute)
+	_, err = o.Results()
+	if err == nil {
+		fmt.Println("Results returned for an operation out of time")
+		t.Fail()
+	}
+	o.SetAllowPartial(true)
+	e, err := o.Results()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	b, err := base64.RawURLEncoding.DecodeString(e)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	r, err := a.DecodeAsResults(b)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if r.Partial() == false {
+		fmt.Println("Results not marked as partial")
+		t.Fail()
+	}
+	if len(r.Pairs()) != 1 || string(r.Pairs()[0].Values()[0]) != "collected" {
+		fmt.Println("Collected values not returned in partial results")
+		t.Fail()
+	}
+}
+
+func TestStoreWarmup(t *testing.T) {
+	var s *Services
+	h := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			HandlerEncrypt(s)(w, r)
+		}))
+	defer h.Close()
+	u, err := url.Parse(h.URL)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	var a []*node
+	for _, d := range []struct {
+		domain string
+		role   int
+	}{
+		{u.Host, roleAccess},
+		{"storage-1.com", roleStorage},
+		{"storage-2.com", roleStorage}} {
+		n, err := newNode(
+			"network",
+			d.domain,
+			time.Now().UTC(),
+			time.Now().UTC().Add(-time.Minute),
+			time.Now().UTC().AddDate(1, 0, 0),
+			d.role,
+			"",
+			"")
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		x, err := newSecret()
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		n.addSecret(x)
+		a = append(a, n)
+	}
+	c := newConfigurationTest()
+	c.Debug = false
+	c.Scheme = "http"
+	c.NodeCount = 2
+	c.StorageOperationTimeout = 60
+	c.HomeNodeTimeout = 60
+	s = NewServices(
+		c,
+		NewStorageService(c, newVolatile("test", false, a)),
+		NewAccessSimple(nil),
+		nil)
+
+	// Only a warm up operation returns after visiting the home node.
+	for _, warmup := range []bool{false, true} {
+		q := url.Values{}
+		q.Set("table", "t")
+		q.Set("returnUrl", "http://return.com/")
+		q.Set("a>2099-01-01", "A")
+		if warmup {
+			q.Set("warmup", "true")
+		}
+		r, err := CreateWithResult(s, u.Host, q)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		j := make(map[string][]*http.Cookie)
+		n, err := testJourneyStep(s, r.URL, j)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		if strings.HasPrefix(n, "http://return.com/") != warmup {
+			fmt.Printf("Warm up '%t' next URL '%s'\n", warmup, n)
+			t.Fail()
+		}
+		if len(j[r.HomeNode]) == 0 {
+			fmt.Printf("Warm up '%t' did not set cookies\n", warmup)
+			t.Fail()
+		}
+	}
+}
+
+func TestStoreCompleteBlankOnHomeNode(t *testing.T) {
+	var s *Services
+	h := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			HandlerEncrypt(s)(w, r)
+		}))
+	defer h.Close()
+	u, err := url.Parse(h.URL)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	a, err := newNode(
+		"network",
+		u.Host,
+		time.Now().UTC(),
+		time.Now().UTC().Add(-time.Minute),
+		time.Now().UTC().AddDate(1, 0, 0),
+		roleAccess,
+		"",
+		"")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	x, err := newSecret()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	a.addSecret(x)
+	c := newConfigurationTest()
+	c.Debug = false
+	c.Scheme = "http"
+	c.StorageOperationTimeout = 60
+	s = NewServices(
+		c,
+		NewStorageService(c, newVolatile("test", false, []*node{a})),
+		NewAccessSimple(nil),
+		nil)
+
+	// The progress user interface is only hidden when the operation completes
+	// at the home node alone and the flag is set. The message is always posted.
+	for _, d := range []struct {
+		blank   bool
+		visited byte
+		ui      bool
+	}{
+		{false, 1, true},
+		{true, 1, false},
+		{true, 3, true}} {
+		o := newOperation(s, a)
+		o.accessNodes = []string{a.domain}
+		o.returnURL = "http://return.com/"
+		o.nodesVisited = d.visited
+		o.nodeCount = 3
+		o.SetDisplayUserInterface(true)
+		o.SetPostMessageOnComplete(true)
+		o.SetBlankOnHomeNode(d.blank)
+		o.request = httptest.NewRequest("GET", "http://"+a.domain+"/", nil)
+		w := httptest.NewRecorder()
+		o.storeComplete(s, w, o.request)
+		if w.Code != http.StatusOK {
+			fmt.Printf("Status '%d' returned\n", w.Code)
+			t.Fail()
+			continue
+		}
+		b, err := testGzipBody(w)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		if strings.Contains(string(b), "<svg") != d.ui {
+			fmt.Printf("Blank '%t' visited '%d' user interface not '%t'\n",
+				d.blank,
+				d.visited,
+				d.ui)
+			t.Fail()
+		}
+		if strings.Contains(string(b), "postMessage") == false {
+			fmt.Println("Message not posted")
+			t.Fail()
+		}
+	}
+}
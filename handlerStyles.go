@@ -0,0 +1,129 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"text/template"
+	"time"
+)
+
+// stylesMaxAge is the time the style sheet can be cached for. The style sheet
+// only changes with the colors which are part of the URL.
+const stylesMaxAge = 24 * time.Hour
+
+// colorRegex matches CSS color values such as names, hex values and rgb()
+// functions without any characters that could end the declaration.
+var colorRegex = regexp.MustCompile(`^[#a-zA-Z0-9(),.% ]*$`)
+
+// stylesModel is the data used with the styles template.
+type stylesModel struct {
+	BackgroundColor string
+	MessageColor    string
+	ProgressColor   string
+	SVGSize         int
+	SVGStroke       int
+}
+
+// stylesTemplate contains the styles that the CSP safe templates reference
+// instead of inline styles.
+var stylesTemplate = template.Must(template.New("styles").Parse(
+	removeHTMLWhiteSpace(bodyStyle + debugStyle + `
+.swift-message {
+	padding-bottom: 2.5em; }
+.swift-progress {
+	display: grid;
+	width: {{.SVGSize}}px;
+	margin: auto;
+	line-height: {{.SVGSize}}px; }
+.swift-progress div, .swift-progress svg {
+	grid-column: 1;
+	grid-row: 1; }
+.swift-progress svg {
+	z-index: -1;
+	stroke: {{.ProgressColor}};
+	fill: none;
+	stroke-width: {{.SVGStroke}};
+	width: {{.SVGSize}}px;
+	height: {{.SVGSize}}px; }`)))
+
+// getStylesURL returns the URL of the styles handler on the host for the
+// colors of the user interface h.
+func getStylesURL(c Configuration, host string, h *HTML) string {
+	u := c.APIURL(host, "styles")
+	q := url.Values{}
+	q.Set(backgroundColorParam, h.BackgroundColor)
+	q.Set(messageColorParam, h.MessageColor)
+	q.Set(progressColorParam, h.ProgressColor)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// HandlerStyles returns the style sheet used by the user interface when the
+// storage operation is CSP safe. The colors are provided as parameters and
+// default to those in the configuration.
+func HandlerStyles(s *Services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseForm()
+		if err != nil {
+			returnAPIError(s, w, r, err, http.StatusBadRequest)
+			return
+		}
+		m := stylesModel{
+			BackgroundColor: s.config.BackgroundColor,
+			MessageColor:    s.config.MessageColor,
+			ProgressColor:   s.config.ProgressColor,
+			SVGSize:         svgSize,
+			SVGStroke:       svgStroke}
+		for k, v := range map[string]*string{
+			backgroundColorParam: &m.BackgroundColor,
+			messageColorParam:    &m.MessageColor,
+			progressColorParam:   &m.ProgressColor} {
+			c := r.Form.Get(k)
+			if c == "" {
+				continue
+			}
+			if colorRegex.MatchString(c) == false {
+				returnAPIError(
+					s,
+					w,
+					r,
+					fmt.Errorf("color '%s' for '%s' invalid", c, k),
+					http.StatusBadRequest)
+				return
+			}
+			*v = c
+		}
+		var b bytes.Buffer
+		err = stylesTemplate.Execute(&b, &m)
+		if err != nil {
+			returnServerError(s, w, r, err)
+			return
+		}
+		sendCacheableResponse(
+			s,
+			w,
+			r,
+			"text/css; charset=utf-8",
+			b.Bytes(),
+			stylesMaxAge)
+	}
+}
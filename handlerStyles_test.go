@@ -0,0 +1,218 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHandlerStyles(t *testing.T) {
+	s := NewServices(newConfigurationTest(), nil, nil, nil)
+	t.Run("valid", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		HandlerStyles(s)(w, httptest.NewRequest(
+			"GET",
+			"http://access.com/swift/api/v1/styles?progressColor=%23ff0000",
+			nil))
+		if w.Code != http.StatusOK {
+			fmt.Printf("Expected '%d', got '%d'\n", http.StatusOK, w.Code)
+			t.Fail()
+			return
+		}
+		b, err := testGzipBody(w)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		if strings.Contains(string(b), "stroke: #ff0000") == false {
+			fmt.Println("Progress color missing from styles")
+			t.Fail()
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		HandlerStyles(s)(w, httptest.NewRequest(
+			"GET",
+			"http://access.com/swift/api/v1/styles?progressColor="+
+				url.QueryEscape("red;}body{display:none"),
+			nil))
+		if w.Code != http.StatusBadRequest {
+			fmt.Printf("Expected '%d', got '%d'\n",
+				http.StatusBadRequest,
+				w.Code)
+			t.Fail()
+		}
+	})
+}
+
+func TestOperationCSPSafe(t *testing.T) {
+	c := newConfigurationTest()
+	c.Debug = false
+	s := NewServices(c, nil, nil, nil)
+	n, err := newResultCompressTestNode()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	for _, v := range []bool{false, true} {
+		o := newOperation(s, n)
+		o.nodeCount = 2
+		o.nodesVisited = 1
+		o.nextURL, _ = url.Parse("http://storage.com/")
+		o.SetCSPSafe(v)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http://access.com/", nil)
+		o.request = r
+		o.sendHTMLTemplate(s, w, r, progressTemplate)
+		b, err := testGzipBody(w)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		p := w.Result().Header.Get("Content-Security-Policy")
+		i := strings.Contains(string(b), "<style") ||
+			strings.Contains(string(b), "style=")
+		if v && (p == "" || i) {
+			fmt.Println("CSP safe response has inline styles or no policy")
+			t.Fail()
+		}
+		if v && strings.Contains(string(b), "/swift/api/v1/styles") == false {
+			fmt.Println("CSP safe response does not reference styles")
+			t.Fail()
+		}
+		if v == false && (p != "" || i == false) {
+			fmt.Println("Response unexpectedly CSP safe")
+			t.Fail()
+		}
+		if strings.Contains(string(b), "d=\"M ") == false {
+			fmt.Println("Progress path missing")
+			t.Fail()
+		}
+	}
+}
+
+func TestOperationContentSecurityPolicy(t *testing.T) {
+	n, err := newResultCompressTestNode()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	for _, d := range []struct {
+		policy string
+		prefix string
+	}{
+		{"", ""},
+		{"default", "default-src 'none'; style-src 'self' 'unsafe-inline'"},
+		{"script-src 'nonce-{nonce}'", "script-src 'nonce-"}} {
+		c := newConfigurationTest()
+		c.Debug = false
+		c.ContentSecurityPolicy = d.policy
+		s := NewServices(c, nil, nil, nil)
+		o := newOperation(s, n)
+		o.nodeCount = 2
+		o.nodesVisited = 1
+		o.nextURL, _ = url.Parse("http://storage.com/")
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http://access.com/", nil)
+		o.request = r
+		o.sendHTMLTemplate(s, w, r, progressTemplate)
+		if w.Code != http.StatusOK {
+			fmt.Printf("Status '%d' returned\n", w.Code)
+			t.Fail()
+			return
+		}
+		p := w.Result().Header.Get("Content-Security-Policy")
+		if d.policy == "" {
+			if p != "" {
+				fmt.Println("Policy set when not configured")
+				t.Fail()
+			}
+			continue
+		}
+		if o.Nonce() == "" ||
+			strings.HasPrefix(p, d.prefix) == false ||
+			strings.Contains(p, "{nonce}") ||
+			strings.Contains(p, o.Nonce()) == false {
+			fmt.Printf("Policy '%s' for '%s'\n", p, d.policy)
+			t.Fail()
+		}
+	}
+}
+
+func TestMalformedContentSecurityPolicy(t *testing.T) {
+	n, err := newResultCompressTestNode()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	c := newConfigurationTest()
+	c.Debug = false
+	c.ContentSecurityPolicy = contentSecurityPolicyDefault
+	s := NewServices(c, nil, nil, nil)
+	o := newOperation(s, n)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://access.com/", nil)
+	o.request = r
+	o.sendHTMLTemplate(s, w, r, malformedTemplate)
+	b, err := testGzipBody(w)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+
+	// The try again link uses a script with the nonce of the policy rather
+	// than a javascript URL which the policy blocks.
+	if strings.Contains(string(b), "javascript:") ||
+		strings.Contains(string(b), "<script nonce=\""+o.Nonce()+"\">") == false {
+		fmt.Println("Malformed response script not allowed by policy")
+		t.Fail()
+	}
+}
+
+func TestRegisterContentSecurityPolicy(t *testing.T) {
+	v, err := newVolatileTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	c := newConfigurationTest()
+	c.ContentSecurityPolicy = contentSecurityPolicyDefault
+	s := NewServices(c, NewStorageService(c, v), NewAccessSimple(nil), nil)
+	w := httptest.NewRecorder()
+	HandlerRegister(s)(w, httptest.NewRequest(
+		"GET",
+		"http://new.com/swift/register",
+		nil))
+	p := w.Result().Header.Get("Content-Security-Policy")
+	if strings.HasPrefix(p, "default-src 'none'") == false ||
+		strings.Contains(p, contentSecurityPolicyNonce) {
+		fmt.Printf("Register policy '%s' not set\n", p)
+		t.Fail()
+	}
+}
@@ -22,6 +22,8 @@ import (
 	"html/template"
 	"io/ioutil"
 	"net/http"
+	"strings"
+	"time"
 )
 
 // AddHandlers to the http default mux for shared web state.
@@ -31,17 +33,41 @@ func AddHandlers(
 	services *Services,
 	malformedHandler func(w http.ResponseWriter, r *http.Request)) {
 	http.HandleFunc("/swift/register", HandlerRegister(services))
+	http.HandleFunc(
+		"/swift/api/v1/register-token",
+		HandlerRegisterToken(services))
+	http.HandleFunc("/swift/api/v1/register", HandlerRegisterJSON(services))
+	http.HandleFunc("/swift/api/v1/delete", HandlerDelete(services))
 	http.HandleFunc("/swift/api/v1/alive", handlerAlive(services))
 	http.HandleFunc("/swift/api/v1/create", HandlerCreate(services))
 	http.HandleFunc("/swift/api/v1/encrypt", HandlerEncrypt(services))
 	http.HandleFunc("/swift/api/v1/decrypt", HandlerDecrypt(services))
 	http.HandleFunc("/swift/api/v1/decode-as-json", HandlerDecodeAsJSON(services))
+	http.HandleFunc(
+		"/swift/api/v1/decode-path-as-json/",
+		HandlerDecodePathAsJSON(services))
+	http.HandleFunc(
+		"/swift/api/v1/decode-batch-as-json",
+		HandlerDecodeBatchAsJSON(services))
+	http.HandleFunc("/swift/api/v1/decode-as-jwt", HandlerDecodeAsJWT(services))
+	http.HandleFunc("/swift/api/v1/decode-value", HandlerDecodeValue(services))
 	http.HandleFunc("/swift/api/v1/share", HandlerShare(services))
+	http.HandleFunc("/swift/api/v1/export", HandlerExport(services))
+	http.HandleFunc("/swift/api/v1/styles", HandlerStyles(services))
+	http.HandleFunc("/swift/api/v1/status", HandlerStatus(services))
+	http.HandleFunc("/swift/api/v1/expiring", HandlerExpiring(services))
+	http.HandleFunc("/swift/api/v1/networks", HandlerNetworks(services))
 	http.HandleFunc("/", HandlerStore(services, malformedHandler))
 
 	if services.config.Debug {
 		http.HandleFunc("/swift/nodes", HandlerNodes(services))
 		http.HandleFunc("/swift/api/v1/nodes", HandlerNodesJSON(services))
+		http.HandleFunc(
+			"/swift/api/v1/nodes-by-role",
+			HandlerNodesByRole(services))
+		http.HandleFunc(
+			"/swift/api/v1/debug-cookies",
+			HandlerDebugCookies(services))
 	}
 }
 
@@ -54,11 +80,28 @@ func newResponseError(url string, resp *http.Response) error {
 		url, resp.StatusCode, in)
 }
 
+// ErrorHandler writes the response for an error returned from a handler. The
+// code is the HTTP status code that would be used by default. Used to render
+// errors in a format consistent with the rest of the application.
+type ErrorHandler func(
+	w http.ResponseWriter,
+	r *http.Request,
+	err error,
+	code int)
+
 func returnAPIError(
 	s *Services,
 	w http.ResponseWriter,
+	r *http.Request,
 	err error,
 	code int) {
+	if s.errorHandler != nil {
+		s.errorHandler(w, r, err, code)
+		if s.config.Debug {
+			println(err.Error())
+		}
+		return
+	}
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	http.Error(w, err.Error(), code)
@@ -67,7 +110,18 @@ func returnAPIError(
 	}
 }
 
-func returnServerError(s *Services, w http.ResponseWriter, err error) {
+func returnServerError(
+	s *Services,
+	w http.ResponseWriter,
+	r *http.Request,
+	err error) {
+	if s.errorHandler != nil {
+		s.errorHandler(w, r, err, http.StatusInternalServerError)
+		if s.config.Debug {
+			println(err.Error())
+		}
+		return
+	}
 	w.Header().Set("Cache-Control", "no-cache")
 	if s.config.Debug {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -88,8 +142,31 @@ func getWriter(w http.ResponseWriter, c string) *gzip.Writer {
 	return g
 }
 
+// setAllowOrigin sets the Access-Control-Allow-Origin header for the request.
+// If no origins are configured then any origin is allowed. Otherwise the
+// origin of the request is returned if it is allowed and the header is omitted
+// if it is not.
+func setAllowOrigin(s *Services, w http.ResponseWriter, r *http.Request) {
+	if len(s.config.AllowedOrigins) == 0 {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	w.Header().Add("Vary", "Origin")
+	o := r.Header.Get("Origin")
+	if o == "" {
+		return
+	}
+	for _, a := range s.config.AllowedOrigins {
+		if strings.EqualFold(a, o) {
+			w.Header().Set("Access-Control-Allow-Origin", o)
+			return
+		}
+	}
+}
+
 func sendTemplate(s *Services,
 	w http.ResponseWriter,
+	r *http.Request,
 	t *template.Template,
 	c string,
 	m interface{}) {
@@ -97,42 +174,86 @@ func sendTemplate(s *Services,
 	defer g.Close()
 	err := t.Execute(g, m)
 	if err != nil {
-		returnServerError(s, w, err)
+		returnServerError(s, w, r, err)
 	}
 }
 
+// sendHTMLTemplate sends the template t with the model m. If a content security
+// policy is configured and one has not already been set for the response then
+// the policy is set.
 func sendHTMLTemplate(s *Services,
 	w http.ResponseWriter,
+	r *http.Request,
 	t *template.Template,
 	m interface{}) {
-	sendTemplate(s, w, t, "text/html; charset=utf-8", m)
+	p := s.config.ContentSecurityPolicyOrDefault()
+	if p != "" && w.Header().Get("Content-Security-Policy") == "" {
+		_, err := setContentSecurityPolicy(w, p)
+		if err != nil {
+			returnServerError(s, w, r, err)
+			return
+		}
+	}
+	sendTemplate(s, w, r, t, "text/html; charset=utf-8", m)
 }
 
 func sendJSTemplate(s *Services,
 	w http.ResponseWriter,
+	r *http.Request,
 	t *template.Template,
 	m interface{}) {
-	sendTemplate(s, w, t, "application/javascript; charset=utf-8", m)
+	sendTemplate(s, w, r, t, "application/javascript; charset=utf-8", m)
 }
 
 func sendResponse(
 	s *Services,
 	w http.ResponseWriter,
+	r *http.Request,
 	c string,
 	b []byte) {
 	g := getWriter(w, c)
 	defer g.Close()
 	l, err := g.Write(b)
 	if err != nil {
-		returnAPIError(s, w, err, http.StatusInternalServerError)
+		returnAPIError(s, w, r, err, http.StatusInternalServerError)
 		return
 	}
 	if l != len(b) {
 		returnAPIError(
 			s,
 			w,
+			r,
 			fmt.Errorf("Byte count mismatch"),
 			http.StatusInternalServerError)
 		return
 	}
 }
+
+// sendCacheableResponse sends the response allowing it to be cached by the
+// browser and shared caches for the duration d.
+func sendCacheableResponse(
+	s *Services,
+	w http.ResponseWriter,
+	r *http.Request,
+	c string,
+	b []byte,
+	d time.Duration) {
+	g := getWriter(w, c)
+	defer g.Close()
+	w.Header().Set(
+		"Cache-Control",
+		fmt.Sprintf("public, max-age=%d", int(d.Seconds())))
+	l, err := g.Write(b)
+	if err != nil {
+		returnAPIError(s, w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if l != len(b) {
+		returnAPIError(
+			s,
+			w,
+			r,
+			fmt.Errorf("Byte count mismatch"),
+			http.StatusInternalServerError)
+	}
+}
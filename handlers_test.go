@@ -0,0 +1,149 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSetAllowOrigin(t *testing.T) {
+	c := newConfigurationTest()
+	s := NewServices(c, nil, nil, nil)
+	for _, d := range []struct {
+		allowed  []string
+		origin   string
+		expected string
+	}{
+		{nil, "https://any.com", "*"},
+		{nil, "", "*"},
+		{[]string{"https://a.com"}, "https://a.com", "https://a.com"},
+		{[]string{"https://a.com"}, "https://A.com", "https://A.com"},
+		{[]string{"https://a.com"}, "https://b.com", ""},
+		{[]string{"https://a.com"}, "http://a.com", ""},
+		{[]string{"https://a.com"}, "", ""}} {
+		s.config.AllowedOrigins = d.allowed
+		r := httptest.NewRequest("GET", "http://access.com/", nil)
+		if d.origin != "" {
+			r.Header.Set("Origin", d.origin)
+		}
+		w := httptest.NewRecorder()
+		setAllowOrigin(s, w, r)
+		a := w.Header().Get("Access-Control-Allow-Origin")
+		if a != d.expected {
+			fmt.Printf("Origin '%s' returned '%s' not '%s'\n",
+				d.origin,
+				a,
+				d.expected)
+			t.Fail()
+		}
+	}
+}
+
+func TestHandlerDecodeAsJSONAllowOrigin(t *testing.T) {
+	s, _, err := newHandlerDeleteTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	s.config.AllowedOrigins = []string{"https://a.com"}
+	r := httptest.NewRequest(
+		"GET",
+		"http://test-1.com/swift/api/v1/decode-as-json",
+		nil)
+	r.Header.Set("Origin", "https://a.com")
+	w := httptest.NewRecorder()
+	HandlerDecodeAsJSON(s)(w, r)
+	// The header is set even when the caller is not authorized so that the
+	// browser can read the error.
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://a.com" {
+		fmt.Println("Allowed origin not returned")
+		t.Fail()
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	c := newConfigurationTest()
+	c.Debug = false
+	s := NewServices(c, nil, NewAccessSimple([]string{"key"}), nil)
+
+	// The default handler returns plain text.
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://access.com/?accessKey=wrong", nil)
+	HandlerNetworks(s)(w, r)
+	if w.Code != http.StatusNetworkAuthenticationRequired ||
+		strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") ==
+			false {
+		fmt.Printf("Default error response '%d' not plain text\n", w.Code)
+		t.Fail()
+	}
+
+	// A custom handler is used with the request, error and status code.
+	var x *http.Request
+	s.SetErrorHandler(func(
+		w http.ResponseWriter,
+		r *http.Request,
+		err error,
+		code int) {
+		x = r
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(code)
+		fmt.Fprintf(w, `{"status":%d,"detail":"%s"}`, code, err.Error())
+	})
+	w = httptest.NewRecorder()
+	HandlerNetworks(s)(w, r)
+	if x != r ||
+		w.Code != http.StatusNetworkAuthenticationRequired ||
+		w.Header().Get("Content-Type") != "application/problem+json" ||
+		strings.Contains(w.Body.String(), "Access denied") == false {
+		fmt.Printf("Custom error response '%s' not used\n", w.Body.String())
+		t.Fail()
+	}
+}
+
+func TestIsAccessAllowed(t *testing.T) {
+	s := NewServices(
+		newConfigurationTest(),
+		nil,
+		NewAccessSimple([]string{"key"}),
+		nil)
+	for _, d := range []struct {
+		key     string
+		allowed bool
+	}{
+		{"key", true},
+		{"wrong", false},
+		{"", false}} {
+		r := httptest.NewRequest(
+			"GET",
+			"http://access.com/?accessKey="+d.key,
+			nil)
+		v, _ := s.IsAccessAllowed(r)
+		if v != d.allowed {
+			fmt.Printf("Key '%s' expected '%t'\n", d.key, d.allowed)
+			t.Fail()
+		}
+		if r.Form.Get("accessKey") != "" {
+			fmt.Println("Access key not removed from form")
+			t.Fail()
+		}
+	}
+}
@@ -16,7 +16,10 @@
 
 package swift
 
-import "bytes"
+import (
+	"bytes"
+	"fmt"
+)
 
 // Constants for the bits in operation.flags where the constant name corresponds
 // to the public method of operation.
@@ -25,8 +28,20 @@ const (
 	flagPostMessageOnComplete = iota
 	flagUseHomeNode           = iota
 	flagJavaScript            = iota
+	flagAllowPartial          = iota
+	flagCSPSafe               = iota
+	flagWarmup                = iota
+	flagBlankOnHomeNode       = iota
+	flagCount                 = iota
 )
 
+// flagBits is the number of bits available in HTML.flags. A new flag constant
+// beyond the last free bit fails to compile at the guard below rather than
+// being silently dropped when shifted into the byte.
+const flagBits = 8
+
+var _ [flagBits - flagCount]struct{}
+
 // HTML parameters that control the function and display of the user interface.
 type HTML struct {
 	Title           string // Window title
@@ -40,7 +55,8 @@ type HTML struct {
 // DisplayUserInterface true if a UI should be displayed during the storage
 // operation, otherwise false.
 func (h *HTML) DisplayUserInterface() bool {
-	return h.hasBit(flagDisplayUserInterface)
+	v, _ := h.hasBit(flagDisplayUserInterface)
+	return v
 }
 
 // DisplayUserInterfaceAsString returns the flag as string either "true" or
@@ -66,7 +82,8 @@ func (h *HTML) SetDisplayUserInterface(v bool) {
 // false.
 // parent.postMessage("swan","[Encrypted SWAN data]");
 func (h *HTML) PostMessageOnComplete() bool {
-	return h.hasBit(flagPostMessageOnComplete)
+	v, _ := h.hasBit(flagPostMessageOnComplete)
+	return v
 }
 
 // PostMessageOnCompleteAsString returns the flag as string either "true" or
@@ -91,7 +108,8 @@ func (h *HTML) SetPostMessageOnComplete(v bool) {
 // False if the SWAN network should be consulted irrespective of the state of
 // data held on the home node.
 func (h *HTML) UseHomeNode() bool {
-	return h.hasBit(flagUseHomeNode)
+	v, _ := h.hasBit(flagUseHomeNode)
+	return v
 }
 
 // UseHomeNodeAsString returns the flag as a string. Either "true" or "false".
@@ -115,7 +133,8 @@ func (h *HTML) SetUseHomeNode(v bool) {
 // include that will continue the operation. This feature requires cookies to be
 // sent for DOM inserted JavaScript elements.
 func (h *HTML) JavaScript() bool {
-	return h.hasBit(flagJavaScript)
+	v, _ := h.hasBit(flagJavaScript)
+	return v
 }
 
 // UseJavaScriptAsString returns the flag as a string. Either "true" or "false".
@@ -135,19 +154,94 @@ func (h *HTML) SetJavaScript(v bool) {
 	}
 }
 
-func (h *HTML) setBit(pos uint8) byte {
+// AllowPartial true if the values collected so far should be returned when the
+// operation runs out of time rather than no values.
+func (h *HTML) AllowPartial() bool {
+	v, _ := h.hasBit(flagAllowPartial)
+	return v
+}
+
+// SetAllowPartial sets the flag to true or false.
+func (h *HTML) SetAllowPartial(v bool) {
+	if v {
+		h.setBit(flagAllowPartial)
+	} else {
+		h.clearBit(flagAllowPartial)
+	}
+}
+
+// CSPSafe true if the user interface must not use inline styles and any
+// inline script must carry a nonce so that strict content security policies
+// are not violated.
+func (h *HTML) CSPSafe() bool {
+	v, _ := h.hasBit(flagCSPSafe)
+	return v
+}
+
+// SetCSPSafe sets the flag to true or false.
+func (h *HTML) SetCSPSafe(v bool) {
+	if v {
+		h.setBit(flagCSPSafe)
+	} else {
+		h.clearBit(flagCSPSafe)
+	}
+}
+
+// Warmup true if the operation only visits the home node to set or refresh the
+// cookies for the keys so that later operations can use the home node alone.
+func (h *HTML) Warmup() bool {
+	v, _ := h.hasBit(flagWarmup)
+	return v
+}
+
+// SetWarmup sets the flag to true or false.
+func (h *HTML) SetWarmup(v bool) {
+	if v {
+		h.setBit(flagWarmup)
+	} else {
+		h.clearBit(flagWarmup)
+	}
+}
+
+// BlankOnHomeNode true if no user interface should be displayed when the
+// operation completes at the home node without visiting other nodes, even if
+// DisplayUserInterface is true.
+func (h *HTML) BlankOnHomeNode() bool {
+	v, _ := h.hasBit(flagBlankOnHomeNode)
+	return v
+}
+
+// SetBlankOnHomeNode sets the flag to true or false.
+func (h *HTML) SetBlankOnHomeNode(v bool) {
+	if v {
+		h.setBit(flagBlankOnHomeNode)
+	} else {
+		h.clearBit(flagBlankOnHomeNode)
+	}
+}
+
+func (h *HTML) setBit(pos uint8) (byte, error) {
+	if pos >= flagBits {
+		return h.flags, fmt.Errorf("flag position '%d' beyond '%d' bits", pos, flagBits)
+	}
 	h.flags |= (1 << pos)
-	return h.flags
+	return h.flags, nil
 }
 
-func (h *HTML) clearBit(pos uint8) byte {
+func (h *HTML) clearBit(pos uint8) (byte, error) {
+	if pos >= flagBits {
+		return h.flags, fmt.Errorf("flag position '%d' beyond '%d' bits", pos, flagBits)
+	}
 	h.flags &= ^(1 << pos)
-	return h.flags
+	return h.flags, nil
 }
 
-func (h *HTML) hasBit(pos uint8) bool {
+func (h *HTML) hasBit(pos uint8) (bool, error) {
+	if pos >= flagBits {
+		return false, fmt.Errorf("flag position '%d' beyond '%d' bits", pos, flagBits)
+	}
 	val := h.flags & (1 << pos)
-	return (val > 0)
+	return (val > 0), nil
 }
 
 func (h *HTML) write(b *bytes.Buffer) error {
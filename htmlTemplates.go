@@ -38,21 +38,30 @@ table {
 	text-align: center; }
 `
 
-var progressRedirect = `
+var debugStyle = `
+.debug {
+	text-align:left;
+	font-weight:initial;
+}
+.debug tr td {
+	word-wrap:break-word;
+	word-break:break-all;
+}`
+
+var progressRedirect = newProgressRedirect("<style>" + debugStyle + "</style>")
+
+// progressRedirectCSP is the same as progressRedirect without the inline style
+// which is provided by the styles handler instead.
+var progressRedirectCSP = newProgressRedirect("")
+
+// newProgressRedirect returns the redirect, or debug information if enabled,
+// with the style s for the debug tables.
+func newProgressRedirect(s string) string {
+	return `
 {{if .Debug}}
 <tr><td><a href="{{.NextURL}}">Next</a></td></tr>
 <tr>
-	<td>
-		<style>
-			.debug {
-				text-align:left;
-				font-weight:initial;
-			}
-			.debug tr td {
-				word-wrap:break-word;
-				word-break:break-all;
-			}
-		</style>
+	<td>` + s + `
 		<table class="debug">
 			<tr><th>TimeStamp:</th><td>{{.TimeStamp}}</td></tr>
 			<tr><th>TimeValid:</th><td>{{.IsTimeStampValid}}</td></tr>
@@ -79,6 +88,7 @@ var progressRedirect = `
 {{else}}
 <meta http-equiv="refresh" content="0;URL='{{.NextURL}}'"/>
 {{end}}`
+}
 
 var progressUI = `
 <tr>
@@ -116,6 +126,48 @@ var progressTemplate = newHTMLTemplate("progress", `
 <body><table>`+progressUI+progressRedirect+`</table></body>
 </html>`)
 
+// progressUICSP is the same as progressUI without inline styles. The classes
+// are defined by the styles handler.
+var progressUICSP = `
+<tr>
+	<td>
+		<p class="swift-message">{{.Message}}</p>
+	</td>
+</tr>
+<tr>
+	<td>
+		<div class="swift-progress">
+			<div>{{.PercentageComplete}}%</div>
+			<svg><path d="{{.SVGPath}}"></path></svg>
+		</div>
+	</td>
+</tr>`
+
+// cspHead is the head for templates used when the operation is CSP safe. The
+// styles are referenced from the styles handler rather than included inline.
+var cspHead = `
+<head>
+	<meta charset="utf-8" />
+	<title>{{.Title}}</title>
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<link rel="icon" href="data:;base64,=">
+	<link rel="stylesheet" href="{{.StylesURL}}">
+</head>`
+
+var progressCSPTemplate = newHTMLTemplate("progressCSP", `
+<!DOCTYPE html>
+<html lang="{{.Language}}">`+cspHead+`
+<body><table>`+progressUICSP+progressRedirectCSP+`</table></body>
+</html>`)
+
+var blankCSPTemplate = newHTMLTemplate("blankCSP", `
+<!DOCTYPE html>
+<html lang="{{.Language}}">`+cspHead+`
+<body>
+	<meta http-equiv="refresh" content="0;URL='{{.NextURL}}'"/>
+</body>
+</html>`)
+
 var blankTemplate = newHTMLTemplate("blank", `
 <!DOCTYPE html>
 <html lang="{{.Language}}">
@@ -135,7 +187,7 @@ var malformedTemplate = newHTMLTemplate("malformed", `
 <html lang="{{.Language}}">
 <head>
 	<meta charset="utf-8" />
-	<title>Bad Request</title>
+	<title>{{text . "badRequest"}}</title>
 	<meta name="viewport" content="width=device-width, initial-scale=1">
 	<link rel="icon" href="data:;base64,=">
 	<style>`+bodyStyle+`</style>
@@ -144,16 +196,22 @@ var malformedTemplate = newHTMLTemplate("malformed", `
 	<table style="text-align: center; background-color: white; padding: 1em; border: solid black 2px;">
 		<tr>
 			<td>
-				<p>Invalid request.</p>
-				<p>Use the settings option in your web browser to disable tracking prevention.</p>
+				<p>{{text . "invalidRequest"}}</p>
+				<p>{{text . "disableTracking"}}</p>
 			</td>
 		</tr>        
 		<tr>
 			<td style="padding: 0.5em;">
-				<a href="javascript:history.go(-1)" style="display: inline; padding: 0.5em; background-color:black; text-decoration: none; color: white; border: none;">Try Again</a>
+				<a id="back" href="#" style="display: inline; padding: 0.5em; background-color:black; text-decoration: none; color: white; border: none;">{{text . "tryAgain"}}</a>
 			</td>
 		</tr>
 	</table>
+	<script nonce="{{.Nonce}}">
+		document.getElementById("back").addEventListener("click", function(e) {
+			e.preventDefault();
+			history.go(-1);
+		});
+	</script>
 </body>
 </html>`)
 
@@ -210,6 +268,21 @@ var registerTemplate = newHTMLTemplate("register", `
 				{{end}}
 			</td>
 		</tr>
+		{{if .TokenRequired}}
+		<tr>
+			<td>
+				<p><label for="token">Setup Token</label></p>
+			</td>
+			<td>
+				<p><input type="text" id="token" name="token" value="{{.Token}}" {{if .ReadOnly}}disabled{{end}}></p>
+			</td>
+			<td>
+				{{if .DisplayErrors}}
+				<p>{{.TokenError}}</p>
+				{{end}}
+			</td>
+		</tr>
+		{{end}}
 		<tr>
 			<td>
 				<p><label for="starts">Starts (UTC)</label></p>
@@ -288,6 +361,11 @@ var registerTemplate = newHTMLTemplate("register", `
 			<td>
 			<p><input type="text" maxlength="30" id="cookieDomain" name="cookieDomain" value="{{.CookieDomain}}" {{if .ReadOnly}}disabled{{end}}></p>
 			</td>
+			<td>
+				{{if .DisplayErrors}}
+				<p>{{.CookieDomainError}}</p>
+				{{end}}
+			</td>
 		</tr>				
 		<tr>
 			<td colspan="3">
@@ -322,14 +400,14 @@ var warningTemplate = newHTMLTemplate("warning", `
 	<table style="text-align: center; background-color: white; padding: 1em; border: solid black 2px;">
 		<tr>
 			<td>
-				<p>Cookies are required.</p>
-				<p>Use the settings option in your web browser to enable cookies.</p>
-				<p>You may need to disable tracking prevention.</p>
+				<p>{{text . "cookiesRequired"}}</p>
+				<p>{{text . "enableCookies"}}</p>
+				<p>{{text . "mayDisableTracking"}}</p>
 			</td>
 		</tr>
 		<tr>
 			<td style="padding: 0.5em;">
-				<a href="{{.NextURL}}" style="display: inline; padding: 0.5em; background-color:black; text-decoration: none; color: white; border: none;">Try Again</a>
+				<a href="{{.NextURL}}" style="display: inline; padding: 0.5em; background-color:black; text-decoration: none; color: white; border: none;">{{text . "tryAgain"}}</a>
 			</td>
 		</tr>
 		{{if .Debug}}
@@ -368,6 +446,33 @@ var warningTemplate = newHTMLTemplate("warning", `
 </body>
 </html>`)
 
+var giveUpTemplate = newHTMLTemplate("giveUp", `
+<!DOCTYPE html>
+<html lang="{{.Language}}">
+<head>
+	<meta charset="utf-8" />
+	<title>{{.Title}}</title>
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<link rel="icon" href="data:;base64,=">
+	<style>`+bodyStyle+`</style>
+</head>
+<body>
+	<table style="text-align: center; background-color: white; padding: 1em; border: solid black 2px;">
+		<tr>
+			<td>
+				<p>Cookies could not be used.</p>
+				<p>Your preferences will not be remembered.</p>
+			</td>
+		</tr>
+		<tr>
+			<td style="padding: 0.5em;">
+				<a href="{{.NextURL}}" style="display: inline; padding: 0.5em; background-color:black; text-decoration: none; color: white; border: none;">Continue</a>
+			</td>
+		</tr>
+	</table>
+</body>
+</html>`)
+
 var postMessageScript = `
 const r = "{{.ReturnURL}}";
 const d = "{{.Results}}";
@@ -384,7 +489,7 @@ var postMessageTemplate = newHTMLTemplate("postMessage", `
 	<style>`+bodyStyle+`</style>
 </head>
 <body><table>`+progressUI+`</table>
-	<script>`+postMessageScript+`</script>
+	<script nonce="{{.Nonce}}">`+postMessageScript+`</script>
 </body>
 </html>`)
 
@@ -399,10 +504,34 @@ var postMessageBlankTemplate = newHTMLTemplate("postMessage", `
 	<style>`+bodyStyle+`</style>
 </head>
 <body>
-<script>`+postMessageScript+`</script>
+<script nonce="{{.Nonce}}">`+postMessageScript+`</script>
+</body>
+</html>`)
+
+var postMessageCSPTemplate = newHTMLTemplate("postMessageCSP", `
+<!DOCTYPE html>
+<html lang="{{.Language}}">`+cspHead+`
+<body><table>`+progressUICSP+`</table>
+	<script nonce="{{.Nonce}}">`+postMessageScript+`</script>
+</body>
+</html>`)
+
+var postMessageBlankCSPTemplate = newHTMLTemplate("postMessageCSP", `
+<!DOCTYPE html>
+<html lang="{{.Language}}">`+cspHead+`
+<body>
+<script nonce="{{.Nonce}}">`+postMessageScript+`</script>
 </body>
 </html>`)
 
+// cspTemplates maps the templates used for storage operations to the CSP safe
+// equivalent.
+var cspTemplates = map[*template.Template]*template.Template{
+	progressTemplate:         progressCSPTemplate,
+	blankTemplate:            blankCSPTemplate,
+	postMessageTemplate:      postMessageCSPTemplate,
+	postMessageBlankTemplate: postMessageBlankCSPTemplate}
+
 var javaScriptProgressTemplate = newJavaScriptTemplate("javaScriptProgress", `
 var s=document.createElement("script");
 s.src="{{.NextURL}}";
@@ -451,13 +580,22 @@ var swiftNodesTemplate = newHTMLTemplate("swiftNodes", `
         </tr>
     {{ end}}
 </table>
+<p>
+    {{ if .HasPrevious }}
+        <a href="?page={{ .PreviousPage }}&pageSize={{ .PageSize }}">Previous</a>
+    {{ end }}
+    Page {{ .Page }} of {{ .Pages }} ({{ .Total }} nodes)
+    {{ if .HasNext }}
+        <a href="?page={{ .NextPage }}&pageSize={{ .PageSize }}">Next</a>
+    {{ end }}
+</p>
 </body>
 </html>
 `)
 
 func newHTMLTemplate(n string, h string) *template.Template {
 	c := removeHTMLWhiteSpace(h)
-	return template.Must(template.New(n).Parse(c))
+	return template.Must(template.New(n).Funcs(templateFuncs).Parse(c))
 }
 
 func newJavaScriptTemplate(n string, h string) *template.Template {
@@ -0,0 +1,64 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestHTMLFlags checks that every flag can be set and cleared independently.
+func TestHTMLFlags(t *testing.T) {
+	for p := uint8(0); p < flagCount; p++ {
+		var h HTML
+		if _, err := h.setBit(p); err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		if h.flags != 1<<p {
+			fmt.Printf("flag '%d' set '%08b'\n", p, h.flags)
+			t.Fail()
+		}
+		if _, err := h.clearBit(p); err != nil || h.flags != 0 {
+			fmt.Printf("flag '%d' not cleared\n", p)
+			t.Fail()
+		}
+	}
+}
+
+// TestHTMLFlagsBeyondByte checks that positions outside the flags byte are
+// rejected rather than silently ignored.
+func TestHTMLFlagsBeyondByte(t *testing.T) {
+	var h HTML
+	if _, err := h.setBit(flagBits); err == nil {
+		fmt.Println("set beyond byte should fail")
+		t.Fail()
+	}
+	if _, err := h.clearBit(flagBits); err == nil {
+		fmt.Println("clear beyond byte should fail")
+		t.Fail()
+	}
+	if _, err := h.hasBit(flagBits); err == nil {
+		fmt.Println("has beyond byte should fail")
+		t.Fail()
+	}
+	if h.flags != 0 {
+		fmt.Printf("flags changed '%08b'\n", h.flags)
+		t.Fail()
+	}
+}
@@ -27,6 +27,16 @@ import (
 // The base year for all dates encoded with the io time methods.
 var ioDateBase = time.Date(2020, time.Month(1), 1, 0, 0, 0, 0, time.UTC)
 
+// ioDateWide is the two byte value written in place of the days since
+// ioDateBase when the date is before ioDateBase or too far in the future to
+// fit in two bytes. It is followed by the signed number of days as four bytes.
+// Dates written before the wide format existed never use this value so they
+// continue to decode.
+const ioDateWide = math.MaxUint16
+
+// The number of seconds in a day used to convert Unix times to days.
+const secondsPerDay = 24 * 60 * 60
+
 func readString(b *bytes.Buffer) (string, error) {
 	s, err := b.ReadBytes(0)
 	if err == nil {
@@ -116,11 +126,44 @@ func readDate(b *bytes.Buffer) (time.Time, error) {
 		return time.Time{}, err
 	}
 	d := int(h)<<8 | int(l)
+	if d == ioDateWide {
+		w, err := readUint32(b)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return ioDateBase.AddDate(0, 0, int(int32(w))), nil
+	}
 	return ioDateBase.Add(time.Duration(d) * time.Hour * 24), nil
 }
 
+// writeDate writes the number of days between ioDateBase and t. Uses two bytes
+// if possible, otherwise the ioDateWide marker followed by four bytes. The
+// difference is calculated from Unix seconds as time.Sub saturates for dates
+// more than 292 years apart. Dates beyond the range of four bytes are limited
+// to the range.
 func writeDate(b *bytes.Buffer, t time.Time) error {
-	i := int(t.Sub(ioDateBase).Hours() / 24)
+	s := t.Unix() - ioDateBase.Unix()
+	d := s / secondsPerDay
+	if s%secondsPerDay < 0 {
+		d--
+	}
+	if d > math.MaxInt32 {
+		d = math.MaxInt32
+	} else if d < math.MinInt32 {
+		d = math.MinInt32
+	}
+	i := int(d)
+	if i < 0 || i >= ioDateWide {
+		err := writeByte(b, byte(ioDateWide>>8))
+		if err != nil {
+			return err
+		}
+		err = writeByte(b, byte(ioDateWide&0x00FF))
+		if err != nil {
+			return err
+		}
+		return writeUint32(b, uint32(int32(i)))
+	}
 	err := writeByte(b, byte(i>>8))
 	if err != nil {
 		return err
@@ -140,6 +183,18 @@ func writeByte(b *bytes.Buffer, i byte) error {
 	return b.WriteByte(i)
 }
 
+func readBool(b *bytes.Buffer) (bool, error) {
+	d, err := readByte(b)
+	return d != 0, err
+}
+
+func writeBool(b *bytes.Buffer, v bool) error {
+	if v {
+		return writeByte(b, 1)
+	}
+	return writeByte(b, 0)
+}
+
 func readUint64(b *bytes.Buffer) (uint64, error) {
 	d := b.Next(8)
 	if len(d) != 8 {
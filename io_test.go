@@ -55,3 +55,61 @@ func testCompareDate(t *testing.T, a time.Time, b time.Time) {
 		t.Fail()
 	}
 }
+
+func TestIoDateWide(t *testing.T) {
+	for _, d := range []time.Time{
+		time.Date(1999, time.Month(12), 31, 0, 0, 0, 0, time.UTC),
+		time.Date(1999, time.Month(6), 15, 12, 0, 0, 0, time.UTC),
+		time.Date(2200, time.Month(1), 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2199, time.Month(5), 1, 0, 0, 0, 0, time.UTC)} {
+		var b bytes.Buffer
+		err := writeDate(&b, d)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			continue
+		}
+		if b.Len() != 6 && d.Year() != 2199 {
+			fmt.Printf("Date '%s' not written in the wide format\n", d)
+			t.Fail()
+		}
+		r, err := readDate(&b)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			continue
+		}
+		testCompareDate(t, r, d)
+	}
+
+	// Dates more than 292 years from the base date round trip.
+	for _, d := range []time.Time{
+		time.Date(1, time.Month(1), 1, 0, 0, 0, 0, time.UTC),
+		time.Date(1600, time.Month(2), 29, 23, 0, 0, 0, time.UTC),
+		time.Date(2400, time.Month(3), 1, 1, 0, 0, 0, time.UTC),
+		time.Date(9999, time.Month(12), 31, 0, 0, 0, 0, time.UTC)} {
+		var b bytes.Buffer
+		err := writeDate(&b, d)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			continue
+		}
+		r, err := readDate(&b)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			continue
+		}
+		testCompareDate(t, r, d)
+	}
+
+	// Dates written in two bytes before the wide format still decode.
+	r, err := readDate(bytes.NewBuffer([]byte{0x01, 0x00}))
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	testCompareDate(t, r, ioDateBase.AddDate(0, 0, 256))
+}
@@ -0,0 +1,316 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// journeyLeg is a network that remains to be visited as part of a multi network
+// operation.
+type journeyLeg struct {
+	network   string // The name of the network
+	homeNode  string // The domain of the home node in the network
+	nodeCount byte   // Number of nodes that should be visited in the network
+}
+
+// networkPairs are the resolved pairs from a network that has been visited as
+// part of a multi network operation.
+type networkPairs struct {
+	network string  // The name of the network
+	pairs   []*pair // The resolved pairs for the network
+}
+
+// setJourney adds a leg to the operation for each of the additional networks
+// provided in the networks form parameter. The home node for each network is
+// determined when the operation is created so that the journey is consistent
+// for the web browser.
+func setJourney(s *Services, o *operation, q *url.Values) error {
+	for _, x := range (*q)[networksParam] {
+		v := normalizeNetwork(x)
+		if v == o.thisNode.network {
+			return fmt.Errorf(
+				"Network '%s' is the network of the access node",
+				v)
+		}
+		ns, err := s.store.getNodes(v)
+		if err != nil {
+			return err
+		}
+		if ns == nil {
+			return fmt.Errorf("Network '%s' does not exist", v)
+		}
+		h, err := s.getHomeNodeInNetwork(
+			ns,
+			q.Get(xforwarededfor),
+			q.Get(remoteAddr))
+		if err != nil {
+			return err
+		}
+		c, err := getCount(q, s)
+		if err != nil {
+			return err
+		}
+		if c > (byte)(len(ns.hash)) {
+			c = (byte)(len(ns.hash))
+		}
+		o.journey = append(o.journey, &journeyLeg{
+			network:   v,
+			homeNode:  h.domain,
+			nodeCount: c})
+	}
+	return nil
+}
+
+// storeNextNetwork completes the operation for the current network and
+// continues the operation at the home node of the next network in the journey.
+// The pairs requested when the operation was created are used for the next
+// network.
+func (o *operation) storeNextNetwork(
+	s *Services,
+	w http.ResponseWriter,
+	r *http.Request) {
+	var err error
+
+	// Sets cookies for any non empty resolved pairs in this network.
+	o.setCookies(s, w, r)
+
+	// Record the results for this network.
+	o.completed = append(o.completed, &networkPairs{
+		network: o.thisNode.network,
+		pairs:   o.resolved})
+
+	// Move to the next leg of the journey.
+	l := o.journey[0]
+	o.journey = o.journey[1:]
+	o.network, err = s.store.getNodes(l.network)
+	if err != nil {
+		returnServerError(s, w, r, err)
+		return
+	}
+	o.homeNode = l.homeNode
+	o.homeNodePtr = nil
+	o.prevNode = ""
+	o.prevNodePtr = nil
+	o.nextNode = o.HomeNode()
+	if o.nextNode == nil {
+		returnServerError(s, w, r, fmt.Errorf("No home node available"))
+		return
+	}
+	o.nodesVisited = 0
+	o.nodeCount = l.nodeCount
+	o.timeStamp = s.clock.Now()
+	o.resolved = o.requested
+
+	// Get the next URL for the home node of the next network.
+	o.nextURL, err = o.getNextURL()
+	if err != nil {
+		returnServerError(s, w, r, err)
+		return
+	}
+
+	if o.JavaScript() {
+		o.storeContinueJavaScript(s, w, r)
+	} else {
+		o.storeContinueHTML(s, w, r)
+	}
+}
+
+// getNetworkResults returns the results for each network visited by the
+// operation including the current one, and the pairs merged across all the
+// networks using the conflict policy of each pair. If only one network has
+// been visited then no network results are returned.
+func (o *operation) getNetworkResults() ([]*NetworkResults, []*pair, error) {
+	if len(o.completed) == 0 {
+		return nil, o.resolved, nil
+	}
+	a := append(o.completed, &networkPairs{
+		network: o.thisNode.network,
+		pairs:   o.resolved})
+	var n []*NetworkResults
+	var m []*pair
+	for _, c := range a {
+		var r NetworkResults
+		r.network = c.network
+		for _, p := range c.pairs {
+			if p.existsOnly {
+				p = p.withoutValues()
+			}
+			r.pairs = append(r.pairs, &p.Pair)
+			i := findPair(m, p.key)
+			if i < 0 {
+				m = append(m, p)
+			} else {
+				f := m[i].flags | p.flags
+				x, err := o.services.resolveConflict(m[i], p)
+				if err != nil {
+					return nil, nil, err
+				}
+				x.flags = f
+				m[i] = x
+			}
+		}
+		n = append(n, &r)
+	}
+	return n, m, nil
+}
+
+// findPair returns the index of the pair with the key k or -1 if not found.
+func findPair(a []*pair, k string) int {
+	for i, p := range a {
+		if p.key == k {
+			return i
+		}
+	}
+	return -1
+}
+
+// getJourneyPairs returns the requested pairs followed by the pairs of each
+// completed network in the order they are written by writeJourney. Used to
+// write and read the per pair fields that follow the journey.
+func (o *operation) getJourneyPairs() []*pair {
+	a := o.requested
+	for _, c := range o.completed {
+		a = append(a[:len(a):len(a)], c.pairs...)
+	}
+	return a
+}
+
+func writeJourney(b *bytes.Buffer, o *operation) error {
+	err := writePairs(b, o.requested)
+	if err != nil {
+		return err
+	}
+	err = writeByte(b, byte(len(o.journey)))
+	if err != nil {
+		return err
+	}
+	for _, l := range o.journey {
+		err = writeString(b, l.network)
+		if err != nil {
+			return err
+		}
+		err = writeString(b, l.homeNode)
+		if err != nil {
+			return err
+		}
+		err = writeByte(b, l.nodeCount)
+		if err != nil {
+			return err
+		}
+	}
+	err = writeByte(b, byte(len(o.completed)))
+	if err != nil {
+		return err
+	}
+	for _, c := range o.completed {
+		err = writeString(b, c.network)
+		if err != nil {
+			return err
+		}
+		err = writePairs(b, c.pairs)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readJourney(b *bytes.Buffer, o *operation) error {
+	var err error
+	o.requested, err = readPairs(b)
+	if err != nil {
+		return err
+	}
+	c, err := readByte(b)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < int(c); i++ {
+		var l journeyLeg
+		l.network, err = readString(b)
+		if err != nil {
+			return err
+		}
+		l.homeNode, err = readString(b)
+		if err != nil {
+			return err
+		}
+		l.nodeCount, err = readByte(b)
+		if err != nil {
+			return err
+		}
+		o.journey = append(o.journey, &l)
+	}
+	c, err = readByte(b)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < int(c); i++ {
+		var n networkPairs
+		n.network, err = readString(b)
+		if err != nil {
+			return err
+		}
+		n.pairs, err = readPairs(b)
+		if err != nil {
+			return err
+		}
+		o.completed = append(o.completed, &n)
+	}
+	return nil
+}
+
+func writePairs(b *bytes.Buffer, a []*pair) error {
+	err := writeByte(b, byte(len(a)))
+	if err != nil {
+		return err
+	}
+	for _, p := range a {
+
+		// Exists queries only pass the presence of the key and timestamps to
+		// the next network.
+		if p.existsOnly {
+			p = p.withoutValues()
+		}
+		err = p.writeToBuffer(b)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readPairs(b *bytes.Buffer) ([]*pair, error) {
+	c, err := readByte(b)
+	if err != nil {
+		return nil, err
+	}
+	a := make([]*pair, 0, c)
+	for i := 0; i < int(c); i++ {
+		var p pair
+		err = p.setFromBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, &p)
+	}
+	return a, nil
+}
@@ -0,0 +1,369 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Used to find the next URL in the HTML returned from the store handler.
+var testNextURLRegex = regexp.MustCompile("URL='([^']+)'")
+
+func TestJourneyMergedResults(t *testing.T) {
+	s, ns, a, h, err := newJourneyTest()
+	defer h.Close()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+
+	// The browser has state for key a in network a and key b in network b.
+	j := make(map[string][]*http.Cookie)
+	j["a-storage.com"] = testJourneyCookie(s, ns["a-storage.com"], "a", "A")
+	j["b-storage.com"] = testJourneyCookie(s, ns["b-storage.com"], "b", "B")
+
+	// Create a single operation that visits both networks.
+	q := url.Values{}
+	q.Set("table", "t")
+	q.Set("returnUrl", "http://return.com/")
+	q.Set("a>", "")
+	q.Set("b>", "")
+	q.Set("networks", "b")
+	r, err := testJourney(s, a, q, j)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	for k, e := range map[string]string{"a": "A", "b": "B"} {
+		p := r.Get(k)
+		if p == nil || len(p.values) != 1 || string(p.values[0]) != e {
+			fmt.Printf("Merged result for key '%s' is not '%s'\n", k, e)
+			t.Fail()
+		}
+	}
+	if len(r.Networks()) != 2 ||
+		r.Networks()[0].Network() != "a" ||
+		r.Networks()[1].Network() != "b" {
+		fmt.Println("Results do not contain both networks")
+		t.Fail()
+	}
+}
+
+// TestJourneyExistsOnly checks that exists only queries do not return values
+// from any network of a multi network operation.
+func TestJourneyExistsOnly(t *testing.T) {
+	s, ns, a, h, err := newJourneyTest()
+	defer h.Close()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	j := make(map[string][]*http.Cookie)
+	j["a-storage.com"] = testJourneyCookie(s, ns["a-storage.com"], "a", "A")
+	j["b-storage.com"] = testJourneyCookie(s, ns["b-storage.com"], "b", "B")
+	q := url.Values{}
+	q.Set("table", "t")
+	q.Set("returnUrl", "http://return.com/")
+	q.Set("a~", "")
+	q.Set("b~", "")
+	q.Set("networks", "b")
+	r, err := testJourney(s, a, q, j)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	for _, k := range []string{"a", "b"} {
+		p := r.Get(k)
+		if p == nil || p.Exists() == false || len(p.values) != 0 {
+			fmt.Printf("Key '%s' not returned as exists only\n", k)
+			t.Fail()
+		}
+	}
+	for _, n := range r.Networks() {
+		for _, p := range n.pairs {
+			if len(p.values) != 0 {
+				fmt.Printf("Network '%s' returned values for key '%s'\n",
+					n.Network(),
+					p.key)
+				t.Fail()
+			}
+		}
+	}
+}
+
+// TestJourneyPairFlags checks that the pair flags and the cookie expiry apply
+// in every network of a multi network operation.
+func TestJourneyPairFlags(t *testing.T) {
+	s, _, a, h, err := newJourneyTest()
+	defer h.Close()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	j := make(map[string][]*http.Cookie)
+	e := time.Now().UTC().AddDate(2, 0, 0)
+	c := time.Now().UTC().AddDate(1, 0, 0)
+	q := url.Values{}
+	q.Set("table", "t")
+	q.Set("returnUrl", "http://return.com/")
+	q.Set("b>"+e.Format("2006-01-02")+">"+c.Format("2006-01-02"), "B")
+	q.Set(sensitiveParam, "b")
+	q.Set("networks", "b")
+	r, err := testJourney(s, a, q, j)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	p := r.Get("b")
+	if p == nil || p.Sensitive() == false {
+		fmt.Println("Key 'b' not sensitive after second network")
+		t.Fail()
+	}
+	if len(j["b-storage.com"]) == 0 {
+		fmt.Println("No cookies set in second network")
+		t.Fail()
+	}
+	for _, k := range j["b-storage.com"] {
+		if k.Expires.After(c.AddDate(0, 0, 1)) {
+			fmt.Printf("Cookie '%s' expires '%s' after cookie expiry '%s'\n",
+				k.Name,
+				k.Expires,
+				c)
+			t.Fail()
+		}
+	}
+}
+
+// TestJourneyClock checks that the time stamp of the operation is reset with
+// the services clock when moving to the next network.
+func TestJourneyClock(t *testing.T) {
+	s, ns, a, h, err := newJourneyTest()
+	defer h.Close()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	f := newFakeClock()
+	f.now = time.Now().UTC().Add(time.Hour)
+	s.SetClock(f)
+	j := make(map[string][]*http.Cookie)
+	j["b-storage.com"] = testJourneyCookie(s, ns["b-storage.com"], "b", "B")
+	q := url.Values{}
+	q.Set("table", "t")
+	q.Set("returnUrl", "http://return.com/")
+	q.Set("b>", "")
+	q.Set("networks", "b")
+	r, err := testJourney(s, a, q, j)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	p := r.Get("b")
+	if p == nil || len(p.values) != 1 || string(p.values[0]) != "B" {
+		fmt.Println("Result for key 'b' not returned")
+		t.Fail()
+	}
+}
+
+// TestJourneyNormalizeNetwork checks that the networks parameter is normalized
+// before it is compared to the network of the access node and stored.
+func TestJourneyNormalizeNetwork(t *testing.T) {
+	s, _, a, h, err := newJourneyTest()
+	defer h.Close()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	q := url.Values{}
+	q.Set("networks", " A ")
+	err = setJourney(s, newOperation(s, s.store.getNode(a)), &q)
+	if err == nil {
+		fmt.Println("Network of the access node accepted")
+		t.Fail()
+	}
+	q.Set("networks", "B")
+	o := newOperation(s, s.store.getNode(a))
+	err = setJourney(s, o, &q)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if len(o.journey) != 1 || o.journey[0].network != "b" {
+		fmt.Println("Network not normalized")
+		t.Fail()
+	}
+}
+
+// newJourneyTest returns services with an access node and storage node in
+// network a and a storage node in network b, the nodes keyed on domain, the
+// domain of the access node, and the server for the access node that must be
+// closed by the caller.
+func newJourneyTest() (
+	*Services,
+	map[string]*node,
+	string,
+	*httptest.Server,
+	error) {
+	var s *Services
+
+	// Create a server for the access node to encrypt the results.
+	h := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			HandlerEncrypt(s)(w, r)
+		}))
+	u, err := url.Parse(h.URL)
+	if err != nil {
+		return nil, nil, "", h, err
+	}
+
+	// Create an access node and storage node in network a, and a storage node
+	// in network b.
+	var a []*node
+	ns := make(map[string]*node)
+	for _, d := range []struct {
+		network string
+		domain  string
+		role    int
+	}{
+		{"a", u.Host, roleAccess},
+		{"a", "a-storage.com", roleStorage},
+		{"b", "b-storage.com", roleStorage}} {
+		n, err := newNode(
+			d.network,
+			d.domain,
+			time.Now().UTC(),
+			time.Now().UTC().Add(-time.Minute),
+			time.Now().UTC().AddDate(1, 0, 0),
+			d.role,
+			"",
+			"")
+		if err != nil {
+			return nil, nil, "", h, err
+		}
+		x, err := newSecret()
+		if err != nil {
+			return nil, nil, "", h, err
+		}
+		n.addSecret(x)
+		a = append(a, n)
+		ns[d.domain] = n
+	}
+	v := newVolatile("test", false, a)
+	c := newConfigurationTest()
+	c.Debug = false
+	c.Scheme = "http"
+	c.NodeCount = 1
+	c.StorageOperationTimeout = 60
+	c.HomeNodeTimeout = 60
+	s = NewServices(c, NewStorageService(c, v), NewAccessSimple(nil), nil)
+	return s, ns, u.Host, h, nil
+}
+
+// testJourney creates an operation at the access node a with the parameters q
+// and follows it with the cookies in the jar j until the browser returns to
+// the return URL. Returns the results decrypted with the access node.
+func testJourney(
+	s *Services,
+	a string,
+	q url.Values,
+	j map[string][]*http.Cookie) (*Results, error) {
+	n, err := Create(s, a, q)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < 10 && strings.HasPrefix(n, "http://return.com/") == false; i++ {
+		n, err = testJourneyStep(s, n, j)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if strings.HasPrefix(n, "http://return.com/") == false {
+		return nil, fmt.Errorf("Journey did not return, last URL '%s'", n)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(
+		strings.TrimPrefix(n, "http://return.com/"))
+	if err != nil {
+		return nil, err
+	}
+	return s.store.getNode(a).DecodeAsResults(b)
+}
+
+// testJourneyCookie returns the cookies node n would write for the key k and
+// value v.
+func testJourneyCookie(s *Services, n *node, k string, v string) []*http.Cookie {
+	o := newOperation(s, n)
+	o.request = httptest.NewRequest("GET", "http://"+n.domain+"/", nil)
+	o.table = "t"
+	var p pair
+	p.key = k
+	p.created = time.Now().UTC()
+	p.expires = time.Now().UTC().AddDate(0, 0, 1)
+	p.values = [][]byte{[]byte(v)}
+	p.conflict = conflictNewest
+	w := httptest.NewRecorder()
+	o.setValueInCookie(w, o.request, &p)
+	return w.Result().Cookies()
+}
+
+// testJourneyStep requests the URL n from the store handler with the cookies
+// from the jar j for the host and returns the next URL.
+func testJourneyStep(
+	s *Services,
+	n string,
+	j map[string][]*http.Cookie) (string, error) {
+	r := httptest.NewRequest("GET", n, nil)
+	for _, c := range j[r.Host] {
+		r.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	HandlerStore(s, nil)(w, r)
+	j[r.Host] = append(j[r.Host], w.Result().Cookies()...)
+	g, err := gzip.NewReader(w.Result().Body)
+	if err != nil {
+		return "", err
+	}
+	b, err := ioutil.ReadAll(g)
+	if err != nil {
+		return "", err
+	}
+	m := testNextURLRegex.FindStringSubmatch(string(b))
+	if m == nil {
+		return "", fmt.Errorf("No next URL in response from '%s'", n)
+	}
+	return html.UnescapeString(m[1]), nil
+}
@@ -0,0 +1,66 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// The header used for all JWTs. Only HMAC SHA-256 signing is supported.
+var jwtHeader = base64.RawURLEncoding.EncodeToString(
+	[]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// AsJWT returns the results as a JWT signed using HMAC SHA-256 and the key k.
+// Each pair becomes a claim keyed on the pair key with the value from
+// Pair.Value. The expiry of the results becomes the exp claim. Returns an error
+// if the results have expired or a pair key collides with a registered claim.
+func (r *Results) AsJWT(k []byte) (string, error) {
+	if len(k) == 0 {
+		return "", fmt.Errorf("JWT signing key must be provided")
+	}
+	if r.IsTimeStampValid() == false {
+		return "", fmt.Errorf("data expired and can no longer be used")
+	}
+	c := make(map[string]interface{})
+	for _, p := range r.pairs {
+		if p.key == "exp" || p.key == "iat" {
+			return "", fmt.Errorf(
+				"Key '%s' is a reserved JWT claim", p.key)
+		}
+		c[p.key] = p.Value()
+	}
+	c["exp"] = r.expires.Unix()
+	c["iat"] = time.Now().UTC().Unix()
+	j, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	s := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(j)
+	return s + "." + base64.RawURLEncoding.EncodeToString(signJWT(k, s)), nil
+}
+
+// signJWT returns the HMAC SHA-256 signature of the header and payload s.
+func signJWT(k []byte, s string) []byte {
+	h := hmac.New(sha256.New, k)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
@@ -0,0 +1,104 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJWTVerifies(t *testing.T) {
+	r := newResultsTest(time.Now().UTC().Add(time.Minute))
+	j, err := r.AsJWT([]byte("key"))
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if testVerifyJWT(j, []byte("key")) == false {
+		fmt.Println("JWT did not verify with the signing key")
+		t.Fail()
+	}
+	if testVerifyJWT(j, []byte("other")) {
+		fmt.Println("JWT verified with a different key")
+		t.Fail()
+	}
+}
+
+func TestJWTClaims(t *testing.T) {
+	r := newResultsTest(time.Now().UTC().Add(time.Minute))
+	j, err := r.AsJWT([]byte("key"))
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.Split(j, ".")[1])
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	var c map[string]interface{}
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	for _, p := range r.pairs {
+		if c[p.key] != p.Value() {
+			fmt.Printf("Claim '%s' is '%v' not '%s'\n",
+				p.key,
+				c[p.key],
+				p.Value())
+			t.Fail()
+		}
+	}
+	if int64(c["exp"].(float64)) != r.expires.Unix() {
+		fmt.Printf("exp '%v' does not match expiry '%d'\n",
+			c["exp"],
+			r.expires.Unix())
+		t.Fail()
+	}
+}
+
+func TestJWTExpired(t *testing.T) {
+	r := newResultsTest(time.Now().UTC().Add(-time.Minute))
+	_, err := r.AsJWT([]byte("key"))
+	if err == nil {
+		fmt.Println("JWT created from expired results")
+		t.Fail()
+	}
+}
+
+func testVerifyJWT(j string, k []byte) bool {
+	p := strings.Split(j, ".")
+	if len(p) != 3 {
+		return false
+	}
+	s, err := base64.RawURLEncoding.DecodeString(p[2])
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(s, signJWT(k, p[0]+"."+p[1]))
+}
@@ -17,7 +17,9 @@
 package swift
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
@@ -31,17 +33,68 @@ type Local struct {
 	name      string    // The name of the store.
 	timestamp time.Time // The last time the maps were refreshed
 	nodesFile string    // Reference to the node table
+	master    *crypto   // Encrypts secrets in the file, or nil for plaintext
+	readOnly  bool      // True if the nodes file can not be changed
+	data      []byte    // Nodes JSON provided in memory rather than a file
 	common
 }
 
 // NewLocalStore creates a new instance of Local and configures the path for
 // the persistent JSON file.
 func NewLocalStore(nodesFile string) (*Local, error) {
+	return NewLocalStoreWithKey(nodesFile, "")
+}
+
+// NewLocalStoreReadOnly creates a new instance of Local for the persistent JSON
+// file which can not be changed. Used to seed the nodes alongside a writeable
+// store.
+func NewLocalStoreReadOnly(nodesFile string) (*Local, error) {
+	l, err := NewLocalStore(nodesFile)
+	if err != nil {
+		return nil, err
+	}
+	l.readOnly = true
+	return l, nil
+}
+
+// NewLocalStoreWithKey creates a new instance of Local and configures the path
+// for the persistent JSON file. If the master key is provided then the secrets
+// of the nodes are encrypted with it in the JSON file. The master key is a
+// base 64 URL encoded AES key of 16, 24 or 32 bytes.
+func NewLocalStoreWithKey(nodesFile string, masterKey string) (*Local, error) {
 	var l Local
 
 	l.name = "Local Storage"
 	l.nodesFile = nodesFile
 
+	if masterKey != "" {
+		k, err := base64.RawURLEncoding.DecodeString(masterKey)
+		if err != nil {
+			return nil, err
+		}
+		l.master, err = newCrypto(k)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	l.mutex = &sync.Mutex{}
+	err := l.refresh()
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// NewLocalStoreFromBytes creates a new read only instance of Local from nodes
+// JSON held in memory in the same format as the persistent JSON file. Used
+// where the nodes are provided via an environment variable rather than a file.
+// The secrets in the JSON must not be encrypted.
+func NewLocalStoreFromBytes(name string, data []byte) (*Local, error) {
+	var l Local
+	l.name = name
+	l.data = data
+	l.readOnly = true
 	l.mutex = &sync.Mutex{}
 	err := l.refresh()
 	if err != nil {
@@ -54,41 +107,29 @@ func (l *Local) getName() string {
 	return l.name
 }
 
+// ping confirms the nodes file exists. Always succeeds if the nodes are held in
+// memory.
+func (l *Local) ping() error {
+	if l.data != nil {
+		return nil
+	}
+	_, err := os.Stat(l.nodesFile)
+	return err
+}
+
 func (l *Local) getReadOnly() bool {
-	return false
+	return l.readOnly
 }
 
 // GetNode takes a domain name and returns the associated node. If a node
 // does not exist then nil is returned.
 func (l *Local) getNode(domain string) (*node, error) {
-	n, err := l.common.getNode(domain)
-	if err != nil {
-		return nil, err
-	}
-	if n == nil {
-		err = l.refresh()
-		if err != nil {
-			return nil, err
-		}
-		n, err = l.common.getNode(domain)
-	}
-	return n, err
+	return l.common.getNodeOrRefresh(domain, l.refresh)
 }
 
 // GetNodes returns all the nodes associated with a network.
 func (l *Local) getNodes(network string) (*nodes, error) {
-	ns, err := l.common.getNodes(network)
-	if err != nil {
-		return nil, err
-	}
-	if ns == nil {
-		err = l.refresh()
-		if err != nil {
-			return nil, err
-		}
-		ns, err = l.getNodes(network)
-	}
-	return ns, err
+	return l.common.getNodesOrRefresh(network, l.refresh)
 }
 
 // getAllNodes refreshes internal data and returns all nodes.
@@ -115,6 +156,9 @@ func (l *Local) iterateNodes(
 
 // SetNode inserts or updates the node.
 func (l *Local) setNode(n *node) error {
+	if l.readOnly {
+		return fmt.Errorf("store '%s' is read only", l.name)
+	}
 	nis := make(map[string]*node)
 
 	// Fetch all the records from the nodes file.
@@ -122,6 +166,10 @@ func (l *Local) setNode(n *node) error {
 	if err != nil {
 		return err
 	}
+	data, _, err = l.openSecrets(data)
+	if err != nil {
+		return err
+	}
 
 	err = json.Unmarshal(data, &nis)
 	if err != nil && len(data) > 0 {
@@ -134,6 +182,10 @@ func (l *Local) setNode(n *node) error {
 	if err != nil {
 		return err
 	}
+	data, err = l.sealSecrets(data)
+	if err != nil {
+		return err
+	}
 
 	err = ioutil.WriteFile(l.nodesFile, data, 0644)
 	if err != nil {
@@ -143,38 +195,60 @@ func (l *Local) setNode(n *node) error {
 	return nil
 }
 
-func (l *Local) refresh() error {
-	nets := make(map[string]*nodes)
+// deleteNode removes the node from the nodes file and refreshes the maps.
+func (l *Local) deleteNode(domain string) error {
+	if l.readOnly {
+		return fmt.Errorf("store '%s' is read only", l.name)
+	}
+	nis := make(map[string]*node)
 
-	// Fetch the nodes and then add the secrets.
-	ns, err := l.fetchNodes()
+	// Fetch all the records from the nodes file.
+	data, err := ioutil.ReadFile(l.nodesFile)
+	if err != nil {
+		return err
+	}
+	data, _, err = l.openSecrets(data)
 	if err != nil {
 		return err
 	}
 
-	// Create a map of networks from the nodes found.
-	for _, v := range ns {
-		net := nets[v.network]
-		if net == nil {
-			net = &nodes{}
-			net.dict = make(map[string]*node)
-			nets[v.network] = net
-		}
-		net.all = append(net.all, v)
-		net.dict[v.domain] = v
+	err = json.Unmarshal(data, &nis)
+	if err != nil && len(data) > 0 {
+		return err
+	}
+
+	if nis[domain] == nil {
+		return fmt.Errorf("node '%s' not found", domain)
+	}
+	delete(nis, domain)
+
+	data, err = json.MarshalIndent(&nis, "", "\t")
+	if err != nil {
+		return err
+	}
+	data, err = l.sealSecrets(data)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(l.nodesFile, data, 0644)
+	if err != nil {
+		return err
 	}
 
-	// Finally sort the nodes by hash values and whether they are active.
-	for _, net := range nets {
-		net.order()
+	return l.refresh()
+}
+
+func (l *Local) refresh() error {
+	// Fetch the nodes and then add the secrets.
+	ns, err := l.fetchNodes()
+	if err != nil {
+		return err
 	}
 
-	// In a single atomic operation update the reference to the networks and
-	// nodes.
-	l.mutex.Lock()
-	l.nodes = ns
-	l.networks = nets
-	l.mutex.Unlock()
+	// Build the networks and replace the nodes and networks in a single
+	// operation.
+	l.replaceNodes(ns)
 
 	return nil
 }
@@ -183,12 +257,32 @@ func (l *Local) fetchNodes() (map[string]*node, error) {
 	var err error
 	ns := make(map[string]*node)
 
-	// Fetch all the records from the nodes file.
-	data, err := readLocalStore(l.nodesFile)
+	// Fetch all the records from memory or the nodes file.
+	data := l.data
+	if data == nil {
+		data, err = readLocalStore(l.nodesFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	data, plain, err := l.openSecrets(data)
 	if err != nil {
 		return nil, err
 	}
 
+	// Encrypt the secrets of a nodes file written before the master key was
+	// configured so that they are not left in plaintext.
+	if plain && l.data == nil && l.readOnly == false {
+		s, err := l.sealSecrets(data)
+		if err != nil {
+			return nil, err
+		}
+		err = writeLocalStore(l.nodesFile, s)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	err = json.Unmarshal(data, &ns)
 	if err != nil && len(data) > 0 {
 		return nil, err
@@ -199,6 +293,80 @@ func (l *Local) fetchNodes() (map[string]*node, error) {
 	return ns, err
 }
 
+// sealSecrets encrypts the key of every secret in the nodes JSON data with the
+// master key. If there is no master key then the data is returned unchanged.
+func (l *Local) sealSecrets(data []byte) ([]byte, error) {
+	return l.mapSecrets(data, func(k string) (string, error) {
+		b, err := l.master.encrypt([]byte(k))
+		if err != nil {
+			return "", err
+		}
+		return base64.RawURLEncoding.EncodeToString(b), nil
+	})
+}
+
+// openSecrets decrypts the key of every secret in the nodes JSON data with the
+// master key. If there is no master key then the data is returned unchanged.
+// Keys that are already valid plaintext secret keys, for example in a file
+// written before the master key was configured, are returned unchanged and
+// the returned flag is true.
+func (l *Local) openSecrets(data []byte) ([]byte, bool, error) {
+	plain := false
+	data, err := l.mapSecrets(data, func(k string) (string, error) {
+		if _, err := newSecretFromKey(k, time.Time{}); err == nil {
+			plain = true
+			return k, nil
+		}
+		b, err := base64.RawURLEncoding.DecodeString(k)
+		if err != nil {
+			return "", err
+		}
+		b, err = l.master.decrypt(b)
+		if err != nil {
+			return "", fmt.Errorf("secret can not be decrypted: %s", err)
+		}
+		return string(b), nil
+	})
+	return data, plain, err
+}
+
+// mapSecrets replaces the key of every secret in the nodes JSON data with the
+// result of the function f. If there is no master key then the data is
+// returned unchanged.
+func (l *Local) mapSecrets(
+	data []byte,
+	f func(k string) (string, error)) ([]byte, error) {
+	if l.master == nil || len(data) == 0 {
+		return data, nil
+	}
+	var nis map[string]map[string]interface{}
+	err := json.Unmarshal(data, &nis)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range nis {
+		secrets, ok := n["secrets"].([]interface{})
+		if !ok {
+			continue
+		}
+		for _, i := range secrets {
+			s, ok := i.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			k, ok := s["key"].(string)
+			if !ok {
+				continue
+			}
+			s["key"], err = f(k)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return json.MarshalIndent(&nis, "", "\t")
+}
+
 // readLocalStore reads the contents of a file and returns the binary data.
 func readLocalStore(file string) ([]byte, error) {
 	err := createLocalStore(file)
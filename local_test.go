@@ -0,0 +1,445 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalSecretsPlaintext(t *testing.T) {
+	testLocalSecrets(t, "", true)
+}
+
+func TestLocalSecretsEncrypted(t *testing.T) {
+	k, err := randomBytes(32)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	testLocalSecrets(t, base64.RawURLEncoding.EncodeToString(k), false)
+}
+
+func TestLocalSecretsWrongKey(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "nodes.json")
+	k, err := randomBytes(32)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	l, err := NewLocalStoreWithKey(f, base64.RawURLEncoding.EncodeToString(k))
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	n, err := newStoreQueueTestNode("local.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	err = l.setNode(n)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	k, err = randomBytes(32)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	_, err = NewLocalStoreWithKey(f, base64.RawURLEncoding.EncodeToString(k))
+	if err == nil {
+		fmt.Println("Secrets decrypted with the wrong master key")
+		t.Fail()
+	}
+}
+
+func TestLocalSecretsUpgrade(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "nodes.json")
+	l, err := NewLocalStore(f)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	n, err := newStoreQueueTestNode("local.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	err = l.setNode(n)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	k, err := randomBytes(32)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+
+	// The plaintext file is loaded with the master key and re-encrypted.
+	l, err = NewLocalStoreWithKey(f, base64.RawURLEncoding.EncodeToString(k))
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	r, err := l.getNode("local.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if r == nil || r.secrets[0].key != n.secrets[0].key {
+		fmt.Println("Plaintext secret not loaded with master key")
+		t.Fail()
+		return
+	}
+	b, err := ioutil.ReadFile(f)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if strings.Contains(string(b), n.secrets[0].key) {
+		fmt.Println("Plaintext secret not encrypted on first load")
+		t.Fail()
+	}
+}
+
+// testLocalSecrets writes a node to a local store with the master key m, reads
+// it back with a new instance and checks the secret survives the round trip.
+// p is true if the secret is expected in plaintext in the file.
+func testLocalSecrets(t *testing.T, m string, p bool) {
+	f := filepath.Join(t.TempDir(), "nodes.json")
+	l, err := NewLocalStoreWithKey(f, m)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	n, err := newStoreQueueTestNode("local.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	err = l.setNode(n)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	b, err := ioutil.ReadFile(f)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if strings.Contains(string(b), n.secrets[0].key) != p {
+		fmt.Printf("Secret in plaintext should be '%t'\n", p)
+		t.Fail()
+	}
+	l, err = NewLocalStoreWithKey(f, m)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	r, err := l.getNode("local.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if r == nil || len(r.secrets) != 1 {
+		fmt.Println("Node not read from local store")
+		t.Fail()
+		return
+	}
+	if r.secrets[0].key != n.secrets[0].key {
+		fmt.Println("Secret changed after round trip")
+		t.Fail()
+	}
+}
+
+func TestLocalReadOnly(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "nodes.json")
+	l, err := NewLocalStore(f)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	n, err := newStoreQueueTestNode("local.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	err = l.setNode(n)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	r, err := NewLocalStoreReadOnly(f)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if r.getReadOnly() == false {
+		fmt.Println("Local store not read only")
+		t.Fail()
+	}
+	x, err := r.getNode("local.com")
+	if err != nil || x == nil {
+		fmt.Println("Node not read from read only local store")
+		t.Fail()
+	}
+	if r.setNode(n) == nil || r.deleteNode("local.com") == nil {
+		fmt.Println("Read only local store changed")
+		t.Fail()
+	}
+
+	// The storage manager writes to the writeable store.
+	c := newConfigurationTest()
+	v := newVolatile("volatile", false, nil)
+	s := NewStorageService(c, r, v)
+	w, err := newStoreQueueTestNode("new.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	err = s.setNodes("", w)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if v.nodes["new.com"] == nil {
+		fmt.Println("Node not written to writeable store")
+		t.Fail()
+	}
+}
+
+func TestLocalPing(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "nodes.json")
+	l, err := NewLocalStore(f)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	n, err := newStoreQueueTestNode("local.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	err = l.setNode(n)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if l.ping() != nil {
+		fmt.Println("Ping failed for existing nodes file")
+		t.Fail()
+	}
+	err = os.Remove(f)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if l.ping() == nil {
+		fmt.Println("Ping succeeded for missing nodes file")
+		t.Fail()
+	}
+
+	// The unreachable store is skipped when there are other stores.
+	c := newConfigurationTest()
+	v, err := newVolatileTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	sm, err := newStorageManager(c, nil, l, v)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if len(sm.stores) != 1 || sm.stores[0] != v {
+		fmt.Println("Unreachable store not skipped")
+		t.Fail()
+	}
+	if sm.getNode("local.com") != nil {
+		fmt.Println("Node from unreachable store added")
+		t.Fail()
+	}
+
+	// A single store is always used.
+	sm, err = newStorageManager(c, nil, l)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if len(sm.stores) != 1 {
+		fmt.Println("Single store skipped")
+		t.Fail()
+	}
+}
+
+func TestLocalFromBytes(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "nodes.json")
+	l, err := NewLocalStore(f)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	n, err := newStoreQueueTestNode("local.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	err = l.setNode(n)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	b, err := ioutil.ReadFile(f)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	r, err := NewLocalStoreFromBytes("bytes", b)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if r.getName() != "bytes" || r.getReadOnly() == false {
+		fmt.Println("Local store from bytes not named or not read only")
+		t.Fail()
+	}
+	x, err := r.getNode("local.com")
+	if err != nil || x == nil {
+		fmt.Println("Node not read from bytes")
+		t.Fail()
+		return
+	}
+	if x.secrets[0].key != n.secrets[0].key {
+		fmt.Println("Secret changed after reading from bytes")
+		t.Fail()
+	}
+	if r.setNode(n) == nil {
+		fmt.Println("Local store from bytes changed")
+		t.Fail()
+	}
+	if r.ping() != nil {
+		fmt.Println("Ping failed for local store from bytes")
+		t.Fail()
+	}
+	_, err = NewLocalStoreFromBytes("bytes", []byte("not json"))
+	if err == nil {
+		fmt.Println("Invalid JSON bytes accepted")
+		t.Fail()
+	}
+}
+
+func TestLocalRefreshOnMiss(t *testing.T) {
+	for _, sync := range []bool{false, true} {
+		f := filepath.Join(t.TempDir(), "nodes.json")
+		r, err := NewStoreFromConfig(StoreConfig{
+			Kind:        StoreKindLocal,
+			File:        f,
+			SyncRefresh: sync})
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+
+		// Add a node to the file via a different instance so that the first
+		// store only finds it after a refresh.
+		w, err := NewLocalStore(f)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		n, err := newStoreQueueTestNode("miss.com")
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		err = w.setNode(n)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+
+		m, err := r.getNode("miss.com")
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		if sync && m == nil {
+			fmt.Println("Synchronous refresh did not find node")
+			t.Fail()
+		}
+		if sync == false && m != nil {
+			fmt.Println("Asynchronous refresh found node immediately")
+			t.Fail()
+		}
+		if testStoreQueueWait(func() bool {
+			m, _ := r.(*Local).common.getNode("miss.com")
+			return m != nil
+		}) == false {
+			fmt.Println("Node not found after refresh")
+			t.Fail()
+		}
+	}
+}
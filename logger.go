@@ -0,0 +1,46 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import "log"
+
+// Logger is used to record debug and error messages. Implement the interface to
+// route messages to a structured logger. The default implementation uses the
+// standard log package.
+type Logger interface {
+
+	// Debugf records a message that is only needed when debugging. Only called
+	// when the configuration debug flag is true.
+	Debugf(format string, v ...interface{})
+
+	// Errorf records an error that did not prevent the request being handled.
+	Errorf(format string, v ...interface{})
+}
+
+// stdLogger is the default Logger which uses the standard log package.
+type stdLogger struct{}
+
+func (l *stdLogger) Debugf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
+
+func (l *stdLogger) Errorf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
+
+// defaultLogger is used when no logger is set in the configuration.
+var defaultLogger Logger = &stdLogger{}
@@ -0,0 +1,123 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testLogger records the messages logged at each level.
+type testLogger struct {
+	debug []string
+	error []string
+}
+
+func (l *testLogger) Debugf(format string, v ...interface{}) {
+	l.debug = append(l.debug, fmt.Sprintf(format, v...))
+}
+
+func (l *testLogger) Errorf(format string, v ...interface{}) {
+	l.error = append(l.error, fmt.Sprintf(format, v...))
+}
+
+func TestLoggerDebug(t *testing.T) {
+	var l testLogger
+	c := newConfigurationTest()
+	c.Logger = &l
+
+	// Debug messages are only recorded if debug is enabled.
+	c.Debug = false
+	c.debugf("hidden")
+	c.errorf("error")
+	c.Debug = true
+	c.debugf("shown")
+	if len(l.debug) != 1 || l.debug[0] != "shown" {
+		fmt.Printf("Debug messages '%v' not 'shown'\n", l.debug)
+		t.Fail()
+	}
+	if len(l.error) != 1 || l.error[0] != "error" {
+		fmt.Printf("Error messages '%v' not 'error'\n", l.error)
+		t.Fail()
+	}
+}
+
+func TestLoggerDefault(t *testing.T) {
+	c := newConfigurationTest()
+	if c.getLogger() != defaultLogger {
+		fmt.Println("Default logger not used when none is configured")
+		t.Fail()
+	}
+}
+
+func TestLoggerStorageManager(t *testing.T) {
+	var l testLogger
+	c := newConfigurationTest()
+	c.Logger = &l
+
+	// A store that can not be reached is reported to the logger.
+	f := filepath.Join(t.TempDir(), "missing.json")
+	m, err := NewLocalStore(f)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	os.Remove(f)
+	_, err = newStorageManager(c, nil, m, newVolatile("test", false, nil))
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if len(l.error) == 0 {
+		fmt.Println("Store ping failure not logged")
+		t.Fail()
+	}
+}
+
+func TestLoggerRetry(t *testing.T) {
+	var l testLogger
+	c := newConfigurationTest()
+	c.Logger = &l
+	c.Debug = true
+	retry(&c, 2, 0, func() error { return fmt.Errorf("transient") })
+	if len(l.debug) != 1 {
+		fmt.Printf("Retry messages '%v' not logged\n", l.debug)
+		t.Fail()
+	}
+}
+
+func TestLoggerStore(t *testing.T) {
+	var l testLogger
+	c := newConfigurationTest()
+	c.Logger = &l
+	v := newVolatile("test", false, nil)
+	_, err := newStorageManager(c, nil, v)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	v.getConfig().errorf("error")
+	if len(l.error) != 1 {
+		fmt.Println("Store not given the configured logger")
+		t.Fail()
+	}
+}
@@ -0,0 +1,92 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"html/template"
+	"strings"
+)
+
+// Identifiers for the messages displayed in the user interface.
+const (
+	messageBadRequest         = "badRequest"
+	messageInvalidRequest     = "invalidRequest"
+	messageDisableTracking    = "disableTracking"
+	messageCookiesRequired    = "cookiesRequired"
+	messageEnableCookies      = "enableCookies"
+	messageMayDisableTracking = "mayDisableTracking"
+	messageTryAgain           = "tryAgain"
+)
+
+// defaultLanguage is used if there is no message for the requested language.
+const defaultLanguage = "en"
+
+// defaultMessages are used if the configuration does not provide a message for
+// the language and identifier.
+var defaultMessages = map[string]map[string]string{
+	defaultLanguage: {
+		messageBadRequest:     "Bad Request",
+		messageInvalidRequest: "Invalid request.",
+		messageDisableTracking: "Use the settings option in your web " +
+			"browser to disable tracking prevention.",
+		messageCookiesRequired: "Cookies are required.",
+		messageEnableCookies: "Use the settings option in your web browser " +
+			"to enable cookies.",
+		messageMayDisableTracking: "You may need to disable tracking " +
+			"prevention.",
+		messageTryAgain: "Try Again"}}
+
+// templateFuncs are the functions available to the HTML templates.
+var templateFuncs = template.FuncMap{
+	"text": func(o *operation, id string) string {
+		return o.getText(id)
+	}}
+
+// getText returns the message for the identifier in the language of the
+// request falling back to English.
+func (o *operation) getText(id string) string {
+	var m map[string]map[string]string
+	if o.services != nil {
+		m = o.services.config.Messages
+	}
+	return getMessage(m, o.Language(), id)
+}
+
+// getMessage returns the message for the identifier id and language l from the
+// messages m, or the default messages if m does not contain it. The language
+// is tried in full, then without any region, and finally English is used. If
+// no message is found the identifier is returned. The lower case identifier is
+// also tried as the configuration loader converts keys to lower case.
+func getMessage(m map[string]map[string]string, l string, id string) string {
+	l = strings.ToLower(strings.TrimSpace(l))
+	ls := []string{l}
+	if i := strings.Index(l, "-"); i > 0 {
+		ls = append(ls, l[:i])
+	}
+	ls = append(ls, defaultLanguage)
+	for _, v := range ls {
+		for _, c := range []map[string]map[string]string{m, defaultMessages} {
+			if t, ok := c[v][id]; ok {
+				return t
+			}
+			if t, ok := c[v][strings.ToLower(id)]; ok {
+				return t
+			}
+		}
+	}
+	return id
+}
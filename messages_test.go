@@ -0,0 +1,80 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGetMessage(t *testing.T) {
+	m := map[string]map[string]string{
+		"fr":    {messageTryAgain: "Réessayer"},
+		"de":    {strings.ToLower(messageTryAgain): "Erneut versuchen"},
+		"en-gb": {messageTryAgain: "Try again"}}
+	for _, d := range []struct {
+		language string
+		expected string
+	}{
+		{"fr", "Réessayer"},
+		{"fr-CA", "Réessayer"},
+		{"de", "Erneut versuchen"},
+		{"en-GB", "Try again"},
+		{"en-US", "Try Again"},
+		{"es", "Try Again"},
+		{"", "Try Again"}} {
+		v := getMessage(m, d.language, messageTryAgain)
+		if v != d.expected {
+			fmt.Printf("Language '%s' expected '%s' got '%s'\n",
+				d.language,
+				d.expected,
+				v)
+			t.Fail()
+		}
+	}
+	if getMessage(nil, "en", "missing") != "missing" {
+		fmt.Println("Missing message did not return identifier")
+		t.Fail()
+	}
+}
+
+func TestMalformedLocalized(t *testing.T) {
+	c := newConfigurationTest()
+	c.Messages = map[string]map[string]string{
+		"fr": {messageInvalidRequest: "Requête invalide."}}
+	s := NewServices(c, nil, nil, nil)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://access.com/", nil)
+	r.Header.Set("Accept-Language", "fr-FR,fr;q=0.9")
+	storeMalformed(s, w, r)
+	b, err := testGzipBody(w)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if strings.Contains(string(b), "Requête invalide.") == false {
+		fmt.Println("Localized message missing")
+		t.Fail()
+	}
+	if strings.Contains(string(b), "Try Again") == false {
+		fmt.Println("English fall back missing")
+		t.Fail()
+	}
+}
@@ -18,6 +18,7 @@ package swift
 
 import (
 	"bytes"
+	"crypto/hmac"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -25,10 +26,32 @@ import (
 	"hash/fnv"
 	"math/rand"
 	"net/http"
+	"regexp"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
+// networkRegex is the valid form of a normalized network name.
+var networkRegex = regexp.MustCompile("^[a-z0-9-]{3,20}$")
+
+// normalizeNetwork returns the network name without surrounding white space
+// and in lower case so that network names can be compared for equality.
+func normalizeNetwork(network string) string {
+	return strings.ToLower(strings.TrimSpace(network))
+}
+
+// validateNetwork returns an error if the normalized network name is not
+// between 3 and 20 letters, numbers or hyphens.
+func validateNetwork(network string) error {
+	if networkRegex.MatchString(network) == false {
+		return fmt.Errorf(
+			"Network must be 3 to 20 letters, numbers or hyphens")
+	}
+	return nil
+}
+
 // Table used to initialize hash functions.
 var nodeHashTable = crc64.MakeTable(crc64.ECMA)
 
@@ -38,21 +61,70 @@ const (
 	roleShare   = iota // The node responds to share requests
 )
 
+const (
+	defaultNodeWeight = 1   // The weight of a node if none is provided
+	maxNodeWeight     = 100 // The largest weight that a node can have
+)
+
 // node is a SWIFT storage node associated with a network and a domain name.
 type node struct {
-	network      string    // The name of the network the node belongs to
-	domain       string    // The domain name associated with the node
-	hash         uint64    // Number used to relate client IPs to node
-	created      time.Time // The time that the node first came online
-	starts       time.Time // The time that the node will begin operation
-	expires      time.Time // The time that the node will retire from the network
-	role         int       // The role the node has in the network
-	secrets      []*secret // All the secrets associated with the node
-	scrambler    *secret   // Secret used to scramble data with fixed nonce
-	nonce        []byte    // Fixed nonce used with the scrambler
-	accessed     time.Time // The time the node was last accessed
-	alive        bool      // True if the node is reachable via a HTTP request
-	cookieDomain string    // The domain to use for cookies
+	network      string       // The name of the network the node belongs to
+	domain       string       // The domain name associated with the node
+	hash         uint64       // Number used to relate client IPs to node
+	created      time.Time    // The time that the node first came online
+	starts       time.Time    // The time that the node will begin operation
+	expires      time.Time    // The time that the node will retire from the network
+	role         int          // The role the node has in the network
+	secrets      []*secret    // All the secrets associated with the node
+	scrambler    *secret      // Secret used to scramble data with fixed nonce
+	nonce        []byte       // Fixed nonce used with the scrambler
+	previous     *secret      // Scrambler replaced by the last rotation or nil
+	accessed     time.Time    // The time the node was last accessed
+	alive        bool         // True if the node is reachable via a HTTP request
+	cookieDomain string       // The domain to use for cookies
+	compressor   Compressor   // Used by encode and decode, nil for the default
+	weight       int          // Relative capacity of the node for home nodes
+	mutex        sync.RWMutex // Guards accessed, alive and compressor
+}
+
+// SetAlive records if the node is reachable via a HTTP request. Safe to call
+// from the alive service whilst handlers read the value.
+func (n *node) SetAlive(v bool) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.alive = v
+}
+
+// IsAlive returns true if the node is reachable via a HTTP request.
+func (n *node) IsAlive() bool {
+	n.mutex.RLock()
+	defer n.mutex.RUnlock()
+	return n.alive
+}
+
+// SetAccessed records the time the node was last accessed.
+func (n *node) SetAccessed(t time.Time) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.accessed = t
+}
+
+// Accessed returns the time the node was last accessed.
+func (n *node) Accessed() time.Time {
+	n.mutex.RLock()
+	defer n.mutex.RUnlock()
+	return n.accessed
+}
+
+// setWeight sets the relative capacity of the node used when selecting home
+// nodes. Values outside the range 1 to maxNodeWeight are limited to the range.
+func (n *node) setWeight(w int) {
+	if w < defaultNodeWeight {
+		w = defaultNodeWeight
+	} else if w > maxNodeWeight {
+		w = maxNodeWeight
+	}
+	n.weight = w
 }
 
 // Domain returns the internet domain associated with the Node.
@@ -74,6 +146,71 @@ func (n *node) getScramblerKey() string {
 	return ""
 }
 
+// getPreviousScramblerKey returns the key of the scrambler that was replaced
+// when the scrambler was last rotated, or an empty string if the scrambler has
+// not been rotated.
+func (n *node) getPreviousScramblerKey() string {
+	if n.previous != nil {
+		return n.previous.key
+	}
+	return ""
+}
+
+// setPreviousScramblerKey sets the scrambler that was replaced when the
+// scrambler was last rotated. An empty key means there is no previous
+// scrambler.
+func (n *node) setPreviousScramblerKey(k string) error {
+	p, err := makeScrambler(n.created, k)
+	if err != nil {
+		return err
+	}
+	n.previous = p
+	return nil
+}
+
+// RotateScrambler replaces the scrambler with a new random secret. The current
+// scrambler is retained so that values scrambled before the rotation, such as
+// the table in the path of an in flight storage operation, can be unscrambled
+// until the next rotation. The node must be written to the store with setNode
+// for other instances to use the new scrambler.
+func (n *node) RotateScrambler() error {
+	if n.domain == "" {
+		return fmt.Errorf("domain required to scramble")
+	}
+	s, err := newSecret()
+	if err != nil {
+		return err
+	}
+	p, o := n.scrambler, n.nonce
+	n.scrambler = s
+	n.nonce = makeNonce(s, []byte(n.domain))
+	err = n.checkScrambler()
+	if err != nil {
+		n.scrambler, n.nonce = p, o
+		return err
+	}
+	n.previous = p
+	return nil
+}
+
+// getCompressor returns the compressor to use with encode and decode.
+func (n *node) getCompressor() Compressor {
+	n.mutex.RLock()
+	defer n.mutex.RUnlock()
+	if n.compressor != nil {
+		return n.compressor
+	}
+	return defaultCompressor
+}
+
+// setCompressor sets the compressor used with encode and decode. Safe to call
+// whilst handlers are using the node.
+func (n *node) setCompressor(c Compressor) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.compressor = c
+}
+
 // supportsCrypto returns true if the node can encrypt and decrypt data.
 func (n *node) supportsCrypto() bool { return len(n.secrets) > 0 }
 
@@ -90,8 +227,11 @@ func newNode(
 	if err != nil {
 		return nil, err
 	}
+	if scrambler != nil && domain == "" {
+		return nil, fmt.Errorf("domain required to scramble")
+	}
 	n := node{
-		network:      network,
+		network:      normalizeNetwork(network),
 		domain:       domain,
 		hash:         getHash(domain),
 		created:      created,
@@ -103,10 +243,48 @@ func newNode(
 		nonce:        makeNonce(scrambler, []byte(domain)),
 		accessed:     time.Time{},
 		alive:        false,
-		cookieDomain: cookieDomain}
+		cookieDomain: cookieDomain,
+		weight:       defaultNodeWeight}
+	err = n.checkScrambler()
+	if err != nil {
+		return nil, err
+	}
 	return &n, nil
 }
 
+// scrambleCheck is the known value used to verify that a node's scrambler can
+// reverse the values it scrambles.
+const scrambleCheck = "swift-scramble-check"
+
+// checkScrambler verifies that a value scrambled by the node can be
+// unscrambled to the original value. Used to catch a bad scramble key when the
+// node is created rather than when a storage operation fails.
+func (n *node) checkScrambler() error {
+	if n.scrambler == nil {
+		return nil
+	}
+	if len(n.nonce) != n.scrambler.crypto.aead.NonceSize() {
+		return fmt.Errorf(
+			"node '%s' scramble nonce length '%d' invalid",
+			n.domain,
+			len(n.nonce))
+	}
+	v, err := n.unscramble(n.scramble(scrambleCheck))
+	if err != nil {
+		return fmt.Errorf(
+			"node '%s' scramble key check failed: %s",
+			n.domain,
+			err.Error())
+	}
+	if v != scrambleCheck {
+		return fmt.Errorf(
+			"node '%s' scramble key check returned '%s'",
+			n.domain,
+			v)
+	}
+	return nil
+}
+
 // makeScrambler If a scramble key is provided then make the scrambler,
 // otherwise return nil to indicate the node will not scramble the table name
 // to form the first fragment of the storage path.
@@ -125,7 +303,7 @@ func makeScrambler(created time.Time, scrambleKey string) (*secret, error) {
 // otherwise an empty array.
 func makeNonce(s *secret, d []byte) []byte {
 	if s != nil {
-		n := make([]byte, s.crypto.gcm.NonceSize())
+		n := make([]byte, s.crypto.aead.NonceSize())
 		c := 0
 		for i := 0; i < len(n); i++ {
 			n[i] = d[c]
@@ -144,10 +322,17 @@ func (n *node) isActive() bool {
 	return n.expires.After(time.Now().UTC())
 }
 
+// ExpiresWithin returns true if the node expires within the duration d from
+// now, including if it has already expired.
+func (n *node) ExpiresWithin(d time.Duration) bool {
+	return n.expires.Before(time.Now().UTC().Add(d))
+}
+
 // unscramble if the node has been configured with a scrambler then the input
 // string should be a base 64 encoded string created by the scramble method
-// previously. If no scrambler is used with the node then the input is the same
-// as the output.
+// previously. If the current scrambler can not unscramble the input and the
+// scrambler has been rotated then the previous scrambler is tried. If no
+// scrambler is used with the node then the input is the same as the output.
 func (n *node) unscramble(s string) (string, error) {
 	if n.scrambler != nil {
 		b, err := base64.RawURLEncoding.DecodeString(s)
@@ -155,6 +340,9 @@ func (n *node) unscramble(s string) (string, error) {
 			return "", err
 		}
 		d, err := n.scrambler.crypto.decrypt(b)
+		if err != nil && n.previous != nil {
+			d, err = n.previous.crypto.decrypt(b)
+		}
 		if err != nil {
 			return "", err
 		}
@@ -163,6 +351,19 @@ func (n *node) unscramble(s string) (string, error) {
 	return s, nil
 }
 
+// scramblePrevious returns the input scrambled with the scrambler replaced by
+// the last rotation, or an empty string if the scrambler has not been rotated.
+// Used to find values such as cookies named before the rotation.
+func (n *node) scramblePrevious(s string) string {
+	if n.previous != nil {
+		return base64.RawURLEncoding.EncodeToString(
+			n.previous.crypto.encryptWithNonce(
+				[]byte(s),
+				makeNonce(n.previous, []byte(n.domain))))
+	}
+	return ""
+}
+
 // scramble the input string if there is a scrambler used with the node. If no
 // scrambler is used with the node then the input is the same as the output.
 func (n *node) scramble(s string) string {
@@ -188,15 +389,17 @@ func (n *node) encrypt(d []byte) ([]byte, error) {
 //
 // b encrypted byte array
 func (n *node) decrypt(b []byte) ([]byte, error) {
+	var err error
 	for _, s := range n.secrets {
-		d, err := s.crypto.decrypt(b)
-		if err != nil {
-			return nil, err
-		}
-		if d != nil {
+		var d []byte
+		d, err = s.crypto.decrypt(b)
+		if err == nil && d != nil {
 			return d, nil
 		}
 	}
+	if err != nil {
+		return nil, err
+	}
 	return nil, fmt.Errorf("no secrets available to decrypt byte array")
 }
 
@@ -205,7 +408,13 @@ func (n *node) decrypt(b []byte) ([]byte, error) {
 //
 // b byte array to encode
 func (n *node) encode(b []byte) ([]byte, error) {
-	e, err := compress(b)
+	return n.encodeWith(n.getCompressor(), b)
+}
+
+// encodeWith takes the byte array, compresses it with the compressor c and if
+// there are secrets for the node encrypts it.
+func (n *node) encodeWith(c Compressor, b []byte) ([]byte, error) {
+	e, err := c.Compress(b)
 	if err != nil {
 		return nil, err
 	}
@@ -230,17 +439,28 @@ func (n *node) decode(b []byte) ([]byte, error) {
 			return nil, err
 		}
 	}
-	return decompress(b)
+	return decompressAny(n.getCompressor(), b)
 }
 
 // DecodeAsResults takes the byte array, decodes it into a Results structure
 // checking that the time stamp is valid.
 func (n *node) DecodeAsResults(d []byte) (*Results, error) {
+	var err error
 
-	// Decrypt the byte array using the node.
-	b, err := n.decode(d)
-	if err != nil {
-		return nil, err
+	// Decrypt the byte array using the node. If the results were packed and
+	// not compressed by the node then DecodeResults will unpack them.
+	b := d
+	if n.supportsCrypto() {
+		b, err = n.decrypt(d)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if isPackedResults(b) == false {
+		b, err = decompressAny(n.getCompressor(), b)
+		if err != nil {
+			return nil, err
+		}
 	}
 	if b == nil {
 		return nil, fmt.Errorf("could not decrypt byte array")
@@ -255,6 +475,21 @@ func (n *node) DecodeAsResults(d []byte) (*Results, error) {
 	return r, nil
 }
 
+// DecodeResultsFromString takes a base 64 string, decodes it into a Results
+// structure checking that the time stamp is valid. The string can use either
+// the URL alphabet without padding, as used in return URLs, or the standard
+// alphabet with padding.
+func (n *node) DecodeResultsFromString(s string) (*Results, error) {
+	d, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		d, err = base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("results not valid base 64")
+		}
+	}
+	return n.DecodeAsResults(d)
+}
+
 // MarshalJSON marshals a node to JSON without having to expose the fields in
 // the node struct. This is achieved by converting a node to a map.
 func (n *node) MarshalJSON() ([]byte, error) {
@@ -267,10 +502,35 @@ func (n *node) MarshalJSON() ([]byte, error) {
 		"role":         n.role,
 		"secrets":      n.secrets,
 		"scrambler":    n.getScramblerKey(),
+		"previous":     n.getPreviousScramblerKey(),
 		"cookieDomain": n.cookieDomain,
+		"weight":       n.weight,
 	})
 }
 
+// copyFrom copies all the fields from node o other than the mutex which must
+// not be copied.
+func (n *node) copyFrom(o *node) {
+	n.network = o.network
+	n.domain = o.domain
+	n.hash = o.hash
+	n.created = o.created
+	n.starts = o.starts
+	n.expires = o.expires
+	n.role = o.role
+	n.secrets = o.secrets
+	n.scrambler = o.scrambler
+	n.nonce = o.nonce
+	n.previous = o.previous
+	n.accessed = o.Accessed()
+	n.alive = o.IsAlive()
+	n.cookieDomain = o.cookieDomain
+	o.mutex.RLock()
+	n.compressor = o.compressor
+	o.mutex.RUnlock()
+	n.weight = o.weight
+}
+
 // UnmarshalJSON called by json.Unmarshall unmarshals a node from JSON and turns
 // it into a new node. As the node is marshalled to JSON by converting it to a
 // map, the unmarshalling from JSON needs to handle the type of each field
@@ -309,6 +569,9 @@ func (n *node) UnmarshalJSON(b []byte) error {
 		d["scrambler"].(string),
 		d["cookieDomain"].(string),
 	)
+	if err != nil {
+		return err
+	}
 	secrets := d["secrets"].([]interface{})
 
 	for _, secret := range secrets {
@@ -326,17 +589,27 @@ func (n *node) UnmarshalJSON(b []byte) error {
 			return err
 		}
 
-		np.secrets = append(n.secrets, sec)
+		np.secrets = append(np.secrets, sec)
 	}
 
-	*n = *np
-	if err != nil {
-		return err
+	n.copyFrom(np)
+	if w, ok := d["weight"].(float64); ok {
+		n.setWeight(int(w))
+	}
+	if p, ok := d["previous"].(string); ok {
+		return n.setPreviousScramblerKey(p)
 	}
 	return nil
 }
 
-func (n *node) getValueFromCookie(c *http.Cookie) (*pair, error) {
+// getValueFromCookie decodes the pair stored in the cookie c. If m is greater
+// than zero then pairs with a value larger than m bytes are rejected.
+//
+// Every secret held by the node is tried when decrypting so cookies written
+// before a new secret was added remain readable. Old secrets must therefore be
+// retained for at least the lifetime of the cookies written with them, which
+// is the expiry of the longest lived pair, before being removed from the store.
+func (n *node) getValueFromCookie(c *http.Cookie, m int) (*pair, error) {
 	var p pair
 	v, err := base64.StdEncoding.DecodeString(c.Value)
 	if err != nil {
@@ -361,6 +634,12 @@ func (n *node) getValueFromCookie(c *http.Cookie) (*pair, error) {
 			c.Name,
 			err.Error())
 	}
+	for _, v := range p.values {
+		err = validateValueSize(p.key, v, m)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return &p, nil
 }
 
@@ -368,6 +647,35 @@ func (n *node) addSecret(secret *secret) {
 	n.secrets = append(n.secrets, secret)
 }
 
+// mac returns a base 64 HMAC of the scrambled table t and the encrypted
+// operation v keyed with the same secret used by encrypt. This is the first
+// secret once sorted by time stamp, and therefore the oldest.
+func (n *node) mac(t string, v string) (string, error) {
+	s, err := n.getSecret()
+	if err != nil {
+		return "", err
+	}
+	if s == nil {
+		return "", fmt.Errorf("no secret for node '%s'", n.domain)
+	}
+	return base64.RawURLEncoding.EncodeToString(s.mac(t, v)), nil
+}
+
+// verifyMac returns true if m is the HMAC of the scrambled table t and the
+// encrypted operation v for any of the secrets of the node.
+func (n *node) verifyMac(t string, v string, m string) bool {
+	b, err := base64.RawURLEncoding.DecodeString(m)
+	if err != nil || len(b) == 0 {
+		return false
+	}
+	for _, s := range n.secrets {
+		if s != nil && hmac.Equal(s.mac(t, v), b) {
+			return true
+		}
+	}
+	return false
+}
+
 func (n *node) getSecret() (*secret, error) {
 	if len(n.secrets) > 0 {
 		return n.secrets[0], nil
@@ -375,8 +683,15 @@ func (n *node) getSecret() (*secret, error) {
 	return nil, fmt.Errorf("no secrets for node '%s'", n.domain)
 }
 
+// sortSecrets orders the secrets by time stamp. Secrets with the same time
+// stamp are ordered by key so that every node holding the same secrets selects
+// the same one.
 func (n *node) sortSecrets() {
 	sort.Slice(n.secrets, func(i, j int) bool {
-		return n.secrets[i].timeStamp.Sub(n.secrets[j].timeStamp) < 0
+		a, b := n.secrets[i], n.secrets[j]
+		if a.timeStamp.Equal(b.timeStamp) {
+			return a.key < b.key
+		}
+		return a.timeStamp.Before(b.timeStamp)
 	})
 }
@@ -0,0 +1,360 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNodeScramblerCheck(t *testing.T) {
+	n, err := newStoreQueueTestNode("scramble.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	err = n.checkScrambler()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+	}
+	n.nonce = n.nonce[1:]
+	if n.checkScrambler() == nil {
+		fmt.Println("Expected invalid nonce to fail check")
+		t.Fail()
+	}
+}
+
+func TestNodeScramblerDomainRequired(t *testing.T) {
+	_, err := newStoreQueueTestNode("")
+	if err == nil {
+		fmt.Println("Expected error for scrambler without domain")
+		t.Fail()
+	}
+}
+
+func TestNodeWeightJSON(t *testing.T) {
+	n, err := newStoreQueueTestNode("weight.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if n.weight != defaultNodeWeight {
+		fmt.Printf("Default weight '%d' incorrect\n", n.weight)
+		t.Fail()
+	}
+	n.setWeight(5)
+	b, err := json.Marshal(n)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	var r node
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if r.weight != 5 {
+		fmt.Printf("Weight '%d' not restored\n", r.weight)
+		t.Fail()
+	}
+	n.setWeight(0)
+	if n.weight != defaultNodeWeight {
+		fmt.Printf("Weight '%d' not limited to minimum\n", n.weight)
+		t.Fail()
+	}
+	n.setWeight(maxNodeWeight + 1)
+	if n.weight != maxNodeWeight {
+		fmt.Printf("Weight '%d' not limited to maximum\n", n.weight)
+		t.Fail()
+	}
+}
+
+func TestNodeRotateScrambler(t *testing.T) {
+	n, err := newStoreQueueTestNode("rotate.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	k := n.getScramblerKey()
+	o := n.scramble("table")
+	err = n.RotateScrambler()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if n.getScramblerKey() == k || n.getPreviousScramblerKey() != k {
+		fmt.Println("Scrambler not rotated")
+		t.Fail()
+	}
+	if n.scramble("table") == o {
+		fmt.Println("Scrambled value unchanged after rotation")
+		t.Fail()
+	}
+
+	// Paths scrambled before the rotation are handled in the grace window.
+	v, err := n.unscramble(o)
+	if err != nil || v != "table" {
+		fmt.Printf("Old path not unscrambled '%s' '%v'\n", v, err)
+		t.Fail()
+	}
+	if n.scramblePrevious("table") != o {
+		fmt.Println("Previous scrambler name not available")
+		t.Fail()
+	}
+
+	// The previous scrambler survives JSON.
+	b, err := json.Marshal(n)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	var j node
+	err = json.Unmarshal(b, &j)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	v, err = j.unscramble(o)
+	if err != nil || v != "table" {
+		fmt.Println("Previous scrambler not unmarshalled")
+		t.Fail()
+	}
+
+	// The grace window ends at the next rotation.
+	err = n.RotateScrambler()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	_, err = n.unscramble(o)
+	if err == nil {
+		fmt.Println("Path unscrambled after second rotation")
+		t.Fail()
+	}
+}
+
+func TestStorageServiceRotateScrambler(t *testing.T) {
+	n, err := newStoreQueueTestNode("rotate.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	k := n.getScramblerKey()
+	v := newVolatile("test", false, []*node{n})
+	s := NewStorageService(newConfigurationTest(), v)
+	err = s.RotateScrambler("", "rotate.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	x, err := v.getNode("rotate.com")
+	if err != nil || x == nil || x.getPreviousScramblerKey() != k {
+		fmt.Println("Rotated scrambler not written to store")
+		t.Fail()
+	}
+	if n.getScramblerKey() != k || n.getPreviousScramblerKey() != "" {
+		fmt.Println("Node in use changed by rotation")
+		t.Fail()
+	}
+	y := s.getNode("rotate.com")
+	if y == nil || y.getPreviousScramblerKey() != k {
+		fmt.Println("Storage manager not recreated after rotation")
+		t.Fail()
+	}
+	if s.RotateScrambler("", "missing.com") == nil {
+		fmt.Println("Missing node rotated")
+		t.Fail()
+	}
+}
+
+func TestNodeNetworkNormalized(t *testing.T) {
+	var a []*node
+	for _, d := range []struct {
+		network string
+		domain  string
+		role    int
+	}{
+		{" Test ", "access.com", roleAccess},
+		{"TEST", "storage.com", roleStorage}} {
+		n, err := newNode(
+			d.network,
+			d.domain,
+			time.Now().UTC(),
+			time.Now().UTC(),
+			time.Now().UTC().AddDate(1, 0, 0),
+			d.role,
+			"",
+			"")
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		if n.network != "test" {
+			fmt.Printf("Network '%s' not normalized\n", n.network)
+			t.Fail()
+		}
+		a = append(a, n)
+	}
+	c := newConfigurationTest()
+	s := NewStorageService(c, newVolatile("test", false, a))
+	for _, v := range []string{"test", "Test", " test\t", "TEST"} {
+		ns, err := s.getNodes(v)
+		if err != nil || ns == nil || len(ns.all) != 2 {
+			fmt.Printf("Network '%s' nodes not found\n", v)
+			t.Fail()
+		}
+		d, err := s.store.GetAccessNode(v)
+		if err != nil || d != "access.com" {
+			fmt.Printf("No access node for network '%s'\n", v)
+			t.Fail()
+		}
+	}
+}
+
+func TestNodeValidateNetwork(t *testing.T) {
+	for _, d := range []struct {
+		network string
+		valid   bool
+	}{
+		{"net", true},
+		{"my-network-1", true},
+		{"abcdefghijklmnopqrst", true},
+		{"ab", false},
+		{"abcdefghijklmnopqrstu", false},
+		{"my_network", false},
+		{"my network", false},
+		{"Network", false},
+		{"", false}} {
+		err := validateNetwork(d.network)
+		if (err == nil) != d.valid {
+			fmt.Printf("Network '%s' valid '%v'\n", d.network, err == nil)
+			t.Fail()
+		}
+	}
+}
+
+func TestNodeCookieSecretRotation(t *testing.T) {
+	s := NewServices(newConfigurationTest(), nil, nil, nil)
+	n, err := newStoreQueueTestNode("access.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	o := newOperation(s, n)
+	o.table = "t"
+	var p pair
+	p.key = "a"
+	p.created = time.Now().UTC()
+	p.expires = p.created.AddDate(0, 0, 1)
+	p.values = [][]byte{[]byte("A")}
+	p.conflict = conflictNewest
+	w := httptest.NewRecorder()
+	o.request = httptest.NewRequest("GET", "http://access.com/", nil)
+	err = o.setValueInCookie(w, o.request, &p)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+
+	// Add a newer secret ahead of the one used to write the cookie.
+	x, err := newSecret()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	n.secrets = append([]*secret{x}, n.secrets...)
+
+	cp, err := n.getValueFromCookie(w.Result().Cookies()[0], 0)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if string(cp.values[0]) != "A" {
+		fmt.Println("Cookie value incorrect after secret rotation")
+		t.Fail()
+	}
+
+	// Once the old secret is removed the cookie can no longer be read.
+	n.secrets = []*secret{x}
+	_, err = n.getValueFromCookie(w.Result().Cookies()[0], 0)
+	if err == nil {
+		fmt.Println("Cookie decrypted without the secret used to write it")
+		t.Fail()
+	}
+}
+
+// TestNodeSortSecretsSameTimeStamp confirms that secrets with the same time
+// stamp are ordered the same way whatever order they are added in.
+func TestNodeSortSecretsSameTimeStamp(t *testing.T) {
+	a, err := newSecret()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	b, err := newSecret()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	b.timeStamp = a.timeStamp
+	var x, y node
+	x.addSecret(a)
+	x.addSecret(b)
+	y.addSecret(b)
+	y.addSecret(a)
+	x.sortSecrets()
+	y.sortSecrets()
+	i, err := x.getSecret()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	j, err := y.getSecret()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if i != j {
+		fmt.Println("Different secrets selected")
+		t.Fail()
+	}
+}
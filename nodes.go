@@ -19,15 +19,29 @@ package swift
 import (
 	"fmt"
 	"math/rand"
+	"net"
 	"regexp"
 	"sort"
+	"strings"
+	"time"
 )
 
 type nodes struct {
 	all    []*node          // All the nodes in a random order
 	active []*node          // Active nodes ordered by creation time
 	hash   []*node          // Active storage nodes ordered by hash value
+	ring   []hashPoint      // Weighted storage node points ordered by hash
 	dict   map[string]*node // All the nodes keyed on domain name
+	salt   string           // Mixed into the hashes used for the ring
+	// True if some active nodes scramble table names and others do not
+	scrambleMixed bool
+}
+
+// hashPoint is a position for a storage node in the hash ring. Nodes appear in
+// the ring once for each unit of weight.
+type hashPoint struct {
+	hash uint64 // Position of the point in the ring
+	node *node  // Storage node that the point relates to
 }
 
 func newNodes() *nodes {
@@ -35,17 +49,27 @@ func newNodes() *nodes {
 	ns.all = []*node{}
 	ns.active = []*node{}
 	ns.hash = []*node{}
+	ns.ring = []hashPoint{}
 	ns.dict = make(map[string]*node)
 	return &ns
 }
 
 func (ns *nodes) getRandomNode(condition func(n *node) bool) *node {
+	return ns.getRandomNodeFrom(rand.Intn, condition)
+}
+
+// getRandomNodeFrom returns a random active node that matches the condition
+// using intn to generate the random numbers. Used to reproduce the selection of
+// nodes from a known source of random numbers.
+func (ns *nodes) getRandomNodeFrom(
+	intn func(n int) int,
+	condition func(n *node) bool) *node {
 	indexes := make([]int, len(ns.active))
 	for i := 0; i < len(ns.active); i++ {
 		indexes[i] = i
 	}
 	for i := range indexes {
-		j := rand.Intn(i + 1)
+		j := intn(i + 1)
 		indexes[i], indexes[j] = indexes[j], indexes[i]
 	}
 	for _, i := range indexes {
@@ -56,13 +80,86 @@ func (ns *nodes) getRandomNode(condition func(n *node) bool) *node {
 	return nil
 }
 
+// isStorageCandidate returns true if the node n can be the next storage node of
+// an operation at time t where c is the current node and h the home node. If
+// alive is true then the node must also be confirmed as alive.
+func isStorageCandidate(
+	n *node,
+	c *node,
+	h *node,
+	t time.Time,
+	alive bool) bool {
+	return n.role == roleStorage &&
+		n.domain != c.domain &&
+		n.domain != h.domain &&
+		n.starts.Before(t) &&
+		(alive == false || n.IsAlive())
+}
+
+// getRandomStorageNodeFrom returns a random storage node using intn to generate
+// the random numbers for an operation at time t where c is the current node
+// and h the home node. If alive is true then nodes confirmed as alive are
+// preferred and other nodes are only used if no alive node can be found.
+// Returns nil if there is no suitable node.
+func (ns *nodes) getRandomStorageNodeFrom(
+	intn func(n int) int,
+	c *node,
+	h *node,
+	t time.Time,
+	alive bool) *node {
+	if alive {
+		n := ns.getRandomNodeFrom(intn, func(x *node) bool {
+			return isStorageCandidate(x, c, h, t, true)
+		})
+		if n != nil {
+			return n
+		}
+	}
+	return ns.getRandomNodeFrom(intn, func(x *node) bool {
+		return isStorageCandidate(x, c, h, t, false)
+	})
+}
+
+// simulateVisit returns the nodes, in order, that a storage operation with the
+// home node h started at time t would visit with nodeCount nodes. The storage
+// nodes are chosen in the same way as HandlerStore with alive nodes preferred
+// if alive is true, using a source created from the seed so that the same seed
+// always returns the same nodes.
+func (ns *nodes) simulateVisit(
+	h *node,
+	nodeCount byte,
+	seed int64,
+	t time.Time,
+	alive bool) []*node {
+	r := rand.New(rand.NewSource(seed))
+	c := getCountForNodes(nodeCount, ns)
+	v := []*node{h}
+	p := h
+	for i := byte(1); i < c; i++ {
+
+		// The last visit returns to the home node, otherwise use a random
+		// storage node that is not the home node or the current node.
+		var n *node
+		if i < c-1 {
+			n = ns.getRandomStorageNodeFrom(r.Intn, p, h, t, alive)
+		}
+		if n == nil {
+			n = h
+		}
+		v = append(v, n)
+		p = n
+	}
+	return v
+}
+
 // Get the hash of the remote address for the request by removing the port if
-// present and using the domain or IP address.
-func getRemoteAddrHash(xff string, ra string) uint64 {
+// present and using the domain or IP address. The salt is mixed into the hash
+// so that the same address produces different hashes in different networks.
+func getRemoteAddrHash(salt string, xff string, ra string, p int) uint64 {
 	var a uint64
-	d := getRemoteAddr(xff, ra)
+	d := getRemoteAddr(xff, ra, p)
 	if len(d) > 0 {
-		a = getHash(d)
+		a = getHash(salt + d)
 	}
 	return a
 }
@@ -70,47 +167,158 @@ func getRemoteAddrHash(xff string, ra string) uint64 {
 var regexClientIP, _ = regexp.Compile("[\\d\\.]+|\\[[^\\]]+\\]")
 
 // GetIP gets a requests IP address by reading off the forwarded-for header
-// (for proxies) and falls back to use the remote address.
-func getRemoteAddr(xff string, ra string) string {
+// (for proxies) and falls back to use the remote address. p is the number of
+// addresses at the end of the header added by trusted proxies and the address
+// before them is used. If p is zero then the last address is used. As addresses
+// at the start of the header can be supplied by the client this prevents the
+// home node being chosen by the client. If the header contains p or fewer
+// addresses then the first is used.
+func getRemoteAddr(xff string, ra string, p int) string {
 	if xff != "" {
-		b := regexClientIP.FindString(xff)
-		if b != "" {
-			return b
+		a := strings.Split(xff, ",")
+		i := len(a) - 1 - p
+		if i < 0 {
+			i = 0
 		}
-		return xff
+		return normalizeRemoteAddr(a[i])
 	}
 	if ra != "" {
-		b := regexClientIP.FindString(ra)
-		if b != "" {
-			return b
-		}
-		return ra
+		return normalizeRemoteAddr(ra)
 	}
 	return ""
 }
 
+// normalizeRemoteAddr removes any port, brackets and IPv6 zone from the address
+// and returns the canonical form of the IP address so that the same client
+// always produces the same hash. If the address is not an IP address then the
+// first part that looks like an IP address is returned, or the address if none.
+func normalizeRemoteAddr(a string) string {
+	h := strings.TrimSpace(a)
+	if s, _, err := net.SplitHostPort(h); err == nil {
+		h = s
+	}
+	h = strings.TrimSuffix(strings.TrimPrefix(h, "["), "]")
+	if i := strings.IndexByte(h, '%'); i >= 0 {
+		h = h[:i]
+	}
+	if ip := net.ParseIP(h); ip != nil {
+		return ip.String()
+	}
+	b := regexClientIP.FindString(a)
+	if b != "" {
+		return b
+	}
+	return a
+}
+
 // Find the node that has a hash value closest to that of the remote IP address.
 func (ns *nodes) getHomeNode(xff string, ra string) (*node, error) {
-	i := ns.getNodeIndexByHash(getRemoteAddrHash(xff, ra))
-	if i < 0 || i >= len(ns.hash) {
+	return ns.getHomeNodeWithin(xff, ra, 0, time.Now().UTC())
+}
+
+// getHomeNodeWithin finds the node that has a hash value closest to that of the
+// remote IP address and does not expire before the time e. p is the number of
+// trusted proxy addresses at the end of the forwarded-for header. Used to avoid
+// choosing a home node that will expire before the storage operation completes.
+// If the closest node expires before e then the next node in the hash ring that
+// does not is used. If every node expires before e then the closest is used.
+func (ns *nodes) getHomeNodeWithin(
+	xff string,
+	ra string,
+	p int,
+	e time.Time) (*node, error) {
+	err := ns.getHomeNodeAvailable()
+	if err != nil {
+		return nil, err
+	}
+	i := ns.getNodeIndexByHash(
+		getRemoteAddrHash(ns.salt, xff, ra, p))
+	if i < 0 || i >= len(ns.ring) {
 		return nil, fmt.Errorf(
 			"None of the '%d' available nodes were identified as a home node "+
 				"for remote address '%s'",
 			len(ns.hash),
-			getRemoteAddr(xff, ra))
+			getRemoteAddr(xff, ra, p))
+	}
+	for j := 0; j < len(ns.ring); j++ {
+		n := ns.ring[(i+j)%len(ns.ring)].node
+		if n.expires.After(e) {
+			return n, nil
+		}
+	}
+	return ns.ring[i].node, nil
+}
+
+// getHomeNodeByDomain returns the storage node with the domain provided for use
+// as the home node. Returns an error if the domain is not an active storage
+// node that has started in this network.
+func (ns *nodes) getHomeNodeByDomain(domain string) (*node, error) {
+	n := ns.dict[domain]
+	if n == nil {
+		return nil, fmt.Errorf("'%s' is not a node in the network", domain)
+	}
+	if n.role != roleStorage {
+		return nil, fmt.Errorf("'%s' is not a storage node", domain)
+	}
+	if n.isActive() == false {
+		return nil, fmt.Errorf("'%s' has expired", domain)
+	}
+	if n.starts.After(time.Now().UTC()) {
+		return nil, fmt.Errorf(
+			"'%s' does not start until '%s'",
+			domain,
+			n.starts.Format(time.RFC3339))
+	}
+	return n, nil
+}
+
+// getHomeNodeAvailable returns nil if at least one active storage node has
+// started, otherwise an error explaining why there is no home node. Storage
+// nodes that exist but have not started yet are reported with the time the
+// first will start so that operators know whether to wait or add nodes.
+func (ns *nodes) getHomeNodeAvailable() error {
+	t := time.Now().UTC()
+	var f *node
+	for _, n := range ns.hash {
+		if n.starts.After(t) == false {
+			return nil
+		}
+		if f == nil || n.starts.Before(f.starts) {
+			f = n
+		}
+	}
+	if f != nil {
+		return fmt.Errorf(
+			"'%d' storage nodes exist but none have started yet, the first "+
+				"starts at '%s'",
+			len(ns.hash),
+			f.starts.Format(time.RFC3339))
+	}
+	c := 0
+	for _, n := range ns.all {
+		if n.role == roleStorage {
+			c++
+		}
 	}
-	return ns.hash[i], nil
+	if c > 0 {
+		return fmt.Errorf(
+			"'%d' storage nodes exist but all have expired",
+			c)
+	}
+	return fmt.Errorf("No storage nodes exist")
 }
 
+// getNodeIndexByHash returns the index of the point in the hash ring closest to
+// the hash value h.
 func (ns *nodes) getNodeIndexByHash(h uint64) int {
 	m := 0
 	l := 0
-	u := len(ns.hash) - 1
+	u := len(ns.ring) - 1
 	for l <= u {
 		m = (l + u) / 2
-		if ns.hash[m].hash < h {
+		if ns.ring[m].hash < h {
 			l = m + 1
-		} else if ns.hash[m].hash > h {
+		} else if ns.ring[m].hash > h {
 			u = m - 1
 		} else {
 			break
@@ -122,6 +330,32 @@ func (ns *nodes) getNodeIndexByHash(h uint64) int {
 func (ns *nodes) order() {
 	ns.active = getActiveOrdered(ns.all)
 	ns.hash = getHashOrdered(ns.active)
+	ns.ring = getHashRing(ns.salt, ns.hash)
+	ns.scrambleMixed = ns.validate() != nil
+}
+
+// validate returns an error if the active nodes in the network do not share
+// compatible settings. A network that contains nodes that scramble table names
+// and nodes that do not is reported as the scrambled table paths and cookie
+// names of the nodes differ in form.
+func (ns *nodes) validate() error {
+	var s, u []string
+	for _, n := range ns.active {
+		if n.scrambler != nil {
+			s = append(s, n.domain)
+		} else {
+			u = append(u, n.domain)
+		}
+	}
+	if len(s) > 0 && len(u) > 0 {
+		return fmt.Errorf(
+			"Network contains '%d' nodes that scramble and '%d' that do "+
+				"not including '%s'",
+			len(s),
+			len(u),
+			u[0])
+	}
+	return nil
 }
 
 func getHashOrdered(active []*node) []*node {
@@ -137,6 +371,36 @@ func getHashOrdered(active []*node) []*node {
 	return h
 }
 
+// getHashRing returns the points for the storage nodes ordered by hash value.
+// Each node is placed in the ring once for each unit of weight so that nodes
+// with more capacity are the home node for proportionally more clients. The
+// first point uses the node's hash and subsequent points are derived from the
+// domain so that all instances produce the same ring for the same nodes. If a
+// salt is provided it is mixed into the hash of every point so that the ring
+// differs from that of other networks with the same nodes.
+func getHashRing(salt string, h []*node) []hashPoint {
+	r := make([]hashPoint, 0, len(h))
+	for _, n := range h {
+		f := n.hash
+		if salt != "" {
+			f = getHash(salt + n.domain)
+		}
+		r = append(r, hashPoint{f, n})
+		for i := 1; i < n.weight; i++ {
+			r = append(r, hashPoint{
+				getHash(fmt.Sprintf("%s%s#%d", salt, n.domain, i)),
+				n})
+		}
+	}
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].hash == r[j].hash {
+			return r[i].node.domain < r[j].node.domain
+		}
+		return r[i].hash < r[j].hash
+	})
+	return r
+}
+
 func getActiveOrdered(all []*node) []*node {
 	a := make([]*node, 0, len(all))
 	for _, n := range all {
@@ -19,6 +19,7 @@ package swift
 import (
 	"fmt"
 	"log"
+	"strings"
 	"testing"
 	"time"
 )
@@ -84,19 +85,19 @@ func TestNodesHomeNode(t *testing.T) {
 		t.Fail()
 		return
 	}
-	hn1, err := ns.getHomeNode("212.36.33.158, 172.31.23.19", "127.0.0.1")
+	hn1, err := ns.getHomeNode("172.31.23.19, 212.36.33.158", "127.0.0.1")
 	if err != nil {
 		fmt.Println(err)
 		t.Fail()
 		return
 	}
-	hn2, err := ns.getHomeNode("109.249.187.121, 172.31.23.19", "127.0.0.1")
+	hn2, err := ns.getHomeNode("172.31.23.19, 109.249.187.121", "127.0.0.1")
 	if err != nil {
 		fmt.Println(err)
 		t.Fail()
 		return
 	}
-	hn3, err := ns.getHomeNode("109.249.187.120, 172.31.23.19", "127.0.0.1")
+	hn3, err := ns.getHomeNode("172.31.23.19, 109.249.187.120", "127.0.0.1")
 	log.Println(hn1.domain)
 	log.Println(hn2.domain)
 	log.Println(hn3.domain)
@@ -114,6 +115,159 @@ func TestNodesHomeNode(t *testing.T) {
 	}
 }
 
+// TestNodesHomeNodeWeighted confirms that a node with a larger weight is the
+// home node for proportionally more clients, and that two instances with the
+// same weights return the same home node.
+func TestNodesHomeNodeWeighted(t *testing.T) {
+	ns1, err := createNodes()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	ns2, err := createNodes()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	ns1.dict["node0"].setWeight(20)
+	ns2.dict["node0"].setWeight(20)
+	ns1.order()
+	ns2.order()
+	if len(ns1.ring) != len(ns1.hash)+19 {
+		fmt.Printf("Ring contains '%d' points\n", len(ns1.ring))
+		t.Fail()
+		return
+	}
+	c := 0
+	for i := 0; i < 2000; i++ {
+		a := fmt.Sprintf("10.%d.%d.1", i/250, i%250)
+		hn1, err := ns1.getHomeNode("", a)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		hn2, err := ns2.getHomeNode("", a)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		if hn1.domain != hn2.domain {
+			fmt.Printf("'%s' != '%s' for '%s'\n", hn1.domain, hn2.domain, a)
+			t.Fail()
+			return
+		}
+		if hn1.domain == "node0" {
+			c++
+		}
+	}
+
+	// With 119 points node0 has 20 and should expect around 336 clients. An
+	// unweighted node would expect around 20.
+	if c < 100 {
+		fmt.Printf("Weighted node only home for '%d' clients\n", c)
+		t.Fail()
+	}
+}
+
+// TestNodesHomeNodeSalt confirms that the same salt produces the same home
+// nodes and that different salts produce different home nodes.
+func TestNodesHomeNodeSalt(t *testing.T) {
+	var a []*nodes
+	for _, s := range []string{"a", "a", "b"} {
+		ns, err := createNodes()
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		ns.salt = s
+		ns.order()
+		a = append(a, ns)
+	}
+	d := 0
+	for i := 0; i < 20; i++ {
+		ip := fmt.Sprintf("212.36.33.%d", i)
+		var h []string
+		for _, ns := range a {
+			n, err := ns.getHomeNode(ip, "127.0.0.1")
+			if err != nil {
+				fmt.Println(err)
+				t.Fail()
+				return
+			}
+			h = append(h, n.domain)
+		}
+		if h[0] != h[1] {
+			fmt.Printf("Same salt returned '%s' and '%s'\n", h[0], h[1])
+			t.Fail()
+		}
+		if h[0] != h[2] {
+			d++
+		}
+	}
+	if d == 0 {
+		fmt.Println("Different salts returned the same home nodes")
+		t.Fail()
+	}
+}
+
+// TestNodesSaltStorageManager confirms that the storage manager applies the
+// configured salt to the network, including after the store changes.
+func TestNodesSaltStorageManager(t *testing.T) {
+	v, err := newVolatileTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	n, err := v.getNode("test-1.com")
+	if err != nil || n == nil {
+		fmt.Println("Test node missing")
+		t.Fail()
+		return
+	}
+	c := newConfigurationTest()
+	c.NetworkSalts = map[string]string{n.network: "salt"}
+	sm, err := newStorageManager(c, nil, v)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	ns, err := sm.getNodes(n.network)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if ns.salt != "salt" {
+		fmt.Printf("Salt '%s' not applied\n", ns.salt)
+		t.Fail()
+	}
+
+	// Networks built when the store changes also use the salt.
+	err = v.deleteNode("test-2.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	ns, err = sm.getNodes(n.network)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if ns.salt != "salt" || ns.dict["test-2.com"] != nil {
+		fmt.Printf("Salt '%s' not applied after change\n", ns.salt)
+		t.Fail()
+	}
+}
+
 func createNodes() (*nodes, error) {
 	ns := newNodes()
 	for i := 0; i < 100; i++ {
@@ -145,3 +299,317 @@ func createNodes() (*nodes, error) {
 	ns.order()
 	return ns, nil
 }
+
+// TestNodesHomeNodeNoStorage confirms the error when a network has no storage
+// nodes.
+func TestNodesHomeNodeNoStorage(t *testing.T) {
+	ns := newNodes()
+	ns.order()
+	_, err := ns.getHomeNode("212.36.33.158", "127.0.0.1")
+	if err == nil || err.Error() != "No storage nodes exist" {
+		fmt.Println(err)
+		t.Fail()
+	}
+}
+
+// TestNodesHomeNodeNotStarted confirms the error when the storage nodes exist
+// but none have started yet.
+func TestNodesHomeNodeNotStarted(t *testing.T) {
+	ns, err := createNodes()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	s := time.Now().UTC().Add(time.Hour)
+	for _, n := range ns.all {
+		n.starts = s
+	}
+	_, err = ns.getHomeNode("212.36.33.158", "127.0.0.1")
+	if err == nil ||
+		strings.Contains(err.Error(), "none have started yet") == false ||
+		strings.Contains(err.Error(), s.Format(time.RFC3339)) == false {
+		fmt.Println(err)
+		t.Fail()
+	}
+}
+
+// TestNodesHomeNodeIPv6 confirms that the same IPv6 client maps to the same
+// home node regardless of port, brackets, zone or formatting.
+func TestNodesHomeNodeIPv6(t *testing.T) {
+	ns, err := createNodes()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	for _, d := range []struct {
+		xff      string
+		ra       string
+		expected string
+	}{
+		{"", "2001:db8::1", "2001:db8::1"},
+		{"", "[2001:db8::1]:8080", "2001:db8::1"},
+		{"", "[2001:DB8:0:0::1%eth0]:443", "2001:db8::1"},
+		{"172.31.23.19, 2001:db8::1", "127.0.0.1", "2001:db8::1"},
+		{"[2001:0db8::0001]:1234", "127.0.0.1", "2001:db8::1"},
+		{"", "212.36.33.158:80", "212.36.33.158"},
+		{"172.31.23.19, 212.36.33.158", "127.0.0.1", "212.36.33.158"}} {
+		a := getRemoteAddr(d.xff, d.ra, 0)
+		if a != d.expected {
+			fmt.Printf("Address '%s' not '%s'\n", a, d.expected)
+			t.Fail()
+		}
+	}
+	a, err := ns.getHomeNode("", "2001:db8::1")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	b, err := ns.getHomeNode("", "[2001:db8::1]:8080")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if a != b {
+		fmt.Printf("Home node '%s' not '%s'\n", b.domain, a.domain)
+		t.Fail()
+	}
+}
+
+// TestNodesHomeNodeWithin confirms that a home node expiring within the
+// duration is skipped in favour of the next node in the ring, and that the
+// closest node is used if every node expires within the duration.
+func TestNodesHomeNodeWithin(t *testing.T) {
+	ns, err := createNodes()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	h, err := ns.getHomeNode("212.36.33.158", "127.0.0.1")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	h.expires = time.Now().UTC().Add(time.Minute)
+	w, err := ns.getHomeNodeWithin(
+		"212.36.33.158",
+		"127.0.0.1",
+		0,
+		time.Now().UTC().Add(time.Hour))
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if w == h {
+		fmt.Println("Home node expiring within the duration was chosen")
+		t.Fail()
+	}
+	w, err = ns.getHomeNodeWithin(
+		"212.36.33.158",
+		"127.0.0.1",
+		0,
+		time.Now().UTC())
+	if err != nil || w != h {
+		fmt.Println("Home node not expiring within the duration was skipped")
+		t.Fail()
+	}
+	for _, n := range ns.all {
+		n.expires = time.Now().UTC().Add(time.Minute)
+	}
+	w, err = ns.getHomeNodeWithin(
+		"212.36.33.158",
+		"127.0.0.1",
+		0,
+		time.Now().UTC().Add(time.Hour))
+	if err != nil || w != h {
+		fmt.Println("Closest home node not used when all nodes expire")
+		t.Fail()
+	}
+}
+
+// TestNodesValidateScramble confirms that a network mixing nodes that scramble
+// with nodes that do not is reported.
+func TestNodesValidateScramble(t *testing.T) {
+	ns := newNodes()
+	for _, d := range []struct {
+		domain   string
+		scramble bool
+	}{{"scramble.com", true}, {"plain.com", false}} {
+		k := ""
+		if d.scramble {
+			x, err := newSecret()
+			if err != nil {
+				fmt.Println(err)
+				t.Fail()
+				return
+			}
+			k = x.key
+		}
+		n, err := newNode(
+			"network",
+			d.domain,
+			time.Now().UTC(),
+			time.Now().UTC().Add(-time.Minute),
+			time.Now().UTC().AddDate(1, 0, 0),
+			roleStorage,
+			k,
+			"")
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		ns.all = append(ns.all, n)
+		ns.dict[n.domain] = n
+		ns.order()
+		if ns.scrambleMixed != (len(ns.all) == 2) {
+			fmt.Printf("Scramble mixed '%v' for '%d' nodes\n",
+				ns.scrambleMixed,
+				len(ns.all))
+			t.Fail()
+		}
+	}
+	err := ns.validate()
+	if err == nil || strings.Contains(err.Error(), "plain.com") == false {
+		fmt.Println("Mixed scramble settings not reported")
+		t.Fail()
+	}
+}
+
+// TestNodesTrustedProxies confirms the address used from the forwarded-for
+// chain for different numbers of trusted proxies.
+func TestNodesTrustedProxies(t *testing.T) {
+	x := "1.1.1.1, 2.2.2.2, 3.3.3.3"
+	for _, d := range []struct {
+		xff      string
+		proxies  int
+		expected string
+	}{
+		{x, 0, "3.3.3.3"},
+		{x, 1, "2.2.2.2"},
+		{x, 2, "1.1.1.1"},
+		{"4.4.4.4, " + x, 2, "1.1.1.1"},
+		{"2.2.2.2, 3.3.3.3", 2, "2.2.2.2"}, // shorter chain
+		{"3.3.3.3", 1, "3.3.3.3"}} {
+		a := getRemoteAddr(d.xff, "127.0.0.1", d.proxies)
+		if a != d.expected {
+			fmt.Printf("Address '%s' not '%s' for '%d' proxies\n",
+				a,
+				d.expected,
+				d.proxies)
+			t.Fail()
+		}
+	}
+
+	// The client can not choose the home node by adding addresses to the
+	// start of the chain.
+	ns, err := createNodes()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	a, err := ns.getHomeNodeWithin(
+		"212.36.33.158, 109.249.187.121, 172.31.23.19",
+		"127.0.0.1",
+		1,
+		time.Now().UTC())
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	b, err := ns.getHomeNodeWithin(
+		"109.249.187.121, 172.31.23.19",
+		"127.0.0.1",
+		1,
+		time.Now().UTC())
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if a != b {
+		fmt.Println("Spoofed address changed the home node")
+		t.Fail()
+	}
+}
+
+// TestNodesSimulateVisit confirms the simulated visit starts and ends at the
+// home node, and is the same for the same seed.
+func TestNodesSimulateVisit(t *testing.T) {
+	ns, err := createNodes()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	h, err := ns.getHomeNode("212.36.33.158", "127.0.0.1")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	a := ns.simulateVisit(h, 5, 1, time.Now().UTC(), false)
+	if len(a) != 5 || a[0] != h || a[4] != h {
+		fmt.Println("Home node not visited first and last")
+		t.Fail()
+		return
+	}
+	for i := 1; i < 4; i++ {
+		if a[i] == h || a[i] == a[i-1] {
+			fmt.Printf("Visit '%d' repeats a node\n", i)
+			t.Fail()
+		}
+	}
+	b := ns.simulateVisit(h, 5, 1, time.Now().UTC(), false)
+	for i := range a {
+		if a[i] != b[i] {
+			fmt.Println("Same seed visited different nodes")
+			t.Fail()
+			return
+		}
+	}
+}
+
+// TestNodesSimulateVisitSelection confirms the simulated visit prefers alive
+// nodes when required and only visits nodes that have started at the time
+// provided.
+func TestNodesSimulateVisitSelection(t *testing.T) {
+	ns, err := createNodes()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	h, err := ns.getHomeNode("212.36.33.158", "127.0.0.1")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	var l *node
+	for _, n := range ns.all {
+		if n != h {
+			l = n
+			break
+		}
+	}
+	l.SetAlive(true)
+	a := ns.simulateVisit(h, 3, 1, time.Now().UTC().Add(time.Minute), true)
+	if a[1] != l {
+		fmt.Println("Alive node not preferred")
+		t.Fail()
+	}
+	b := ns.simulateVisit(h, 3, 1, time.Now().UTC().Add(-time.Hour), true)
+	if b[1] != h {
+		fmt.Println("Node visited before it started")
+		t.Fail()
+	}
+}
@@ -21,20 +21,40 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// macParam is the query string parameter of storage operation URLs that
+// contains the HMAC of the table and operation.
+const macParam = "m"
+
+// cookieTableSeparator separates the table and key in the cookie name when a
+// shared cookie path is configured.
+const cookieTableSeparator = "|"
+
+// warningCookieName is the name of the cookie used to record the number of
+// cookie warnings shown to the browser.
+const warningCookieName = "w"
+
+// browserWarningCookieName is the name of the short lived cookie set to check
+// whether the browser returns cookies.
+const browserWarningCookieName = "t"
+
+// accessNodeSeparator separates the access node domains in the accessNode
+// parameter and the serialized operation.
+const accessNodeSeparator = ","
+
 type operation struct {
 
 	// Internal persisted state fields.
 	timeStamp    time.Time // The time that the state information was created
 	returnURL    string    // The URL to return to when the operation completes
-	accessNode   string    // The domain name of the access node
+	accessNodes  []string  // The domain names of the access nodes in order
 	nodesVisited byte      // Nodes visited so far including current
 	nodeCount    byte      // Number of nodes that should be visited
 	pairs        []*pair   // Value pairs from the operation
@@ -43,6 +63,14 @@ type operation struct {
 	homeNode     string    // The domain of the home node
 	state        []string  // Optional state information
 
+	// Internal persisted state fields for multi network operations.
+	requested []*pair         // The pairs requested when created
+	journey   []*journeyLeg   // The networks remaining to be visited
+	completed []*networkPairs // The results from the networks visited
+
+	// Number of times the cookie warning has been shown for the operation.
+	warnings byte
+
 	// The following fields are calculated for each request. Not stored.
 	services    *Services     // The services used for the operation
 	nextURL     *url.URL      // The next URL to navigate to
@@ -54,6 +82,8 @@ type operation struct {
 	request     *http.Request // Http request associated with the operation
 	cookiePairs []*pair       // The value pairs from cookies
 	resolved    []*pair       // The resolved pairs
+	replay      bool          // True if the operation has already completed
+	nonce       string        // Nonce for inline scripts if CSP safe
 
 	HTML // Include the common HTML UI members.
 }
@@ -72,22 +102,44 @@ func (o *operation) BackgroundColor() string { return o.HTML.BackgroundColor }
 func (o *operation) MessageColor() string    { return o.HTML.MessageColor }
 func (o *operation) ProgressColor() string   { return o.HTML.ProgressColor }
 func (o *operation) ReturnURL() string       { return o.returnURL }
-func (o *operation) AccessNode() string      { return o.accessNode }
+func (o *operation) AccessNodes() []string   { return o.accessNodes }
 func (o *operation) NextURL() *url.URL       { return o.nextURL }
 func (o *operation) NodesVisited() byte      { return o.nodesVisited }
 func (o *operation) NodeCount() byte         { return o.nodeCount }
 func (o *operation) Debug() bool             { return o.services.config.Debug }
 func (o *operation) SVGStroke() int          { return svgStroke }
 func (o *operation) SVGSize() int            { return svgSize }
-func (o *operation) Values() []*pair         { return o.resolved }
 func (o *operation) Table() string           { return o.table }
+func (o *operation) Nonce() string           { return o.nonce }
+
+// Values returns the resolved pairs of the operation.
+func (o *operation) Values() []*Pair {
+	v := make([]*Pair, 0, len(o.resolved))
+	for _, p := range o.resolved {
+		if p != nil {
+			v = append(v, &p.Pair)
+		}
+	}
+	return v
+}
 
-// Results of the operation to return to the caller.
+// AccessNode returns the domain name of the first access node, or an empty
+// string if there are no access nodes.
+func (o *operation) AccessNode() string {
+	if len(o.accessNodes) > 0 {
+		return o.accessNodes[0]
+	}
+	return ""
+}
+
+// Results of the operation to return to the caller. If the operation has run
+// out of time then the results are only returned if partial results are
+// allowed.
 func (o *operation) Results() (string, error) {
-	if o.IsTimeStampValid() == false {
+	if o.IsTimeStampValid() == false && o.AllowPartial() == false {
 		return "", fmt.Errorf("Operation timestamp invalid")
 	}
-	if o.accessNode == "" {
+	if len(o.accessNodes) == 0 {
 		return "", fmt.Errorf("No access node provided")
 	}
 	return o.getResults()
@@ -104,15 +156,18 @@ func (o *operation) Language() string {
 
 // HomeNode returns the home node for the web browser. Used to ensure that the
 // first and last operation occur against a consistent node for the web browser.
-// TODO: What should the behavior be if the home node does not exist, what to do
-// with the error from getNode.
+// If the home node no longer exists then the first active node in the network
+// is used. Returns nil if there are no active nodes in the network. Callers
+// must respond with a server error rather than continue the operation.
 // See https://github.com/SWAN-community/swift/issues/2
 func (o *operation) HomeNode() *node {
 	if o.homeNodePtr == nil {
 		if o.homeNode != "" {
 			o.homeNodePtr = o.services.store.getNode(o.homeNode)
 		}
-		if o.homeNodePtr == nil {
+		if o.homeNodePtr == nil &&
+			o.network != nil &&
+			len(o.network.active) > 0 {
 			o.homeNodePtr = o.network.active[0]
 		}
 	}
@@ -134,7 +189,13 @@ func (o *operation) PrevNode() *node {
 // otherwise false.
 func (o *operation) IsTimeStampValid() bool {
 	t := o.timeStamp.Add(o.services.config.StorageOperationTimeoutDuration())
-	return time.Now().UTC().Before(t)
+	return o.services.clock.Now().Before(t)
+}
+
+// isPartial true if the operation has run out of time and partial results are
+// allowed.
+func (o *operation) isPartial() bool {
+	return o.AllowPartial() && o.IsTimeStampValid() == false
 }
 
 // PercentageComplete the progress as a percentage of the operation.
@@ -152,10 +213,16 @@ func (o *operation) SVGPath() string {
 	return svgPath(o.PercentageComplete())
 }
 
+// StylesURL the URL of the style sheet for the user interface when the
+// operation is CSP safe.
+func (o *operation) StylesURL() string {
+	return getStylesURL(o.services.config, o.thisNode.domain, &o.HTML)
+}
+
 func newOperation(s *Services, n *node) *operation {
 	var o operation
 	o.services = s
-	o.timeStamp = time.Now().UTC()
+	o.timeStamp = s.clock.Now()
 	o.thisNode = n
 	return &o
 }
@@ -193,13 +260,35 @@ func newOperationFromRequest(
 		return nil, fmt.Errorf("'%s' is not a registered Swift node", r.Host)
 	}
 
+	// Remove the cookie path that prefixes the storage operation URL.
+	u := r.URL.Path
+	if c := s.config.getOperationPathPrefix(); c != "" {
+		if strings.HasPrefix(u, c+"/") == false {
+			return nil, fmt.Errorf(
+				"Path '%s' does not start with cookie path '%s'",
+				u,
+				s.config.CookiePath)
+		}
+		u = strings.TrimPrefix(u, c)
+	}
+
 	// Get the operation data from the request using the node to decrypt.
-	a := strings.Split(r.URL.Path, "/")
+	a := strings.Split(u, "/")
 	if len(a) < 2 {
 		return nil, fmt.Errorf(
 			"Path '%s' contains insufficient segments",
 			r.URL.Path)
 	}
+
+	// If configured verify that the table and the operation were created
+	// together before using them.
+	if s.config.OperationMAC {
+		m := r.URL.Query().Get(macParam)
+		if t.verifyMac(a[len(a)-2], a[len(a)-1], m) == false {
+			return nil, fmt.Errorf("Operation MAC invalid")
+		}
+	}
+
 	o, err := newOperationFromString(s, t, a[len(a)-1])
 	if err != nil {
 		return nil, err
@@ -214,6 +303,18 @@ func newOperationFromRequest(
 		return nil, err
 	}
 
+	// If the operation has already completed then respond with the completion
+	// response again rather than visiting the nodes of the network.
+	if s.replays != nil {
+		if c := s.replays.get(o); c != nil {
+			x := c.copyForReplay()
+			x.thisNode = o.thisNode
+			x.request = r
+			x.replay = true
+			return x, nil
+		}
+	}
+
 	// Get the network the current node is associated with.
 	o.network, err = s.store.getNodes(o.thisNode.network)
 	if err != nil {
@@ -232,32 +333,47 @@ func newOperationFromRequest(
 		// Default the resolved pair to the one from the operation.
 		o.resolved[i] = p
 
-		// Get the cookie if it exists for this pair.
-		c, err := r.Cookie(t.scramble(p.key))
+		// Get the cookie if it exists for this pair. If the scrambler of the
+		// node has been rotated then the cookie might still have the name
+		// from before the rotation.
+		c, err := r.Cookie(o.getCookieName(t, p.key))
+		if err != nil && t.previous != nil {
+			c, err = r.Cookie(o.getPreviousCookieName(t, p.key))
+		}
 		if err == nil && c != nil {
 
 			// Decrypt the cookie value, and if valid add it to the array of
 			// cookies and resolve any conflicts with the operations pair.
-			cp, err := t.getValueFromCookie(c)
+			cp, err := t.getValueFromCookie(c, s.config.MaxValueBytes)
 
 			// It is possible the cookie is corrupt and therefore the value
 			// should be ignored. Only log this situation in debug mode as the
 			// scenario is legitimate in production.
-			if s.config.Debug {
-				log.Println(err)
+			if err != nil {
+				s.config.debugf("SWIFT:%s\n", err.Error())
 			}
 
 			if cp != nil {
 
+				// Record if the value came from the home node so that it can
+				// be preferred over values from other nodes.
+				cp.home = t == o.HomeNode()
+
 				// Add to the array of cookie pairs.
 				o.cookiePairs = append(o.cookiePairs, cp)
 
 				// Resolve any conflict between the operation pair and the
 				// cookie pair. Use this value for further storage operations.
-				o.resolved[i], err = resolveConflict(p, cp)
+				o.resolved[i], err = s.resolveConflict(p, cp)
 				if err != nil {
 					return nil, err
 				}
+
+				// The cookie expiry, exists query flag and metadata flags are
+				// always those of the operation.
+				o.resolved[i].cookieExpires = p.cookieExpires
+				o.resolved[i].existsOnly = p.existsOnly
+				o.resolved[i].flags = p.flags
 			}
 		}
 	}
@@ -265,6 +381,40 @@ func newOperationFromRequest(
 	return o, err
 }
 
+// copyForReplay returns a copy of the completed operation o with copies of the
+// pairs so that responding to a replay does not change the cached operation
+// or race with other replays of the same operation.
+func (o *operation) copyForReplay() *operation {
+	x := *o
+	x.pairs = copyPairs(o.pairs)
+	x.requested = copyPairs(o.requested)
+	x.resolved = copyPairs(o.resolved)
+	x.cookiePairs = copyPairs(o.cookiePairs)
+	x.journey = append([]*journeyLeg(nil), o.journey...)
+	x.completed = make([]*networkPairs, len(o.completed))
+	for i, c := range o.completed {
+		x.completed[i] = &networkPairs{
+			network: c.network,
+			pairs:   copyPairs(c.pairs)}
+	}
+	return &x
+}
+
+// copyPairs returns a new array containing a copy of each of the pairs in a.
+func copyPairs(a []*pair) []*pair {
+	if a == nil {
+		return nil
+	}
+	c := make([]*pair, len(a))
+	for i, p := range a {
+		if p != nil {
+			n := *p
+			c[i] = &n
+		}
+	}
+	return c
+}
+
 // done returns true if all the nodes needed have been visited
 // The storage operation is complete id all the required nodes (nodeCount) have
 // been visited OR the current node is the same as the next node and more than
@@ -277,14 +427,15 @@ func (o *operation) done() bool {
 }
 
 // getCookiesValid confirms that the cookies that are present were written
-// within the home node timeout and are still valid. This can be used to
-// determine if the rest of the network needs to be checked for the storage
-// operation. If there is no cookie AND the value is not empty then the rest of
-// the network will need to be visited. If all the values are empty then cookies
-// can never be valid.
+// within the home node timeout for the table and are still valid. This can be
+// used to determine if the rest of the network needs to be checked for the
+// storage operation. If there is no cookie AND the value is not empty then the
+// rest of the network will need to be visited. If all the values are empty then
+// cookies can never be valid.
 func (o *operation) getCookiesValid() bool {
 	e := 0
-	t := time.Now().UTC()
+	n := o.services.clock.Now()
+	t := n
 	for _, p := range o.resolved {
 		c := o.getCookie(p)
 		if c != nil {
@@ -293,22 +444,46 @@ func (o *operation) getCookiesValid() bool {
 				t = c.cookieWriteTime
 			}
 		} else {
-			if p.isEmpty() {
+
+			// An exists query that has found the key elsewhere has no values
+			// but still needs the cookie on this node.
+			if p.isEmpty() && (p.existsOnly == false || p.present() == false) {
 				e++
 			} else {
 				return false
 			}
 		}
 	}
-	d := time.Now().UTC().Sub(t)
-	return d < o.services.config.HomeNodeTimeoutDuration() &&
+	d := n.Sub(t)
+	return d < o.services.config.HomeNodeTimeoutTableDuration(o.table) &&
 		e < len(o.resolved)
 }
 
-// getAnyCookiesPresent returns true if any cookies are present, otherwise
-// false.
+// getAnyCookiesPresent returns true if any cookies with the cookie prefix other
+// than the warning cookie are present, otherwise false.
 func (o *operation) getAnyCookiesPresent() bool {
-	return len(o.request.Cookies()) > 0
+	p := o.services.config.CookiePrefix
+	w := o.getPrefixedCookieName(warningCookieName)
+	for _, c := range o.request.Cookies() {
+		if strings.HasPrefix(c.Name, p) && c.Name != w {
+			return true
+		}
+	}
+	return false
+}
+
+// getWarningCount returns the number of warnings recorded in the warning
+// cookie, or zero if the cookie is not present or invalid.
+func (o *operation) getWarningCount() int {
+	c, err := o.request.Cookie(o.getPrefixedCookieName(warningCookieName))
+	if err != nil {
+		return 0
+	}
+	i, err := strconv.Atoi(c.Value)
+	if err != nil {
+		return 0
+	}
+	return i
 }
 
 // setValueInCookie writes a node cookie for the pair provided.
@@ -318,7 +493,7 @@ func (o *operation) setValueInCookie(
 	p *pair) error {
 	var b bytes.Buffer
 	var v []byte
-	err := writeTime(&b, time.Now().UTC())
+	err := writeTime(&b, o.services.clock.Now())
 	if err != nil {
 		return err
 	}
@@ -341,18 +516,55 @@ func (o *operation) setValueInCookie(
 		ss = http.SameSiteLaxMode
 	}
 	cookie := http.Cookie{
-		Name:     o.thisNode.scramble(p.key),
+		Name:     o.getCookieName(o.thisNode, p.key),
 		Domain:   o.getCookieDomain(),
 		Value:    base64.StdEncoding.EncodeToString(v),
-		Path:     fmt.Sprintf("/%s", o.thisNode.scramble(o.table)),
+		Path:     o.getCookiePath(),
 		SameSite: ss,
 		Secure:   s,
 		HttpOnly: true,
-		Expires:  p.expires}
+		Expires:  p.getCookieExpires()}
 	http.SetCookie(w, &cookie)
 	return nil
 }
 
+// getCookieName returns the name of the cookie used by node n to store the
+// value for key k. If a cookie path is configured then cookies for all tables
+// share the path and the table is included in the name to keep them separate.
+func (o *operation) getCookieName(n *node, k string) string {
+	return o.getCookieNameWith(n.scramble, k)
+}
+
+// getPreviousCookieName returns the name of the cookie used by node n to store
+// the value for key k before the scrambler of the node was last rotated.
+func (o *operation) getPreviousCookieName(n *node, k string) string {
+	return o.getCookieNameWith(n.scramblePrevious, k)
+}
+
+// getCookieNameWith returns the cookie name for key k using the scramble
+// function f.
+func (o *operation) getCookieNameWith(f func(string) string, k string) string {
+	if o.services.config.CookiePath != "" {
+		return o.getPrefixedCookieName(f(o.table + cookieTableSeparator + k))
+	}
+	return o.getPrefixedCookieName(f(k))
+}
+
+// getPrefixedCookieName returns the cookie name v with the configured cookie
+// prefix so that the cookies do not collide with others on the same domain.
+func (o *operation) getPrefixedCookieName(v string) string {
+	return o.services.config.CookiePrefix + v
+}
+
+// getCookiePath returns the path to use for the cookies that store values.
+// Either the configured cookie path or the scrambled table.
+func (o *operation) getCookiePath() string {
+	if o.services.config.CookiePath != "" {
+		return o.services.config.CookiePath
+	}
+	return fmt.Sprintf("/%s", o.thisNode.scramble(o.table))
+}
+
 // getCookieDomain returns the domain to be used when setting the cookie in the
 // response.
 func (o *operation) getCookieDomain() string {
@@ -391,10 +603,11 @@ func (o *operation) resolvePairs() ([]*pair, error) {
 		if c != nil {
 
 			// If there are two possible values then resolve the conflict.
-			r[i], err = resolveConflict(p, c)
+			r[i], err = o.services.resolveConflict(p, c)
 			if err != nil {
 				return nil, err
 			}
+			r[i].flags = p.flags
 		} else {
 
 			// These is no cookie so use the operation pair.
@@ -415,7 +628,7 @@ func (o *operation) asByteArray() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	err = writeString(&b, o.accessNode)
+	err = writeString(&b, strings.Join(o.accessNodes, accessNodeSeparator))
 	if err != nil {
 		return nil, err
 	}
@@ -448,11 +661,67 @@ func (o *operation) asByteArray() ([]byte, error) {
 		return nil, err
 	}
 	for _, v := range o.resolved {
+
+		// Exists queries only pass the presence of the key and timestamps to
+		// the next node.
+		if v.existsOnly {
+			v = v.withoutValues()
+		}
 		err = v.writeToBuffer(&b)
 		if err != nil {
 			return nil, err
 		}
 	}
+	err = writeJourney(&b, o)
+	if err != nil {
+		return nil, err
+	}
+	err = writeByte(&b, o.warnings)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range o.resolved {
+		err = writeDate(&b, v.getCookieExpires())
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, v := range o.resolved {
+		err = writeBool(&b, v.home)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, v := range o.resolved {
+		err = writeBool(&b, v.existsOnly)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, v := range o.resolved {
+		err = writeByte(&b, v.flags)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, v := range o.getJourneyPairs() {
+		err = writeBool(&b, v.existsOnly)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, v := range o.getJourneyPairs() {
+		err = writeDate(&b, v.getCookieExpires())
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, v := range o.getJourneyPairs() {
+		err = writeByte(&b, v.flags)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return b.Bytes(), nil
 }
 
@@ -470,10 +739,13 @@ func (o *operation) setFromByteArray(d []byte) error {
 	if err != nil {
 		return err
 	}
-	o.accessNode, err = readString(b)
+	a, err := readString(b)
 	if err != nil {
 		return err
 	}
+	if a != "" {
+		o.accessNodes = strings.Split(a, accessNodeSeparator)
+	}
 	err = o.HTML.set(b)
 	if err != nil {
 		return err
@@ -511,6 +783,70 @@ func (o *operation) setFromByteArray(d []byte) error {
 		}
 		o.pairs = append(o.pairs, &p)
 	}
+	err = readJourney(b, o)
+	if err != nil {
+		return err
+	}
+	o.warnings, err = readByte(b)
+	if err != nil {
+		return err
+	}
+	for _, p := range o.pairs {
+		p.cookieExpires, err = readDate(b)
+		if err != nil {
+			return err
+		}
+	}
+	for _, p := range o.pairs {
+		p.home, err = readBool(b)
+		if err != nil {
+			return err
+		}
+	}
+	for _, p := range o.pairs {
+		p.existsOnly, err = readBool(b)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Operations created before pair flags were added do not include them.
+	if b.Len() > 0 {
+		for _, p := range o.pairs {
+			p.flags, err = readByte(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Operations created before the journey pairs included the exists only
+	// flag do not include them.
+	if b.Len() > 0 {
+		for _, p := range o.getJourneyPairs() {
+			p.existsOnly, err = readBool(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Operations created before the journey pairs included the cookie expiry
+	// and flags do not include them.
+	if b.Len() > 0 {
+		for _, p := range o.getJourneyPairs() {
+			p.cookieExpires, err = readDate(b)
+			if err != nil {
+				return err
+			}
+		}
+		for _, p := range o.getJourneyPairs() {
+			p.flags, err = readByte(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
 	r := b.Bytes()
 	if len(r) != 0 {
 		err = fmt.Errorf("%d bytes remaining", len(r))
@@ -17,8 +17,14 @@
 package swift
 
 import (
+	"encoding/base64"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestOperation(t *testing.T) {
@@ -39,6 +45,8 @@ func TestOperation(t *testing.T) {
 	c := newConfigurationTest()
 	s := NewServices(c, NewStorageService(c, v), a, r)
 	o1 := newOperation(s, nil)
+	o1.warnings = 3
+	o1.accessNodes = []string{"test-1.com", "test-2.com"}
 	b, err := o1.asByteArray()
 	if err != nil {
 		fmt.Println(err)
@@ -99,4 +107,428 @@ func TestOperation(t *testing.T) {
 		t.Fail()
 		return
 	}
+	if strings.Join(o1.accessNodes, ",") != strings.Join(o2.accessNodes, ",") {
+		fmt.Println(o1.accessNodes)
+		fmt.Println(o2.accessNodes)
+		t.Fail()
+		return
+	}
+	if o1.warnings != o2.warnings {
+		fmt.Println(o1.warnings)
+		fmt.Println(o2.warnings)
+		t.Fail()
+		return
+	}
+}
+
+func TestOperationCookiePath(t *testing.T) {
+	s, err := newCreateHomeNodeTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	s.config.CookiePath = "/"
+	h := s.store.getNode("storage-1.com")
+	k := "Test>" + time.Now().UTC().AddDate(1, 0, 0).Format("2006-01-02")
+	p, err := createPair(k, "path-value", 0, time.Now().UTC())
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+
+	// The cookie uses the configured path and a name that includes the table.
+	o := newOperation(s, h)
+	o.homeNode = h.domain
+	o.table = "t"
+	o.network, err = s.store.getNodes(h.network)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	o.resolved = []*pair{p}
+	o.request = httptest.NewRequest("GET", "http://storage-1.com/", nil)
+	w := httptest.NewRecorder()
+	err = o.setValueInCookie(w, o.request, p)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	c := w.Result().Cookies()
+	if len(c) != 1 || c[0].Path != "/" || c[0].Name == h.scramble(p.key) {
+		fmt.Println("Cookie not written with the configured path")
+		t.Fail()
+		return
+	}
+
+	// The value is found for the same table but not for a different table.
+	for _, x := range []struct {
+		table string
+		found bool
+	}{{"t", true}, {"other", false}} {
+		o.table = x.table
+		b, err := o.asByteArray()
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		e, err := h.encode(b)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		r := httptest.NewRequest(
+			"GET",
+			"http://storage-1.com/"+h.scramble(x.table)+"/"+
+				base64.RawURLEncoding.EncodeToString(e),
+			nil)
+		r.AddCookie(c[0])
+		n, err := newOperationFromRequest(s, httptest.NewRecorder(), r)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		if (len(n.cookiePairs) == 1) != x.found {
+			fmt.Printf("Cookie found '%v' for table '%s'\n",
+				len(n.cookiePairs) == 1,
+				x.table)
+			t.Fail()
+		}
+	}
+}
+
+// TestOperationCookiePathPrefix confirms that storage operation URLs are
+// prefixed with the cookie path so that the browser sends the cookies, and
+// that the prefix is removed when the operation is read.
+func TestOperationCookiePathPrefix(t *testing.T) {
+	s, err := newCreateHomeNodeTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	s.config.CookiePath = "/swift/"
+	h := s.store.getNode("storage-1.com")
+	o := newOperation(s, h)
+	o.homeNode = h.domain
+	o.table = "t"
+	o.nextNode = h
+	o.network, err = s.store.getNodes(h.network)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	u, err := o.getNextURL()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if strings.HasPrefix(u.Path, s.config.CookiePath) == false {
+		fmt.Printf("Path '%s' not within the cookie path\n", u.Path)
+		t.Fail()
+		return
+	}
+	n, err := newOperationFromRequest(
+		s,
+		httptest.NewRecorder(),
+		httptest.NewRequest("GET", u.String(), nil))
+	if err != nil || n.table != "t" {
+		fmt.Printf("Operation not read from prefixed path '%v'\n", err)
+		t.Fail()
+	}
+
+	// A path outside the cookie path is rejected.
+	u.Path = strings.TrimPrefix(u.Path, "/swift")
+	_, err = newOperationFromRequest(
+		s,
+		httptest.NewRecorder(),
+		httptest.NewRequest("GET", u.String(), nil))
+	if err == nil {
+		fmt.Println("Path outside the cookie path accepted")
+		t.Fail()
+	}
+}
+
+func TestOperationMAC(t *testing.T) {
+	n, err := newStoreQueueTestNode("storage.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	c := newConfigurationTest()
+	c.OperationMAC = true
+	s := NewServices(
+		c,
+		NewStorageService(c, newVolatile("test", false, []*node{n})),
+		nil,
+		nil)
+	o := newOperation(s, n)
+	o.table = "t"
+	o.nextNode = n
+	o.request = httptest.NewRequest("GET", "http://storage.com/", nil)
+	u, err := o.getNextURL()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if u.Query().Get(macParam) == "" {
+		fmt.Println("No MAC in next URL")
+		t.Fail()
+		return
+	}
+	_, err = newOperationFromRequest(
+		s,
+		httptest.NewRecorder(),
+		httptest.NewRequest("GET", u.String(), nil))
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+
+	// The operation can not be used with a different table or without the
+	// MAC.
+	a := strings.Split(u.Path, "/")
+	a[len(a)-2] = n.scramble("other")
+	x := *u
+	x.Path = strings.Join(a, "/")
+	y := *u
+	y.RawQuery = ""
+	for _, v := range []string{x.String(), y.String()} {
+		_, err = newOperationFromRequest(
+			s,
+			httptest.NewRecorder(),
+			httptest.NewRequest("GET", v, nil))
+		if err == nil {
+			fmt.Printf("Operation accepted for URL '%s'\n", v)
+			t.Fail()
+		}
+	}
+}
+
+func TestOperationCookiePrefix(t *testing.T) {
+	s, err := newCreateHomeNodeTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	s.config.CookiePrefix = "swift-"
+	h := s.store.getNode("storage-1.com")
+	k := "Test>" + time.Now().UTC().AddDate(1, 0, 0).Format("2006-01-02")
+	p, err := createPair(k, "prefix-value", 0, time.Now().UTC())
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+
+	// The value and warning cookies are written with the prefix.
+	o := newOperation(s, h)
+	o.homeNode = h.domain
+	o.table = "t"
+	o.network, err = s.store.getNodes(h.network)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	o.resolved = []*pair{p}
+	o.request = httptest.NewRequest("GET", "http://storage-1.com/", nil)
+	w := httptest.NewRecorder()
+	err = o.setValueInCookie(w, o.request, p)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	o.setWarningCookie(s, w, 1)
+	c := w.Result().Cookies()
+	if len(c) != 2 ||
+		c[0].Name != "swift-"+h.scramble(p.key) ||
+		c[1].Name != "swift-"+warningCookieName {
+		fmt.Println("Cookies not written with the configured prefix")
+		t.Fail()
+		return
+	}
+
+	// Cookies without the prefix are not considered present.
+	o.request.AddCookie(&http.Cookie{Name: "first-party", Value: "v"})
+	o.request.AddCookie(c[1])
+	if o.getAnyCookiesPresent() || o.getWarningCount() != 1 {
+		fmt.Println("Cookies without the prefix treated as present")
+		t.Fail()
+	}
+
+	// The value is read from the prefixed cookie.
+	b, err := o.asByteArray()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	e, err := h.encode(b)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	r := httptest.NewRequest(
+		"GET",
+		"http://storage-1.com/"+h.scramble(o.table)+"/"+
+			base64.RawURLEncoding.EncodeToString(e),
+		nil)
+	r.AddCookie(c[0])
+	n, err := newOperationFromRequest(s, httptest.NewRecorder(), r)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if len(n.cookiePairs) != 1 {
+		fmt.Println("Value not read from the prefixed cookie")
+		t.Fail()
+	}
+}
+
+func TestOperationNextURLRewriter(t *testing.T) {
+	n, err := newStoreQueueTestNode("storage.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	c := newConfigurationTest()
+	s := NewServices(
+		c,
+		NewStorageService(c, newVolatile("test", false, []*node{n})),
+		nil,
+		nil)
+	o := newOperation(s, n)
+	o.table = "t"
+	o.nextNode = n
+	o.request = httptest.NewRequest("GET", "http://storage.com/", nil)
+	d, err := o.getNextURL()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	var x string
+	s.SetNextURLRewriter(func(u *url.URL, domain string) *url.URL {
+		x = domain
+		u.Scheme = "https"
+		u.Host = "public.example.com"
+		return u
+	})
+	u, err := o.getNextURL()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if x != "storage.com" ||
+		u.Scheme != "https" ||
+		u.Host != "public.example.com" ||
+		strings.Split(u.Path, "/")[0] != strings.Split(d.Path, "/")[0] {
+		fmt.Printf("Next URL '%s' not rewritten\n", u)
+		t.Fail()
+	}
+
+	// A rewriter that returns nil leaves the original URL unchanged.
+	s.SetNextURLRewriter(func(u *url.URL, domain string) *url.URL {
+		u.Host = "changed.example.com"
+		return nil
+	})
+	u, err = o.getNextURL()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if u == nil || u.Host != d.Host || u.Scheme != d.Scheme {
+		fmt.Printf("Next URL '%v' not the original '%s'\n", u, d)
+		t.Fail()
+	}
+}
+
+// TestOperationHomeNodeMissing confirms that an operation without a home node
+// in a network with no active nodes responds with a server error rather than
+// panicking.
+func TestOperationHomeNodeMissing(t *testing.T) {
+	n, err := newResultCompressTestNode()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	c := newConfigurationTest()
+	s := NewServices(
+		c,
+		NewStorageService(c, newVolatile("test", false, []*node{n})),
+		nil,
+		nil)
+	o := newOperation(s, n)
+	o.network = newNodes()
+	o.homeNode = "missing.com"
+	if o.HomeNode() != nil {
+		fmt.Println("Home node returned for an empty network")
+		t.Fail()
+		return
+	}
+	o.request = httptest.NewRequest("GET", "http://access.com/", nil)
+	w := httptest.NewRecorder()
+	o.storeWarning(s, w, o.request)
+	if w.Code != http.StatusInternalServerError {
+		fmt.Printf("Status '%d' returned\n", w.Code)
+		t.Fail()
+	}
+}
+
+// TestOperationPreviousCookieName confirms that the cookie name used before the
+// scrambler of the node was rotated can still be found after the rotation.
+func TestOperationPreviousCookieName(t *testing.T) {
+	n, err := newStoreQueueTestNode("rotate.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	c := newConfigurationTest()
+	s := NewServices(
+		c,
+		NewStorageService(c, newVolatile("test", false, []*node{n})),
+		nil,
+		nil)
+	o := newOperation(s, n)
+	o.table = "table"
+	if o.getPreviousCookieName(n, "key") != c.CookiePrefix {
+		fmt.Println("Previous cookie name returned before rotation")
+		t.Fail()
+	}
+	v := o.getCookieName(n, "key")
+	err = n.RotateScrambler()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if o.getCookieName(n, "key") == v {
+		fmt.Println("Cookie name unchanged after rotation")
+		t.Fail()
+	}
+	if o.getPreviousCookieName(n, "key") != v {
+		fmt.Println("Cookie name before rotation not found")
+		t.Fail()
+	}
 }
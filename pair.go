@@ -19,6 +19,7 @@ package swift
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
@@ -29,6 +30,10 @@ const (
 	conflictOldest  = iota
 	conflictNewest  = iota
 	conflictAdd     = iota
+	// Newest wins and on a tie the lexicographically greater value wins
+	conflictNewestValue = iota
+	// A newer operation value wins, then the home node value, then the newest
+	conflictHomeWins = iota
 )
 
 // An empty pair referenced in the resolveConflict method if both parameters are
@@ -41,13 +46,34 @@ type Pair struct {
 	created time.Time // The UTC time that the value was created
 	expires time.Time // The UTC time that the value will expire
 	values  [][]byte  // The values as byte arrays
+	// The duration clients should trust the value for before querying the
+	// network again. Zero if not set.
+	clientTTL time.Duration
+	// The structured form of each value if a value parser is configured for
+	// the key. Nil if no values could be parsed.
+	parsed []interface{}
+	// True if a value for the key was found in the network. Used with exists
+	// queries where the values are not returned.
+	exists bool
+	// Metadata flags set when the operation was created. See PairFlagSensitive.
+	flags byte
 }
 
+// Flags that can be set on a pair to provide metadata to consumers of the
+// results.
+const (
+	// PairFlagSensitive indicates the values of the pair should not be logged.
+	PairFlagSensitive byte = 1 << iota
+)
+
 // pair used internally and adds more information for the operation.
 type pair struct {
 	Pair
 	conflict        byte      // Flag for conflict resolution
 	cookieWriteTime time.Time // Last time the cookie was written to
+	cookieExpires   time.Time // Expiry of the cookie if sooner than expires
+	home            bool      // True if the value came from the home node
+	existsOnly      bool      // True if only the presence of the key is needed
 }
 
 // Key readonly accessor to the pair's key.
@@ -62,6 +88,23 @@ func (p *Pair) Expires() time.Time { return p.expires }
 // Value readonly accessor to the pair's value.
 func (p *Pair) Values() [][]byte { return p.values }
 
+// Exists true if a value for the key was found in the network. For exists
+// queries the values are not returned and this is the only way to determine if
+// the key is present.
+func (p *Pair) Exists() bool { return p.exists }
+
+// ClientTTL readonly accessor to the duration clients should trust the value
+// for before querying the network again. Independent of the expiry time of the
+// stored value. Zero if not set in which case the expiry time applies.
+func (p *Pair) ClientTTL() time.Duration { return p.clientTTL }
+
+// Flags readonly accessor to the pair's metadata flags.
+func (p *Pair) Flags() byte { return p.flags }
+
+// Sensitive true if the pair has been flagged as sensitive and the values
+// should not be logged.
+func (p *Pair) Sensitive() bool { return p.flags&PairFlagSensitive != 0 }
+
 // Value returns the value as string. Used with HTML templates or JSON
 // serialization.
 func (p *Pair) Value() string {
@@ -72,6 +115,41 @@ func (p *Pair) Value() string {
 	return strings.Join(s, "\r\n")
 }
 
+// MarshalJSON marshals a pair to JSON without having to expose the fields in
+// the pair struct. This is achieved by converting a pair to a map.
+func (p *Pair) MarshalJSON() ([]byte, error) {
+	m := map[string]interface{}{
+		"key":       p.key,
+		"created":   p.created,
+		"expires":   p.expires,
+		"clientTTL": int64(p.clientTTL.Seconds()),
+		"exists":    p.exists,
+		"flags":     p.flags,
+		"values":    p.values}
+	if p.parsed != nil {
+		m["parsed"] = p.parsed
+	}
+	return json.Marshal(m)
+}
+
+// parse sets the structured form of the values using the parser provided.
+// Values that can not be parsed are left as raw values only.
+func (p *Pair) parse(v ValueParser) {
+	p.parsed = nil
+	a := make([]interface{}, len(p.values))
+	f := false
+	for i, b := range p.values {
+		d, err := v.Parse(b)
+		if err == nil {
+			a[i] = d
+			f = true
+		}
+	}
+	if f {
+		p.parsed = a
+	}
+}
+
 // Conflict returns conflict policy as a string. Used with HTML templates.
 func (p *pair) Conflict() string {
 	switch p.conflict {
@@ -83,6 +161,10 @@ func (p *pair) Conflict() string {
 		return "oldest"
 	case conflictAdd:
 		return "add"
+	case conflictNewestValue:
+		return "newest-value"
+	case conflictHomeWins:
+		return "home"
 	}
 	return ""
 }
@@ -136,12 +218,44 @@ func (p *pair) writeToBuffer(b *bytes.Buffer) error {
 	return nil
 }
 
+// getCookieExpires returns the time that the cookie for the pair should expire.
+// This is the cookie expiry if one was provided and it is before the pair
+// expiry, otherwise the pair expiry.
+func (p *pair) getCookieExpires() time.Time {
+	if p.cookieExpires.IsZero() == false &&
+		p.cookieExpires.Before(p.expires) {
+		return p.cookieExpires
+	}
+	return p.expires
+}
+
+// withoutValues returns a copy of the pair without the values. Used for exists
+// queries so that only the presence of the key and its timestamps are passed
+// between nodes.
+func (p *pair) withoutValues() *pair {
+	n := *p
+	n.values = nil
+	return &n
+}
+
+// withMaxValues returns the pair p if it has no more than m values, otherwise a
+// copy of the pair with only the first m values. Returns true if values were
+// removed. If m is zero then there is no limit.
+func (p *pair) withMaxValues(m int) (*pair, bool) {
+	if m <= 0 || len(p.values) <= m {
+		return p, false
+	}
+	n := *p
+	n.values = p.values[:m:m]
+	return &n, true
+}
+
 func (p *pair) present() bool {
 	return p.created.IsZero() == false
 }
 
-func (p *pair) isValid() bool {
-	return p.expires.After(time.Now().UTC())
+func (p *pair) isValid(c Clock) bool {
+	return p.expires.After(c.Now())
 }
 
 // isEmpty treats any pair without any values as empty. A pair with values, but
@@ -173,8 +287,12 @@ func (p *pair) equals(o *pair) bool {
 }
 
 // Performs a distinct merge of the values in the two pairs. Duplicates are
-// removed.
-func mergeValues(o *pair, c *pair) [][]byte {
+// removed. The values from the operation pair o are placed before those only
+// found in the cookie pair c so that values carried forward from earlier merges
+// are at the end of the list. If there are more than m values then those at
+// the end are removed and true is returned. If m is zero then there is no
+// limit.
+func mergeValues(o *pair, c *pair, m int) ([][]byte, bool) {
 
 	// Make an array of values that has sufficient capacity to support all
 	// the values.
@@ -199,24 +317,31 @@ func mergeValues(o *pair, c *pair) [][]byte {
 			v = append(v, a)
 		}
 	}
-	return v
+	if m > 0 && len(v) > m {
+		return v[:m], true
+	}
+	return v, false
 }
 
-func mergePairs(o *pair, c *pair) *pair {
+// mergePairs returns a new pair created at time t with the values of the pairs
+// o and c merged if the values differ, otherwise the pair c. The returned pair
+// has no more than m values and true is returned if values were removed.
+func mergePairs(o *pair, c *pair, m int, t time.Time) (*pair, bool) {
 	if valuesEqual(o.values, c.values) == false {
 		var n pair
 		n.conflict = conflictAdd
-		n.created = time.Now().UTC()
+		n.created = t
 		if o.expires.After(c.expires) {
 			n.expires = o.expires
 		} else {
 			n.expires = c.expires
 		}
 		n.key = o.key
-		n.values = mergeValues(o, c)
-		return &n
+		var r bool
+		n.values, r = mergeValues(o, c, m)
+		return &n, r
 	}
-	return c
+	return c.withMaxValues(m)
 }
 
 func valuesEqual(a [][]byte, b [][]byte) bool {
@@ -257,26 +382,82 @@ func resolveConflictNewest(o *pair, c *pair) *pair {
 	return c
 }
 
+// resolveConflictHomeWins returns the pair from the operation if it is newer
+// than the stored pair so that new values replace existing ones. Otherwise the
+// pair that came from the home node is returned. If neither or both came from
+// the home node then the newest pair is returned.
+func resolveConflictHomeWins(o *pair, c *pair) *pair {
+	if o.created.After(c.created) {
+		return o
+	}
+	if o.home && !c.home {
+		return o
+	}
+	if c.home && !o.home {
+		return c
+	}
+	return resolveConflictNewest(o, c)
+}
+
+// resolveConflictNewestValue returns the newest pair. If both pairs were created
+// at the same time then the pair with the lexicographically greater value is
+// returned so that the result is the same on every node.
+func resolveConflictNewestValue(o *pair, c *pair) *pair {
+	if o.created.After(c.created) {
+		return o
+	}
+	if c.created.After(o.created) {
+		return c
+	}
+	if bytes.Compare(c.firstValue(), o.firstValue()) > 0 {
+		return c
+	}
+	return o
+}
+
+// firstValue returns the first value of the pair or nil if there are no values.
+func (p *pair) firstValue() []byte {
+	if len(p.values) > 0 {
+		return p.values[0]
+	}
+	return nil
+}
+
 // Where there are two pairs for the same key determine which one should be used
 // for the next operation in the storage operation.
 // o is the pair from the storage operation
 // c is the pair stored in a cookie for the current node
-func resolveConflict(o *pair, c *pair) (*pair, error) {
+// m is the maximum number of values for pairs that add values, or zero for no
+// limit
+// t is the current time used as the created time of merged pairs
+// Returns true if values were removed from the resolved pair to honour m.
+func resolveConflict(
+	o *pair,
+	c *pair,
+	m int,
+	t time.Time) (*pair, bool, error) {
 	var p *pair
+	var r bool
 	if o == nil && c == nil {
 		// Neither has any information.
 		p = &emptyValue
 	} else if o != nil && c == nil {
 		// o is the only valid pair.
 		p = o
+		if o.conflict == conflictAdd {
+			p, r = o.withMaxValues(m)
+		}
 	} else if o == nil && c != nil {
 		// c is the only valid pair.
 		p = c
+		if c.conflict == conflictAdd {
+			p, r = c.withMaxValues(m)
+		}
 	} else {
 		// Resolve any conflict using o's conflict flag.
 		switch o.conflict {
 		case conflictInvalid:
-			return nil, fmt.Errorf("Conflict flag is not initialized")
+			return nil, false, fmt.Errorf("Conflict flag is not initialized")
 		case conflictNewest:
 			p = resolveConflictNewest(o, c)
 			break
@@ -284,12 +465,18 @@ func resolveConflict(o *pair, c *pair) (*pair, error) {
 			p = resolveConflictOldest(o, c)
 			break
 		case conflictAdd:
-			p = mergePairs(o, c)
+			p, r = mergePairs(o, c, m, t)
+			break
+		case conflictNewestValue:
+			p = resolveConflictNewestValue(o, c)
+			break
+		case conflictHomeWins:
+			p = resolveConflictHomeWins(o, c)
 			break
 		default:
 			p = o
 			break
 		}
 	}
-	return p, nil
+	return p, r, nil
 }
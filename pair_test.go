@@ -18,7 +18,10 @@ package swift
 
 import (
 	"bytes"
+	"encoding/base64"
 	"fmt"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -63,3 +66,560 @@ func TestPair(t *testing.T) {
 	testCompareDate(t, a.created, b.created)
 	testCompareDate(t, a.expires, b.expires)
 }
+
+func TestPairMaxValueBytes(t *testing.T) {
+	k := "Test>" + time.Now().UTC().AddDate(1, 0, 0).Format("2006-01-02")
+	_, err := createPair(k, "Hello World", 11, time.Now().UTC())
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	_, err = createPair(k, "Hello World", 10, time.Now().UTC())
+	if err == nil {
+		fmt.Println("value larger than the limit was accepted")
+		t.Fail()
+		return
+	}
+	_, err = createPair(k, "Hello World", 0, time.Now().UTC())
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+}
+
+func TestPairNewestValue(t *testing.T) {
+	k := "Test>>" + time.Now().UTC().AddDate(1, 0, 0).Format("2006-01-02")
+	a, err := createPair(k, "apple", 0, time.Now().UTC())
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if a.key != "Test" || a.conflict != conflictNewestValue {
+		fmt.Printf("Key '%s' conflict '%s'\n", a.key, a.Conflict())
+		t.Fail()
+		return
+	}
+	b, err := createPair(k, "banana", 0, time.Now().UTC())
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+
+	// Identical created times pick the greater value regardless of order or
+	// cookie write time.
+	b.created = a.created
+	a.cookieWriteTime = time.Now().UTC()
+	for _, p := range [][]*pair{{a, b}, {b, a}} {
+		r, _, err := resolveConflict(p[0], p[1], 0, time.Now().UTC())
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		if string(r.values[0]) != "banana" {
+			fmt.Printf("Value '%s' not 'banana'\n", r.values[0])
+			t.Fail()
+		}
+	}
+
+	// Otherwise the newest value wins.
+	a.created = b.created.Add(time.Second)
+	r, _, err := resolveConflict(b, a, 0, time.Now().UTC())
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if string(r.values[0]) != "apple" {
+		fmt.Printf("Value '%s' not 'apple'\n", r.values[0])
+		t.Fail()
+	}
+
+	// The conflict policy survives serialization.
+	var out bytes.Buffer
+	err = a.writeToBuffer(&out)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	var c pair
+	err = c.setFromBuffer(bytes.NewBuffer(out.Bytes()))
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if c.conflict != conflictNewestValue || c.Conflict() != "newest-value" {
+		fmt.Printf("Conflict '%s' not 'newest-value'\n", c.Conflict())
+		t.Fail()
+	}
+}
+
+func TestPairCookieExpires(t *testing.T) {
+	e := time.Now().UTC().AddDate(1, 0, 0).Format("2006-01-02")
+	c := time.Now().UTC().AddDate(0, 0, 7).Format("2006-01-02")
+	p, err := createPair("Test>"+e+">"+c, "Hello", 0, time.Now().UTC())
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if p.key != "Test" ||
+		p.expires.Format("2006-01-02") != e ||
+		p.getCookieExpires().Format("2006-01-02") != c {
+		fmt.Printf("Key '%s' expires '%s' cookie expires '%s'\n",
+			p.key,
+			p.expires,
+			p.getCookieExpires())
+		t.Fail()
+		return
+	}
+
+	// The cookie expiry must not be after the key expiry.
+	_, err = createPair("Test>"+c+">"+e, "Hello", 0, time.Now().UTC())
+	if err == nil {
+		fmt.Println("Cookie expiry after key expiry accepted")
+		t.Fail()
+	}
+
+	// Without a cookie expiry the key expiry is used.
+	p, err = createPair("Test>"+e, "Hello", 0, time.Now().UTC())
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if p.getCookieExpires() != p.expires {
+		fmt.Println("Cookie expiry not key expiry")
+		t.Fail()
+	}
+}
+
+func TestPairCookieExpiresOperation(t *testing.T) {
+	e := time.Now().UTC().AddDate(1, 0, 0).Format("2006-01-02")
+	c := time.Now().UTC().AddDate(0, 0, 7).Format("2006-01-02")
+	p, err := createPair("Test>"+e+">"+c, "Hello", 0, time.Now().UTC())
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	n, err := newResultCompressTestNode()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	cfg := newConfigurationTest()
+	s := NewServices(
+		cfg,
+		NewStorageService(cfg, newVolatile("test", false, []*node{n})),
+		nil,
+		nil)
+
+	// The cookie expiry survives serialization of the operation.
+	o1 := newOperation(s, n)
+	o1.resolved = []*pair{p}
+	b, err := o1.asByteArray()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	o2, err := newOperationFromByteArray(s, n, b)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if len(o2.pairs) != 1 ||
+		o2.pairs[0].expires.Format("2006-01-02") != e ||
+		o2.pairs[0].cookieExpires.Format("2006-01-02") != c {
+		fmt.Println("Cookie expiry not restored from operation")
+		t.Fail()
+		return
+	}
+
+	// The cookie is written with the cookie expiry.
+	o2.table = "t"
+	o2.request = httptest.NewRequest("GET", "http://access.com/", nil)
+	w := httptest.NewRecorder()
+	err = o2.setValueInCookie(w, o2.request, o2.pairs[0])
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	x := w.Result().Cookies()
+	if len(x) != 1 || x[0].Expires.Format("2006-01-02") != c {
+		fmt.Println("Cookie not written with cookie expiry")
+		t.Fail()
+	}
+}
+
+func TestPairHomeWins(t *testing.T) {
+	k := "Test^" + time.Now().UTC().AddDate(1, 0, 0).Format("2006-01-02")
+	a, err := createPair(k, "home-value", 0, time.Now().UTC())
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if a.key != "Test" || a.conflict != conflictHomeWins {
+		fmt.Printf("Key '%s' conflict '%s'\n", a.key, a.Conflict())
+		t.Fail()
+		return
+	}
+	b, err := createPair(k, "remote", 0, time.Now().UTC())
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+
+	// The home value wins even though the stored remote value is newer.
+	a.home = true
+	b.created = a.created.Add(time.Hour)
+	r, _, err := resolveConflict(a, b, 0, time.Now().UTC())
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if string(r.values[0]) != "home-value" {
+		fmt.Printf("Value '%s' not 'home-value'\n", r.values[0])
+		t.Fail()
+	}
+
+	// A newer value from the operation replaces the stored home value.
+	r, _, err = resolveConflict(b, a, 0, time.Now().UTC())
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if string(r.values[0]) != "remote" {
+		fmt.Printf("Value '%s' not 'remote'\n", r.values[0])
+		t.Fail()
+	}
+
+	// An older value from the operation does not replace the home value.
+	b.created = a.created.Add(-time.Hour)
+	r, _, err = resolveConflict(b, a, 0, time.Now().UTC())
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if string(r.values[0]) != "home-value" {
+		fmt.Printf("Value '%s' not 'home-value'\n", r.values[0])
+		t.Fail()
+	}
+
+	// Without a home value the newest wins.
+	a.home = false
+	b.created = a.created.Add(time.Hour)
+	r, _, err = resolveConflict(a, b, 0, time.Now().UTC())
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if string(r.values[0]) != "remote" {
+		fmt.Printf("Value '%s' not 'remote'\n", r.values[0])
+		t.Fail()
+	}
+}
+
+func TestPairHomeWinsOperation(t *testing.T) {
+	s, err := newCreateHomeNodeTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	h := s.store.getNode("storage-1.com")
+	k := "Test^" + time.Now().UTC().AddDate(1, 0, 0).Format("2006-01-02")
+	p, err := createPair(k, "home-value", 0, time.Now().UTC())
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	o := newOperation(s, h)
+	o.homeNode = h.domain
+	o.table = "t"
+	o.network, err = s.store.getNodes(h.network)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	o.resolved = []*pair{p}
+
+	// Write the home value to a cookie on the home node and read it back as
+	// the home node would.
+	o.request = httptest.NewRequest("GET", "http://storage-1.com/", nil)
+	w := httptest.NewRecorder()
+	err = o.setValueInCookie(w, o.request, p)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	b, err := o.asByteArray()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	e, err := h.encode(b)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	r := httptest.NewRequest(
+		"GET",
+		"http://storage-1.com/"+h.scramble(o.table)+"/"+
+			base64.RawURLEncoding.EncodeToString(e),
+		nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	x, err := newOperationFromRequest(s, httptest.NewRecorder(), r)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if len(x.resolved) != 1 || x.resolved[0].home == false {
+		fmt.Println("Value from home node cookie not marked as home")
+		t.Fail()
+		return
+	}
+
+	// The home flag survives serialization to the next node.
+	b, err = x.asByteArray()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	var y operation
+	err = y.setFromByteArray(b)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if len(y.pairs) != 1 || y.pairs[0].home == false {
+		fmt.Println("Home flag not serialized")
+		t.Fail()
+	}
+}
+
+func TestPairExists(t *testing.T) {
+	p, err := createPair("Test~", "", 0, time.Now().UTC())
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if p.key != "Test" || p.existsOnly == false || p.isEmpty() == false {
+		fmt.Printf("Key '%s' not an exists query\n", p.key)
+		t.Fail()
+	}
+	e := time.Now().UTC().AddDate(1, 0, 0).Format("2006-01-02")
+	_, err = createPair("Test~"+e, "Hello", 0, time.Now().UTC())
+	if err == nil {
+		fmt.Println("Exists query with a value accepted")
+		t.Fail()
+	}
+}
+
+func TestPairExistsOperation(t *testing.T) {
+	s, err := newCreateHomeNodeTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	h := s.store.getNode("storage-1.com")
+	k := "Test>" + time.Now().UTC().AddDate(1, 0, 0).Format("2006-01-02")
+	v, err := createPair(k, "stored-value", 0, time.Now().UTC())
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	p, err := createPair("Test~", "", 0, time.Now().UTC())
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	o := newOperation(s, h)
+	o.homeNode = h.domain
+	o.table = "t"
+	o.network, err = s.store.getNodes(h.network)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	o.resolved = []*pair{p}
+
+	// Write a value to a cookie on the node and then read it back with the
+	// exists query.
+	o.request = httptest.NewRequest("GET", "http://storage-1.com/", nil)
+	w := httptest.NewRecorder()
+	err = o.setValueInCookie(w, o.request, v)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	b, err := o.asByteArray()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	d, err := h.encode(b)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	r := httptest.NewRequest(
+		"GET",
+		"http://storage-1.com/"+h.scramble(o.table)+"/"+
+			base64.RawURLEncoding.EncodeToString(d),
+		nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	x, err := newOperationFromRequest(s, httptest.NewRecorder(), r)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if len(x.resolved) != 1 || x.resolved[0].existsOnly == false {
+		fmt.Println("Exists query flag not kept after resolving the cookie")
+		t.Fail()
+		return
+	}
+
+	// The presence and timestamps, but not the value, are passed to the next
+	// node.
+	b, err = x.asByteArray()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	var y operation
+	err = y.setFromByteArray(b)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if len(y.pairs) != 1 ||
+		y.pairs[0].existsOnly == false ||
+		y.pairs[0].present() == false ||
+		y.pairs[0].isEmpty() == false {
+		fmt.Println("Exists query not serialized without the value")
+		t.Fail()
+	}
+
+	// The exists flag survives the encoding of the results.
+	e := newResultsTest(time.Now().UTC().Add(time.Minute))
+	e.pairs[0].exists = true
+	c, err := encodeResults(e)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	a, err := DecodeResults(c)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if a.pairs[0].Exists() == false || a.pairs[1].Exists() {
+		fmt.Println("Exists flag not serialized in results")
+		t.Fail()
+	}
+}
+
+func TestPairFlags(t *testing.T) {
+	p, err := createPair("a>2099-01-01", "value", 0, time.Now().UTC())
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	p.flags |= PairFlagSensitive
+	var o operation
+	o.resolved = []*pair{p}
+	b, err := o.asByteArray()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+
+	// The flags survive serialization to the next node.
+	var x operation
+	err = x.setFromByteArray(b)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if len(x.pairs) != 1 || x.pairs[0].Sensitive() == false {
+		fmt.Println("Flags not serialized")
+		t.Fail()
+	}
+
+	// Operations without the flags can still be read.
+	var y operation
+	err = y.setFromByteArray(b[:len(b)-1])
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if len(y.pairs) != 1 || y.pairs[0].flags != 0 {
+		fmt.Println("Operation without flags not read")
+		t.Fail()
+	}
+}
+
+// TestPairSecondOperator confirms that a key with a second operator before the
+// date is rejected and that a newest wins key with a greatest value tie break
+// is not.
+func TestPairSecondOperator(t *testing.T) {
+	e := time.Now().UTC().AddDate(1, 0, 0).Format("2006-01-02")
+	for _, k := range []string{"Test<+", "Test>>>", "Test+^", "Test+~"} {
+		_, err := createPair(k+e, "Hello", 0, time.Now().UTC())
+		if err == nil || strings.Contains(err.Error(), "only one") == false {
+			fmt.Printf("Key '%s' with a second operator accepted\n", k)
+			t.Fail()
+		}
+	}
+	_, err := createPair("Test>>"+e, "Hello", 0, time.Now().UTC())
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+	}
+}
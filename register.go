@@ -23,26 +23,48 @@ import (
 
 // Register contains HTML template data used to register a node with the network
 type Register struct {
-	Services      *Services
-	StoreNames    []string
-	Store         string
-	Domain        string
-	Network       string
-	Starts        time.Time
-	Expires       time.Time
-	Role          int
-	Scramble      bool
-	Secret        bool
-	CookieDomain  string
-	Error         string
-	NetworkError  string
-	ExpiresError  string
-	StartsError   string
-	StoreError    string
-	RoleError     string
-	ReadOnly      bool
-	DisplayErrors bool
-	request       *http.Request
+	Services          *Services
+	StoreNames        []string
+	Store             string
+	Domain            string
+	Network           string
+	Starts            time.Time
+	Expires           time.Time
+	Role              int
+	Scramble          bool
+	Secret            bool
+	CookieDomain      string
+	Token             string
+	Error             string
+	NetworkError      string
+	ExpiresError      string
+	StartsError       string
+	StoreError        string
+	RoleError         string
+	CookieDomainError string
+	TokenError        string
+	ReadOnly          bool
+	DisplayErrors     bool
+	request           *http.Request
+}
+
+// setRegisterRequest sets the fields of the registration from the request.
+func (r *Register) setRegisterRequest(q *RegisterRequest) {
+	r.Store = q.Store
+	r.Network = q.Network
+	r.Role = q.Role
+	r.Starts = q.Starts
+	r.Expires = q.Expires
+	r.CookieDomain = q.CookieDomain
+	r.Secret = q.Secret
+	r.Scramble = q.Scramble
+	r.Token = q.Token
+}
+
+// TokenRequired returns true if a one time setup token must be provided to
+// register the node.
+func (r *Register) TokenRequired() bool {
+	return r.Services.config.RegisterTokenRequired
 }
 
 // ExpiresString returns the expires date as a string
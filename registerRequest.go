@@ -0,0 +1,151 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RegisterRequest contains the typed fields of a request to register a node.
+// Used by both the HTML form and the JSON register handlers.
+type RegisterRequest struct {
+	Store        string    `json:"store"`        // Name of the store for the node
+	Network      string    `json:"network"`      // Name of the network
+	Role         int       `json:"role"`         // Role of the node
+	Starts       time.Time `json:"starts"`       // Time the node starts
+	Expires      time.Time `json:"expires"`      // Time the node expires
+	CookieDomain string    `json:"cookieDomain"` // Domain for the cookies
+	Secret       bool      `json:"secret"`       // True to add a secret
+	Scramble     bool      `json:"scramble"`     // True to scramble tables
+	Token        string    `json:"token"`        // One time setup token
+}
+
+// registerRequestError contains the validation errors for the fields of a
+// register request. Empty fields are valid.
+type registerRequestError struct {
+	network string
+	role    string
+	expires string
+	starts  string
+}
+
+func (e *registerRequestError) Error() string {
+	var a []string
+	for _, v := range []string{e.network, e.role, e.expires, e.starts} {
+		if v != "" {
+			a = append(a, v)
+		}
+	}
+	return strings.Join(a, ". ")
+}
+
+// newRegisterRequest returns a register request with the default values for
+// a node with the domain provided.
+func newRegisterRequest(domain string) *RegisterRequest {
+	var q RegisterRequest
+	q.Starts = time.Now().UTC().AddDate(0, 0, 1)
+	q.Expires = time.Now().UTC().AddDate(0, 3, 0)
+	q.Role = roleStorage
+	q.Secret = true
+	q.Scramble = true
+	q.CookieDomain = domain
+	return &q
+}
+
+// parseRegisterRequest returns the register request from the form values of
+// the HTTP request. If any of the fields are invalid a registerRequestError is
+// returned alongside the request. Any other error means the form could not be
+// parsed.
+func parseRegisterRequest(r *http.Request) (*RegisterRequest, error) {
+	var e registerRequestError
+	q := newRegisterRequest(r.Host)
+
+	err := r.ParseForm()
+	if err != nil {
+		return nil, err
+	}
+
+	q.Store = r.FormValue("store")
+	q.Network = r.FormValue("network")
+	q.Token = r.FormValue("token")
+
+	// Get the role information.
+	if v := r.FormValue("role"); v != "" {
+		q.Role, err = strconv.Atoi(v)
+		if err != nil {
+			e.role = err.Error()
+		}
+	}
+
+	// Get the node expiry information.
+	if v := r.FormValue("expires"); v != "" {
+		q.Expires, err = time.Parse("2006-01-02", v)
+		if err != nil {
+			e.expires = err.Error()
+		}
+	}
+
+	// Get the node starts information.
+	if v := r.FormValue("starts"); v != "" {
+		q.Starts, err = time.Parse("2006-01-02T15:04", v)
+		if err != nil {
+			e.starts = err.Error()
+		}
+	}
+
+	// Get the secrets, scramble and cookie domain. The check boxes are only
+	// present in the form when checked.
+	if v := r.FormValue("cookieDomain"); v != "" {
+		q.CookieDomain = v
+	}
+	q.Secret = parseFormBool(r.FormValue("secret"))
+	q.Scramble = parseFormBool(r.FormValue("scramble"))
+
+	return q, q.validate(&e)
+}
+
+// validate normalizes the network and records any invalid fields that have not
+// already been recorded in e. Returns e if any field is invalid.
+func (q *RegisterRequest) validate(e *registerRequestError) error {
+	q.Network = normalizeNetwork(q.Network)
+	err := validateNetwork(q.Network)
+	if err != nil {
+		e.network = err.Error()
+	}
+	if e.role == "" &&
+		q.Role != roleAccess &&
+		q.Role != roleStorage &&
+		q.Role != roleShare {
+		e.role = fmt.Sprintf("Role '%d' invalid", q.Role)
+	}
+	if e.expires == "" && q.Expires.Before(time.Now().UTC()) {
+		e.expires = "Expiry date must be in the future"
+	}
+	if e.Error() != "" {
+		return e
+	}
+	return nil
+}
+
+// parseFormBool returns true if the form value v is "true", "yes" or "1".
+func parseFormBool(v string) bool {
+	return v == "true" || v == "yes" || v == "1"
+}
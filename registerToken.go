@@ -0,0 +1,206 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// registerToken is a one time token issued by an operator that permits a
+// domain to register as a node in the network and role provided.
+type registerToken struct {
+	Network string    `json:"network"` // The network the node can register with
+	Role    int       `json:"role"`    // The role the node can register with
+	Expires time.Time `json:"expires"` // The time after which the token is invalid
+	pending bool      // True whilst a registration is using the token
+}
+
+// registerTokens is a concurrency safe collection of register tokens keyed on a
+// hash of the token value so that the values are not held in memory or in the
+// file after they have been issued. Tokens are removed once they have been used
+// or have expired. If a file is provided then the tokens are persisted to it so
+// that they survive a restart.
+type registerTokens struct {
+	tokens map[string]*registerToken
+	file   string // File used to persist the tokens, or empty
+	mutex  *sync.Mutex
+}
+
+// newRegisterTokens creates a new collection of tokens. If the file f is not
+// empty then any tokens persisted to it are loaded.
+func newRegisterTokens(f string) (*registerTokens, error) {
+	var t registerTokens
+	t.tokens = make(map[string]*registerToken)
+	t.file = f
+	t.mutex = &sync.Mutex{}
+	if f != "" {
+		data, err := ioutil.ReadFile(f)
+		if err != nil && os.IsNotExist(err) == false {
+			return nil, err
+		}
+		if len(data) > 0 {
+			err = json.Unmarshal(data, &t.tokens)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &t, nil
+}
+
+// issue creates a new random token for the network, role and expiry time
+// provided and returns the token value.
+func (t *registerTokens) issue(
+	network string,
+	role int,
+	expires time.Time) (string, error) {
+	b, err := randomBytes(32)
+	if err != nil {
+		return "", err
+	}
+	v := base64.RawURLEncoding.EncodeToString(b)
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.prune()
+	t.tokens[hashRegisterToken(v)] = &registerToken{
+		Network: network,
+		Role:    role,
+		Expires: expires}
+	err = t.save()
+	if err != nil {
+		delete(t.tokens, hashRegisterToken(v))
+		return "", err
+	}
+	return v, nil
+}
+
+// validate returns an error if the token v can not be used to register a node
+// for the network and role provided.
+func (t *registerTokens) validate(v string, network string, role int) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	_, err := t.check(v, network, role)
+	return err
+}
+
+// reserve marks the token v as in use by a registration so that a concurrent
+// request can not present it. The registration must then call consume if the
+// node was stored or release if it was not. Returns an error if the token is
+// not valid for the network and role.
+func (t *registerTokens) reserve(v string, network string, role int) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	k, err := t.check(v, network, role)
+	if err != nil {
+		return err
+	}
+	k.pending = true
+	return nil
+}
+
+// release makes the token v reserved by a registration that failed available
+// again.
+func (t *registerTokens) release(v string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if k := t.tokens[hashRegisterToken(v)]; k != nil {
+		k.pending = false
+	}
+}
+
+// consume removes the token v once the node registered with it has been stored
+// so that it can not be presented again.
+func (t *registerTokens) consume(v string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.tokens, hashRegisterToken(v))
+	return t.save()
+}
+
+// check returns the token v if it is valid for the network and role. Expired
+// tokens are removed before the check. Must be called with the mutex held.
+func (t *registerTokens) check(
+	v string,
+	network string,
+	role int) (*registerToken, error) {
+	if v == "" {
+		return nil, fmt.Errorf("Setup token required")
+	}
+	t.prune()
+	k := t.tokens[hashRegisterToken(v)]
+	if k == nil {
+		return nil, fmt.Errorf("Setup token invalid or already used")
+	}
+	if k.pending {
+		return nil, fmt.Errorf("Setup token in use")
+	}
+	if k.Network != network {
+		return nil, fmt.Errorf(
+			"Setup token not valid for network '%s'",
+			network)
+	}
+	if k.Role != role {
+		return nil, fmt.Errorf("Setup token not valid for role '%d'", role)
+	}
+	return k, nil
+}
+
+// prune removes the tokens that have expired. The file is only rewritten if a
+// token was removed. Must be called with the mutex held.
+func (t *registerTokens) prune() {
+	n := time.Now().UTC()
+	c := len(t.tokens)
+	for h, k := range t.tokens {
+		if k.Expires.Before(n) {
+			delete(t.tokens, h)
+		}
+	}
+	if len(t.tokens) != c {
+		t.save()
+	}
+}
+
+// save writes the tokens to the file if one is configured. The file is only
+// readable by the owner. Must be called with the mutex held.
+func (t *registerTokens) save() error {
+	if t.file == "" {
+		return nil
+	}
+	data, err := json.Marshal(t.tokens)
+	if err != nil {
+		return err
+	}
+	f := t.file + ".tmp"
+	err = ioutil.WriteFile(f, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(f, t.file)
+}
+
+// hashRegisterToken returns the key used to store the token v.
+func hashRegisterToken(v string) string {
+	h := sha256.Sum256([]byte(v))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
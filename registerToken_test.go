@@ -0,0 +1,249 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegisterTokenValid(t *testing.T) {
+	s, v, err := newRegisterTokenTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	k, err := s.IssueRegisterToken(
+		"network",
+		roleStorage,
+		time.Now().UTC().AddDate(0, 0, 1))
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	testRegisterWithToken(s, "new-1.com", k)
+	n, err := v.getNode("new-1.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if n == nil {
+		fmt.Println("Node with valid token not registered")
+		t.Fail()
+		return
+	}
+	err = s.tokens.validate(k, "network", roleStorage)
+	if err == nil {
+		fmt.Println("Token not consumed after registration")
+		t.Fail()
+	}
+}
+
+func TestRegisterTokenReused(t *testing.T) {
+	s, v, err := newRegisterTokenTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	k, err := s.IssueRegisterToken(
+		"network",
+		roleStorage,
+		time.Now().UTC().AddDate(0, 0, 1))
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	testRegisterWithToken(s, "new-1.com", k)
+	testRegisterWithToken(s, "new-2.com", k)
+	n, err := v.getNode("new-2.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if n != nil {
+		fmt.Println("Node registered with consumed token")
+		t.Fail()
+	}
+}
+
+func TestRegisterTokenMissing(t *testing.T) {
+	s, v, err := newRegisterTokenTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	testRegisterWithToken(s, "new-1.com", "")
+	n, err := v.getNode("new-1.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if n != nil {
+		fmt.Println("Node registered without token")
+		t.Fail()
+	}
+}
+
+func newRegisterTokenTest() (*Services, *Volatile, error) {
+	v, err := newVolatileTest()
+	if err != nil {
+		return nil, nil, err
+	}
+	c := newConfigurationTest()
+	c.RegisterTokenRequired = true
+	s := NewServices(c, NewStorageService(c, v), NewAccessSimple(nil), nil)
+	return s, v, nil
+}
+
+func testRegisterWithToken(s *Services, d string, k string) {
+	q := url.Values{}
+	q.Set("store", "test")
+	q.Set("network", "network")
+	q.Set("role", fmt.Sprintf("%d", roleStorage))
+	q.Set("token", k)
+	r := httptest.NewRequest(
+		"GET",
+		"http://"+d+"/swift/register?"+q.Encode(),
+		nil)
+	HandlerRegister(s)(httptest.NewRecorder(), r)
+}
+
+func TestRegisterTokenStoreFailed(t *testing.T) {
+	v, err := newVolatileTest()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	c := newConfigurationTest()
+	c.RegisterTokenRequired = true
+	s := NewServices(
+		c,
+		NewStorageService(c, v, newVolatile("read-only", true, nil)),
+		NewAccessSimple(nil),
+		nil)
+	k, err := s.IssueRegisterToken(
+		"network",
+		roleStorage,
+		time.Now().UTC().AddDate(0, 0, 1))
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+
+	// The token remains valid if the node could not be stored.
+	d := Register{
+		Services: s,
+		Domain:   "new-1.com",
+		Network:  "network",
+		Role:     roleStorage,
+		Store:    "read-only",
+		Token:    k,
+		Starts:   time.Now().UTC(),
+		Expires:  time.Now().UTC().AddDate(1, 0, 0)}
+	storeNode(s, &d)
+	if d.StoreError == "" {
+		fmt.Println("Node stored in read only store")
+		t.Fail()
+		return
+	}
+	err = s.tokens.validate(k, "network", roleStorage)
+	if err != nil {
+		fmt.Printf("Token consumed by failed registration '%s'\n", err)
+		t.Fail()
+	}
+}
+
+func TestRegisterTokenPersisted(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "tokens.json")
+	a, err := newRegisterTokens(f)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	k, err := a.issue("network", roleStorage, time.Now().UTC().AddDate(0, 0, 1))
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	e, err := a.issue("network", roleStorage, time.Now().UTC().Add(time.Second))
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	b, err := ioutil.ReadFile(f)
+	if err != nil || strings.Contains(string(b), k) {
+		fmt.Println("Token value persisted")
+		t.Fail()
+	}
+
+	// The tokens are available after a restart.
+	b2, err := newRegisterTokens(f)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	for _, v := range []string{k, e} {
+		if b2.validate(v, "network", roleStorage) != nil {
+			fmt.Println("Token not available after restart")
+			t.Fail()
+		}
+	}
+
+	// Expired and used tokens are removed.
+	b2.tokens[hashRegisterToken(e)].Expires = time.Now().UTC().Add(-time.Second)
+	err = b2.reserve(k, "network", roleStorage)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	err = b2.consume(k)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if len(b2.tokens) != 0 {
+		fmt.Printf("Tokens '%d' not removed\n", len(b2.tokens))
+		t.Fail()
+	}
+	b3, err := newRegisterTokens(f)
+	if err != nil || len(b3.tokens) != 0 {
+		fmt.Println("Removed tokens still persisted")
+		t.Fail()
+	}
+}
@@ -0,0 +1,91 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"container/list"
+	"sync"
+)
+
+// defaultReplayCacheSize is the number of completed operations remembered if
+// no other size is configured.
+const defaultReplayCacheSize = 1000
+
+// replayCache is a concurrency safe least recently used cache of completed
+// storage operations. Used to respond to a repeated request for an operation
+// that has already completed with the completion response rather than visiting
+// the nodes of the network again.
+type replayCache struct {
+	size  int                      // Maximum number of operations
+	order *list.List               // Keys with the most recently used first
+	items map[string]*list.Element // Elements of order keyed on operation key
+	mutex *sync.Mutex
+}
+
+// replayItem is the value of an element in the order list.
+type replayItem struct {
+	key string     // Key returned from getReplayKey
+	o   *operation // The completed operation
+}
+
+func newReplayCache(size int) *replayCache {
+	var c replayCache
+	c.size = size
+	c.order = list.New()
+	c.items = make(map[string]*list.Element)
+	c.mutex = &sync.Mutex{}
+	return &c
+}
+
+// getReplayKey returns the key for the operation made up of the time stamp,
+// return URL and table which together identify the storage operation.
+func getReplayKey(o *operation) string {
+	return o.timeStamp.String() + "\n" + o.returnURL + "\n" + o.table
+}
+
+// add records the completed operation removing the least recently used
+// operation if the cache is full.
+func (c *replayCache) add(o *operation) {
+	k := getReplayKey(o)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if e := c.items[k]; e != nil {
+		e.Value.(*replayItem).o = o
+		c.order.MoveToFront(e)
+		return
+	}
+	c.items[k] = c.order.PushFront(&replayItem{k, o})
+	for c.order.Len() > c.size {
+		e := c.order.Back()
+		c.order.Remove(e)
+		delete(c.items, e.Value.(*replayItem).key)
+	}
+}
+
+// get returns the completed operation with the same key as the operation
+// provided, or nil if the operation has not completed or has been removed.
+func (c *replayCache) get(o *operation) *operation {
+	k := getReplayKey(o)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	e := c.items[k]
+	if e == nil {
+		return nil
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*replayItem).o
+}
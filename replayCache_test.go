@@ -0,0 +1,254 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"compress/gzip"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReplayCacheEviction(t *testing.T) {
+	c := newReplayCache(2)
+	var a []*operation
+	for i := 0; i < 3; i++ {
+		var o operation
+		o.timeStamp = time.Now().UTC().Add(time.Duration(i) * time.Second)
+		o.returnURL = "http://return.com/"
+		o.table = "t"
+		a = append(a, &o)
+	}
+	c.add(a[0])
+	c.add(a[1])
+	if c.get(a[0]) != a[0] {
+		fmt.Println("Completed operation not found")
+		t.Fail()
+	}
+	c.add(a[2])
+	if c.get(a[1]) != nil {
+		fmt.Println("Least recently used operation not removed")
+		t.Fail()
+	}
+	if c.get(a[0]) != a[0] || c.get(a[2]) != a[2] {
+		fmt.Println("Recently used operation removed")
+		t.Fail()
+	}
+}
+
+func TestReplayCacheOperation(t *testing.T) {
+	for _, e := range []bool{false, true} {
+		h, v, err := testReplayOperation(e)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		if e && (h != 1 || v != 0) {
+			fmt.Printf("Replay completed '%d' times with '%d' cookies\n", h, v)
+			t.Fail()
+		}
+		if e == false && h != 2 {
+			fmt.Printf("Operation completed '%d' times without cache\n", h)
+			t.Fail()
+		}
+	}
+}
+
+// TestReplayCacheCopy checks that changes to a replayed operation do not change
+// the cached operation.
+func TestReplayCacheCopy(t *testing.T) {
+	var o operation
+	var p pair
+	p.key = "a"
+	p.values = [][]byte{[]byte("A")}
+	o.resolved = []*pair{&p}
+	o.completed = []*networkPairs{{network: "b", pairs: []*pair{&p}}}
+	var w sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		w.Add(1)
+		go func() {
+			defer w.Done()
+			x := o.copyForReplay()
+			x.resolved[0].clientTTL = 1
+			x.resolved[0].exists = true
+			x.completed[0].pairs[0].exists = true
+			x.completed = append(x.completed, &networkPairs{network: "c"})
+		}()
+	}
+	w.Wait()
+	if p.clientTTL != 0 || p.exists || len(o.completed) != 1 {
+		fmt.Println("Replay changed the cached operation")
+		t.Fail()
+	}
+}
+
+// testReplayOperation follows a storage operation until it returns to the
+// caller and then requests the first URL of the operation again. Returns the
+// number of times the operation completed and the number of cookies set by
+// the repeated request.
+func testReplayOperation(e bool) (int, int, error) {
+	var s *Services
+	h := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			HandlerEncrypt(s)(w, r)
+		}))
+	defer h.Close()
+	u, err := url.Parse(h.URL)
+	if err != nil {
+		return 0, 0, err
+	}
+	var a []*node
+	for _, d := range []struct {
+		domain string
+		role   int
+	}{
+		{u.Host, roleAccess},
+		{"storage-1.com", roleStorage},
+		{"storage-2.com", roleStorage}} {
+		n, err := newNode(
+			"network",
+			d.domain,
+			time.Now().UTC(),
+			time.Now().UTC().Add(-time.Minute),
+			time.Now().UTC().AddDate(1, 0, 0),
+			d.role,
+			"",
+			"")
+		if err != nil {
+			return 0, 0, err
+		}
+		x, err := newSecret()
+		if err != nil {
+			return 0, 0, err
+		}
+		n.addSecret(x)
+		a = append(a, n)
+	}
+	c := newConfigurationTest()
+	c.Debug = false
+	c.Scheme = "http"
+	c.NodeCount = 2
+	c.StorageOperationTimeout = 60
+	c.ReplayCache = e
+	s = NewServices(
+		c,
+		NewStorageService(c, newVolatile("test", false, a)),
+		NewAccessSimple(nil),
+		nil)
+	k := 0
+	s.SetOnComplete(func(o Operation) error {
+		k++
+		return nil
+	})
+
+	q := url.Values{}
+	q.Set("table", "t")
+	q.Set("returnUrl", "http://return.com/")
+	q.Set("a>"+time.Now().UTC().AddDate(1, 0, 0).Format("2006-01-02"),
+		"home-value")
+	f, err := Create(s, u.Host, q)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Follow the operation until it returns to the caller.
+	j, err := cookiejar.New(nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	n := f
+	for i := 0; i < 10 && strings.HasPrefix(n, "http://return.com/") == false; i++ {
+		b, _, err := testReplayStep(s, j, n)
+		if err != nil {
+			return 0, 0, err
+		}
+		m := testNextURLRegex.FindStringSubmatch(b)
+		if m == nil {
+			return 0, 0, fmt.Errorf("No next URL in response from '%s'", n)
+		}
+		n = html.UnescapeString(m[1])
+	}
+	if k != 1 {
+		return 0, 0, fmt.Errorf("Operation did not complete")
+	}
+
+	// Request the first URL again.
+	b, v, err := testReplayStep(s, j, f)
+	if err != nil {
+		return 0, 0, err
+	}
+	m := testNextURLRegex.FindStringSubmatch(b)
+	if e && (m == nil ||
+		strings.HasPrefix(html.UnescapeString(m[1]), "http://return.com/") ==
+			false) {
+		return 0, 0, fmt.Errorf("Replay did not return to the caller")
+	}
+	if e == false {
+		n = html.UnescapeString(m[1])
+		for i := 0; i < 10 &&
+			strings.HasPrefix(n, "http://return.com/") == false; i++ {
+			b, _, err = testReplayStep(s, j, n)
+			if err != nil {
+				return 0, 0, err
+			}
+			m = testNextURLRegex.FindStringSubmatch(b)
+			if m == nil {
+				return 0, 0, fmt.Errorf(
+					"No next URL in response from '%s'", n)
+			}
+			n = html.UnescapeString(m[1])
+		}
+	}
+	return k, v, nil
+}
+
+// testReplayStep requests the URL n from the store handler with the cookies
+// from the jar j and returns the HTML response and the number of cookies set.
+func testReplayStep(
+	s *Services,
+	j *cookiejar.Jar,
+	n string) (string, int, error) {
+	u, err := url.Parse(n)
+	if err != nil {
+		return "", 0, err
+	}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", n, nil)
+	for _, c := range j.Cookies(u) {
+		r.AddCookie(c)
+	}
+	HandlerStore(s, nil)(w, r)
+	j.SetCookies(u, w.Result().Cookies())
+	g, err := gzip.NewReader(w.Result().Body)
+	if err != nil {
+		return "", 0, err
+	}
+	b, err := ioutil.ReadAll(g)
+	if err != nil {
+		return "", 0, err
+	}
+	return string(b), len(w.Result().Cookies()), nil
+}
@@ -18,6 +18,7 @@ package swift
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"strings"
 	"time"
@@ -26,17 +27,51 @@ import (
 // Character used to separate state elements.
 const resultSeparator = "\r"
 
+// The version of the data that follows the pairs in the results byte array.
+// Version 1 contains the client TTL for each pair followed by the results for
+// each network. Version 2 adds a flag for each pair indicating if the key
+// exists. Version 3 adds a flag indicating if the results are partial. Version
+// 4 adds the metadata flags for each pair. Results without this data are
+// treated as version 0.
+const resultsVersion byte = 4
+
 // Results from a storage operation.
 type Results struct {
 	HTML              // Include the common HTML UI members.
 	expires time.Time // The time after which the data can not be decrypted
 	pairs   []*Pair   // Array of key value pairs
 	state   []string  // Optional state information
+	// Results for each network of a multi network operation
+	networks []*NetworkResults
+	// True if the operation ran out of time before all the nodes were visited
+	partial bool
+}
+
+// NetworkResults are the key value pairs from one of the networks visited as
+// part of a multi network operation.
+type NetworkResults struct {
+	network string  // The name of the network
+	pairs   []*Pair // Array of key value pairs from the network
 }
 
+// Network readonly accessor to the name of the network.
+func (n *NetworkResults) Network() string { return n.network }
+
+// Pairs readonly accessor to the key value pairs from the network.
+func (n *NetworkResults) Pairs() []*Pair { return n.pairs }
+
+// Networks readonly accessor to the results from each network visited as part
+// of a multi network operation. Pairs contains the values merged across all
+// the networks. Empty if only one network was visited.
+func (r *Results) Networks() []*NetworkResults { return r.networks }
+
 // Pairs readonly accessor to the results's key value pairs.
 func (r *Results) Pairs() []*Pair { return r.pairs }
 
+// Partial true if the operation ran out of time before all the nodes were
+// visited and the results only contain the values collected so far.
+func (r *Results) Partial() bool { return r.partial }
+
 // State readonly accessor to the results's state array.
 func (r *Results) State() []string { return r.state }
 
@@ -60,18 +95,127 @@ func (r *Results) Map() map[string]*Pair {
 	return p
 }
 
+// Filter returns the pairs with a key that starts with the prefix provided.
+func (r *Results) Filter(prefix string) []*Pair {
+	var a []*Pair
+	for _, p := range r.pairs {
+		if strings.HasPrefix(p.key, prefix) {
+			a = append(a, p)
+		}
+	}
+	return a
+}
+
+// Keys returns the keys of all the pairs in the results.
+func (r *Results) Keys() []string {
+	k := make([]string, len(r.pairs))
+	for i, p := range r.pairs {
+		k[i] = p.key
+	}
+	return k
+}
+
+// MergeResults combines the results of several storage operations into one.
+// Pairs with the same key are de-duplicated keeping the one with the newest
+// created time. Results do not record the table the pairs were stored in so
+// pairs with the same key from different tables are treated as the same key
+// and only the newest is kept. Use distinct keys, or a key prefix for each
+// table, where the values must be kept apart. The merged results expire at the
+// earliest expiry of the inputs, the state is the union of the state values in
+// the order first seen, and the results are partial if any input is partial.
+// The HTML parameters and network results are taken from the first input.
+// Nil inputs are ignored.
+func MergeResults(rs ...*Results) *Results {
+	var m Results
+	k := make(map[string]int)
+	s := make(map[string]bool)
+	f := true
+	for _, r := range rs {
+		if r == nil {
+			continue
+		}
+		if f {
+			m.HTML = r.HTML
+			m.expires = r.expires
+			m.networks = r.networks
+			f = false
+		} else if r.expires.Before(m.expires) {
+			m.expires = r.expires
+		}
+		for _, p := range r.pairs {
+			if i, ok := k[p.key]; ok {
+				if p.created.After(m.pairs[i].created) {
+					m.pairs[i] = p
+				}
+			} else {
+				k[p.key] = len(m.pairs)
+				m.pairs = append(m.pairs, p)
+			}
+		}
+		for _, v := range r.state {
+			if s[v] == false {
+				s[v] = true
+				m.state = append(m.state, v)
+			}
+		}
+		m.partial = m.partial || r.partial
+	}
+	return &m
+}
+
+// MarshalJSON marshals the results to JSON including the HTML parameters, the
+// expiry time, the state and the pairs.
+func (r *Results) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"Title":           r.HTML.Title,
+		"Message":         r.HTML.Message,
+		"BackgroundColor": r.HTML.BackgroundColor,
+		"MessageColor":    r.HTML.MessageColor,
+		"ProgressColor":   r.HTML.ProgressColor,
+		"expires":         r.expires,
+		"state":           r.state,
+		"partial":         r.partial,
+		"pairs":           r.pairs})
+}
+
+// getClientTTL returns the duration a client should trust a value that expires
+// at e for given the configured client TTL of s seconds. The duration is never
+// longer than the time until the value expires at the current time t. Zero if
+// s is zero.
+func getClientTTL(s int, e time.Time, t time.Time) time.Duration {
+	if s <= 0 {
+		return 0
+	}
+	d := time.Duration(s) * time.Second
+	u := e.Sub(t)
+	if u < d {
+		if u < 0 {
+			return 0
+		}
+		return u.Truncate(time.Second)
+	}
+	return d
+}
+
 // IsTimeStampValid returns true if the time stamp of the result is valid.
 func (r *Results) IsTimeStampValid() bool {
 	return time.Now().UTC().Before(r.expires)
 }
 
-// DecodeResults turns a byte array into a results data structure.
+// DecodeResults turns a byte array into a results data structure. The byte
+// array can be packed with a compression marker or unpacked.
 func DecodeResults(d []byte) (*Results, error) {
 	var err error
 	var r Results
 	if d == nil {
 		return nil, errors.New("Byte array empty")
 	}
+	if isPackedResults(d) {
+		d, err = unpackResults(d)
+		if err != nil {
+			return nil, err
+		}
+	}
 	b := bytes.NewBuffer(d)
 	r.expires, err = readTime(b)
 	if err != nil {
@@ -90,24 +234,50 @@ func DecodeResults(d []byte) (*Results, error) {
 	if err != nil {
 		return nil, err
 	}
-	for i := byte(0); i < n; i++ {
-		k, err := readString(b)
+	r.pairs, err = readResultPairs(b, n)
+	if err != nil {
+		return nil, err
+	}
+	if b.Len() > 0 {
+		v, err := readByte(b)
 		if err != nil {
 			return nil, err
 		}
-		c, err := readDate(b)
-		if err != nil {
-			return nil, err
+		if v >= 1 {
+			for _, p := range r.pairs {
+				t, err := readUint32(b)
+				if err != nil {
+					return nil, err
+				}
+				p.clientTTL = time.Duration(t) * time.Second
+			}
+			r.networks, err = readNetworkResults(b)
+			if err != nil {
+				return nil, err
+			}
 		}
-		e, err := readDate(b)
-		if err != nil {
-			return nil, err
+		if v >= 2 {
+			for _, p := range r.pairs {
+				p.exists, err = readBool(b)
+				if err != nil {
+					return nil, err
+				}
+			}
 		}
-		v, err := readByteArrayArray(b)
-		if err != nil {
-			return nil, err
+		if v >= 3 {
+			r.partial, err = readBool(b)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if v >= 4 {
+			for _, p := range r.pairs {
+				p.flags, err = readByte(b)
+				if err != nil {
+					return nil, err
+				}
+			}
 		}
-		r.pairs = append(r.pairs, &Pair{k, c, e, v})
 	}
 	return &r, nil
 }
@@ -131,23 +301,135 @@ func encodeResults(r *Results) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	for _, e := range r.pairs {
-		err = writeString(&b, e.key)
+	err = writeResultPairs(&b, r.pairs)
+	if err != nil {
+		return nil, err
+	}
+	err = writeByte(&b, resultsVersion)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range r.pairs {
+		err = writeUint32(&b, uint32(p.clientTTL.Seconds()))
 		if err != nil {
 			return nil, err
 		}
-		err = writeDate(&b, e.created)
+	}
+	err = writeNetworkResults(&b, r.networks)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range r.pairs {
+		err = writeBool(&b, p.exists)
 		if err != nil {
 			return nil, err
 		}
-		err = writeDate(&b, e.expires)
+	}
+	err = writeBool(&b, r.partial)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range r.pairs {
+		err = writeByte(&b, p.flags)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b.Bytes(), nil
+}
+
+func writeResultPairs(b *bytes.Buffer, a []*Pair) error {
+	for _, e := range a {
+		err := writeString(b, e.key)
+		if err != nil {
+			return err
+		}
+		err = writeDate(b, e.created)
+		if err != nil {
+			return err
+		}
+		err = writeDate(b, e.expires)
+		if err != nil {
+			return err
+		}
+		err = writeByteArrayArray(b, e.values)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readResultPairs(b *bytes.Buffer, n byte) ([]*Pair, error) {
+	var a []*Pair
+	for i := byte(0); i < n; i++ {
+		k, err := readString(b)
 		if err != nil {
 			return nil, err
 		}
-		err = writeByteArrayArray(&b, e.values)
+		c, err := readDate(b)
 		if err != nil {
 			return nil, err
 		}
+		e, err := readDate(b)
+		if err != nil {
+			return nil, err
+		}
+		v, err := readByteArrayArray(b)
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, &Pair{key: k, created: c, expires: e, values: v})
 	}
-	return b.Bytes(), nil
+	return a, nil
+}
+
+// writeNetworkResults appends the results for each network of a multi network
+// operation after the merged pairs. Only the count is written if this is not a
+// multi network operation.
+func writeNetworkResults(b *bytes.Buffer, a []*NetworkResults) error {
+	err := writeByte(b, byte(len(a)))
+	if err != nil {
+		return err
+	}
+	for _, n := range a {
+		err = writeString(b, n.network)
+		if err != nil {
+			return err
+		}
+		err = writeByte(b, byte(len(n.pairs)))
+		if err != nil {
+			return err
+		}
+		err = writeResultPairs(b, n.pairs)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readNetworkResults(b *bytes.Buffer) ([]*NetworkResults, error) {
+	c, err := readByte(b)
+	if err != nil {
+		return nil, err
+	}
+	var a []*NetworkResults
+	for i := byte(0); i < c; i++ {
+		var n NetworkResults
+		n.network, err = readString(b)
+		if err != nil {
+			return nil, err
+		}
+		l, err := readByte(b)
+		if err != nil {
+			return nil, err
+		}
+		n.pairs, err = readResultPairs(b, l)
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, &n)
+	}
+	return a, nil
 }
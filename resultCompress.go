@@ -0,0 +1,87 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Marker at the start of results that have been packed with packResults. The
+// byte after the marker identifies the compression used for the rest of the
+// results. Results without the marker are compressed by the node encoding.
+var resultsMarker = []byte("SWR")
+
+// Identifiers for the compression used with packed results.
+const (
+	resultsCompressionNone = iota
+	resultsCompressionZlib = iota
+	resultsCompressionGzip = iota
+)
+
+// packResults compresses the encoded results b with the compression mode named
+// and prefixes them with the marker and compression identifier.
+func packResults(b []byte, name string) ([]byte, error) {
+	var i byte
+	switch name {
+	case compressionNone:
+		i = resultsCompressionNone
+	case compressionZlib:
+		i = resultsCompressionZlib
+	case compressionGzip:
+		i = resultsCompressionGzip
+	default:
+		return nil, fmt.Errorf("Results compression '%s' invalid", name)
+	}
+	c, err := NewCompressor(name)
+	if err != nil {
+		return nil, err
+	}
+	d, err := c.Compress(b)
+	if err != nil {
+		return nil, err
+	}
+	o := make([]byte, 0, len(resultsMarker)+1+len(d))
+	o = append(o, resultsMarker...)
+	o = append(o, i)
+	return append(o, d...), nil
+}
+
+// isPackedResults returns true if the byte array starts with the marker.
+func isPackedResults(b []byte) bool {
+	return len(b) > len(resultsMarker) && bytes.HasPrefix(b, resultsMarker)
+}
+
+// unpackResults returns the encoded results from the packed byte array using
+// the compression identified after the marker.
+func unpackResults(b []byte) ([]byte, error) {
+	if isPackedResults(b) == false {
+		return nil, fmt.Errorf("Results are not packed")
+	}
+	d := b[len(resultsMarker)+1:]
+	switch b[len(resultsMarker)] {
+	case resultsCompressionNone:
+		return (&noneCompressor{}).Decompress(d)
+	case resultsCompressionZlib:
+		return (&zlibCompressor{}).Decompress(d)
+	case resultsCompressionGzip:
+		return (&gzipCompressor{}).Decompress(d)
+	}
+	return nil, fmt.Errorf(
+		"Results compression '%d' invalid",
+		b[len(resultsMarker)])
+}
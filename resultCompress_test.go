@@ -0,0 +1,276 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestResultsCompression(t *testing.T) {
+	n, err := newResultCompressTestNode()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	r := newResultsTest(time.Now().UTC().Add(time.Minute))
+	b, err := encodeResults(r)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	for _, c := range []string{compressionNone, compressionZlib, compressionGzip} {
+		p, err := packResults(b, c)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			continue
+		}
+
+		// Encrypted without node compression as the access node does when
+		// results are packed, and with node compression as older access nodes
+		// do.
+		for _, e := range []Compressor{&noneCompressor{}, n.getCompressor()} {
+			d, err := n.encodeWith(e, p)
+			if err != nil {
+				fmt.Println(err)
+				t.Fail()
+				continue
+			}
+			v, err := n.DecodeAsResults(d)
+			if err != nil {
+				fmt.Printf("%s: %s\n", c, err)
+				t.Fail()
+				continue
+			}
+			testResultsEqual(t, r, v)
+		}
+	}
+
+	// Unpacked results compressed by the node are still supported.
+	d, err := n.encode(b)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	v, err := n.DecodeAsResults(d)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	testResultsEqual(t, r, v)
+}
+
+func TestResultsCompressionSmallPayload(t *testing.T) {
+	var r Results
+	r.expires = time.Now().UTC().Add(time.Minute)
+	b, err := encodeResults(&r)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	u, err := packResults(b, compressionNone)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	z, err := packResults(b, compressionZlib)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if len(u) >= len(z) {
+		fmt.Printf("Uncompressed '%d' bytes not smaller than compressed '%d'\n",
+			len(u),
+			len(z))
+		t.Fail()
+	}
+	for _, p := range [][]byte{u, z} {
+		v, err := DecodeResults(p)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			continue
+		}
+		if v.expires.Unix() != r.expires.Unix() {
+			fmt.Println("Expiry changed after round trip")
+			t.Fail()
+		}
+	}
+}
+
+func TestResultsCompressionInvalid(t *testing.T) {
+	_, err := packResults([]byte{}, "bad")
+	if err == nil {
+		fmt.Println("Invalid results compression accepted")
+		t.Fail()
+	}
+}
+
+// testResultsEqual checks the pairs of the results b match those of a.
+func testResultsEqual(t *testing.T, a *Results, b *Results) {
+	if len(a.pairs) != len(b.pairs) {
+		fmt.Printf("'%d' pairs not '%d'\n", len(b.pairs), len(a.pairs))
+		t.Fail()
+		return
+	}
+	for i, p := range a.pairs {
+		if p.key != b.pairs[i].key ||
+			string(p.values[0]) != string(b.pairs[i].values[0]) {
+			fmt.Printf("Pair '%s' changed after round trip\n", p.key)
+			t.Fail()
+		}
+	}
+}
+
+func newResultCompressTestNode() (*node, error) {
+	n, err := newNode(
+		"network",
+		"access.com",
+		time.Now().UTC(),
+		time.Now().UTC(),
+		time.Now().UTC().AddDate(1, 0, 0),
+		roleAccess,
+		"",
+		"")
+	if err != nil {
+		return nil, err
+	}
+	x, err := newSecret()
+	if err != nil {
+		return nil, err
+	}
+	n.addSecret(x)
+	return n, nil
+}
+
+func TestDecodeResultsFromString(t *testing.T) {
+	n, err := newResultCompressTestNode()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	r := newResultsTest(time.Now().UTC().Add(time.Minute))
+	b, err := encodeResults(r)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	d, err := n.encode(b)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	for _, e := range []*base64.Encoding{
+		base64.RawURLEncoding,
+		base64.StdEncoding} {
+		v, err := n.DecodeResultsFromString(e.EncodeToString(d))
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			continue
+		}
+		testResultsEqual(t, r, v)
+	}
+	_, err = n.DecodeResultsFromString("not*base64!")
+	if err == nil {
+		fmt.Println("Malformed string decoded")
+		t.Fail()
+	}
+}
+
+func TestDecodeResultsAnyNode(t *testing.T) {
+	var a []*node
+	for _, d := range []string{"access-1.com", "access-2.com"} {
+		n, err := newNode(
+			"network",
+			d,
+			time.Now().UTC(),
+			time.Now().UTC(),
+			time.Now().UTC().AddDate(1, 0, 0),
+			roleAccess,
+			"",
+			"")
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		x, err := newSecret()
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		n.addSecret(x)
+		a = append(a, n)
+	}
+	c := newConfigurationTest()
+	s := NewServices(
+		c,
+		NewStorageService(c, newVolatile("test", false, a)),
+		NewAccessSimple(nil),
+		nil)
+	r := newResultsTest(time.Now().UTC().Add(time.Minute))
+	b, err := encodeResults(r)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	for _, n := range a {
+		d, err := n.encode(b)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		v, f, err := s.DecodeResultsAnyNode("network", d)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			continue
+		}
+		if f != n {
+			fmt.Printf("Node '%s' returned not '%s'\n", f.domain, n.domain)
+			t.Fail()
+		}
+		testResultsEqual(t, r, v)
+	}
+	_, _, err = s.DecodeResultsAnyNode("network", []byte("not encrypted"))
+	if err == nil {
+		fmt.Println("Invalid byte array decoded")
+		t.Fail()
+	}
+	_, _, err = s.DecodeResultsAnyNode("missing", b)
+	if err == nil {
+		fmt.Println("Results decoded for missing network")
+		t.Fail()
+	}
+}
@@ -0,0 +1,307 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResultsClientTTL(t *testing.T) {
+	r := newResultsTest(time.Now().UTC().Add(time.Minute))
+	r.pairs[0].expires = time.Now().UTC().AddDate(1, 0, 0)
+	r.pairs[0].clientTTL = getClientTTL(300, r.pairs[0].expires, time.Now().UTC())
+	if r.pairs[0].clientTTL != 5*time.Minute {
+		fmt.Printf("Client TTL '%s' not 5 minutes\n", r.pairs[0].clientTTL)
+		t.Fail()
+		return
+	}
+	b, err := encodeResults(r)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	d, err := DecodeResults(b)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	p := d.Get(r.pairs[0].key)
+	if p.ClientTTL() != r.pairs[0].clientTTL {
+		fmt.Printf("Client TTL '%s' does not match '%s'\n",
+			p.ClientTTL(),
+			r.pairs[0].clientTTL)
+		t.Fail()
+	}
+	if p.Expires().Sub(time.Now().UTC()) <= p.ClientTTL() {
+		fmt.Println("Client TTL not distinct from storage expiry")
+		t.Fail()
+	}
+	if d.Get(r.pairs[1].key).ClientTTL() != 0 {
+		fmt.Println("Client TTL set for pair without one")
+		t.Fail()
+	}
+}
+
+func TestResultsClientTTLCapped(t *testing.T) {
+	e := time.Now().UTC().Add(time.Minute)
+	if getClientTTL(300, e, time.Now().UTC()) > time.Minute {
+		fmt.Println("Client TTL longer than the time until expiry")
+		t.Fail()
+	}
+	if getClientTTL(0, e, time.Now().UTC()) != 0 {
+		fmt.Println("Client TTL set when not configured")
+		t.Fail()
+	}
+}
+
+func TestResultsClientTTLJSON(t *testing.T) {
+	r := newResultsTest(time.Now().UTC().Add(time.Minute))
+	r.pairs[0].clientTTL = 5 * time.Minute
+	b, err := json.Marshal(r)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	var m struct {
+		Pairs []struct {
+			Key       string `json:"key"`
+			ClientTTL int64  `json:"clientTTL"`
+		} `json:"pairs"`
+	}
+	err = json.Unmarshal(b, &m)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if len(m.Pairs) != 2 || m.Pairs[0].ClientTTL != 300 {
+		fmt.Println(string(b))
+		t.Fail()
+	}
+}
+
+func newResultsTest(e time.Time) *Results {
+	var r Results
+	r.expires = e
+	r.pairs = []*Pair{
+		&Pair{
+			key:     "a",
+			created: time.Now().UTC(),
+			expires: e,
+			values:  [][]byte{[]byte("Hello")}},
+		&Pair{
+			key:     "b",
+			created: time.Now().UTC(),
+			expires: e,
+			values:  [][]byte{[]byte("World")}}}
+	return &r
+}
+
+func TestResultsFilter(t *testing.T) {
+	r := newResultsFilterTest("a.x", "a.y", "b.x")
+	for _, d := range []struct {
+		name     string
+		results  *Results
+		prefix   string
+		expected []string
+	}{
+		{"empty results", newResultsFilterTest(), "a.", []string{}},
+		{"no matches", r, "c.", []string{}},
+		{"multiple matches", r, "a.", []string{"a.x", "a.y"}},
+		{"single match", r, "b.", []string{"b.x"}},
+		{"empty prefix", r, "", []string{"a.x", "a.y", "b.x"}}} {
+		a := d.results.Filter(d.prefix)
+		if len(a) != len(d.expected) {
+			fmt.Printf("%s: '%d' pairs not '%d'\n",
+				d.name,
+				len(a),
+				len(d.expected))
+			t.Fail()
+			continue
+		}
+		for i, p := range a {
+			if p.Key() != d.expected[i] {
+				fmt.Printf("%s: key '%s' not '%s'\n",
+					d.name,
+					p.Key(),
+					d.expected[i])
+				t.Fail()
+			}
+		}
+	}
+}
+
+func TestResultsKeys(t *testing.T) {
+	for _, d := range []struct {
+		name     string
+		results  *Results
+		expected []string
+	}{
+		{"empty results", newResultsFilterTest(), []string{}},
+		{"multiple keys", newResultsFilterTest("a", "b"), []string{"a", "b"}}} {
+		k := d.results.Keys()
+		if len(k) != len(d.expected) {
+			fmt.Printf("%s: '%d' keys not '%d'\n",
+				d.name,
+				len(k),
+				len(d.expected))
+			t.Fail()
+			continue
+		}
+		for i, v := range k {
+			if v != d.expected[i] {
+				fmt.Printf("%s: key '%s' not '%s'\n", d.name, v, d.expected[i])
+				t.Fail()
+			}
+		}
+	}
+}
+
+func newResultsFilterTest(keys ...string) *Results {
+	var r Results
+	for _, k := range keys {
+		r.pairs = append(r.pairs, &Pair{key: k})
+	}
+	return &r
+}
+
+func TestMergeResults(t *testing.T) {
+	n := time.Now().UTC()
+	newPair := func(k string, v string, c time.Time) *Pair {
+		return &Pair{
+			key:     k,
+			created: c,
+			expires: n.AddDate(0, 0, 1),
+			values:  [][]byte{[]byte(v)}}
+	}
+	a := &Results{
+		expires: n.Add(time.Hour),
+		state:   []string{"x", "y"},
+		pairs: []*Pair{
+			newPair("a", "old", n.Add(-time.Hour)),
+			newPair("b", "b", n)}}
+	b := &Results{
+		expires: n.Add(time.Minute),
+		state:   []string{"y", "z"},
+		partial: true,
+		pairs: []*Pair{
+			newPair("a", "new", n),
+			newPair("c", "c", n)}}
+	c := &Results{
+		expires: n.Add(2 * time.Hour),
+		pairs:   []*Pair{newPair("d", "d", n)}}
+
+	t.Run("overlapping", func(t *testing.T) {
+		m := MergeResults(a, b)
+		if strings.Join(m.Keys(), ",") != "a,b,c" {
+			fmt.Printf("Keys '%v' not 'a,b,c'\n", m.Keys())
+			t.Fail()
+		}
+		if string(m.Get("a").values[0]) != "new" {
+			fmt.Println("Newest pair for 'a' not kept")
+			t.Fail()
+		}
+		if m.expires != b.expires {
+			fmt.Println("Earliest expiry not used")
+			t.Fail()
+		}
+		if strings.Join(m.State(), ",") != "x,y,z" {
+			fmt.Printf("State '%v' not 'x,y,z'\n", m.State())
+			t.Fail()
+		}
+		if m.Partial() == false {
+			fmt.Println("Merged results not partial")
+			t.Fail()
+		}
+	})
+
+	t.Run("disjoint", func(t *testing.T) {
+		m := MergeResults(a, nil, c)
+		if strings.Join(m.Keys(), ",") != "a,b,d" {
+			fmt.Printf("Keys '%v' not 'a,b,d'\n", m.Keys())
+			t.Fail()
+		}
+		if m.expires != a.expires {
+			fmt.Println("Earliest expiry not used")
+			t.Fail()
+		}
+		if m.Partial() {
+			fmt.Println("Merged results partial")
+			t.Fail()
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		m := MergeResults()
+		if len(m.Pairs()) != 0 || m.expires.IsZero() == false {
+			fmt.Println("Empty merge not empty")
+			t.Fail()
+		}
+	})
+}
+
+func TestResultsPairFlags(t *testing.T) {
+	r := newResultsTest(time.Now().UTC().AddDate(0, 0, 1))
+	r.pairs[0].flags = PairFlagSensitive
+	b, err := encodeResults(r)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	d, err := DecodeResults(b)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if d.pairs[0].Sensitive() == false || d.pairs[1].Sensitive() {
+		fmt.Println("Flags not decoded")
+		t.Fail()
+	}
+
+	// Version 3 results end after the partial flag without the pair flags.
+	// The version byte is followed by the client TTL for each pair, the
+	// network count, the exists flag for each pair, the partial flag and the
+	// pair flags.
+	n := len(r.pairs)
+	v := len(b) - (1 + 4*n + 1 + n + 1 + n)
+	if b[v] != resultsVersion {
+		fmt.Println("Version byte not found")
+		t.Fail()
+		return
+	}
+	o := append([]byte{}, b[:len(b)-n]...)
+	o[v] = 3
+	d, err = DecodeResults(o)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if d.pairs[0].flags != 0 || d.Partial() {
+		fmt.Println("Version 3 results not decoded")
+		t.Fail()
+	}
+}
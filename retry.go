@@ -0,0 +1,57 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"time"
+)
+
+const (
+	// defaultStoreRetryAttempts is the number of attempts made to read from a
+	// cloud store if the configuration does not specify a value.
+	defaultStoreRetryAttempts = 3
+
+	// defaultStoreRetryDelay is the delay before the second attempt. Each
+	// subsequent attempt doubles the delay.
+	defaultStoreRetryDelay = 500 * time.Millisecond
+)
+
+// retry calls f up to attempts times until it returns nil, doubling the delay
+// between each attempt. The last error is returned if all the attempts fail.
+// Each retry is recorded as a debug message with the configuration c.
+func retry(
+	c *Configuration,
+	attempts int,
+	delay time.Duration,
+	f func() error) error {
+	var err error
+	if attempts <= 0 {
+		attempts = 1
+	}
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			c.debugf("SWIFT:retry %d of %d after '%s'\n", i, attempts-1, err)
+			time.Sleep(delay)
+			delay *= 2
+		}
+		err = f()
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
@@ -0,0 +1,84 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRetrySuccessAfterFailures(t *testing.T) {
+	c := 0
+	err := retry(&Configuration{}, 3, time.Millisecond, func() error {
+		c++
+		if c < 3 {
+			return fmt.Errorf("transient %d", c)
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if c != 3 {
+		fmt.Printf("expected 3 calls, got %d\n", c)
+		t.Fail()
+	}
+}
+
+func TestRetryReturnsLastError(t *testing.T) {
+	c := 0
+	err := retry(&Configuration{}, 3, time.Millisecond, func() error {
+		c++
+		return fmt.Errorf("transient %d", c)
+	})
+	if err == nil || err.Error() != "transient 3" {
+		fmt.Printf("expected last error, got '%v'\n", err)
+		t.Fail()
+	}
+	if c != 3 {
+		fmt.Printf("expected 3 calls, got %d\n", c)
+		t.Fail()
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	s := time.Now()
+	retry(&Configuration{}, 3, 10*time.Millisecond, func() error {
+		return fmt.Errorf("transient")
+	})
+	// Delays of 10ms then 20ms should be observed.
+	if d := time.Since(s); d < 30*time.Millisecond {
+		fmt.Printf("expected at least 30ms of backoff, got '%s'\n", d)
+		t.Fail()
+	}
+}
+
+func TestRetryAttemptsDefault(t *testing.T) {
+	c := newConfigurationTest()
+	if c.StoreRetryAttemptsOrDefault() != defaultStoreRetryAttempts {
+		fmt.Println("expected default store retry attempts")
+		t.Fail()
+	}
+	c.StoreRetryAttempts = -1
+	if c.Validate() == nil {
+		fmt.Println("expected negative StoreRetryAttempts to be invalid")
+		t.Fail()
+	}
+}
@@ -17,39 +17,79 @@
 package swift
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"strings"
 	"time"
 )
 
 type secret struct {
 	timeStamp time.Time
 	key       string
+	cipher    string
 	crypto    *crypto
 }
 
+// secretCipherSeparator separates the cipher identifier from the base 64 key
+// in the key string of secrets that do not use the default cipher. The
+// character is not part of the base 64 URL alphabet.
+const secretCipherSeparator = ":"
+
+// mac returns a HMAC of the values provided keyed with the secret. Each value
+// is followed by a zero byte so that the boundaries between values can not be
+// moved.
+func (s *secret) mac(v ...string) []byte {
+	h := hmac.New(sha256.New, []byte(s.key))
+	for _, i := range v {
+		h.Write([]byte(i))
+		h.Write([]byte{0})
+	}
+	return h.Sum(nil)
+}
+
 func newSecret() (*secret, error) {
+	return newSecretWithCipher(cipherAESGCM)
+}
+
+// newSecretWithCipher returns a new random secret for the cipher c. The cipher
+// identifier is included in the key string so that it is stored with the key
+// and used when the secret is read back with newSecretFromKey. Secrets for the
+// default AES-GCM cipher have no identifier for compatibility with existing
+// keys.
+func newSecretWithCipher(c string) (*secret, error) {
 	b, err := randomBytes(32)
 	if err != nil {
 		return nil, err
 	}
-	x, err := newCrypto(b)
+	x, err := newCryptoWithCipher(c, b)
 	if err != nil {
 		return nil, err
 	}
-	return &secret{time.Now(), base64.RawURLEncoding.EncodeToString(b), x}, nil
+	k := base64.RawURLEncoding.EncodeToString(b)
+	if c != cipherAESGCM {
+		k = c + secretCipherSeparator + k
+	}
+	return &secret{time.Now(), k, c, x}, nil
 }
 
 func newSecretFromKey(key string, timeStamp time.Time) (*secret, error) {
-	b, err := base64.RawURLEncoding.DecodeString(key)
+	c := cipherAESGCM
+	v := key
+	if i := strings.Index(key, secretCipherSeparator); i >= 0 {
+		c = key[:i]
+		v = key[i+len(secretCipherSeparator):]
+	}
+	b, err := base64.RawURLEncoding.DecodeString(v)
 	if err != nil {
 		return nil, err
 	}
-	x, err := newCrypto(b)
+	x, err := newCryptoWithCipher(c, b)
 	if err != nil {
 		return nil, err
 	}
-	return &secret{timeStamp, key, x}, nil
+	return &secret{timeStamp, key, c, x}, nil
 }
 
 // MarshalJSON marshals a secret to JSON without having to expose the fields in
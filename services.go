@@ -19,14 +19,41 @@ package swift
 import (
 	"fmt"
 	"net/http"
+	"net/url"
+	"time"
 )
 
 // Services references all the information needed for every method.
 type Services struct {
-	config  Configuration   // Configuration used by the server.
-	store   storageService  // Instance of storage service for node data
-	browser BrowserDetector // Service to provide browser warnings
-	access  Access          // Instance of the access control interface
+	config  Configuration          // Configuration used by the server.
+	store   *storageService        // Instance of storage service for node data
+	browser BrowserDetector        // Service to provide browser warnings
+	access  Access                 // Instance of the access control interface
+	tokens  *registerTokens        // One time setup tokens for node registration
+	parsers map[string]ValueParser // Value parsers keyed on pair key
+	clock   Clock                  // Source of the current time
+	encrypt *http.Client           // Client used to call access nodes
+	replays *replayCache           // Completed operations, or nil if disabled
+	// Number of conflicts resolved between operation and cookie values
+	conflicts *conflictCounters
+	// Called when a storage operation completes, or nil
+	onComplete func(o Operation) error
+	// Writes error responses, or nil for the default plain text responses
+	errorHandler ErrorHandler
+	// Rewrites the URL of the next node in a storage operation, or nil
+	nextURLRewriter func(u *url.URL, domain string) *url.URL
+}
+
+// Operation provides read only access to a storage operation for hooks such as
+// the one set with SetOnComplete.
+type Operation interface {
+	TimeStamp() time.Time
+	Table() string
+	ReturnURL() string
+	AccessNode() string
+	NodesVisited() byte
+	NodeCount() byte
+	Values() []*Pair
 }
 
 // NewServices a set of services to use with SWIFT. These provide defaults via
@@ -34,7 +61,7 @@ type Services struct {
 // parameter.
 func NewServices(
 	config Configuration,
-	store storageService,
+	store *storageService,
 	access Access,
 	browser BrowserDetector) *Services {
 	var s Services
@@ -42,12 +69,104 @@ func NewServices(
 	s.store = store
 	s.access = access
 	s.browser = browser
+	t, err := newRegisterTokens(config.RegisterTokenFile)
+	if err != nil {
+		panic(err)
+	}
+	s.tokens = t
+	s.conflicts = newConflictCounters()
+	s.parsers = make(map[string]ValueParser)
+	s.clock = realClock{}
+	s.encrypt = &http.Client{Timeout: config.AccessNodeTimeoutDuration()}
+	if config.ReplayCache {
+		s.replays = newReplayCache(config.ReplayCacheSizeOrDefault())
+	}
+	if config.ConsentKey != "" {
+		s.parsers[config.ConsentKey] = consentParser{}
+	}
 	return &s
 }
 
 // Config returns the configuration service.
 func (s *Services) Config() *Configuration { return &s.config }
 
+// IssueRegisterToken returns a new one time setup token which permits a single
+// domain to register as a node in the network and role provided before the
+// expires time.
+func (s *Services) IssueRegisterToken(
+	network string,
+	role int,
+	expires time.Time) (string, error) {
+	return s.tokens.issue(network, role, expires)
+}
+
+// SetClock sets the clock used for time sensitive operations such as operation
+// timeouts and cookie write times. If c is nil then the system time is used.
+func (s *Services) SetClock(c Clock) {
+	if c == nil {
+		s.clock = realClock{}
+	} else {
+		s.clock = c
+	}
+}
+
+// SetOnComplete sets the function called when a storage operation completes
+// and before the response returning to the caller is written. Used to trigger
+// server side actions such as audit logging. The function runs synchronously
+// so should return quickly. If the function returns an error then the
+// response is a server error and the caller is not returned to. If f is nil
+// then no function is called.
+func (s *Services) SetOnComplete(f func(o Operation) error) {
+	s.onComplete = f
+}
+
+// SetErrorHandler sets the function used to write the response when a handler
+// returns an error. If h is nil then errors are returned as plain text with
+// the message only included for server errors when debug is enabled.
+func (s *Services) SetErrorHandler(h ErrorHandler) {
+	s.errorHandler = h
+}
+
+// SetNextURLRewriter sets the function used to change the URL of the next node
+// in a storage operation. The function is passed the URL built from the
+// configured scheme and the registered domain of the node, and returns the URL
+// to redirect the browser to. Used when nodes are behind reverse proxies with
+// public host names that differ from their registered domains. The path must
+// be retained. If f is nil, or f returns nil, then the URL is not changed.
+func (s *Services) SetNextURLRewriter(
+	f func(u *url.URL, domain string) *url.URL) {
+	s.nextURLRewriter = f
+}
+
+// SetValueParser sets the parser used to provide the structured form of values
+// for the key k when results are decoded as JSON. If p is nil then any parser
+// for the key is removed.
+func (s *Services) SetValueParser(k string, p ValueParser) {
+	if p == nil {
+		delete(s.parsers, k)
+	} else {
+		s.parsers[k] = p
+	}
+}
+
+// parseValues sets the structured form of the values for any pairs in the
+// results that have a value parser.
+func (s *Services) parseValues(r *Results) {
+	for _, p := range r.pairs {
+		if v := s.parsers[p.key]; v != nil {
+			p.parse(v)
+		}
+	}
+}
+
+// SetAliveClient sets the HTTP client used to poll nodes to determine if they
+// are alive. Used to provide custom timeouts, TLS or proxy settings, or a
+// http.RoundTripper to intercept requests when testing. If c is nil then the
+// default client is used.
+func (s *Services) SetAliveClient(c *http.Client) {
+	s.store.setAliveClient(c)
+}
+
 // GetAccessNodeForHost returns the access node, if there is one, for the host
 // name provided. If the host does not exist then an error is returned. If the
 // host exists, but is not an access node then an error is returned.
@@ -69,7 +188,65 @@ func (s *Services) GetHomeNode(r *http.Request) (*node, error) {
 	if err != nil {
 		return nil, err
 	}
-	return n.getHomeNode(q.Get(xforwarededfor), q.Get(remoteAddr))
+	return s.getHomeNodeInNetwork(
+		n,
+		q.Get(xforwarededfor),
+		q.Get(remoteAddr))
+}
+
+// getHomeNodeInNetwork returns the home node in the network ns for the
+// forwarded-for header xff and remote address ra. The home node must not expire
+// before a storage operation started now by the services clock times out.
+func (s *Services) getHomeNodeInNetwork(
+	ns *nodes,
+	xff string,
+	ra string) (*node, error) {
+	return ns.getHomeNodeWithin(
+		xff,
+		ra,
+		s.config.TrustedProxyCount,
+		s.clock.Now().Add(s.config.StorageOperationTimeoutDuration()))
+}
+
+// SimulateVisit returns the domains of the nodes, in order, that a storage
+// operation in the network for the forwarded-for header xff and remote address
+// ra would visit with nodeCount nodes. The home node is selected in the same
+// way as Create and the storage nodes are chosen at random using a source
+// created from the seed so that the same seed always returns the same nodes.
+// The services clock and the RequireAliveNodes preference are applied as they
+// are by HandlerStore. No requests are made to the nodes.
+//
+// The simulation is a method of Services rather than nodes because the home
+// node selection depends on the configured trusted proxies and clock, and the
+// storage node selection on the alive preference, none of which are known to
+// nodes. Domains are returned because the node type is not exported.
+func (s *Services) SimulateVisit(
+	network string,
+	xff string,
+	ra string,
+	nodeCount byte,
+	seed int64) ([]string, error) {
+	ns, err := s.store.getNodes(network)
+	if err != nil {
+		return nil, err
+	}
+	if ns == nil {
+		return nil, fmt.Errorf("Network '%s' does not exist", network)
+	}
+	h, err := s.getHomeNodeInNetwork(ns, xff, ra)
+	if err != nil {
+		return nil, err
+	}
+	var d []string
+	for _, n := range ns.simulateVisit(
+		h,
+		nodeCount,
+		seed,
+		s.clock.Now(),
+		s.config.RequireAliveNodes) {
+		d = append(d, n.domain)
+	}
+	return d, nil
 }
 
 // GetAliveNodesCount returns the number of nodes reported as alive currently.
@@ -81,6 +258,36 @@ func (s *Services) GetAliveNodesCount() (uint32, error) {
 	return uint32(len(n)), nil
 }
 
+// DecodeResultsAnyNode decodes the byte array d into results by trying each
+// access node in the network until one is able to decrypt it. Returns the
+// results and the access node that decrypted them. Used when the access node
+// that produced the encrypted results is not known.
+// network is the SWIFT network the access nodes belong to
+// d is the encrypted results byte array
+func (s *Services) DecodeResultsAnyNode(
+	network string,
+	d []byte) (*Results, *node, error) {
+	ns, err := s.store.getNodes(network)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ns == nil {
+		return nil, nil, fmt.Errorf("Network '%s' not found", network)
+	}
+	for _, n := range ns.all {
+		if n.role != roleAccess || n.supportsCrypto() == false {
+			continue
+		}
+		r, err := n.DecodeAsResults(d)
+		if err == nil && r != nil {
+			return r, n, nil
+		}
+	}
+	return nil, nil, fmt.Errorf(
+		"No access node in network '%s' could decode results",
+		network)
+}
+
 func (s *Services) getNodeFromRequest(h string, q int) (*node, error) {
 
 	// Get the node associated with the request.
@@ -98,6 +305,21 @@ func (s *Services) getNodeFromRequest(h string, q int) (*node, error) {
 	return n, nil
 }
 
+// IsAccessAllowed returns true if the access key in the request is allowed to
+// access the network, otherwise false and the reason from the Access
+// implementation if one is available. Removes the accessKey parameter from the
+// form to prevent it being used by other methods. Does not respond to the
+// request so the caller can decide how to handle a denial.
+func (s *Services) IsAccessAllowed(r *http.Request) (bool, error) {
+	err := r.ParseForm()
+	if err != nil {
+		return false, err
+	}
+	v, err := s.access.GetAllowed(r.FormValue("accessKey"))
+	r.Form.Del("accessKey")
+	return v, err
+}
+
 // Returns true if the request is allowed to access the handler, otherwise
 // false. Removes the accessKey parameter from the form to prevent it being
 // used by other methods.  If false is returned then no further action is
@@ -107,18 +329,18 @@ func (s *Services) getAccessAllowed(
 	r *http.Request) bool {
 	err := r.ParseForm()
 	if err != nil {
-		returnAPIError(s, w, err, http.StatusInternalServerError)
+		returnAPIError(s, w, r, err, http.StatusInternalServerError)
 		return false
 	}
-	v, err := s.access.GetAllowed(r.FormValue("accessKey"))
+	v, err := s.IsAccessAllowed(r)
 	if v == false || err != nil {
 		returnAPIError(
 			s,
 			w,
+			r,
 			fmt.Errorf("Access denied"),
 			http.StatusNetworkAuthenticationRequired)
 		return false
 	}
-	r.Form.Del("accessKey")
 	return true
 }
@@ -20,9 +20,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
-	"net/url"
 	"strings"
 	"time"
 )
@@ -40,6 +38,8 @@ type storageManager struct {
 	// alive is a background service which polls nodes periodically to ensure
 	// that they are alive
 	alive *aliveService
+	// compressor is the configured compressor assigned to nodes
+	compressor Compressor
 }
 
 // NewStorageManager creates a new instance of storage manager and returns the
@@ -49,12 +49,29 @@ type storageManager struct {
 // The returned nodes are added to a new Volatile read only store which is held
 // in memory and then added to the list of stores. As stores are added, they are
 // checked in turn for additional sharing nodes. A list of checked sharing nodes
-// is maintained to prevent the same node being checked more than once.
-func newStorageManager(c Configuration, sts ...Store) (*storageManager, error) {
+// is maintained to prevent the same node being checked more than once. The
+// client h is used by the alive service and can be nil to use the default.
+func newStorageManager(
+	c Configuration,
+	h *http.Client,
+	sts ...Store) (*storageManager, error) {
 	var sm storageManager
+	var err error
 	sm.nodes = make(map[string]*node)
+	salts := make(map[string]string)
+	for k, v := range c.NetworkSalts {
+		salts[normalizeNetwork(k)] = v
+	}
 	checkedNodes := make(map[string]bool)
 
+	sm.compressor, err = NewCompressor(c.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	// only skip stores that can not be reached if there are others to use
+	skip := len(sts) > 1
+
 	for i := 0; i < len(sts); i++ {
 		// check the maximum number of stores has not been reached
 		if len(sts) > c.MaxStores {
@@ -63,10 +80,36 @@ func newStorageManager(c Configuration, sts ...Store) (*storageManager, error) {
 					"number of stores %d", c.MaxStores)
 		}
 
+		// check the store can be reached
+		err = sts[i].ping()
+		if err != nil {
+			c.errorf("SWIFT:store '%s' ping failed: %s\n",
+				sts[i].getName(),
+				err.Error())
+			if skip {
+				continue
+			}
+		}
+
+		// apply the network salts before the store's networks are used
+		if r, ok := sts[i].(interface{ setSalts(map[string]string) }); ok {
+			r.setSalts(salts)
+		}
+
+		// log messages from the store with the configuration
+		if r, ok := sts[i].(interface{ setConfig(*Configuration) }); ok {
+			r.setConfig(&c)
+		}
+
+		// assign the configured compressor to the store's nodes
+		if r, ok := sts[i].(interface{ setCompressor(Compressor) }); ok {
+			r.setCompressor(sm.compressor)
+		}
+
 		// get the sharing nodes from this store
 		ns, err := getSharingNodesFromStore(sts[i])
 		if err != nil {
-			log.Println(err.Error())
+			c.errorf("SWIFT:%s\n", err.Error())
 		}
 
 		for _, n := range ns {
@@ -79,18 +122,14 @@ func newStorageManager(c Configuration, sts ...Store) (*storageManager, error) {
 			}
 
 			// get all the nodes the shaing node knows about
-			b, err := callShare(n, c.Scheme)
+			b, err := callShare(n, &c)
 			if err != nil {
-				if c.Debug {
-					log.Println(err.Error())
-				}
+				c.debugf("SWIFT:%s\n", err.Error())
 			}
 
 			nodes, err := getNodesFromByteArray(b)
 			if err != nil {
-				if c.Debug {
-					log.Println(err.Error())
-				}
+				c.debugf("SWIFT:%s\n", err.Error())
 			}
 
 			// check if shared nodes contain any storage nodes
@@ -120,14 +159,52 @@ func newStorageManager(c Configuration, sts ...Store) (*storageManager, error) {
 		sm.stores = append(sm.stores, sts[i])
 	}
 
+	// warn about any networks with nodes that have inconsistent settings.
+	sm.validateNetworks(&c)
+
+	// if alive polling is disabled then all the nodes are treated as alive.
+	for _, n := range sm.nodes {
+		if c.AlivePollingSeconds == 0 {
+			n.SetAlive(true)
+		}
+	}
+
 	// create new alive service if the alive polling setting is more than zero
 	if c.AlivePollingSeconds > 0 {
-		sm.alive = newAliveService(c, sm)
+		sm.alive = newAliveService(c, sm, h)
 	}
 
 	return &sm, nil
 }
 
+// stop ends the background services of the storage manager. Called once the
+// storage manager has been replaced.
+func (sm *storageManager) stop() {
+	if sm.alive != nil {
+		sm.alive.stop()
+	}
+}
+
+// validateNetworks logs an error for each network where the nodes do not share
+// compatible settings.
+func (sm *storageManager) validateNetworks(c *Configuration) {
+	v := make(map[string]bool)
+	for _, n := range sm.nodes {
+		if v[n.network] {
+			continue
+		}
+		v[n.network] = true
+		ns, err := sm.getNodes(n.network)
+		if err != nil || ns == nil {
+			continue
+		}
+		err = ns.validate()
+		if err != nil {
+			c.errorf("SWIFT:network '%s' invalid: %s\n", n.network, err)
+		}
+	}
+}
+
 // getNode gets the node associated with the domain.
 func (sm *storageManager) getNode(domain string) *node { return sm.nodes[domain] }
 
@@ -152,6 +229,7 @@ func (sm *storageManager) GetAccessNode(network string) (string, error) {
 
 // getNodes returns the nodes object associated with a network.
 func (sm *storageManager) getNodes(network string) (*nodes, error) {
+	network = normalizeNetwork(network)
 	for _, s := range sm.stores {
 		nets, err := s.getNodes(network)
 		if err != nil {
@@ -174,7 +252,7 @@ func (sm *storageManager) getAllActiveNodes() ([]*node, error) {
 			if !ok {
 				return fmt.Errorf("%v not a []*node", s)
 			}
-			if n.alive && n.starts.Before(time.Now().UTC()) {
+			if n.IsAlive() && n.starts.Before(time.Now().UTC()) {
 				*st = append(*st, n)
 			}
 			return nil
@@ -187,7 +265,23 @@ func (sm *storageManager) getAllActiveNodes() ([]*node, error) {
 	return n, nil
 }
 
-// getAllNodes returns all the nodes from all store instances combined.
+// iterateAllNodes calls the function f for every node in every store without
+// combining the nodes into a single slice. Stops and returns the error if f
+// returns an error.
+func (sm *storageManager) iterateAllNodes(f func(n *node) error) error {
+	for _, s := range sm.stores {
+		err := s.iterateNodes(func(n *node, s interface{}) error {
+			return f(n)
+		}, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getAllNodes returns all the nodes from all store instances combined ordered
+// by network and then domain.
 func (sm *storageManager) getAllNodes() ([]*node, error) {
 	var n []*node
 	for _, s := range sm.stores {
@@ -204,6 +298,7 @@ func (sm *storageManager) getAllNodes() ([]*node, error) {
 		}
 
 	}
+	sortNodes(n)
 	return n, nil
 }
 
@@ -211,12 +306,29 @@ func (sm *storageManager) getAllNodes() ([]*node, error) {
 // setNodes will also succeed if no store name is provided and only one
 // writeable store exists in the storageManager.
 func (sm *storageManager) setNodes(store string, ns ...*node) error {
-	var stores []Store
-
 	if len(ns) == 0 {
 		return fmt.Errorf("supply some nodes to set")
 	}
 
+	s, err := sm.getWriteableStore(store)
+	if err != nil {
+		return err
+	}
+
+	for _, n := range ns {
+		err := s.setNode(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getWriteableStore returns the writeable store with the name provided. If no
+// name is provided then the only writeable store is returned.
+func (sm *storageManager) getWriteableStore(store string) (Store, error) {
+	var stores []Store
+
 	for _, s := range sm.stores {
 		if !s.getReadOnly() &&
 			(store == "" || s.getName() == store) {
@@ -226,9 +338,10 @@ func (sm *storageManager) setNodes(store string, ns ...*node) error {
 
 	if len(stores) == 0 {
 		if store == "" {
-			return fmt.Errorf("no writable stores found")
+			return nil, fmt.Errorf("no writable stores found")
 		} else {
-			return fmt.Errorf("no writable stores by the name of '%s' found", store)
+			return nil, fmt.Errorf(
+				"no writable stores by the name of '%s' found", store)
 		}
 	} else if len(stores) > 1 {
 		var strs []string
@@ -237,17 +350,12 @@ func (sm *storageManager) setNodes(store string, ns ...*node) error {
 			strs = append(strs, s.getName())
 		}
 
-		return fmt.Errorf("multiple writable stores available, please select "+
-			"a store from the following: '%s'", strings.Join(strs[:], ", "))
+		return nil, fmt.Errorf("multiple writable stores available, please "+
+			"select a store from the following: '%s'",
+			strings.Join(strs[:], ", "))
 	}
 
-	for _, n := range ns {
-		err := stores[0].setNode(n)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+	return stores[0], nil
 }
 
 // addNode function for use as an argument for the store.iterateNodes function,
@@ -270,15 +378,11 @@ func addNode(n *node, s interface{}) error {
 
 // callShare makes a request to a sharing node to get shared node data and
 // decrypts the resulting byte array.
-func callShare(n *node, scheme string) ([]byte, error) {
+func callShare(n *node, c *Configuration) ([]byte, error) {
 	client := &http.Client{
 		Timeout: 15 * time.Second,
 	}
-	url := url.URL{
-		Scheme: scheme,
-		Host:   n.domain,
-		Path:   "/swift/api/v1/share",
-	}
+	url := c.APIURL(n.domain, "share")
 
 	req, err := http.NewRequest("GET", url.String(), nil)
 	if err != nil {
@@ -17,8 +17,10 @@
 package swift
 
 import (
+	"encoding/json"
 	"fmt"
-	"log"
+	"net/http"
+	"sort"
 	"sync"
 	"time"
 )
@@ -33,12 +35,16 @@ type storageService struct {
 	stores []Store         // List of stores that the service is initialized with
 	ticker *time.Ticker    // Ticker reference
 	mutex  *sync.Mutex     // mutex used to lock storage manager when updating
+	alive  *http.Client    // Client for the alive service, nil for the default
+	queue  *storeQueue     // Queue for asynchronous writes, nil if synchronous
+	// The time the storage manager was last created from the stores
+	refreshed time.Time
 }
 
 // NewStorageService creates a new instance of storageService and creates the
 // initial instance of storageManager, a go routine is then started which
 // will periodically refresh the storageManager reference with a new instance.
-func NewStorageService(c Configuration, sts ...Store) storageService {
+func NewStorageService(c Configuration, sts ...Store) *storageService {
 	var svc storageService
 	var err error
 	svc.config = c
@@ -46,16 +52,30 @@ func NewStorageService(c Configuration, sts ...Store) storageService {
 	svc.mutex = &sync.Mutex{}
 
 	svc.mutex.Lock()
-	svc.store, err = newStorageManager(c, sts...)
+	svc.store, err = newStorageManager(c, nil, sts...)
 	if err != nil {
 		panic(err)
 	}
+	svc.refreshed = time.Now().UTC()
 	svc.mutex.Unlock()
 
+	// start the queue for asynchronous store writes if configured.
+	if c.StoreQueueFile != "" {
+		svc.queue, err = newStoreQueue(
+			c,
+			c.StoreQueueFile,
+			time.Second,
+			c.StoreQueueMaxBackoffDuration(),
+			svc.writeNode)
+		if err != nil {
+			panic(err)
+		}
+	}
+
 	// start background goroutine to continuously refresh the store.
 	go svc.startStorageService()
 
-	return svc
+	return &svc
 }
 
 // startStorageService creates a new ticker which, every time it executes,
@@ -73,21 +93,97 @@ func (svc *storageService) startStorageService() {
 	defer svc.ticker.Stop()
 
 	for _ = range svc.ticker.C {
-		newStore, err := newStorageManager(svc.config, svc.stores...)
+		err := svc.Invalidate()
 		if err != nil {
-			log.Println(err.Error())
-			continue
-		}
-		if newStore != nil {
-			svc.mutex.Lock()
-			svc.store = newStore
-			svc.mutex.Unlock()
+			svc.config.errorf("SWIFT:%s\n", err.Error())
 		}
 	}
 }
 
-// getNode abstracts calls to storageManager.getNode
+// invalidateUnlessQueued calls Invalidate after nodes have been set unless the
+// writes are queued. Queued nodes are returned by getNode from the queue and
+// may not have been written to the stores yet, so rebuilding the storage
+// manager would add the latency of the stores to the caller without making
+// the nodes available any sooner.
+func (svc *storageService) invalidateUnlessQueued() error {
+	if svc.queue != nil {
+		return nil
+	}
+	return svc.Invalidate()
+}
+
+// Invalidate forces the storage manager to be recreated immediately from the
+// stores rather than waiting for the next refresh. Used after nodes have been
+// changed so that they are available without delay.
+func (svc *storageService) Invalidate() error {
+	t := time.Now().UTC()
+
+	// create the storage manager without holding the lock as the stores are
+	// pinged and sharing nodes called.
+	svc.mutex.Lock()
+	h := svc.alive
+	svc.mutex.Unlock()
+	m, err := newStorageManager(svc.config, h, svc.stores...)
+	if err != nil {
+		return err
+	}
+
+	// replace the storage manager unless a more recent one has already been
+	// set by another call, and stop the one that is not used.
+	svc.mutex.Lock()
+	o := m
+	if t.Before(svc.refreshed) == false {
+		o = svc.store
+		svc.store = m
+		svc.refreshed = t
+	}
+	svc.mutex.Unlock()
+	o.stop()
+
+	if svc.queue != nil {
+		svc.queue.trim(t)
+	}
+	return nil
+}
+
+// getRefreshed returns the time the storage manager was last created from the
+// stores.
+func (svc *storageService) getRefreshed() time.Time {
+	svc.mutex.Lock()
+	defer svc.mutex.Unlock()
+	return svc.refreshed
+}
+
+// setAliveClient sets the client used by the alive service of the current and
+// all future storage managers. If h is nil then the default client is used.
+func (svc *storageService) setAliveClient(h *http.Client) {
+	svc.mutex.Lock()
+	defer svc.mutex.Unlock()
+	svc.alive = h
+	if svc.store.alive != nil {
+		svc.store.alive.setClient(h)
+	}
+}
+
+// getQueueStats returns the counters of the queue used for asynchronous store
+// writes, or nil if writes are synchronous.
+func (svc *storageService) getQueueStats() *StoreQueueStats {
+	if svc.queue == nil {
+		return nil
+	}
+	s := svc.queue.stats()
+	return &s
+}
+
+// getNode abstracts calls to storageManager.getNode. If writes are queued then
+// nodes accepted by the queue are returned in preference so that they are
+// available immediately.
 func (svc *storageService) getNode(domain string) *node {
+	if svc.queue != nil {
+		if n := svc.queue.getNode(domain); n != nil {
+			return n
+		}
+	}
 	return svc.store.getNode(domain)
 }
 
@@ -101,14 +197,217 @@ func (svc *storageService) getAllNodes() ([]*node, error) {
 	return svc.store.getAllNodes()
 }
 
+// iterateAllNodes abstracts calls to storageManager.iterateAllNodes
+func (svc *storageService) iterateAllNodes(f func(n *node) error) error {
+	return svc.store.iterateAllNodes(f)
+}
+
+// GetNodesByRole returns the nodes from all the stores with the role provided
+// ordered by network and then domain. If the same domain appears in more than
+// one store then the most recently created node is used.
+func (svc *storageService) GetNodesByRole(role int) ([]*node, error) {
+	var ns []*node
+	all, err := svc.getNewestNodes()
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range all {
+		if n.role == role {
+			ns = append(ns, n)
+		}
+	}
+	sort.Slice(ns, func(i, j int) bool {
+		if ns[i].network != ns[j].network {
+			return ns[i].network < ns[j].network
+		}
+		return ns[i].domain < ns[j].domain
+	})
+	return ns, nil
+}
+
+// getNewestNodes returns the nodes from all the stores in no particular order
+// with one node per domain. If the same domain appears in more than one store
+// then the most recently created node is used.
+func (svc *storageService) getNewestNodes() ([]*node, error) {
+	d := make(map[string]*node)
+	err := svc.iterateAllNodes(func(n *node) error {
+		if e := d[n.domain]; e == nil || n.created.After(e.created) {
+			d[n.domain] = n
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ns := make([]*node, 0, len(d))
+	for _, n := range d {
+		ns = append(ns, n)
+	}
+	return ns, nil
+}
+
 // getAllActiveNodes abstracts calls to storageManager.getAllNodes
 func (svc *storageService) getAllActiveNodes() ([]*node, error) {
 	return svc.store.getAllActiveNodes()
 }
 
-// setNodes abstracts calls to storageManager.setNodes
+// setNodes abstracts calls to storageManager.setNodes. If writes are queued
+// then the store is validated and the nodes added to the queue to be written
+// in the background.
 func (svc *storageService) setNodes(store string, ns ...*node) error {
-	return svc.store.setNodes(store, ns...)
+	if svc.config.UniqueScramblerKeys {
+		err := svc.checkScramblerKeys(ns)
+		if err != nil {
+			return err
+		}
+	}
+	if svc.queue == nil {
+		return svc.store.setNodes(store, ns...)
+	}
+	if len(ns) == 0 {
+		return fmt.Errorf("supply some nodes to set")
+	}
+	s, err := svc.store.getWriteableStore(store)
+	if err != nil {
+		return err
+	}
+	for _, n := range ns {
+		err = svc.queue.add(s.getName(), n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RotateScrambler replaces the scrambler of the node for the domain with a new
+// random secret and writes the node to the store with the name provided.
+// Values scrambled with the replaced scrambler can still be unscrambled until
+// the next rotation. The node in use is not changed. A copy is rotated and the
+// storage manager is only recreated once the copy has been written.
+func (svc *storageService) RotateScrambler(store string, domain string) error {
+	o := svc.getNode(domain)
+	if o == nil {
+		return fmt.Errorf("node '%s' not found", domain)
+	}
+	var n node
+	n.copyFrom(o)
+	err := n.RotateScrambler()
+	if err != nil {
+		return err
+	}
+	err = svc.setNodes(store, &n)
+	if err != nil {
+		return err
+	}
+	return svc.Invalidate()
+}
+
+// ExportNetwork returns a JSON array of all the nodes in the network including
+// their secrets. The format is the same as that returned by HandlerShare and
+// can be passed to ImportNetwork to move the network to another store.
+func (svc *storageService) ExportNetwork(network string) ([]byte, error) {
+	all, err := svc.getAllNodes()
+	if err != nil {
+		return nil, err
+	}
+	network = normalizeNetwork(network)
+	ns := make([]*node, 0, len(all))
+	for _, n := range all {
+		if n.network == network {
+			ns = append(ns, n)
+		}
+	}
+	if len(ns) == 0 {
+		return nil, fmt.Errorf("no nodes in network '%s'", network)
+	}
+	return json.Marshal(ns)
+}
+
+// ImportNetwork writes the nodes from the JSON array returned by ExportNetwork
+// to the store with the name provided. All the nodes must belong to the same
+// network.
+func (svc *storageService) ImportNetwork(store string, data []byte) error {
+	ns, err := getNodesFromByteArray(data)
+	if err != nil {
+		return err
+	}
+	if len(ns) == 0 {
+		return fmt.Errorf("no nodes to import")
+	}
+	for _, n := range ns {
+		if n.network != ns[0].network {
+			return fmt.Errorf(
+				"node '%s' network '%s' does not match '%s'",
+				n.domain,
+				n.network,
+				ns[0].network)
+		}
+	}
+	return svc.setNodes(store, ns...)
+}
+
+// checkScramblerKeys returns an error if the scrambler key of any of the nodes
+// is already used by a node with a different domain. Nodes without a scrambler
+// are ignored.
+func (svc *storageService) checkScramblerKeys(ns []*node) error {
+	e, err := svc.getAllNodes()
+	if err != nil {
+		return err
+	}
+	k := make(map[string]string)
+	for _, n := range append(e, ns...) {
+		s := n.getScramblerKey()
+		if s == "" {
+			continue
+		}
+		if d, ok := k[s]; ok && d != n.domain {
+			return fmt.Errorf(
+				"scrambler key of node '%s' is already used by node '%s'",
+				n.domain,
+				d)
+		}
+		k[s] = n.domain
+	}
+	return nil
+}
+
+// writeNode writes the node to the store with the name provided. Used by the
+// queue to perform asynchronous writes.
+func (svc *storageService) writeNode(store string, n *node) error {
+	for _, s := range svc.stores {
+		if s.getName() == store && !s.getReadOnly() {
+			return s.setNode(n)
+		}
+	}
+	return fmt.Errorf("no writable stores by the name of '%s' found", store)
+}
+
+// DeleteNode removes the node for the domain from the writeable store that
+// contains it. The storage manager is then recreated so that the node is no
+// longer available from the in-memory maps.
+func (svc *storageService) DeleteNode(domain string) error {
+	f := false
+	for _, s := range svc.stores {
+		if s.getReadOnly() {
+			continue
+		}
+		n, err := s.getNode(domain)
+		if err != nil {
+			return err
+		}
+		if n != nil {
+			err = s.deleteNode(domain)
+			if err != nil {
+				return err
+			}
+			f = true
+		}
+	}
+	if f == false {
+		return fmt.Errorf("node '%s' not found in a writeable store", domain)
+	}
+	return svc.Invalidate()
 }
 
 // GetStoreNames returns an array of names of all the writeable stores
@@ -122,6 +421,27 @@ func (svc *storageService) GetStoreNames() []string {
 	return storeNames
 }
 
+// GetNetworks returns the sorted distinct names of the networks of all the
+// nodes in all the stores.
+func (svc *storageService) GetNetworks() []string {
+	m := make(map[string]bool)
+	for _, s := range svc.stores {
+		err := s.iterateNodes(func(n *node, _ interface{}) error {
+			m[n.network] = true
+			return nil
+		}, nil)
+		if err != nil {
+			svc.config.errorf("SWIFT:%s\n", err.Error())
+		}
+	}
+	a := make([]string, 0, len(m))
+	for k := range m {
+		a = append(a, k)
+	}
+	sort.Strings(a)
+	return a
+}
+
 // SetNode takes a register object and creates a new node, returns boolean
 // for if successful or not and another boolean if this is an update operation.
 func (s *storageService) SetNode(d *Register) (bool, bool) {
@@ -155,7 +475,7 @@ func (s *storageService) SetNode(d *Register) (bool, bool) {
 	}
 
 	// Add the first secret to the node.
-	x, err := newSecret()
+	x, err := newSecretWithCipher(s.config.CipherOrDefault())
 	if err != nil {
 		d.Error = err.Error()
 		return false, isUpdate
@@ -169,6 +489,10 @@ func (s *storageService) SetNode(d *Register) (bool, bool) {
 		d.StoreError = err.Error()
 	} else {
 		d.ReadOnly = true
+		err = s.invalidateUnlessQueued()
+		if err != nil {
+			s.config.errorf("SWIFT:%s\n", err.Error())
+		}
 	}
 	return true, isUpdate
 }
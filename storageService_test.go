@@ -0,0 +1,125 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStorageServiceInvalidate(t *testing.T) {
+	c := newConfigurationTest()
+	svc := NewStorageService(c, newVolatile("test", false, nil))
+	r := Register{
+		Network: "network",
+		Domain:  "new.com",
+		Starts:  time.Now().UTC(),
+		Expires: time.Now().UTC().AddDate(1, 0, 0),
+		Role:    roleStorage,
+		Store:   "test"}
+	ok, _ := svc.SetNode(&r)
+	if ok == false || r.StoreError != "" {
+		fmt.Printf("Registration failed '%s' '%s'\n", r.Error, r.StoreError)
+		t.Fail()
+		return
+	}
+	if svc.store.getNode("new.com") == nil {
+		fmt.Println("Registered node not available from storage manager")
+		t.Fail()
+	}
+}
+
+func TestStorageServiceUniqueScramblerKeys(t *testing.T) {
+	c := newConfigurationTest()
+	c.UniqueScramblerKeys = true
+	svc := NewStorageService(c, newVolatile("test", false, nil))
+	s, err := newSecret()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	x, err := newSecret()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	for _, d := range []struct {
+		domain string
+		key    string
+		valid  bool
+	}{
+		{"first.com", s.key, true},
+		{"first.com", s.key, true},
+		{"copy.com", s.key, false},
+		{"distinct.com", x.key, true}} {
+		n, err := newNode(
+			"network",
+			d.domain,
+			time.Now().UTC(),
+			time.Now().UTC(),
+			time.Now().UTC().AddDate(1, 0, 0),
+			roleStorage,
+			d.key,
+			d.domain)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		err = svc.setNodes("test", n)
+		if d.valid && err != nil {
+			fmt.Println(err)
+			t.Fail()
+		} else if d.valid == false && err == nil {
+			fmt.Printf("Duplicate scrambler key accepted for '%s'\n", d.domain)
+			t.Fail()
+		}
+	}
+}
+
+func TestStorageServiceInvalidateStopsAlive(t *testing.T) {
+	c := newConfigurationTest()
+	c.AlivePollingSeconds = 60
+	svc := NewStorageService(c, newVolatile("test", false, nil))
+	o := svc.store
+	err := svc.Invalidate()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if svc.store == o {
+		fmt.Println("Storage manager not replaced")
+		t.Fail()
+		return
+	}
+	select {
+	case <-o.alive.done:
+	default:
+		fmt.Println("Alive service of replaced storage manager not stopped")
+		t.Fail()
+	}
+	select {
+	case <-svc.store.alive.done:
+		fmt.Println("Alive service of current storage manager stopped")
+		t.Fail()
+	default:
+	}
+}
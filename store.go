@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"os"
 )
 
 const (
@@ -32,6 +33,8 @@ const (
 	expiresFieldName      = "expires"      // When the node expires
 	scramblerKeyFieldName = "ScramblerKey" // Used to scramble table and key names
 	cookieDomainFieldName = "CookieDomain" // The domain to use with cookies
+	weightFieldName       = "Weight"       // Relative capacity of the node
+	previousFieldName     = "Previous"     // Scrambler key before rotation
 )
 
 // Store interface for persistent data shared across instances operated.
@@ -46,6 +49,9 @@ type Store interface {
 	// getNodes returns nodes
 	getNodes(network string) (*nodes, error)
 
+	// ping returns an error if the store can not be reached
+	ping() error
+
 	// getReadonly returns true if the store does not support inserts and updates.
 	getReadOnly() bool
 	// iterateNodes call the callback for every node
@@ -56,45 +62,22 @@ type Store interface {
 	// setNode inserts or updates the node if the store supports inserts and
 	// updates
 	setNode(n *node) error
+
+	// deleteNode removes the node and its secrets if the store supports
+	// deletes
+	deleteNode(domain string) error
 }
 
+// nodesJSONEnv is the environment variable that can contain the nodes JSON in
+// the same format as the local storage file.
+const nodesJSONEnv = "SWIFT_NODES_JSON"
+
 // NewStore returns a work implementation of the Store interface for the
 // configuration supplied.
 func NewStore(c Configuration) []Store {
 	var swiftStores []Store
-	if len(c.AzureStorageAccount) > 0 || len(c.AzureStorageAccessKey) > 0 {
-		log.Printf("SWIFT:Using Azure Table Storage")
-		if len(c.AzureStorageAccount) == 0 || len(c.AzureStorageAccessKey) == 0 {
-			panic(errors.New("Both the AzureStorageAccount or " +
-				"AzureStorageAccessKey settings must be present to use Azure"))
-		}
-		swiftStore, err := NewAzure(
-			c.AzureStorageAccount,
-			c.AzureStorageAccessKey)
-		if err != nil {
-			panic(err)
-		}
-		swiftStores = append(swiftStores, swiftStore)
-	}
-	if len(c.GcpProject) > 0 {
-		log.Printf("SWIFT:Using Google Firebase")
-		swiftStore, err := NewFirebase(c.GcpProject)
-		if err != nil {
-			panic(err)
-		}
-		swiftStores = append(swiftStores, swiftStore)
-	}
-	if len(c.SwiftFile) > 0 {
-		log.Printf("SWIFT:Using local storage")
-		swiftStore, err := NewLocalStore(c.SwiftFile)
-		if err != nil {
-			panic(err)
-		}
-		swiftStores = append(swiftStores, swiftStore)
-	}
-	if c.AwsEnabled {
-		log.Printf("SWIFT:Using AWS DynamoDB")
-		swiftStore, err := NewAWS()
+	for _, sc := range getStoreConfigs(c) {
+		swiftStore, err := NewStoreFromConfig(sc)
 		if err != nil {
 			panic(err)
 		}
@@ -109,6 +92,7 @@ func NewStore(c Configuration) []Store {
 			"(2) GCP project in 'GCP_PROJECT'\r\n" +
 			"(3) Local storage file paths in 'SWIFT_FILE'\r\n" +
 			"(4) AWS Dynamo DB by setting 'AWS_ENABLED' to true\r\n" +
+			"(5) Nodes JSON in 'SWIFT_NODES_JSON'\r\n" +
 			"Refer to https://github.com/SWAN-community/swift-go/blob/main/README.md " +
 			"for specifics on setting up each storage solution"))
 	} else if c.Debug {
@@ -124,3 +108,55 @@ func NewStore(c Configuration) []Store {
 
 	return swiftStores
 }
+
+// getStoreConfigs returns the store configurations in the order the stores
+// should be created from the configuration and environment.
+func getStoreConfigs(c Configuration) []StoreConfig {
+	var r []StoreConfig
+	if len(c.AzureStorageAccount) > 0 || len(c.AzureStorageAccessKey) > 0 {
+		log.Printf("SWIFT:Using Azure Table Storage")
+		if len(c.AzureStorageAccount) == 0 || len(c.AzureStorageAccessKey) == 0 {
+			panic(errors.New("Both the AzureStorageAccount or " +
+				"AzureStorageAccessKey settings must be present to use Azure"))
+		}
+		r = append(r, StoreConfig{
+			Kind:        StoreKindAzure,
+			Account:     c.AzureStorageAccount,
+			AccessKey:   c.AzureStorageAccessKey,
+			Attempts:    c.StoreRetryAttemptsOrDefault(),
+			SyncRefresh: c.SyncStoreRefresh})
+	}
+	if len(c.GcpProject) > 0 {
+		log.Printf("SWIFT:Using Google Firebase")
+		r = append(r, StoreConfig{
+			Kind:        StoreKindGCP,
+			Project:     c.GcpProject,
+			Attempts:    c.StoreRetryAttemptsOrDefault(),
+			SyncRefresh: c.SyncStoreRefresh})
+	}
+	if len(c.SwiftFile) > 0 {
+		log.Printf("SWIFT:Using local storage")
+		r = append(r, StoreConfig{
+			Kind:        StoreKindLocal,
+			File:        c.SwiftFile,
+			LocalKey:    c.SwiftLocalKey,
+			SyncRefresh: c.SyncStoreRefresh})
+	}
+	if v := os.Getenv(nodesJSONEnv); v != "" {
+		log.Printf("SWIFT:Using nodes from '%s'", nodesJSONEnv)
+		r = append(r, StoreConfig{
+			Kind:        StoreKindJSON,
+			Name:        nodesJSONEnv,
+			Data:        []byte(v),
+			SyncRefresh: c.SyncStoreRefresh})
+	}
+	if c.AwsEnabled {
+		log.Printf("SWIFT:Using AWS DynamoDB")
+		r = append(r, StoreConfig{
+			Kind:            StoreKindAWS,
+			Attempts:        c.StoreRetryAttemptsOrDefault(),
+			SyncRefresh:     c.SyncStoreRefresh,
+			ConsistentReads: c.StoreConsistentReads})
+	}
+	return r
+}
@@ -0,0 +1,94 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import "fmt"
+
+// The kinds of store that can be created with NewStoreFromConfig.
+const (
+	StoreKindAzure = "azure" // Azure Table Storage
+	StoreKindGCP   = "gcp"   // Google Firebase
+	StoreKindLocal = "local" // Local JSON file
+	StoreKindJSON  = "json"  // Nodes JSON held in memory
+	StoreKindAWS   = "aws"   // AWS DynamoDB
+)
+
+// StoreConfig contains the settings needed to create a single store. Only the
+// fields relevant to the Kind are used.
+type StoreConfig struct {
+	Kind      string // One of the StoreKind constants
+	Account   string // Azure storage account
+	AccessKey string // Azure storage access key
+	Project   string // GCP project
+	File      string // Path to the local storage file
+	LocalKey  string // Optional master key for the local storage file
+	Name      string // Name of the store when created from Data
+	Data      []byte // Nodes JSON when the kind is json
+	Attempts  int    // Number of attempts to query a table, 0 for default
+	// True to refresh the store on the request path when a node is not found
+	SyncRefresh bool
+	// True to use strongly consistent reads where the store supports them
+	ConsistentReads bool
+}
+
+// attemptsOrDefault returns the number of attempts or the default if not set.
+func (c *StoreConfig) attemptsOrDefault() int {
+	if c.Attempts > 0 {
+		return c.Attempts
+	}
+	return defaultStoreRetryAttempts
+}
+
+// NewStoreFromConfig returns a new store for the kind and settings provided.
+func NewStoreFromConfig(c StoreConfig) (Store, error) {
+	var s Store
+	var err error
+	switch c.Kind {
+	case StoreKindAzure:
+		if c.Account == "" || c.AccessKey == "" {
+			return nil, fmt.Errorf(
+				"both account and access key must be present to use Azure")
+		}
+		s, err = NewAzureWithRetry(c.Account, c.AccessKey, c.attemptsOrDefault())
+	case StoreKindGCP:
+		s, err = NewFirebaseWithRetry(c.Project, c.attemptsOrDefault())
+	case StoreKindLocal:
+		s, err = NewLocalStoreWithKey(c.File, c.LocalKey)
+	case StoreKindJSON:
+		s, err = NewLocalStoreFromBytes(c.Name, c.Data)
+	case StoreKindAWS:
+		s, err = NewAWSWithRetry(c.attemptsOrDefault())
+	default:
+		return nil, fmt.Errorf("store kind '%s' not supported", c.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	applyStoreConfig(s, &c)
+	return s, nil
+}
+
+// applyStoreConfig sets the options from the configuration c that apply after
+// the store s has been created.
+func applyStoreConfig(s Store, c *StoreConfig) {
+	if r, ok := s.(interface{ setSyncRefresh(bool) }); ok {
+		r.setSyncRefresh(c.SyncRefresh)
+	}
+	if r, ok := s.(interface{ setConsistentReads(bool) }); ok {
+		r.setConsistentReads(c.ConsistentReads)
+	}
+}
@@ -0,0 +1,87 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewStoreFromConfig(t *testing.T) {
+	t.Run("local", func(t *testing.T) {
+		s, err := NewStoreFromConfig(StoreConfig{
+			Kind: StoreKindLocal,
+			File: filepath.Join(t.TempDir(), "nodes.json")})
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		if s.getReadOnly() {
+			fmt.Println("local store should not be read only")
+			t.Fail()
+		}
+	})
+	t.Run("json", func(t *testing.T) {
+		s, err := NewStoreFromConfig(StoreConfig{
+			Kind: StoreKindJSON,
+			Name: "json",
+			Data: []byte("{}")})
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		if !s.getReadOnly() || s.getName() != "json" {
+			fmt.Println("json store should be read only and named")
+			t.Fail()
+		}
+	})
+	t.Run("azure missing key", func(t *testing.T) {
+		_, err := NewStoreFromConfig(StoreConfig{
+			Kind:    StoreKindAzure,
+			Account: "account"})
+		if err == nil {
+			fmt.Println("expected error for missing access key")
+			t.Fail()
+		}
+	})
+	t.Run("unknown", func(t *testing.T) {
+		_, err := NewStoreFromConfig(StoreConfig{Kind: "unknown"})
+		if err == nil {
+			fmt.Println("expected error for unknown kind")
+			t.Fail()
+		}
+	})
+}
+
+// TestStoreConfigConsistentReads confirms that the consistent reads option
+// reaches the DynamoDB scan parameters.
+func TestStoreConfigConsistentReads(t *testing.T) {
+	for _, v := range []bool{true, false} {
+		var a AWS
+		applyStoreConfig(&a, &StoreConfig{
+			Kind:            StoreKindAWS,
+			ConsistentReads: v})
+		p := a.newScanInput(nodesTableName)
+		if p.ConsistentRead == nil || *p.ConsistentRead != v {
+			fmt.Printf("Consistent read not '%t'\n", v)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,356 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// The default number of attempts to write an item before it is moved to the
+// dead letter file.
+const defaultStoreQueueMaxAttempts = 10
+
+// The suffix added to the queue file to form the name of the dead letter file.
+const storeQueueDeadSuffix = ".dead"
+
+// storeQueueItem is a single node waiting to be written to the named store.
+type storeQueueItem struct {
+	Store    string `json:"store"`    // The name of the store to write to
+	Node     *node  `json:"node"`     // The node to write
+	Attempts int    `json:"attempts"` // Number of failed attempts so far
+}
+
+// storeQueueNode is a node that has been accepted by the queue and which must
+// be returned from reads until the storage manager has been refreshed.
+type storeQueueNode struct {
+	node    *node     // The node accepted by the queue
+	written time.Time // Time the node was written, or zero if still queued
+}
+
+// storeQueue writes nodes to stores asynchronously. Items are persisted to a
+// JSON file before they are acknowledged so that a crash does not lose writes.
+// Writes that fail are retried with an exponential backoff. Items that still
+// fail after the maximum number of attempts are moved to a dead letter file so
+// that they do not block the items behind them.
+type storeQueue struct {
+	config      Configuration              // Swift configuration for logging
+	file        string                     // File used to persist the items
+	minBackoff  time.Duration              // Backoff after the first failure
+	maxBackoff  time.Duration              // Maximum backoff between retries
+	maxAttempts int                        // Attempts before an item is dead
+	master      *crypto                    // Encrypts the files, or nil
+	items       []*storeQueueItem          // Items waiting to be written
+	nodes       map[string]*storeQueueNode // Nodes accepted keyed on domain
+	write       func(store string, n *node) error
+	written     uint64      // Number of items written successfully
+	failed      uint64      // Number of failed write attempts
+	dead        uint64      // Number of items moved to the dead letter file
+	mutex       *sync.Mutex // Lock for the items, nodes and counters
+	signal      chan bool   // Signals that an item has been added
+	stop        chan bool   // Closed to stop the background writer
+	done        chan bool   // Closed when the background writer has stopped
+}
+
+// StoreQueueStats are the counters of the queue used for asynchronous store
+// writes. Returned by HandlerStatus.
+type StoreQueueStats struct {
+	Queued  int    `json:"queued"`  // Items waiting to be written
+	Written uint64 `json:"written"` // Items written successfully
+	Failed  uint64 `json:"failed"`  // Failed write attempts
+	Dead    uint64 `json:"dead"`    // Items moved to the dead letter file
+}
+
+// newStoreQueue creates a new queue persisted to the file provided and starts
+// the background writer. Any items found in the file from a previous instance
+// are written first. The write function performs the write to the store. The
+// maximum number of attempts and the key used to encrypt the files are taken
+// from the configuration.
+func newStoreQueue(
+	c Configuration,
+	file string,
+	minBackoff time.Duration,
+	maxBackoff time.Duration,
+	write func(store string, n *node) error) (*storeQueue, error) {
+	var q storeQueue
+	q.config = c
+	q.file = file
+	q.maxAttempts = c.StoreQueueMaxAttemptsOrDefault()
+	if k := c.StoreQueueKeyOrDefault(); k != "" {
+		b, err := base64.RawURLEncoding.DecodeString(k)
+		if err != nil {
+			return nil, err
+		}
+		q.master, err = newCrypto(b)
+		if err != nil {
+			return nil, err
+		}
+	}
+	q.minBackoff = minBackoff
+	q.maxBackoff = maxBackoff
+	q.write = write
+	q.nodes = make(map[string]*storeQueueNode)
+	q.mutex = &sync.Mutex{}
+	q.signal = make(chan bool, 1)
+	q.stop = make(chan bool)
+	q.done = make(chan bool)
+	err := q.load()
+	if err != nil {
+		return nil, err
+	}
+	go q.run()
+	return &q, nil
+}
+
+// add persists the node to the queue and returns once the node is durable. The
+// node is available from getNode immediately.
+func (q *storeQueue) add(store string, n *node) error {
+	q.mutex.Lock()
+	q.items = append(q.items, &storeQueueItem{Store: store, Node: n})
+	err := q.save()
+	if err != nil {
+		q.items = q.items[:len(q.items)-1]
+		q.mutex.Unlock()
+		return err
+	}
+	q.nodes[n.domain] = &storeQueueNode{node: n}
+	q.mutex.Unlock()
+	select {
+	case q.signal <- true:
+	default:
+	}
+	return nil
+}
+
+// getNode returns the node for the domain if it has been accepted by the queue
+// since the storage manager was last refreshed, otherwise nil.
+func (q *storeQueue) getNode(domain string) *node {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if i := q.nodes[domain]; i != nil {
+		return i.node
+	}
+	return nil
+}
+
+// trim removes nodes that were written before the time t. Called once the
+// storage manager has been refreshed with nodes read from the stores after t.
+func (q *storeQueue) trim(t time.Time) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for k, i := range q.nodes {
+		if i.written.IsZero() == false && i.written.Before(t) {
+			delete(q.nodes, k)
+		}
+	}
+}
+
+// stats returns the number of items waiting to be written, the number written,
+// the number of failed write attempts and the number of items moved to the
+// dead letter file.
+func (q *storeQueue) stats() StoreQueueStats {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return StoreQueueStats{len(q.items), q.written, q.failed, q.dead}
+}
+
+// close stops the background writer and waits for any write in progress to
+// complete. Items not yet written remain in the file.
+func (q *storeQueue) close() {
+	close(q.stop)
+	<-q.done
+}
+
+// run writes the item at the head of the queue until the queue is empty and
+// then waits for more items to be added.
+func (q *storeQueue) run() {
+	defer close(q.done)
+	for {
+		q.mutex.Lock()
+		var i *storeQueueItem
+		if len(q.items) > 0 {
+			i = q.items[0]
+		}
+		q.mutex.Unlock()
+		if i == nil {
+			select {
+			case <-q.signal:
+				continue
+			case <-q.stop:
+				return
+			}
+		}
+		err := q.write(i.Store, i.Node)
+		if err != nil {
+			d := q.failure(i, err)
+			select {
+			case <-time.After(d):
+			case <-q.stop:
+				return
+			}
+		} else {
+			q.success(i)
+		}
+	}
+}
+
+// failure records the failed attempt for the item and returns the time to wait
+// before the next attempt. If the item has reached the maximum number of
+// attempts then it is moved to the dead letter file and the next item is tried
+// without waiting.
+func (q *storeQueue) failure(i *storeQueueItem, err error) time.Duration {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	i.Attempts++
+	q.failed++
+	q.config.errorf(
+		"SWIFT: write of node '%s' to store '%s' failed (attempt %d): %s\n",
+		i.Node.domain,
+		i.Store,
+		i.Attempts,
+		err.Error())
+	if i.Attempts >= q.maxAttempts {
+		q.kill(i)
+		return 0
+	}
+	serr := q.save()
+	if serr != nil {
+		q.config.errorf("SWIFT:%s\n", serr.Error())
+	}
+	d := q.minBackoff
+	for a := 1; a < i.Attempts && d < q.maxBackoff; a++ {
+		d *= 2
+	}
+	if d > q.maxBackoff {
+		d = q.maxBackoff
+	}
+	return d
+}
+
+// kill moves the item at the head of the queue to the dead letter file. The
+// node is no longer returned from getNode as it will not be written. Must be
+// called with the mutex held.
+func (q *storeQueue) kill(i *storeQueueItem) {
+	q.config.errorf(
+		"SWIFT: write of node '%s' to store '%s' abandoned after %d "+
+			"attempts, see '%s'\n",
+		i.Node.domain,
+		i.Store,
+		i.Attempts,
+		q.file+storeQueueDeadSuffix)
+	var d []*storeQueueItem
+	err := q.readFile(q.file+storeQueueDeadSuffix, &d)
+	if err == nil {
+		err = q.writeFile(q.file+storeQueueDeadSuffix, append(d, i))
+	}
+	if err != nil {
+		q.config.errorf("SWIFT:%s\n", err.Error())
+	}
+	q.items = q.items[1:]
+	q.dead++
+	if n := q.nodes[i.Node.domain]; n != nil && n.node == i.Node {
+		delete(q.nodes, i.Node.domain)
+	}
+	err = q.save()
+	if err != nil {
+		q.config.errorf("SWIFT:%s\n", err.Error())
+	}
+}
+
+// success removes the item from the head of the queue.
+func (q *storeQueue) success(i *storeQueueItem) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.items = q.items[1:]
+	q.written++
+	if n := q.nodes[i.Node.domain]; n != nil && n.node == i.Node {
+		n.written = time.Now().UTC()
+	}
+	err := q.save()
+	if err != nil {
+		q.config.errorf("SWIFT:%s\n", err.Error())
+	}
+}
+
+// load reads any items persisted by a previous instance. Must be called before
+// the background writer is started.
+func (q *storeQueue) load() error {
+	err := q.readFile(q.file, &q.items)
+	if err != nil {
+		return err
+	}
+	for _, i := range q.items {
+		q.nodes[i.Node.domain] = &storeQueueNode{node: i.Node}
+	}
+	return nil
+}
+
+// save writes the items to the queue file. Must be called with the mutex held.
+func (q *storeQueue) save() error {
+	return q.writeFile(q.file, q.items)
+}
+
+// readFile reads the items from the file f into v. A file that does not exist
+// or is empty contains no items. If a key is configured then files that were
+// written in plaintext before the key was set are still read and are encrypted
+// the next time they are written.
+func (q *storeQueue) readFile(f string, v *[]*storeQueueItem) error {
+	data, err := ioutil.ReadFile(f)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	if q.master != nil && json.Valid(data) == false {
+		data, err = q.master.decrypt(data)
+		if err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writeFile writes the items to a temporary file which then replaces the file f
+// so that a crash part way through leaves the previous version intact. The
+// files contain node secrets so are only readable by the owner and are
+// encrypted if a key is configured.
+func (q *storeQueue) writeFile(f string, items []*storeQueueItem) error {
+	data, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+	if q.master != nil {
+		data, err = q.master.encrypt(data)
+		if err != nil {
+			return err
+		}
+	}
+	t := f + ".tmp"
+	err = ioutil.WriteFile(t, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(t, f)
+}
@@ -0,0 +1,425 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package swift
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testQueueStore is a writeable store that can be slow and that fails a set
+// number of writes before succeeding.
+type testQueueStore struct {
+	*Volatile
+	delay time.Duration // Time each write takes
+	fails int           // Number of writes that fail before succeeding
+	mutex *sync.Mutex
+}
+
+func (s *testQueueStore) setNode(n *node) error {
+	time.Sleep(s.delay)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.fails > 0 {
+		s.fails--
+		return fmt.Errorf("store unavailable")
+	}
+	return s.Volatile.setNode(n)
+}
+
+func (s *testQueueStore) hasNode(domain string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	n, _ := s.Volatile.getNode(domain)
+	return n != nil
+}
+
+func TestStoreQueueSlowStore(t *testing.T) {
+	d := 500 * time.Millisecond
+	v := &testQueueStore{newVolatile("test", false, nil), d, 0, &sync.Mutex{}}
+	c := newConfigurationTest()
+	c.StoreQueueFile = filepath.Join(t.TempDir(), "queue.json")
+	svc := NewStorageService(c, v)
+	defer svc.queue.close()
+	r := Register{
+		Network: "network",
+		Domain:  "slow.com",
+		Starts:  time.Now().UTC(),
+		Expires: time.Now().UTC().AddDate(1, 0, 0),
+		Role:    roleStorage,
+		Store:   "test"}
+	f := svc.getRefreshed()
+	s := time.Now()
+	ok, _ := svc.SetNode(&r)
+	if ok == false || r.StoreError != "" {
+		fmt.Printf("Registration failed '%s' '%s'\n", r.Error, r.StoreError)
+		t.Fail()
+		return
+	}
+	if time.Since(s) >= d {
+		fmt.Printf("Registration took '%s'\n", time.Since(s))
+		t.Fail()
+	}
+	if svc.getRefreshed().Equal(f) == false {
+		fmt.Println("Storage manager rebuilt for a queued registration")
+		t.Fail()
+	}
+	if svc.getNode("slow.com") == nil {
+		fmt.Println("Node not available immediately")
+		t.Fail()
+	}
+	if testStoreQueueWait(func() bool { return v.hasNode("slow.com") }) == false {
+		fmt.Println("Node not written to store")
+		t.Fail()
+	}
+}
+
+func TestStoreQueueRetry(t *testing.T) {
+	v := &testQueueStore{newVolatile("test", false, nil), 0, 2, &sync.Mutex{}}
+	q, err := newStoreQueue(
+		newConfigurationTest(),
+		filepath.Join(t.TempDir(), "queue.json"),
+		time.Millisecond,
+		10*time.Millisecond,
+		func(s string, n *node) error { return v.setNode(n) })
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	defer q.close()
+	n, err := newStoreQueueTestNode("retry.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	err = q.add("test", n)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if testStoreQueueWait(func() bool { return q.stats().Written == 1 }) == false {
+		fmt.Println("Node not written to store")
+		t.Fail()
+		return
+	}
+	st := q.stats()
+	if st.Queued != 0 || st.Written != 1 || st.Failed != 2 || st.Dead != 0 {
+		fmt.Printf("Queued '%d', written '%d', failed '%d', dead '%d'\n",
+			st.Queued,
+			st.Written,
+			st.Failed,
+			st.Dead)
+		t.Fail()
+	}
+}
+
+func TestStoreQueueDurable(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "queue.json")
+	q, err := newStoreQueue(
+		newConfigurationTest(),
+		file,
+		time.Hour,
+		time.Hour,
+		func(s string, n *node) error { return fmt.Errorf("store unavailable") })
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	n, err := newStoreQueueTestNode("durable.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	err = q.add("test", n)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	q.close()
+
+	// Create a new queue from the same file as if the process had restarted.
+	v := &testQueueStore{newVolatile("test", false, nil), 0, 0, &sync.Mutex{}}
+	q, err = newStoreQueue(
+		newConfigurationTest(),
+		file,
+		time.Millisecond,
+		time.Millisecond,
+		func(s string, n *node) error { return v.setNode(n) })
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	defer q.close()
+	if q.getNode("durable.com") == nil {
+		fmt.Println("Node not available after restart")
+		t.Fail()
+	}
+	if testStoreQueueWait(func() bool { return v.hasNode("durable.com") }) == false {
+		fmt.Println("Node not written to store after restart")
+		t.Fail()
+	}
+}
+
+func TestStoreQueueDeadLetter(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "queue.json")
+	c := newConfigurationTest()
+	c.StoreQueueMaxAttempts = 2
+	v := &testQueueStore{newVolatile("test", false, nil), 0, 0, &sync.Mutex{}}
+	q, err := newStoreQueue(
+		c,
+		file,
+		time.Millisecond,
+		time.Millisecond,
+		func(s string, n *node) error {
+			if n.domain == "dead.com" {
+				return fmt.Errorf("store rejected node")
+			}
+			return v.setNode(n)
+		})
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	defer q.close()
+	for _, d := range []string{"dead.com", "next.com"} {
+		n, err := newStoreQueueTestNode(d)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+		err = q.add("test", n)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			return
+		}
+	}
+
+	// The failing item does not block the one behind it.
+	if testStoreQueueWait(func() bool { return q.stats().Written == 1 }) == false ||
+		v.hasNode("next.com") == false {
+		fmt.Println("Node behind failing node not written to store")
+		t.Fail()
+		return
+	}
+	st := q.stats()
+	if st.Queued != 0 || st.Dead != 1 || st.Failed != 2 {
+		fmt.Printf("Queued '%d', failed '%d', dead '%d'\n",
+			st.Queued,
+			st.Failed,
+			st.Dead)
+		t.Fail()
+	}
+	if q.getNode("dead.com") != nil {
+		fmt.Println("Dead node still returned from queue")
+		t.Fail()
+	}
+	var d []*storeQueueItem
+	err = q.readFile(file+storeQueueDeadSuffix, &d)
+	if err != nil || len(d) != 1 || d[0].Node.domain != "dead.com" {
+		fmt.Printf("Dead letter file not written '%v'\n", err)
+		t.Fail()
+	}
+}
+
+func TestStoreQueueEncrypted(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "queue.json")
+	k, err := newSecret()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	c := newConfigurationTest()
+	c.StoreQueueKey = k.key
+
+	// Write a plaintext file as an instance without a key would have done.
+	n, err := newStoreQueueTestNode("plain.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	b, err := json.Marshal([]*storeQueueItem{{Store: "test", Node: n}})
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	err = ioutil.WriteFile(file, b, 0600)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	q, err := newStoreQueue(
+		c,
+		file,
+		time.Hour,
+		time.Hour,
+		func(s string, n *node) error { return fmt.Errorf("store unavailable") })
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if q.getNode("plain.com") == nil {
+		fmt.Println("Plaintext queue file not loaded")
+		t.Fail()
+	}
+	n, err = newStoreQueueTestNode("secret.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	err = q.add("test", n)
+	q.close()
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+
+	// The file is only readable by the owner and does not contain the secrets.
+	i, err := os.Stat(file)
+	if err != nil || i.Mode().Perm() != 0600 {
+		fmt.Printf("Queue file permissions '%v' '%v'\n", i.Mode().Perm(), err)
+		t.Fail()
+	}
+	b, err = ioutil.ReadFile(file)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if bytes.Contains(b, []byte("secret.com")) ||
+		bytes.Contains(b, []byte(n.secrets[0].key)) {
+		fmt.Println("Queue file not encrypted")
+		t.Fail()
+	}
+
+	// The encrypted file is read by the next instance.
+	q, err = newStoreQueue(
+		c,
+		file,
+		time.Hour,
+		time.Hour,
+		func(s string, n *node) error { return fmt.Errorf("store unavailable") })
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	defer q.close()
+	if q.getNode("secret.com") == nil || q.getNode("plain.com") == nil {
+		fmt.Println("Encrypted queue file not loaded")
+		t.Fail()
+	}
+}
+
+func newStoreQueueTestNode(domain string) (*node, error) {
+	s, err := newSecret()
+	if err != nil {
+		return nil, err
+	}
+	n, err := newNode(
+		"network",
+		domain,
+		time.Now().UTC(),
+		time.Now().UTC(),
+		time.Now().UTC().AddDate(1, 0, 0),
+		roleStorage,
+		s.key,
+		domain)
+	if err != nil {
+		return nil, err
+	}
+	x, err := newSecret()
+	if err != nil {
+		return nil, err
+	}
+	n.addSecret(x)
+	return n, nil
+}
+
+// testStoreQueueWait returns true if the condition becomes true within five
+// seconds.
+func testStoreQueueWait(f func() bool) bool {
+	for i := 0; i < 500; i++ {
+		if f() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestStoreQueueStatus(t *testing.T) {
+	v := &testQueueStore{newVolatile("test", false, nil), 0, 0, &sync.Mutex{}}
+	c := newConfigurationTest()
+	c.StoreQueueFile = filepath.Join(t.TempDir(), "queue.json")
+	svc := NewStorageService(c, v)
+	defer svc.queue.close()
+	r := Register{
+		Network: "network",
+		Domain:  "status.com",
+		Starts:  time.Now().UTC(),
+		Expires: time.Now().UTC().AddDate(1, 0, 0),
+		Role:    roleStorage,
+		Store:   "test"}
+	ok, _ := svc.SetNode(&r)
+	if ok == false || r.StoreError != "" {
+		fmt.Printf("Registration failed '%s' '%s'\n", r.Error, r.StoreError)
+		t.Fail()
+		return
+	}
+	if testStoreQueueWait(func() bool {
+		return svc.queue.stats().Written == 1
+	}) == false {
+		fmt.Println("Node not written to store")
+		t.Fail()
+		return
+	}
+	s := NewServices(c, svc, nil, nil)
+	st, err := getStatus(s, svc.getNode("status.com"))
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	if st.Queue == nil || st.Queue.Written != 1 {
+		fmt.Println("Queue counters not returned by status")
+		t.Fail()
+	}
+}
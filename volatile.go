@@ -25,6 +25,14 @@ type Volatile struct {
 	common
 }
 
+// NewVolatileStore creates a new in memory store with the name and nodes
+// provided. Nothing is persisted so the store is intended for unit tests that
+// can not use cloud credentials or files. If readOnly is true then nodes can
+// not be added or removed.
+func NewVolatileStore(name string, readOnly bool, nodes []*node) Store {
+	return newVolatile(name, readOnly, nodes)
+}
+
 func newVolatile(name string, readOnly bool, ns []*node) *Volatile {
 	var v Volatile
 	v.name = name
@@ -45,6 +53,11 @@ func (v *Volatile) getNodes(network string) (*nodes, error) {
 	return v.common.getNodes(network)
 }
 
+// ping always succeeds as the nodes are held in memory.
+func (v *Volatile) ping() error {
+	return nil
+}
+
 func (v *Volatile) getReadOnly() bool {
 	return v.readOnly
 }
@@ -52,7 +65,10 @@ func (v *Volatile) getReadOnly() bool {
 func (v *Volatile) iterateNodes(
 	callback func(n *node, s interface{}) error,
 	s interface{}) error {
-	for _, n := range v.common.nodes {
+	v.mutex.Lock()
+	m := v.nodes
+	v.mutex.Unlock()
+	for _, n := range m {
 		err := callback(n, s)
 		if err != nil {
 			return err
@@ -66,14 +82,33 @@ func (v *Volatile) setNode(n *node) error {
 		return fmt.Errorf("store '%s' is read only", v.name)
 	}
 
-	var net *nodes
-	v.nodes[n.domain] = n
-	net = v.networks[n.network]
-	if net == nil {
-		net = newNodes()
-		v.networks[n.network] = net
+	m := v.copyNodes()
+	m[n.domain] = n
+	v.replaceNodes(m)
+	return nil
+}
+
+// copyNodes returns a copy of the map of domains to nodes that can be changed
+// without affecting requests in progress.
+func (v *Volatile) copyNodes() map[string]*node {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	m := make(map[string]*node, len(v.nodes)+1)
+	for k, n := range v.nodes {
+		m[k] = n
+	}
+	return m
+}
+
+func (v *Volatile) deleteNode(domain string) error {
+	if v.readOnly {
+		return fmt.Errorf("store '%s' is read only", v.name)
+	}
+	m := v.copyNodes()
+	if m[domain] == nil {
+		return fmt.Errorf("node '%s' not found", domain)
 	}
-	net.dict[n.domain] = n
-	net.all = append(net.all, n)
+	delete(m, domain)
+	v.replaceNodes(m)
 	return nil
 }
@@ -18,6 +18,7 @@ package swift
 
 import (
 	"fmt"
+	"testing"
 	"time"
 )
 
@@ -47,7 +48,7 @@ func (v *Volatile) testAddStorage(index int) (*node, error) {
 		role:      0,
 		secrets:   make([]*secret, 1),
 		scrambler: s,
-		nonce:     make([]byte, s.crypto.gcm.NonceSize()),
+		nonce:     make([]byte, s.crypto.aead.NonceSize()),
 		accessed:  time.Now(),
 		alive:     true}
 	x, err := newSecret()
@@ -58,3 +59,49 @@ func (v *Volatile) testAddStorage(index int) (*node, error) {
 	v.setNode(&n)
 	return &n, nil
 }
+
+func TestNewVolatileStore(t *testing.T) {
+	n, err := newStoreQueueTestNode("storage.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	s := NewVolatileStore("test", false, []*node{n})
+	if s.getName() != "test" || s.getReadOnly() {
+		fmt.Println("Store name or read only flag incorrect")
+		t.Fail()
+	}
+	x, err := s.getNode("storage.com")
+	if err != nil || x != n {
+		fmt.Println("Node not found in store")
+		t.Fail()
+	}
+	c := 0
+	err = s.iterateNodes(func(n *node, s interface{}) error {
+		c++
+		return nil
+	}, nil)
+	if err != nil || c != 1 {
+		fmt.Printf("'%d' nodes iterated not '1'\n", c)
+		t.Fail()
+	}
+
+	// Nodes can only be added if the store is not read only.
+	o, err := newStoreQueueTestNode("other.com")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	err = s.setNode(o)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+	}
+	err = NewVolatileStore("test", true, nil).setNode(o)
+	if err == nil {
+		fmt.Println("Node added to read only store")
+		t.Fail()
+	}
+}